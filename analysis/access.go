@@ -0,0 +1,72 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+)
+
+// eventWrite is the pseudo event type under which a worker counts commands
+// that modify a cache key, when access is classified.  It is recorded in
+// addition to the event itself.
+const eventWrite model.EventType = -2
+
+// Access describes whether a cache key is mostly read or mostly written.
+type Access int
+
+const (
+	// AccessUnknown is reported when access is not classified.
+	AccessUnknown Access = iota
+	// AccessRead is reported for keys read at least Config.ReadWriteRatio
+	// times per write.  Such keys suit a read-through cache.
+	AccessRead
+	// AccessWrite is reported for keys written more often than that.  Such
+	// keys may suit a write-through cache.
+	AccessWrite
+)
+
+// String returns "read" or "write", or the empty string for AccessUnknown.
+func (a Access) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	default:
+		return ""
+	}
+}
+
+// isGet reports whether events of type t retrieve the cache key.
+func isGet(t model.EventType) bool {
+	switch t {
+	case model.EventGetHit, model.EventGetMiss, model.EventGet:
+		return true
+	default:
+		return false
+	}
+}
+
+// isWrite reports whether events of type t modify the cache key.
+func isWrite(t model.EventType) bool {
+	switch t {
+	case model.EventSet, model.EventDelete, model.EventIncr, model.EventDecr,
+		model.EventCASStored, model.EventCASExists, model.EventCASNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyAccess sets Access in each of krs by comparing its reads, whether
+// hits or misses, to its writes.  A key with at least ratio reads per write
+// is read-dominated.
+func classifyAccess(krs []KeyReport, ratio float64) {
+	for i := range krs {
+		kr := &krs[i]
+		reads := kr.RequestsEstimate + kr.MissesEstimate
+		if float64(reads) >= ratio*float64(kr.WritesEstimate) {
+			kr.Access = AccessRead
+		} else {
+			kr.Access = AccessWrite
+		}
+	}
+}
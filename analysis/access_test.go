@@ -0,0 +1,84 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestClassifyAccess(t *testing.T) {
+	krs := []KeyReport{
+		{Name: "read", RequestsEstimate: 8, MissesEstimate: 2, WritesEstimate: 1},
+		{Name: "write", RequestsEstimate: 3, WritesEstimate: 2},
+		{Name: "unwritten", MissesEstimate: 1},
+		{Name: "threshold", RequestsEstimate: 4, WritesEstimate: 2},
+	}
+	classifyAccess(krs, 2)
+	for i, want := range []Access{AccessRead, AccessWrite, AccessRead, AccessRead} {
+		if krs[i].Access != want {
+			t.Error("expected", krs[i].Name, "to be", want, "got", krs[i].Access)
+		}
+	}
+}
+
+func TestWritesCounted(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, ReadWriteRatio: 2})
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventGetMiss, Key: "a"},
+		{Type: model.EventSet, Key: "a", Size: 1},
+		{Type: model.EventDelete, Key: "a"},
+		{Type: model.EventGetHit, Key: "b", Size: 1},
+		{Type: model.EventGetHit, Key: "b", Size: 1},
+		{Type: model.EventIncr, Key: "b", Size: 1},
+	})
+	p.Wait()
+
+	access := make(map[string]KeyReport)
+	for _, kr := range p.Top(10, MetricRequests) {
+		access[kr.Name] = kr
+	}
+	if a := access["a"]; a.WritesEstimate != 2 || a.Access != AccessWrite {
+		t.Error("expected a to be write-dominated with 2 writes, got", a)
+	}
+	if b := access["b"]; b.WritesEstimate != 1 || b.Access != AccessRead {
+		t.Error("expected b to be read-dominated with 1 write, got", b)
+	}
+}
+
+func TestWritesCountedOutsideTopWrites(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, ReadWriteRatio: 4})
+	defer p.Shutdown(context.Background())
+	var evts []model.Event
+	for i := 0; i < 10; i++ {
+		evts = append(evts, model.Event{Type: model.EventGetHit, Key: "hot", Size: 1})
+	}
+	for i := 0; i < 3; i++ {
+		evts = append(evts, model.Event{Type: model.EventSet, Key: "hot", Size: 1})
+	}
+	for i := 0; i < 5; i++ {
+		evts = append(evts, model.Event{Type: model.EventDelete, Key: "churn"})
+	}
+	p.HandleEvents(evts)
+	p.Wait()
+
+	// the write hotlist's top key is churn, but hot's own writes count
+	krs := p.Top(1, MetricRequests)
+	if len(krs) != 1 || krs[0].Name != "hot" || krs[0].WritesEstimate != 3 || krs[0].Access != AccessWrite {
+		t.Error("expected hot to be write-dominated with 3 writes, got", krs)
+	}
+}
+
+func TestWritesNotCountedByDefault(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10})
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventSet, Key: "a", Size: 1},
+	})
+	p.Wait()
+
+	krs := p.Top(10, MetricRequests)
+	if len(krs) != 1 || krs[0].WritesEstimate != 0 || krs[0].Access != AccessUnknown {
+		t.Error("expected unclassified key, got", krs)
+	}
+}
@@ -0,0 +1,57 @@
+package analysis
+
+import (
+	"time"
+)
+
+// Churn describes how the busiest keys changed between two Reports.
+type Churn struct {
+	// when the earlier and later reports were generated
+	From, To time.Time
+	// keys in the later report that were not in the earlier one
+	Appeared []KeyReport
+	// keys in the earlier report that are not in the later one, as they
+	// were last reported
+	Disappeared []KeyReport
+}
+
+// Diff compares the keys in two Reports, matching them by Client and Name.
+// Keys keep the order of the report they come from.
+func Diff(prev, cur Report) Churn {
+	type churnKey struct {
+		cluster, client, name string
+	}
+	inPrev := make(map[churnKey]bool, len(prev.Keys))
+	for _, kr := range prev.Keys {
+		inPrev[churnKey{kr.Cluster, kr.Client, kr.Name}] = true
+	}
+	inCur := make(map[churnKey]bool, len(cur.Keys))
+	for _, kr := range cur.Keys {
+		inCur[churnKey{kr.Cluster, kr.Client, kr.Name}] = true
+	}
+
+	c := Churn{From: prev.Timestamp, To: cur.Timestamp}
+	for _, kr := range cur.Keys {
+		if !inPrev[churnKey{kr.Cluster, kr.Client, kr.Name}] {
+			c.Appeared = append(c.Appeared, kr)
+		}
+	}
+	for _, kr := range prev.Keys {
+		if !inCur[churnKey{kr.Cluster, kr.Client, kr.Name}] {
+			c.Disappeared = append(c.Disappeared, kr)
+		}
+	}
+	return c
+}
+
+// Churn compares the two most recent Reports in History, to find keys that
+// have newly become hot or have gone cold, such as after a deploy or an
+// eviction.  Churn returns false if fewer than two reports are retained,
+// including when the Pool was not configured with a HistorySize.
+func (p *Pool) Churn() (Churn, bool) {
+	reports := p.History()
+	if len(reports) < 2 {
+		return Churn{}, false
+	}
+	return Diff(reports[len(reports)-2], reports[len(reports)-1]), true
+}
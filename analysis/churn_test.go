@@ -0,0 +1,47 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	prev := Report{Keys: []KeyReport{{Name: "a"}, {Name: "b"}, {Name: "c", Client: "10.0.0.1"}}}
+	cur := Report{Keys: []KeyReport{{Name: "b"}, {Name: "d"}, {Name: "c", Client: "10.0.0.2"}}}
+
+	c := Diff(prev, cur)
+	if len(c.Appeared) != 2 || c.Appeared[0].Name != "d" || c.Appeared[1].Client != "10.0.0.2" {
+		t.Error("expected d and c from 10.0.0.2 to appear, got", c.Appeared)
+	}
+	if len(c.Disappeared) != 2 || c.Disappeared[0].Name != "a" || c.Disappeared[1].Client != "10.0.0.1" {
+		t.Error("expected a and c from 10.0.0.1 to disappear, got", c.Disappeared)
+	}
+}
+
+func TestPoolChurn(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, HistorySize: 3})
+	if _, ok := p.Churn(); ok {
+		t.Error("expected no churn without two reports")
+	}
+	for _, keys := range [][]string{{"a", "b"}, {"b", "c"}} {
+		for _, key := range keys {
+			p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: key, Size: 1}})
+		}
+		p.Wait()
+		p.Report(true)
+	}
+
+	c, ok := p.Churn()
+	if !ok {
+		t.Fatal("expected churn between two reports")
+	}
+	if len(c.Appeared) != 1 || c.Appeared[0].Name != "c" {
+		t.Error("expected c to appear, got", c.Appeared)
+	}
+	if len(c.Disappeared) != 1 || c.Disappeared[0].Name != "a" {
+		t.Error("expected a to disappear, got", c.Disappeared)
+	}
+	if c.To.Before(c.From) {
+		t.Error("expected later report second, got", c.From, c.To)
+	}
+}
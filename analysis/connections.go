@@ -0,0 +1,90 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/hotlist"
+	"sort"
+)
+
+// ConnectionReport contains activity information for a single client
+// connection.
+type ConnectionReport struct {
+	// client and server addresses and ports
+	Conn string
+	// number of requests made on this connection
+	RequestsEstimate int
+	// amount of bandwidth consumed by values sent or received on this
+	// connection in bytes
+	TrafficEstimate int
+}
+
+// TopConnections returns up to k of the busiest connections recorded in this
+// Pool since the last call to Reset, ranked according to the Pool's
+// WeightMode.  It returns nil unless the Pool was configured with
+// TrackConnections.
+func (p *Pool) TopConnections(k int) []ConnectionReport {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	var all []ConnectionReport
+	for _, w := range p.workers {
+		if _, ok := w.lists[eventConnection]; !ok {
+			return nil
+		}
+		for _, e := range w.top(k)[eventConnection] {
+			all = append(all, connectionReport(e))
+		}
+	}
+	conns := mergeConnections(all)
+	sort.Sort(connsByWeight{conns, p.mode})
+	if len(conns) > k {
+		conns = conns[:k]
+	}
+	return conns
+}
+
+func connectionReport(e hotlist.Entry) ConnectionReport {
+	ki := itemKeyInfo(e.Item())
+	traffic := e.Weight()
+	if _, ok := e.Item().(countedKey); ok {
+		traffic = e.Count() * ki.size
+	}
+	return ConnectionReport{
+		Conn:             ki.name,
+		RequestsEstimate: e.Count(),
+		TrafficEstimate:  traffic,
+	}
+}
+
+// connsByWeight sorts ConnectionReports in descending order by the measure
+// corresponding to a WeightMode.
+type connsByWeight struct {
+	conns []ConnectionReport
+	mode  WeightMode
+}
+
+func (b connsByWeight) Len() int      { return len(b.conns) }
+func (b connsByWeight) Swap(i, j int) { b.conns[i], b.conns[j] = b.conns[j], b.conns[i] }
+func (b connsByWeight) Less(i, j int) bool {
+	if b.mode == WeightCount {
+		return b.conns[j].RequestsEstimate < b.conns[i].RequestsEstimate
+	}
+	return b.conns[j].TrafficEstimate < b.conns[i].TrafficEstimate
+}
+
+// mergeConnections combines ConnectionReports for the same connection.
+// Each connection is tracked as several hotlist items by value size, and is
+// usually reported by more than one worker.
+func mergeConnections(crs []ConnectionReport) []ConnectionReport {
+	merged := make([]ConnectionReport, 0, len(crs))
+	index := make(map[string]int, len(crs))
+	for _, cr := range crs {
+		i, ok := index[cr.Conn]
+		if !ok {
+			index[cr.Conn] = len(merged)
+			merged = append(merged, cr)
+			continue
+		}
+		merged[i].RequestsEstimate += cr.RequestsEstimate
+		merged[i].TrafficEstimate += cr.TrafficEstimate
+	}
+	return merged
+}
@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// examples retains a small random sample of the original keys in each
+// family of keys rewritten by NormalizeKey, in bounded memory.  Each family
+// keeps a reservoir of keys sampled uniformly from the events on it, so
+// busier keys are more likely to be retained.  Families are remembered in
+// generations as by lastSeen, so families not active for two generations
+// are forgotten.
+type examples struct {
+	// number of keys retained for each family
+	size     int
+	capacity int
+	rand     *rand.Rand
+	cur      map[keyName]*reservoir
+	prev     map[keyName]*reservoir
+}
+
+// reservoir is a uniform sample of the keys of one family.
+type reservoir struct {
+	// number of keys offered to the sample
+	seen int
+	keys []string
+}
+
+func newExamples(size, capacity int) *examples {
+	if capacity <= 0 {
+		capacity = DefaultSeenKeys
+	}
+	return &examples{
+		size:     size,
+		capacity: capacity,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		cur:      make(map[keyName]*reservoir),
+	}
+}
+
+// add offers key as an example of the family kn.
+func (ex *examples) add(kn keyName, key string) {
+	r, ok := ex.cur[kn]
+	if !ok {
+		// families still in use are carried into the current generation
+		r = ex.prev[kn]
+		if r == nil {
+			r = &reservoir{keys: make([]string, 0, ex.size)}
+		}
+		ex.cur[kn] = r
+		if len(ex.cur) >= ex.capacity {
+			ex.prev = ex.cur
+			ex.cur = make(map[keyName]*reservoir)
+		}
+	}
+	r.seen++
+	if len(r.keys) < ex.size {
+		r.keys = append(r.keys, key)
+	} else if i := ex.rand.Intn(r.seen); i < ex.size {
+		r.keys[i] = key
+	}
+}
+
+// get returns the distinct keys retained for kn in sorted order, or nil if
+// it has been forgotten.
+func (ex *examples) get(kn keyName) []string {
+	r, ok := ex.cur[kn]
+	if !ok {
+		r = ex.prev[kn]
+	}
+	if r == nil {
+		return nil
+	}
+	return mergeExamples(nil, r.keys)
+}
+
+// reset forgets all families.
+func (ex *examples) reset() {
+	ex.cur = make(map[keyName]*reservoir)
+	ex.prev = nil
+}
+
+// mergeExamples returns the distinct keys in a and b in sorted order.
+func mergeExamples(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	merged := make([]string, 0, len(a)+len(b))
+	merged = append(merged, a...)
+	merged = append(merged, b...)
+	sort.Strings(merged)
+	n := 0
+	for i, key := range merged {
+		if i == 0 || key != merged[n-1] {
+			merged[n] = key
+			n++
+		}
+	}
+	return merged[:n]
+}
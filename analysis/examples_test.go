@@ -0,0 +1,47 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestExamplesBounded(t *testing.T) {
+	ex := newExamples(5, 10)
+	kn := keyName{name: "user:#:profile"}
+	for i := 0; i < 1000; i++ {
+		ex.add(kn, fmt.Sprintf("user:%d:profile", i))
+	}
+	keys := ex.get(kn)
+	if len(keys) != 5 {
+		t.Fatal("expected 5 examples, got", keys)
+	}
+	if r := ex.cur[kn]; r.seen != 1000 || cap(r.keys) != 5 {
+		t.Error("expected a reservoir of 5 that saw 1000 keys, got", r.seen, cap(r.keys))
+	}
+
+	ex.reset()
+	if keys := ex.get(kn); keys != nil {
+		t.Error("expected examples to be forgotten, got", keys)
+	}
+}
+
+func TestExamplesReported(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10, NormalizeKey: NewNormalizer(DefaultNormalizeRules), Examples: 5})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "user:42:profile", Size: 10},
+		{Type: model.EventGetHit, Key: "user:99:profile", Size: 20},
+		{Type: model.EventGetHit, Key: "user:42:profile", Size: 10},
+	})
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 1 || len(keys[0].Examples) != 2 ||
+		keys[0].Examples[0] != "user:42:profile" || keys[0].Examples[1] != "user:99:profile" {
+		t.Fatal("expected both keys as examples of one family, got", keys)
+	}
+	if l := keys[0].Label(); l != "user:#:profile (e.g. user:42:profile, user:99:profile)" {
+		t.Error("expected examples in label, got", l)
+	}
+}
@@ -0,0 +1,140 @@
+package analysis
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// bits of a client's hash selecting its register in a hyperLogLog,
+	// giving a standard error of about 3%
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+	// number of distinct clients counted exactly before a hyperLogLog
+	// allocates its registers, since most keys have few clients
+	hllSparseMax = 32
+)
+
+// DefaultFanoutKeys is the number of keys whose clients each worker counts
+// if Config.FanoutKeys is not positive.
+const DefaultFanoutKeys = 1 << 12
+
+// hyperLogLog estimates the number of distinct clients added to it in
+// bounded memory.  Up to hllSparseMax clients are counted exactly by their
+// hashes, and more are estimated from hllRegisters single byte registers.
+type hyperLogLog struct {
+	sparse    []uint64
+	registers []uint8
+}
+
+// add adds a client with hash h.
+func (hll *hyperLogLog) add(h uint64) {
+	if hll.registers == nil {
+		for _, s := range hll.sparse {
+			if s == h {
+				return
+			}
+		}
+		if len(hll.sparse) < hllSparseMax {
+			hll.sparse = append(hll.sparse, h)
+			return
+		}
+		hll.registers = make([]uint8, hllRegisters)
+		for _, s := range hll.sparse {
+			hll.addRegister(s)
+		}
+		hll.sparse = nil
+	}
+	hll.addRegister(h)
+}
+
+// addRegister records h in the register selected by its leading bits, which
+// holds the greatest position of the first set bit among the rest of the
+// hashes it has seen.
+func (hll *hyperLogLog) addRegister(h uint64) {
+	i := h >> (64 - hllPrecision)
+	// the sentinel bit bounds the rank of a hash whose other bits are zero
+	rank := uint8(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > hll.registers[i] {
+		hll.registers[i] = rank
+	}
+}
+
+// count returns the estimated number of distinct clients added.
+func (hll *hyperLogLog) count() int {
+	if hll.registers == nil {
+		return len(hll.sparse)
+	}
+	const m = float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range hll.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small counts
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int(est + 0.5)
+}
+
+// fanout estimates the number of distinct clients of each cache key, in
+// bounded memory.  Keys are remembered in generations as by lastSeen, so
+// keys not active for two generations are forgotten.
+type fanout struct {
+	capacity int
+	cur      map[keyName]*hyperLogLog
+	prev     map[keyName]*hyperLogLog
+}
+
+func newFanout(capacity int) *fanout {
+	if capacity <= 0 {
+		capacity = DefaultFanoutKeys
+	}
+	return &fanout{
+		capacity: capacity,
+		cur:      make(map[keyName]*hyperLogLog),
+	}
+}
+
+// add records a request for kn by client.
+func (f *fanout) add(kn keyName, client string) {
+	hll, ok := f.cur[kn]
+	if !ok {
+		// keys still in use are carried into the current generation
+		hll = f.prev[kn]
+		if hll == nil {
+			hll = &hyperLogLog{}
+		}
+		f.cur[kn] = hll
+		if len(f.cur) >= f.capacity {
+			f.prev = f.cur
+			f.cur = make(map[keyName]*hyperLogLog)
+		}
+	}
+	_, h := keyHashes(client)
+	hll.add(h)
+}
+
+// get returns the estimated number of distinct clients of kn, or zero if
+// it has been forgotten.
+func (f *fanout) get(kn keyName) int {
+	hll, ok := f.cur[kn]
+	if !ok {
+		hll = f.prev[kn]
+	}
+	if hll == nil {
+		return 0
+	}
+	return hll.count()
+}
+
+// reset forgets all keys.
+func (f *fanout) reset() {
+	f.cur = make(map[keyName]*hyperLogLog)
+	f.prev = nil
+}
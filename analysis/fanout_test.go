@@ -0,0 +1,41 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{1, hllSparseMax, 1000, 100000} {
+		var hll hyperLogLog
+		for i := 0; i < n; i++ {
+			_, h := keyHashes(fmt.Sprint("10.0.", i/256, ".", i%256))
+			hll.add(h)
+			// duplicates are not counted
+			hll.add(h)
+		}
+		if est := hll.count(); est < n*95/100 || est > n*105/100 {
+			t.Error("expected about", n, "clients, got", est)
+		}
+	}
+}
+
+func TestTrackFanout(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, TrackFanout: true})
+	defer p.Shutdown(context.Background())
+	var evts []model.Event
+	for i := 0; i < 5; i++ {
+		evts = append(evts,
+			model.Event{Type: model.EventGetHit, Key: "global", Size: 10, Client: fmt.Sprint("10.0.0.", i)},
+			model.Event{Type: model.EventGetHit, Key: "local", Size: 10, Client: "10.0.0.1"})
+	}
+	p.HandleEvents(evts)
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 2 || keys[0].Name != "global" || keys[0].ClientsEstimate != 5 ||
+		keys[1].Name != "local" || keys[1].ClientsEstimate != 1 {
+		t.Error("expected distinct clients of each key, got", keys)
+	}
+}
@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"sync"
+)
+
+// flushCounter counts the requests to flush each cluster, which are not
+// activity on any key.  flushCounter is threadsafe.
+type flushCounter struct {
+	clusters map[string]string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func newFlushCounter(conf Config) *flushCounter {
+	return &flushCounter{
+		clusters: conf.Clusters,
+		counts:   make(map[string]int),
+	}
+}
+
+// observe counts the flushes in evts, and returns the remaining events and
+// whether any flushes were seen.  evts is returned unchanged if it contains
+// no flushes.
+func (fc *flushCounter) observe(evts []model.Event) ([]model.Event, bool) {
+	n := 0
+	for _, evt := range evts {
+		if evt.Type == model.EventFlush {
+			n++
+		}
+	}
+	if n == 0 {
+		return evts, false
+	}
+
+	rest := make([]model.Event, 0, len(evts)-n)
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, evt := range evts {
+		if evt.Type != model.EventFlush {
+			rest = append(rest, evt)
+			continue
+		}
+		fc.counts[clusterOf(fc.clusters, evt.Server)]++
+	}
+	return rest, true
+}
+
+// get returns a copy of the counts.
+func (fc *flushCounter) get() map[string]int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	counts := make(map[string]int, len(fc.counts))
+	for cluster, n := range fc.counts {
+		counts[cluster] = n
+	}
+	return counts
+}
+
+// clusterOf returns the cluster of server according to clusters, or the
+// empty string if clusters is nil.
+func clusterOf(clusters map[string]string, server string) string {
+	if clusters == nil {
+		return ""
+	}
+	if name, ok := clusters[server]; ok {
+		return name
+	}
+	return server
+}
+
+// Flushes returns the number of requests to invalidate every item on a
+// server, such as memcached's flush_all, by cluster as for
+// KeyReport.Cluster.  Flushes are counted since the Pool was created, and
+// are not cleared by Reset.  Activity on all servers is counted under the
+// empty string unless the Pool was configured with Clusters.
+func (p *Pool) Flushes() map[string]int {
+	return p.flushes.get()
+}
@@ -0,0 +1,44 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestFlushes(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, Clusters: map[string]string{"10.0.0.1": "east"}})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.1"},
+		{Type: model.EventFlush, Server: "10.0.0.1"},
+		{Type: model.EventFlush, Server: "10.0.0.1"},
+		{Type: model.EventFlush, Server: "10.0.0.2"},
+	})
+	p.Wait()
+	flushes := p.Flushes()
+	if len(flushes) != 2 || flushes["east"] != 2 || flushes["10.0.0.2"] != 1 {
+		t.Error("expected flushes counted by cluster, got", flushes)
+	}
+	if keys := p.Top(10, MetricRequests); len(keys) != 1 || keys[0].RequestsEstimate != 1 {
+		t.Error("expected flushes not to be reported as keys, got", keys)
+	}
+}
+
+func TestResetOnFlush(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, ResetOnFlush: true})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 10}})
+	p.Wait()
+	p.HandleEvents([]model.Event{
+		{Type: model.EventFlush},
+		{Type: model.EventGetHit, Key: "b", Size: 10},
+	})
+	p.Wait()
+	if keys := p.Top(10, MetricRequests); len(keys) != 1 || keys[0].Name != "b" {
+		t.Error("expected only activity after the flush, got", keys)
+	}
+	if flushes := p.Flushes(); flushes[""] != 1 {
+		t.Error("expected flush counted after reset, got", flushes)
+	}
+}
@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// GrowthRank determines how keys are ranked by Growth.
+type GrowthRank int
+
+const (
+	// GrowthAbsolute ranks keys by the increase in their activity.
+	GrowthAbsolute GrowthRank = iota
+	// GrowthRelative ranks keys by the increase in their activity as a
+	// fraction of their earlier activity, breaking ties by the increase.
+	// Unmatched keys are left out, since their earlier activity is
+	// unknown.
+	GrowthRelative
+)
+
+// KeyGrowth is the change in activity on a key between two Reports.
+type KeyGrowth struct {
+	// the key as in the later report
+	KeyReport
+	// measure of the key in the earlier report, or zero if it was not
+	// reported
+	Previous int
+	// Increase is the measure of the key in the later report less
+	// Previous.
+	Increase int
+	// Ratio is Increase as a fraction of Previous, so that 1 means the
+	// key's activity doubled.  Ratio is +Inf for keys in the earlier report
+	// with no activity by the metric, and 0 for Unmatched keys.
+	Ratio float64
+	// Unmatched is set for keys not in the earlier report.  Such a key may
+	// have been idle, or only ranked just below the keys reported, so its
+	// Increase may greatly overstate its growth.
+	Unmatched bool
+}
+
+// Growth describes the keys whose activity grew the most between two
+// Reports.
+type Growth struct {
+	// when the earlier and later reports were generated
+	From, To time.Time
+	// keys whose activity increased, in descending order of growth
+	Keys []KeyGrowth
+}
+
+// CompareGrowth compares the keys in two Reports, matching them by Cluster,
+// Client and Name, and returns up to k of the keys in cur whose measure by
+// metric grew the most since prev, ranked by rank.  Reports measure
+// activity since the last reset, so growth is meaningful between reports
+// that each reset the Pool, or that cover a sliding window.
+func CompareGrowth(prev, cur Report, k int, by Metric, rank GrowthRank) Growth {
+	type growthKey struct {
+		cluster, client, name string
+	}
+	before := make(map[growthKey]int, len(prev.Keys))
+	for _, kr := range prev.Keys {
+		before[growthKey{kr.Cluster, kr.Client, kr.Name}] = by.value(kr)
+	}
+
+	g := Growth{From: prev.Timestamp, To: cur.Timestamp}
+	for _, kr := range cur.Keys {
+		was, ok := before[growthKey{kr.Cluster, kr.Client, kr.Name}]
+		kg := KeyGrowth{KeyReport: kr, Previous: was, Increase: by.value(kr) - was, Unmatched: !ok}
+		if kg.Increase <= 0 || (!ok && rank == GrowthRelative) {
+			continue
+		}
+		if ok && was > 0 {
+			kg.Ratio = float64(kg.Increase) / float64(was)
+		} else if ok {
+			kg.Ratio = math.Inf(1)
+		}
+		g.Keys = append(g.Keys, kg)
+	}
+	sort.Sort(byGrowth{g.Keys, rank})
+	if len(g.Keys) > k {
+		g.Keys = g.Keys[:k]
+	}
+	return g
+}
+
+// byGrowth sorts KeyGrowths in descending order by a GrowthRank.
+type byGrowth struct {
+	keys []KeyGrowth
+	rank GrowthRank
+}
+
+func (b byGrowth) Len() int      { return len(b.keys) }
+func (b byGrowth) Swap(i, j int) { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+func (b byGrowth) Less(i, j int) bool {
+	if b.rank == GrowthRelative && b.keys[i].Ratio != b.keys[j].Ratio {
+		return b.keys[i].Ratio > b.keys[j].Ratio
+	}
+	if b.keys[i].Increase != b.keys[j].Increase {
+		return b.keys[i].Increase > b.keys[j].Increase
+	}
+	return b.keys[i].Name < b.keys[j].Name
+}
+
+// Growth compares the two most recent Reports in History, to find up to k
+// keys rapidly heating up, such as those whose requests doubled, before
+// they saturate a server.  Growth returns false if fewer than two reports
+// are retained, including when the Pool was not configured with a
+// HistorySize.  Only keys in the busiest ReportSize of the later report
+// are considered, and keys not in the earlier report are Unmatched.
+func (p *Pool) Growth(k int, by Metric, rank GrowthRank) (Growth, bool) {
+	reports := p.History()
+	if len(reports) < 2 {
+		return Growth{}, false
+	}
+	return CompareGrowth(reports[len(reports)-2], reports[len(reports)-1], k, by, rank), true
+}
@@ -0,0 +1,71 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/protocol/model"
+	"math"
+	"testing"
+)
+
+func TestCompareGrowth(t *testing.T) {
+	prev := Report{Keys: []KeyReport{
+		{Name: "a", RequestsEstimate: 100},
+		{Name: "b", RequestsEstimate: 10},
+		{Name: "c", RequestsEstimate: 50},
+	}}
+	cur := Report{Keys: []KeyReport{
+		{Name: "a", RequestsEstimate: 150},
+		{Name: "b", RequestsEstimate: 30},
+		{Name: "c", RequestsEstimate: 40},
+		{Name: "d", RequestsEstimate: 5},
+	}}
+
+	g := CompareGrowth(prev, cur, 10, MetricRequests, GrowthAbsolute)
+	if len(g.Keys) != 3 || g.Keys[0].Name != "a" || g.Keys[1].Name != "b" || g.Keys[2].Name != "d" {
+		t.Fatal("expected a, b and d by increase, got", g.Keys)
+	}
+	if g.Keys[0].Previous != 100 || g.Keys[0].Increase != 50 || g.Keys[0].Ratio != 0.5 || g.Keys[0].Unmatched {
+		t.Error("unexpected growth of a", g.Keys[0])
+	}
+	if !g.Keys[2].Unmatched || g.Keys[2].Ratio != 0 {
+		t.Error("expected d to be unmatched, got", g.Keys[2])
+	}
+
+	// d may have ranked just below the earlier report, so has no ratio
+	g = CompareGrowth(prev, cur, 2, MetricRequests, GrowthRelative)
+	if len(g.Keys) != 2 || g.Keys[0].Name != "b" || g.Keys[1].Name != "a" {
+		t.Fatal("expected b and a by ratio, got", g.Keys)
+	}
+	if g.Keys[0].Ratio != 2 || g.Keys[1].Ratio != 0.5 {
+		t.Error("unexpected ratios", g.Keys)
+	}
+
+	idle := Report{Keys: []KeyReport{{Name: "a"}}}
+	g = CompareGrowth(idle, cur, 10, MetricRequests, GrowthRelative)
+	if len(g.Keys) != 1 || !math.IsInf(g.Keys[0].Ratio, 1) {
+		t.Error("expected infinite growth of a key reported idle, got", g.Keys)
+	}
+}
+
+func TestPoolGrowth(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, WeightMode: WeightCount, HistorySize: 3})
+	defer p.Shutdown(context.Background())
+	if _, ok := p.Growth(10, MetricRequests, GrowthAbsolute); ok {
+		t.Error("expected no growth without two reports")
+	}
+	for _, n := range []int{1, 3} {
+		for i := 0; i < n; i++ {
+			p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}})
+		}
+		p.Wait()
+		p.Report(true)
+	}
+
+	g, ok := p.Growth(10, MetricRequests, GrowthRelative)
+	if !ok {
+		t.Fatal("expected growth between two reports")
+	}
+	if len(g.Keys) != 1 || g.Keys[0].Name != "a" || g.Keys[0].Increase != 2 || g.Keys[0].Ratio != 2 {
+		t.Error("expected requests on a to triple, got", g.Keys)
+	}
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"sync"
+)
+
+// history retains the most recent Reports generated by a Pool.
+// history is threadsafe.
+type history struct {
+	mu sync.Mutex
+	// ring of retained reports, oldest at next once full
+	reports []Report
+	// index at which the next report is stored
+	next int
+	// whether the ring has wrapped around
+	full bool
+}
+
+func newHistory(size int) *history {
+	return &history{reports: make([]Report, size)}
+}
+
+// add retains rep, discarding the oldest report if the history is full.
+func (h *history) add(rep Report) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reports[h.next] = rep
+	h.next++
+	if h.next == len(h.reports) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// snapshot returns the retained reports, oldest first.
+func (h *history) snapshot() []Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]Report(nil), h.reports[:h.next]...)
+	}
+	ret := make([]Report, 0, len(h.reports))
+	ret = append(ret, h.reports[h.next:]...)
+	return append(ret, h.reports[:h.next]...)
+}
+
+// History returns the most recent Reports generated by this Pool, oldest
+// first, up to Config.HistorySize of them.  Each carries the time it was
+// generated, so that successive reports can be compared to find keys whose
+// rank is changing.  History returns nil if the Pool was not configured
+// with a HistorySize.
+//
+// The KeyReports in the returned Reports are shared with earlier callers of
+// Report and must not be modified.
+func (p *Pool) History() []Report {
+	if p.history == nil {
+		return nil
+	}
+	return p.history.snapshot()
+}
@@ -0,0 +1,55 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"sync/atomic"
+)
+
+// DefaultMaxKeyLength is the longest key recorded if Config.MaxKeyLength is
+// not positive, the limit memcached places on keys.
+const DefaultMaxKeyLength = 250
+
+// limitKeys truncates keys in evts longer than the maximum, or discards
+// their events if the Pool was configured with RejectLongKeys, and returns
+// the remaining events.  evts is returned unchanged if no keys are too
+// long.  Events marked LongKeys are never limited.
+func (p *Pool) limitKeys(evts []model.Event) []model.Event {
+	max := p.conf.MaxKeyLength
+	n := 0
+	for _, evt := range evts {
+		if p.tooLong(evt) {
+			n++
+		}
+	}
+	if n == 0 {
+		return evts
+	}
+
+	if !p.conf.RejectLongKeys {
+		atomic.AddInt64(&p.stats.KeysTruncated, int64(n))
+		// truncate in a copy, since the caller may still use evts
+		truncated := make([]model.Event, len(evts))
+		copy(truncated, evts)
+		for i := range truncated {
+			if p.tooLong(truncated[i]) {
+				// copy the prefix so it does not retain the whole key
+				truncated[i].Key = string([]byte(truncated[i].Key[:max]))
+			}
+		}
+		return truncated
+	}
+
+	atomic.AddInt64(&p.stats.KeysRejected, int64(n))
+	rest := make([]model.Event, 0, len(evts)-n)
+	for _, evt := range evts {
+		if !p.tooLong(evt) {
+			rest = append(rest, evt)
+		}
+	}
+	return rest
+}
+
+// tooLong returns whether the key of evt is to be limited.
+func (p *Pool) tooLong(evt model.Event) bool {
+	return len(evt.Key) > p.conf.MaxKeyLength && !evt.LongKeys
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/protocol/model"
+	"strings"
+	"testing"
+)
+
+func TestTruncateLongKeys(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, MaxKeyLength: 4})
+	defer p.Shutdown(context.Background())
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "abcd", Size: 10},
+		{Type: model.EventGetHit, Key: "abcdef", Size: 10},
+	}
+	p.HandleEvents(evts)
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "abcd" || keys[0].RequestsEstimate != 2 {
+		t.Error("expected long key truncated, got", keys)
+	}
+	if evts[1].Key != "abcdef" {
+		t.Error("expected the caller's event not to be truncated, got", evts[1])
+	}
+	if s := p.Stats(); s.KeysTruncated != 1 || s.KeysRejected != 0 {
+		t.Error("expected 1 key truncated, got", s)
+	}
+}
+
+func TestRejectLongKeys(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, RejectLongKeys: true})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: strings.Repeat("x", DefaultMaxKeyLength+1), Size: 10},
+	})
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "a" {
+		t.Error("expected long key rejected, got", keys)
+	}
+	if s := p.Stats(); s.KeysRejected != 1 || s.KeysTruncated != 0 {
+		t.Error("expected 1 key rejected, got", s)
+	}
+}
+
+func TestLongKeysNotLimited(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, RejectLongKeys: true})
+	defer p.Shutdown(context.Background())
+	long := strings.Repeat("x", DefaultMaxKeyLength+1)
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: long, Size: 10, LongKeys: true}})
+	p.Wait()
+	if keys := p.Top(10, MetricRequests); len(keys) != 1 || keys[0].Name != long {
+		t.Error("expected a Redis key to be recorded in full, got", keys)
+	}
+	if s := p.Stats(); s.KeysRejected != 0 || s.KeysTruncated != 0 {
+		t.Error("expected no key limited, got", s)
+	}
+}
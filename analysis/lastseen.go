@@ -0,0 +1,59 @@
+package analysis
+
+import (
+	"time"
+)
+
+// lastSeen remembers when each cache key was last active, in bounded
+// memory.  Once the current generation holds its capacity of keys it
+// replaces the previous generation and a new one is started, so keys not
+// seen for two generations are forgotten.
+type lastSeen struct {
+	capacity int
+	cur      map[keyName]time.Time
+	prev     map[keyName]time.Time
+}
+
+func newLastSeen(capacity int) *lastSeen {
+	if capacity <= 0 {
+		capacity = DefaultSeenKeys
+	}
+	return &lastSeen{
+		capacity: capacity,
+		cur:      make(map[keyName]time.Time),
+	}
+}
+
+// add records activity on kn at t, unless later activity is already known.
+func (ls *lastSeen) add(kn keyName, t time.Time) {
+	if seen, ok := ls.cur[kn]; ok {
+		if t.After(seen) {
+			ls.cur[kn] = t
+		}
+		return
+	}
+	if seen := ls.prev[kn]; seen.After(t) {
+		t = seen
+	}
+	// keys still in use are carried into the current generation
+	ls.cur[kn] = t
+	if len(ls.cur) >= ls.capacity {
+		ls.prev = ls.cur
+		ls.cur = make(map[keyName]time.Time)
+	}
+}
+
+// get returns when kn was last active, or the zero Time if it has been
+// forgotten.
+func (ls *lastSeen) get(kn keyName) time.Time {
+	if t, ok := ls.cur[kn]; ok {
+		return t
+	}
+	return ls.prev[kn]
+}
+
+// reset forgets all keys.
+func (ls *lastSeen) reset() {
+	ls.cur = make(map[keyName]time.Time)
+	ls.prev = nil
+}
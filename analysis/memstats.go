@@ -0,0 +1,57 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/hotlist"
+	"unsafe"
+)
+
+// keyInfoBytes is the memory held by each keyInfo stored in a hotlist, not
+// counting the contents of its strings.
+const keyInfoBytes = int(unsafe.Sizeof(keyInfo{}))
+
+// WorkerMemStats estimates the memory held by the hotlists of one worker.
+type WorkerMemStats struct {
+	// number of entries in the hotlists.  Each type of event is tracked in
+	// a separate hotlist, so a key with both hits and misses is counted
+	// twice.
+	Keys int
+	// estimated bytes held by the hotlists, including the keys they hold,
+	// estimated from the average length of the keys recorded.  Hotlists
+	// that do not implement hotlist.Sizer are not counted.
+	Bytes int
+}
+
+// memStats returns the memory held by the hotlists of this worker, which
+// must be called from the worker's goroutine.
+func (w *worker) memStats() WorkerMemStats {
+	var ms WorkerMemStats
+	for _, hl := range w.lists {
+		s, ok := hl.(hotlist.Sizer)
+		if !ok {
+			continue
+		}
+		ms.Keys += s.Len()
+		ms.Bytes += s.Bytes()
+	}
+	avgKeyBytes := 0
+	if w.recorded > 0 {
+		avgKeyBytes = int(w.keyBytes / w.recorded)
+	}
+	ms.Bytes += ms.Keys * (keyInfoBytes + avgKeyBytes)
+	return ms
+}
+
+// MemStats estimates the memory held by the hotlists of each worker, to
+// watch their growth over time.  Each worker answers between batches of
+// events, so MemStats waits for any batch being recorded.
+func (p *Pool) MemStats() []WorkerMemStats {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	stats := make([]WorkerMemStats, len(p.workers))
+	for i, w := range p.workers {
+		reply := make(chan WorkerMemStats, 1)
+		w.memRequest <- reply
+		stats[i] = <-reply
+	}
+	return stats
+}
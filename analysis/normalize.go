@@ -0,0 +1,45 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NormalizeRule rewrites each match of Pattern in a cache key with
+// Replacement, which may refer to submatches as in regexp.ReplaceAllString.
+type NormalizeRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultNormalizeRules collapses each run of digits in a key to "#", so that
+// for example user:12345:profile and user:67890:profile are both reported as
+// user:#:profile.
+var DefaultNormalizeRules = []NormalizeRule{
+	{regexp.MustCompile(`\d+`), "#"},
+}
+
+// ParseNormalizeRule parses a rule of the form pattern=replacement.
+func ParseNormalizeRule(s string) (NormalizeRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return NormalizeRule{}, fmt.Errorf("normalize rule %q is not of the form pattern=replacement", s)
+	}
+	re, err := regexp.Compile(s[:i])
+	if err != nil {
+		return NormalizeRule{}, err
+	}
+	return NormalizeRule{re, s[i+1:]}, nil
+}
+
+// NewNormalizer returns a function that applies each of rules in turn to a
+// cache key.
+func NewNormalizer(rules []NormalizeRule) func(key string) string {
+	return func(key string) string {
+		for _, r := range rules {
+			key = r.Pattern.ReplaceAllString(key, r.Replacement)
+		}
+		return key
+	}
+}
@@ -0,0 +1,28 @@
+package analysis
+
+import (
+	"testing"
+)
+
+func TestDefaultNormalizeRules(t *testing.T) {
+	normalize := NewNormalizer(DefaultNormalizeRules)
+	for _, key := range []string{"user:12345:profile", "user:6:profile"} {
+		if n := normalize(key); n != "user:#:profile" {
+			t.Error("expected user:#:profile for", key, "got", n)
+		}
+	}
+}
+
+func TestParseNormalizeRule(t *testing.T) {
+	r, err := ParseNormalizeRule(`session:[0-9a-f]+=session:*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := NewNormalizer([]NormalizeRule{r})("session:deadbeef:data"); n != "session:*:data" {
+		t.Error("expected session:*:data, got", n)
+	}
+
+	if _, err := ParseNormalizeRule("no replacement"); err == nil {
+		t.Error("expected error for rule without replacement")
+	}
+}
@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"sync"
+	"time"
+)
+
+// DefaultOversizeInterval is the minimum time between alerts for the same
+// key if Config.OversizeInterval is not positive.
+const DefaultOversizeInterval = time.Minute
+
+// oversizeAlerter reports values larger than a threshold, alerting at most
+// once per interval for each key so that a single pathological key cannot
+// flood the callback.
+// oversizeAlerter is threadsafe.
+type oversizeAlerter struct {
+	threshold int
+	interval  time.Duration
+	alert     func(key string, size int)
+	// current time, replaceable for testing
+	now func() time.Time
+
+	mu sync.Mutex
+	// when each key was last alerted on, including some keys whose
+	// interval has passed until they are pruned
+	last map[string]time.Time
+	// size of last at which to prune it
+	pruneAt int
+}
+
+// minOversizePrune is the smallest size at which the record of alerted keys
+// is pruned.
+const minOversizePrune = 64
+
+func newOversizeAlerter(conf Config) *oversizeAlerter {
+	interval := conf.OversizeInterval
+	if interval <= 0 {
+		interval = DefaultOversizeInterval
+	}
+	return &oversizeAlerter{
+		threshold: conf.OversizeThreshold,
+		interval:  interval,
+		alert:     conf.OnOversize,
+		now:       time.Now,
+		last:      make(map[string]time.Time),
+		pruneAt:   minOversizePrune,
+	}
+}
+
+// observe alerts on any value in evts larger than the threshold.
+func (oa *oversizeAlerter) observe(evts []model.Event) {
+	for _, evt := range evts {
+		switch evt.Type {
+		case model.EventGetHit, model.EventSet:
+			if evt.Size > oa.threshold && oa.allow(evt.Key) {
+				oa.alert(evt.Key, evt.Size)
+			}
+		}
+	}
+}
+
+// allow returns whether an alert for key is due, and if so records it.
+func (oa *oversizeAlerter) allow(key string) bool {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+	now := oa.now()
+	if last, ok := oa.last[key]; ok && now.Sub(last) < oa.interval {
+		return false
+	}
+	oa.last[key] = now
+	if len(oa.last) >= oa.pruneAt {
+		oa.prune(now)
+	}
+	return true
+}
+
+// prune forgets keys whose interval has passed, which would be alerted on
+// again anyway.  Pruning is put off until the record doubles in size, so
+// its cost is spread over the alerts in between.
+func (oa *oversizeAlerter) prune(now time.Time) {
+	for key, last := range oa.last {
+		if now.Sub(last) >= oa.interval {
+			delete(oa.last, key)
+		}
+	}
+	oa.pruneAt = 2 * len(oa.last)
+	if oa.pruneAt < minOversizePrune {
+		oa.pruneAt = minOversizePrune
+	}
+}
@@ -0,0 +1,53 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+	"time"
+)
+
+func TestOversizeAlerts(t *testing.T) {
+	alerts := make(map[string]int)
+	oa := newOversizeAlerter(Config{
+		OversizeThreshold: 100,
+		OnOversize:        func(key string, size int) { alerts[key]++ },
+	})
+	now := time.Unix(1500000000, 0)
+	oa.now = func() time.Time { return now }
+
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "small", Size: 100},
+		{Type: model.EventGetHit, Key: "large", Size: 101},
+		{Type: model.EventSet, Key: "large", Size: 1000},
+		{Type: model.EventSet, Key: "stored", Size: 1000},
+		{Type: model.EventIncr, Key: "counter", Size: 1000},
+	}
+	oa.observe(evts)
+	now = now.Add(DefaultOversizeInterval - time.Second)
+	oa.observe(evts)
+	if len(alerts) != 2 || alerts["large"] != 1 || alerts["stored"] != 1 {
+		t.Error("expected one alert each for large and stored, got", alerts)
+	}
+
+	now = now.Add(time.Second)
+	oa.observe(evts)
+	if alerts["large"] != 2 {
+		t.Error("expected a second alert once the interval passed, got", alerts)
+	}
+}
+
+func TestOversizePrunesExpiredKeys(t *testing.T) {
+	oa := newOversizeAlerter(Config{
+		OversizeThreshold: 1,
+		OnOversize:        func(key string, size int) {},
+	})
+	now := time.Unix(1500000000, 0)
+	oa.now = func() time.Time { return now }
+	for i := 0; i < 10*minOversizePrune; i++ {
+		oa.allow(string(rune('a' + i)))
+		now = now.Add(DefaultOversizeInterval / minOversizePrune)
+	}
+	if len(oa.last) > 2*minOversizePrune {
+		t.Error("expected expired keys pruned, got", len(oa.last))
+	}
+}
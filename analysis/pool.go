@@ -3,14 +3,22 @@
 package analysis
 
 import (
+	"context"
+	"github.com/box/memsniff/hotlist"
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/protocol/model"
 	"hash/fnv"
+	"regexp"
+	"runtime"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Pool tracks datastore activity by hashing inputs to fixed workers.
-// The number of workers is determined when the Pool is created.
+// The number of workers is determined when the Pool is created, and may be
+// changed by Reconfigure.
 // The implementation prioritizes responsiveness over consistency,
 // and data is dropped if the rate of input is too high to be handled
 // by the Pool.
@@ -18,9 +26,63 @@ type Pool struct {
 	// A Logger instance for debugging.  No logging is done if nil.
 	Logger     log.Logger
 	reportSize int
-	workers    []worker
-	filter     filter
-	stats      Stats
+	minWeight  int
+	mode       WeightMode
+	windowed   bool
+	normalize  func(key string) string
+	redact     func(key string) string
+	// whether activity is attributed to clients alone, so that events are
+	// divided among workers by client rather than by key
+	byClient bool
+	// reads per write at which keys are classified as read-dominated, or
+	// zero if access is not classified
+	readWriteRatio float64
+	// upper bounds of value size histogram buckets, if reported
+	sizeBuckets []int
+	// factor by which estimates are scaled to account for sampling
+	scale   float64
+	workers []worker
+	filter  filter
+	stats   Stats
+	// recent reports, if retained
+	history *history
+	// detects scanning connections, if enabled
+	scans *scanDetector
+	// alerts on oversized values, if enabled
+	oversize *oversizeAlerter
+	// alerts on keys exceeding a bandwidth limit, if enabled
+	rates *rateDetector
+	// activity by key prefix, if enabled
+	prefixTree *prefixTree
+	// requests to flush each cluster
+	flushes *flushCounter
+	// drops and evictions by workers replaced by Reconfigure
+	retired Stats
+	// aggregate activity across all keys
+	summary *summaryCounters
+	// whether summary is kept across resets
+	monotonicSummary bool
+
+	// configuration with defaults applied, from which workers are created
+	conf     Config
+	prefixes *prefixFilter
+
+	// held for reading while events are passed to workers, and for writing
+	// while shutting down
+	shutdownLock sync.RWMutex
+	// whether Shutdown has been called
+	shutdown bool
+	// held for reading while collecting results from workers, and for
+	// writing while resetting them, so that no report mixes workers that
+	// have been reset with workers that have not
+	resetLock sync.RWMutex
+
+	// guards carried and carriedAt
+	carriedLock sync.Mutex
+	// busiest keys of workers replaced by Reconfigure, not yet reset
+	carried []KeyReport
+	// when carried was collected
+	carriedAt time.Time
 }
 
 // Stats contains performance metrics for a Pool.
@@ -29,6 +91,17 @@ type Stats struct {
 	EventsHandled int64
 	// number of events sent to HandleEvents that were discarded
 	EventsDropped int64
+	// number of batches of cache keys discarded by workers
+	BatchesDropped int64
+	// number of cache keys discarded by workers
+	KeysDropped int64
+	// number of tracked keys discarded to bound memory, if Config.MaxKeys
+	// is set
+	KeysEvicted int64
+	// number of events whose keys were longer than Config.MaxKeyLength,
+	// and were truncated, or discarded if Config.RejectLongKeys is set
+	KeysTruncated int64
+	KeysRejected  int64
 }
 
 func (s *Stats) addHandled(n int) {
@@ -40,21 +113,312 @@ func (s *Stats) addDropped(n int) {
 
 }
 
-// New returns a new Pool.
-//
-// numWorkers determines the number of workers to hotlists to create.  More
-// workers gives more potential parallelism and performance, but increased
-// memory consumption.
-//
-// reportSize determines the number of entries returned from Report.
-func New(numWorkers, reportSize int) *Pool {
+// DefaultQueueSize is the number of event batches each worker can buffer if
+// Config.QueueSize is not positive.
+const DefaultQueueSize = 1024
+
+// Config determines the behavior of a Pool.
+type Config struct {
+	// Workers determines the number of workers to hotlists to create.  More
+	// workers gives more potential parallelism and performance, but increased
+	// memory consumption.  One worker for each CPU Go schedules goroutines
+	// on, as set by GOMAXPROCS, is created if Workers is not positive.
+	Workers int
+	// ReportSize determines the number of entries returned from Report.
+	ReportSize int
+	// MinWeight, if positive, leaves out of Report, Top, TopAndReset and
+	// TopContext keys measuring less than MinWeight by the metric they are
+	// ranked by, even if fewer keys are returned than requested, so that
+	// quiet periods are not padded with keys seen only once or twice.
+	MinWeight int
+	// WeightMode determines whether keys are ranked by bandwidth or by number
+	// of requests.
+	WeightMode WeightMode
+	// WeightFunc, if not nil, gives the weight of each value returned or
+	// stored in WeightBytes mode in place of its size, such as
+	// WeightSizeLogSize to rank keys whose large values are the problem
+	// above keys with many small values.  Weights are capped at MaxWeight.
+	// Reports still give each key's actual traffic in bytes.
+	WeightFunc func(size int) int
+	// Dimension determines whether activity is attributed to cache keys,
+	// client addresses, or both.
+	Dimension Dimension
+	// Clusters, if not nil, attributes activity to the cluster of the
+	// server it was sent to, so that the same key on different clusters
+	// is reported separately, as KeyReport.Cluster.  Clusters names the
+	// cluster of each server address, and servers not listed are each
+	// reported as their own cluster named by their address.  If Clusters
+	// is nil, activity on all servers is combined.
+	Clusters map[string]string
+	// ByInterface, if set, also attributes activity to the network
+	// interface it was captured on, so that the same key seen on
+	// different interfaces is reported separately.  The interface is
+	// reported as KeyReport.Cluster, after the cluster of the server and
+	// an "@" if Clusters is not nil.  Events naming no interface, as when
+	// capturing on only one, are not divided.
+	ByInterface bool
+	// ResetOnFlush, if set, resets the Pool whenever a request to flush a
+	// server is seen, so that reports describe the activity since the
+	// cache was emptied.  Hotlists do not divide their keys by cluster, so
+	// activity on every cluster is reset, not just the one flushed.
+	// Flushes are counted by cluster regardless, as reported by Flushes.
+	ResetOnFlush bool
+	// NormalizeKey, if not nil, rewrites each cache key before it is
+	// recorded, so that activity on related keys is reported as a single
+	// family.  Filtering still applies to the original key.  See
+	// NewNormalizer.
+	NormalizeKey func(key string) string
+	// RedactPatterns, if not empty, replaces every match of each pattern in
+	// a cache key with RedactMask before the key is recorded, and before
+	// NormalizeKey is applied.  Keys that are identical once redacted are
+	// tracked and reported as one, marked as Redacted.
+	RedactPatterns []*regexp.Regexp
+	// QueueSize is the number of batches of events each worker can buffer
+	// before further input is dropped.  Each slot holds a batch of up to a
+	// few dozen events at roughly 32 bytes apiece plus the key data, so
+	// large queues can cost several MiB per worker when full.
+	// DefaultQueueSize is used if QueueSize is not positive.
+	QueueSize int
+	// BlockTimeout, if positive, makes HandleEvents wait up to this long
+	// for space in a full worker queue before dropping the events.  This
+	// trades liveness for correctness: blocking slows the caller, which
+	// during live capture only moves the loss to the kernel's packet
+	// buffer, but when replaying a file it keeps the analysis complete.
+	BlockTimeout time.Duration
+	// NewHotList creates the hotlists used by each worker to track cache
+	// keys.  hotlist.NewPerfect is used if NewHotList is nil.
+	NewHotList func() hotlist.HotList
+	// MaxKeys, if positive and NewHotList is nil, bounds the number of keys
+	// each hotlist of each worker tracks, using hotlist.NewBoundedPerfect.
+	// Reports are exact until the bound is reached, then approximate;
+	// Stats.KeysEvicted counts the keys discarded.
+	MaxKeys int
+	// Window, if positive, limits reports to activity within a sliding
+	// window of this length.  Data ages out of the window automatically,
+	// so reports do not reset the Pool.
+	Window time.Duration
+	// WindowBuckets is the number of hotlists each worker divides the
+	// window into.  More buckets let the window slide more smoothly at the
+	// cost of more memory.  DefaultWindowBuckets is used if WindowBuckets is
+	// not positive.  Buckets last at least a nanosecond, however short the
+	// window.
+	WindowBuckets int
+	// TrackSets enables tracking the busiest keys by storage commands in
+	// addition to retrievals, and the keys whose storage commands most
+	// often fail.
+	TrackSets bool
+	// TrackDeletes enables tracking the keys most frequently deleted.
+	TrackDeletes bool
+	// TrackCounters enables tracking the keys most frequently incremented
+	// or decremented.
+	TrackCounters bool
+	// TrackConnections enables tracking the busiest client connections, as
+	// reported by TopConnections.
+	TrackConnections bool
+	// TrackCAS enables tracking the keys most frequently updated by
+	// compare-and-swap, and how often those updates conflict.
+	TrackCAS bool
+	// TrackGets enables tracking retrievals whose outcome is unknown, as
+	// decoded when only requests are captured, reported as
+	// RequestsEstimate.  They have no value size, so keys are best ranked
+	// with WeightCount.
+	TrackGets bool
+	// ReadWriteRatio, if positive, classifies the Access of each reported
+	// key by comparing its gets, whether hits or misses, with the storage,
+	// delete, counter and compare-and-swap commands that modify it.  Keys
+	// with at least ReadWriteRatio gets per write are read-dominated.
+	// Writes are counted for every key, independent of TrackSets and
+	// similar options.
+	ReadWriteRatio float64
+	// SampleRate, if between 0 and 1, is the fraction of traffic passed to
+	// the Pool, as when only some flows are analyzed.  Reported estimates
+	// are scaled up by its inverse to approximate the totals.
+	SampleRate float64
+	// SizeBuckets, if not empty, are the upper bounds in bytes of the value
+	// size buckets in KeyReport.SizeHistogram.
+	SizeBuckets []int
+	// HistorySize, if positive, is the number of most recent Reports the
+	// Pool retains for History.
+	HistorySize int
+	// ScanThreshold, if positive, enables flagging connections whose gets
+	// are nearly all for distinct keys, as reported by Scanning.  A
+	// connection is flagged when the fraction of distinct keys among its
+	// last ScanWindow gets is at least ScanThreshold, such as 0.95.
+	ScanThreshold float64
+	// ScanWindow is the number of gets per connection over which distinct
+	// keys are counted.  DefaultScanWindow is used if ScanWindow is not
+	// positive.
+	ScanWindow int
+	// ExcludeScans discards all events from connections currently flagged
+	// as scanning, so that their one-off keys do not crowd out hot keys.
+	ExcludeScans bool
+	// AllowPrefixes, if not empty, limits tracking to cache keys starting
+	// with one of these prefixes.  Untracked keys use no memory in the
+	// hotlists.
+	AllowPrefixes []string
+	// DenyPrefixes excludes cache keys starting with any of these prefixes
+	// from tracking, even if they also match AllowPrefixes.
+	DenyPrefixes []string
+	// MonotonicSummary keeps the counts reported by Summary across resets,
+	// so that they cover all activity since the Pool was created.
+	MonotonicSummary bool
+	// TrackNewKeys counts keys not seen before in Summary.
+	TrackNewKeys bool
+	// OversizeThreshold, if positive, calls OnOversize for each value
+	// returned or stored that is larger than this many bytes, such as the
+	// 1 MiB default item size limit of memcached.  Oversized values can
+	// cause eviction storms.
+	OversizeThreshold int
+	// OnOversize receives the key and size of oversized values.  It is
+	// called at most once per OversizeInterval for each key, after
+	// RedactPatterns are applied but before NormalizeKey, from the
+	// goroutine calling HandleEvents, and so must not block.
+	OnOversize func(key string, size int)
+	// OversizeInterval is the minimum time between calls to OnOversize for
+	// the same key.  DefaultOversizeInterval is used if OversizeInterval is
+	// not positive.
+	OversizeInterval time.Duration
+	// RateRules, if not empty, calls OnRateExceeded when the bandwidth of
+	// values returned and stored for keys starting with a rule's prefix
+	// exceeds the rule's limit, to find noisy neighbours that are
+	// candidates for rate limiting.
+	RateRules []RateRule
+	// OnRateExceeded receives the rule exceeded, the key or, for a
+	// Combined rule, the prefix exceeding it, and the observed rate.  It
+	// is called at most once per RateWindow for each key and rule, after
+	// RedactPatterns are applied but before NormalizeKey, from the
+	// goroutine calling HandleEvents, and so must not block.
+	OnRateExceeded func(rule RateRule, key string, bytesPerSec float64)
+	// RateWindow is the sliding window over which rates are measured for
+	// RateRules.  DefaultRateWindow is used if RateWindow is not positive.
+	RateWindow time.Duration
+	// TrackLastSeen reports when each key was last active, as
+	// KeyReport.LastSeen.  Activity is timed by model.Event.Timestamp, or by
+	// the time the event was handled if it has none.  Each worker remembers
+	// up to twice MaxKeys keys, or twice DefaultSeenKeys if MaxKeys is not
+	// positive, and older keys are forgotten.
+	TrackLastSeen bool
+	// TrackFanout reports the number of distinct client addresses sending
+	// commands for each key, as KeyReport.ClientsEstimate, to distinguish
+	// keys hot for a single client from keys hot for many.  Clients are
+	// counted since the last reset, even in a Pool configured with a
+	// sliding window, and are not scaled for sampling.  Each worker
+	// remembers the clients of up to twice FanoutKeys keys, using at most
+	// about 1KB for each key.
+	TrackFanout bool
+	// FanoutKeys is the number of keys whose clients each worker counts
+	// when TrackFanout is set, so that the busiest keys are remembered.
+	// DefaultFanoutKeys is used if FanoutKeys is not positive.
+	FanoutKeys int
+	// Examples, if positive and NormalizeKey is not nil, retains up to this
+	// many of the original keys in each family, reported as
+	// KeyReport.Examples.  The examples are sampled uniformly from the
+	// events on the family, so busier keys are more likely to be included.
+	// Each worker remembers examples for up to twice MaxKeys families, or
+	// twice DefaultSeenKeys if MaxKeys is not positive.
+	Examples int
+	// PrefixDepth, if positive, aggregates activity by the leading segments
+	// of keys, up to this many segments deep, as reported by TopPrefixes.
+	// Keys are divided into segments after each of PrefixDelimiters.
+	PrefixDepth int
+	// PrefixBreadth bounds the number of longer prefixes tracked under each
+	// prefix, so that at most PrefixBreadth to the power of PrefixDepth
+	// prefixes are tracked.  Further prefixes are combined as OtherPrefix.
+	// The top level prefixes are divided among the workers, each tracking
+	// an equal share of PrefixBreadth, so some may be combined sooner.
+	// DefaultPrefixBreadth is used if PrefixBreadth is not positive.
+	PrefixBreadth int
+	// PrefixDelimiters are the characters ending each segment of a key.
+	// DefaultPrefixDelimiters is used if PrefixDelimiters is empty.
+	PrefixDelimiters string
+	// MaxKeyLength is the longest key recorded, in bytes, so that a
+	// malformed or hostile conversation cannot fill the hotlists with huge
+	// keys.  Longer keys are truncated to MaxKeyLength, as counted by
+	// Stats.KeysTruncated, before any other analysis.  DefaultMaxKeyLength
+	// is used if MaxKeyLength is not positive.  The keys of events marked
+	// LongKeys, such as those of Redis, are not limited.
+	MaxKeyLength int
+	// RejectLongKeys discards events on keys longer than MaxKeyLength
+	// rather than truncating their keys, as counted by Stats.KeysRejected.
+	RejectLongKeys bool
+	// SeenKeys is the number of distinct keys remembered when TrackNewKeys
+	// is set.  Up to twice this many are remembered at a cost of about
+	// 2.5 bytes per key, and older keys are forgotten.  DefaultSeenKeys is
+	// used if SeenKeys is not positive.
+	SeenKeys int
+}
+
+// DefaultWindowBuckets is the number of buckets in a sliding window if
+// Config.WindowBuckets is not positive.
+const DefaultWindowBuckets = 6
+
+// New returns a new Pool configured by conf.
+func New(conf Config) *Pool {
+	if conf.Workers <= 0 {
+		conf.Workers = runtime.GOMAXPROCS(0)
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = DefaultQueueSize
+	}
+	if conf.NewHotList == nil {
+		conf.NewHotList = hotlist.NewPerfect
+		if conf.MaxKeys > 0 {
+			conf.NewHotList = func() hotlist.HotList {
+				return hotlist.NewBoundedPerfect(conf.MaxKeys)
+			}
+		}
+	}
+	if conf.WindowBuckets <= 0 {
+		conf.WindowBuckets = DefaultWindowBuckets
+	}
+	if conf.MaxKeyLength <= 0 {
+		conf.MaxKeyLength = DefaultMaxKeyLength
+	}
+
 	c := &Pool{
-		reportSize: reportSize,
-		workers:    make([]worker, numWorkers),
+		reportSize: conf.ReportSize,
+		minWeight:  conf.MinWeight,
+		conf:       conf,
+		mode:       conf.WeightMode,
+		windowed:   conf.Window > 0,
+		normalize:  conf.NormalizeKey,
+		redact:     newRedactor(conf.RedactPatterns),
+		byClient:   conf.Dimension == DimensionClient,
+		workers:    make([]worker, conf.Workers),
+		scale:      1,
+
+		readWriteRatio: conf.ReadWriteRatio,
+
+		summary:          newSummaryCounters(conf),
+		flushes:          newFlushCounter(conf),
+		monotonicSummary: conf.MonotonicSummary,
+	}
+	if conf.SampleRate > 0 && conf.SampleRate < 1 {
+		c.scale = 1 / conf.SampleRate
+	}
+	if len(conf.SizeBuckets) > 0 {
+		c.sizeBuckets = append([]int(nil), conf.SizeBuckets...)
+		sort.Ints(c.sizeBuckets)
+	}
+	if conf.HistorySize > 0 {
+		c.history = newHistory(conf.HistorySize)
+	}
+	if conf.ScanThreshold > 0 {
+		c.scans = newScanDetector(conf)
+	}
+	if conf.OversizeThreshold > 0 && conf.OnOversize != nil {
+		c.oversize = newOversizeAlerter(conf)
+	}
+	if len(conf.RateRules) > 0 && conf.OnRateExceeded != nil {
+		c.rates = newRateDetector(conf)
+	}
+	if conf.PrefixDepth > 0 {
+		c.prefixTree = newPrefixTree(conf)
 	}
 
-	for i := 0; i < numWorkers; i++ {
-		c.workers[i] = newWorker()
+	c.prefixes = newPrefixFilter(conf.AllowPrefixes, conf.DenyPrefixes)
+	for i := 0; i < conf.Workers; i++ {
+		c.workers[i] = newWorker(conf, c.prefixes)
 	}
 
 	return c
@@ -64,11 +428,61 @@ func New(numWorkers, reportSize int) *Pool {
 //
 // The events will be dispatched to their assigned workers.  If a worker
 // is overloaded, all inputs for that worker  will be discarded and statistics
-// for this Pool updated to reflect the lost data.
+// for this Pool updated to reflect the lost data.  If the Pool was configured
+// with a BlockTimeout, HandleEvents first waits up to that long for the
+// worker to catch up.  evts is not modified.
 //
 // HandleEvents is threadsafe.
 func (p *Pool) HandleEvents(evts []model.Event) {
-	perWorkerEvents := p.partitionEvents(p.filter.filterEvents(evts))
+	p.shutdownLock.RLock()
+	defer p.shutdownLock.RUnlock()
+	if p.shutdown {
+		p.stats.addDropped(len(evts))
+		return
+	}
+
+	evts = p.limitKeys(evts)
+	// flushes are not activity on any key, so count them before filtering
+	evts, flushed := p.flushes.observe(evts)
+	if flushed && p.conf.ResetOnFlush {
+		p.Reset()
+	}
+	evts = p.filter.filterEvents(evts)
+	p.summary.add(evts)
+	if p.scans != nil {
+		// before normalizing, which would make distinct keys look alike
+		evts = p.scans.observe(evts)
+	}
+	if p.redact != nil || p.normalize != nil {
+		// rewrite keys in a copy, since the caller may still use evts
+		evts = append([]model.Event(nil), evts...)
+	}
+	if p.redact != nil {
+		// redact before normalizing, which may alter the parts of the key
+		// the patterns are meant to match
+		p.redactEvents(evts)
+	}
+	if p.oversize != nil {
+		p.oversize.observe(evts)
+	}
+	if p.rates != nil {
+		p.rates.observe(evts)
+	}
+	if p.normalize != nil {
+		// normalize before partitioning so each family is tracked by a
+		// single worker
+		for i := range evts {
+			if p.conf.Examples > 0 {
+				evts[i].Original = evts[i].Key
+			}
+			evts[i].Key = p.normalize(evts[i].Key)
+		}
+	}
+	if p.prefixTree != nil {
+		// after normalizing, so that the prefixes of a family are combined
+		p.prefixTree.observe(evts)
+	}
+	perWorkerEvents := p.partitionEvents(evts)
 	for i, events := range perWorkerEvents {
 		if len(events) > 0 {
 			err := p.workers[i].handleEvents(events)
@@ -85,6 +499,9 @@ func (p *Pool) partitionEvents(evts []model.Event) [][]model.Event {
 	perWorkerEvents := make([][]model.Event, len(p.workers))
 	for _, e := range evts {
 		slot := p.keySlot(e.Key)
+		if p.byClient {
+			slot = p.keySlot(e.Client)
+		}
 		perWorkerEvents[slot] = append(perWorkerEvents[slot], e)
 	}
 	return perWorkerEvents
@@ -104,27 +521,128 @@ func (p *Pool) SetFilterPattern(pattern string) error {
 	return nil
 }
 
-// Reset clears all recorded activity from this Pool.  This operation is
-// asynchronous, and may still be in progress when Reset returns.  New data
-// added by calling HandleGetResponse after Reset returns may be lost, and
-// results from Report immediately after a call to Reset may still contain some
-// information recorded before the call to Reset.
+// Reset clears all recorded activity from this Pool.  Reset waits for
+// reports already being collected to finish, and reports requested while
+// Reset is in progress wait for it, so no report includes some workers from
+// before the reset and others from after.  Events still queued for workers
+// when Reset is called may be recorded either before or after the reset.
 func (p *Pool) Reset() {
+	p.resetLock.Lock()
+	defer p.resetLock.Unlock()
 	for _, w := range p.workers {
 		w.reset()
 	}
+	p.takeCarried(true)
+	if p.scans != nil {
+		p.scans.reset()
+	}
+	p.resetSummary()
+	p.resetPrefixes()
+}
+
+// resetPrefixes clears the activity recorded by prefix, if tracked.
+func (p *Pool) resetPrefixes() {
+	if p.prefixTree != nil {
+		p.prefixTree.reset()
+	}
+}
+
+// resetSummary begins a new period for Summary, unless the Pool was
+// configured with MonotonicSummary.
+func (p *Pool) resetSummary() {
+	if !p.monotonicSummary {
+		p.summary.reset()
+	}
 }
 
 // Stats returns a record of total activity reported to this Pool, including
 // input that was dropped due to not keeping up.
 func (p *Pool) Stats() Stats {
-	return p.stats
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	s := p.retired
+	s.EventsHandled = atomic.LoadInt64(&p.stats.EventsHandled)
+	s.EventsDropped = atomic.LoadInt64(&p.stats.EventsDropped)
+	s.KeysTruncated = atomic.LoadInt64(&p.stats.KeysTruncated)
+	s.KeysRejected = atomic.LoadInt64(&p.stats.KeysRejected)
+	for i := range p.workers {
+		batches, keys := p.workers[i].dropped()
+		s.BatchesDropped += batches
+		s.KeysDropped += keys
+		s.KeysEvicted += p.workers[i].evicted()
+	}
+	return s
+}
+
+// Wait blocks until all events previously passed to HandleEvents have been
+// recorded, so that a subsequent Report or Top includes them.
+func (p *Pool) Wait() {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	// cannot fail without a deadline
+	_ = p.drain(context.Background())
+}
+
+// Shutdown stops the Pool from accepting new events, waits for workers to
+// record all events already queued, and returns a final Report.  If ctx
+// expires first, the Report includes only the events recorded so far and
+// ctx.Err() is returned.
+//
+// The Pool must not be used after Shutdown.
+func (p *Pool) Shutdown(ctx context.Context) (Report, error) {
+	p.shutdownLock.Lock()
+	p.shutdown = true
+	p.shutdownLock.Unlock()
+
+	err := p.drain(ctx)
+	rep := p.Report(false)
+	for i := range p.workers {
+		p.workers[i].close()
+	}
+	return rep, err
+}
+
+// drain waits until every worker queue is empty, or ctx expires.
+func (p *Pool) drain(ctx context.Context) error {
+	for i := range p.workers {
+		// a worker finishes recording its current batch before answering
+		// requests, so an empty queue is sufficient
+		for p.workers[i].queueDepth() > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// QueueDepths returns the number of event batches waiting in the queue of
+// each worker.  Queues that stay near Config.QueueSize, together with the
+// drop counts in Stats, suggest more workers or larger queues are needed.
+//
+// QueueDepths does not block and does not interrupt the workers, so the
+// depths may already be stale when it returns.
+func (p *Pool) QueueDepths() []int {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	depths := make([]int, len(p.workers))
+	for i := range p.workers {
+		depths[i] = p.workers[i].queueDepth()
+	}
+	return depths
 }
 
 func (p *Pool) keySlot(key string) int {
+	return shardOf(key, len(p.workers))
+}
+
+// shardOf returns which of n shards key belongs to.
+func shardOf(key string, n int) int {
 	hash := fnv.New64a()
 	// writing to a Hash can never fail
 	_, _ = hash.Write([]byte(key))
-	h := hash.Sum64() % uint64(len(p.workers))
+	h := hash.Sum64() % uint64(n)
 	return int(h)
 }
@@ -0,0 +1,60 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+)
+
+// prefixFilter selects cache keys by prefix.  A key is accepted if it
+// starts with any allowed prefix, or if there are none, unless it starts
+// with any denied prefix.
+type prefixFilter struct {
+	allow []string
+	deny  []string
+}
+
+// newPrefixFilter returns a prefixFilter for the given prefixes, or nil if
+// there are none, in which case every key is accepted.
+func newPrefixFilter(allow, deny []string) *prefixFilter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	return &prefixFilter{
+		allow: compactPrefixes(allow),
+		deny:  compactPrefixes(deny),
+	}
+}
+
+// compactPrefixes returns prefixes without those made redundant by a
+// shorter prefix, so that fewer comparisons are needed for each key.
+func compactPrefixes(prefixes []string) []string {
+	sorted := append([]string(nil), prefixes...)
+	sort.Strings(sorted)
+	var ret []string
+	for _, p := range sorted {
+		// a prefix sorts immediately before the strings it covers
+		if len(ret) > 0 && strings.HasPrefix(p, ret[len(ret)-1]) {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// accept returns whether key should be tracked.  Denied prefixes take
+// precedence over allowed prefixes.
+func (f *prefixFilter) accept(key string) bool {
+	if hasAnyPrefix(key, f.deny) {
+		return false
+	}
+	return len(f.allow) == 0 || hasAnyPrefix(key, f.allow)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
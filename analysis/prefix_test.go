@@ -0,0 +1,69 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestNoPrefixesTracksAll(t *testing.T) {
+	if f := newPrefixFilter(nil, nil); f != nil {
+		t.Error("expected nil filter without prefixes, got", f)
+	}
+}
+
+func TestAllowPrefixes(t *testing.T) {
+	f := newPrefixFilter([]string{"user:", "session:"}, nil)
+	for _, key := range []string{"user:1", "session:abc", "user:"} {
+		if !f.accept(key) {
+			t.Error("expected to accept", key)
+		}
+	}
+	for _, key := range []string{"item:1", "use", ""} {
+		if f.accept(key) {
+			t.Error("expected to reject", key)
+		}
+	}
+}
+
+func TestDenyPrefixes(t *testing.T) {
+	f := newPrefixFilter(nil, []string{"tmp:"})
+	if f.accept("tmp:1") {
+		t.Error("expected to reject denied key")
+	}
+	if !f.accept("user:1") {
+		t.Error("expected to accept key not denied")
+	}
+}
+
+func TestDenyTakesPrecedence(t *testing.T) {
+	f := newPrefixFilter([]string{"user:"}, []string{"user:admin"})
+	if f.accept("user:admin:1") {
+		t.Error("expected denied prefix to override allowed prefix")
+	}
+	if !f.accept("user:1") {
+		t.Error("expected to accept allowed key")
+	}
+}
+
+func TestCompactPrefixes(t *testing.T) {
+	got := compactPrefixes([]string{"user:1", "item:", "user:", "user:12"})
+	if len(got) != 2 || got[0] != "item:" || got[1] != "user:" {
+		t.Error("expected [item: user:], got", got)
+	}
+}
+
+func TestWorkerSkipsFilteredKeys(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, 1)
+	w.prefixes = newPrefixFilter([]string{"user:"}, nil)
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "user:1", Size: 1},
+		{Type: model.EventGetHit, Key: "item:1", Size: 1},
+	}
+	if err := w.handleEvents(evts); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if kis := <-w.kisChan; len(kis) != 1 || kis[0].ki.name != "user:1" {
+		t.Error("expected only the allowed key to be queued, got", kis)
+	}
+}
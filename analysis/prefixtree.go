@@ -0,0 +1,287 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultPrefixBreadth is the number of children each prefix may have if
+// Config.PrefixBreadth is not positive.
+const DefaultPrefixBreadth = 100
+
+// DefaultPrefixDelimiters separate the segments of a key into prefixes if
+// Config.PrefixDelimiters is empty.
+const DefaultPrefixDelimiters = ":"
+
+// OtherPrefix names the child that combines the activity of a prefix's keys
+// once it has as many children as it may have.
+const OtherPrefix = "(other)"
+
+// PrefixReport is the activity on all keys starting with a prefix.
+type PrefixReport struct {
+	// the prefix, ending with a delimiter unless it is a whole key, or
+	// ending with OtherPrefix if it combines the children of a prefix
+	// beyond the limit
+	Prefix string
+	// number of requests on keys with this prefix
+	Requests int
+	// bytes of values retrieved and stored for keys with this prefix
+	Bytes int
+	// number of longer prefixes tracked under this one, which may be
+	// expanded with TopPrefixes
+	Children int
+}
+
+// prefixNode is the activity on keys starting with one prefix.
+type prefixNode struct {
+	requests int
+	bytes    int
+	children map[string]*prefixNode
+}
+
+// prefixTree aggregates activity by the leading segments of keys, in a trie
+// bounded in depth and in the breadth of each node.
+//
+// The trie is divided among shards by the first segment of each key, one
+// for each worker of the Pool, so that concurrent callers rarely wait for
+// each other.  Each shard holds its share of the top level prefixes, and
+// everything below them.  prefixTree is threadsafe.
+type prefixTree struct {
+	depth      int
+	breadth    int
+	delimiters string
+	// breadth of the root of each shard
+	rootBreadth int
+
+	shards []prefixShard
+}
+
+// prefixShard is the part of a prefixTree for some of the top level
+// prefixes.
+type prefixShard struct {
+	mu   sync.Mutex
+	root *prefixNode
+}
+
+func newPrefixTree(conf Config) *prefixTree {
+	shards := conf.Workers
+	if shards <= 0 {
+		shards = 1
+	}
+	pt := &prefixTree{
+		depth:      conf.PrefixDepth,
+		breadth:    conf.PrefixBreadth,
+		delimiters: conf.PrefixDelimiters,
+		shards:     make([]prefixShard, shards),
+	}
+	if pt.breadth <= 0 {
+		pt.breadth = DefaultPrefixBreadth
+	}
+	if pt.delimiters == "" {
+		pt.delimiters = DefaultPrefixDelimiters
+	}
+	pt.rootBreadth = (pt.breadth + shards - 1) / shards
+	for i := range pt.shards {
+		pt.shards[i].root = &prefixNode{}
+	}
+	return pt
+}
+
+// observe adds evts to the prefixes of their keys.
+func (pt *prefixTree) observe(evts []model.Event) {
+	perShard := make([][]model.Event, len(pt.shards))
+	for _, evt := range evts {
+		if evt.Key == "" {
+			continue
+		}
+		seg, _ := pt.split(evt.Key)
+		s := shardOf(seg, len(pt.shards))
+		perShard[s] = append(perShard[s], evt)
+	}
+	for i, evts := range perShard {
+		if len(evts) > 0 {
+			pt.shards[i].observe(pt, evts)
+		}
+	}
+}
+
+// observe adds evts, whose keys all belong to this shard, to their
+// prefixes.
+func (ps *prefixShard) observe(pt *prefixTree, evts []model.Event) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, evt := range evts {
+		bytes := 0
+		if evt.Type == model.EventGetHit || evt.Type == model.EventSet {
+			bytes = evt.Size
+		}
+		n := ps.root
+		n.requests++
+		n.bytes += bytes
+		rest := evt.Key
+		for d := 0; d < pt.depth && rest != ""; d++ {
+			var seg string
+			seg, rest = pt.split(rest)
+			breadth := pt.breadth
+			if d == 0 {
+				breadth = pt.rootBreadth
+			}
+			var other bool
+			n, other = n.child(seg, breadth)
+			n.requests++
+			n.bytes += bytes
+			if other {
+				// combined prefixes have nothing in common to expand
+				break
+			}
+		}
+	}
+}
+
+// split returns the first segment of key, including its delimiter, and the
+// rest of key.
+func (pt *prefixTree) split(key string) (string, string) {
+	i := strings.IndexAny(key, pt.delimiters)
+	if i < 0 {
+		return key, ""
+	}
+	return key[:i+1], key[i+1:]
+}
+
+// child returns the child of n for the next segment seg of a key, creating
+// it if n has fewer than breadth children, and otherwise returning the
+// child combining the rest, with other set.
+func (n *prefixNode) child(seg string, breadth int) (c *prefixNode, other bool) {
+	if c, ok := n.children[seg]; ok {
+		return c, seg == OtherPrefix
+	}
+	if n.children == nil {
+		n.children = make(map[string]*prefixNode)
+	}
+	if len(n.children) >= breadth {
+		seg, other = OtherPrefix, true
+		if c, ok := n.children[seg]; ok {
+			return c, true
+		}
+	}
+	// copy the segment so it does not retain the whole key
+	c = &prefixNode{}
+	n.children[string([]byte(seg))] = c
+	return c, other
+}
+
+// top returns up to k of the children of prefix in descending order by
+// metric, or nil if prefix is not tracked.
+func (pt *prefixTree) top(prefix string, k int, by Metric) []PrefixReport {
+	var prs []PrefixReport
+	if prefix == "" {
+		// the top level prefixes are spread across every shard
+		prs = []PrefixReport{}
+		for i := range pt.shards {
+			prs = pt.shards[i].children(pt, prefix, prs)
+		}
+		prs = mergePrefixes(prs)
+	} else {
+		seg, _ := pt.split(prefix)
+		prs = pt.shards[shardOf(seg, len(pt.shards))].children(pt, prefix, nil)
+	}
+	sort.Sort(prefixesByMetric{prs, by})
+	if len(prs) > k {
+		prs = prs[:k]
+	}
+	return prs
+}
+
+// children appends the children of prefix in this shard to prs, or returns
+// prs unchanged if prefix is not tracked here.  prs is nil if it was nil
+// and prefix is not tracked.
+func (ps *prefixShard) children(pt *prefixTree, prefix string, prs []PrefixReport) []PrefixReport {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	n := ps.root
+	for rest := prefix; rest != ""; {
+		var seg string
+		seg, rest = pt.split(rest)
+		c, ok := n.children[seg]
+		if !ok {
+			return prs
+		}
+		n = c
+	}
+	if prs == nil {
+		prs = make([]PrefixReport, 0, len(n.children))
+	}
+	for seg, c := range n.children {
+		prs = append(prs, PrefixReport{
+			Prefix:   prefix + seg,
+			Requests: c.requests,
+			Bytes:    c.bytes,
+			Children: len(c.children),
+		})
+	}
+	return prs
+}
+
+// mergePrefixes combines the reports of the same prefix from different
+// shards, which only OtherPrefix can have.
+func mergePrefixes(prs []PrefixReport) []PrefixReport {
+	merged := prs[:0]
+	index := make(map[string]int, len(prs))
+	for _, pr := range prs {
+		i, ok := index[pr.Prefix]
+		if !ok {
+			index[pr.Prefix] = len(merged)
+			merged = append(merged, pr)
+			continue
+		}
+		merged[i].Requests += pr.Requests
+		merged[i].Bytes += pr.Bytes
+		merged[i].Children += pr.Children
+	}
+	return merged
+}
+
+func (pt *prefixTree) reset() {
+	for i := range pt.shards {
+		ps := &pt.shards[i]
+		ps.mu.Lock()
+		ps.root = &prefixNode{}
+		ps.mu.Unlock()
+	}
+}
+
+// prefixesByMetric sorts PrefixReports in descending order by Bytes, or by
+// Requests for MetricRequests, breaking ties by prefix.
+type prefixesByMetric struct {
+	prs []PrefixReport
+	by  Metric
+}
+
+func (s prefixesByMetric) Len() int      { return len(s.prs) }
+func (s prefixesByMetric) Swap(i, j int) { s.prs[i], s.prs[j] = s.prs[j], s.prs[i] }
+func (s prefixesByMetric) Less(i, j int) bool {
+	a, b := s.prs[i].Bytes, s.prs[j].Bytes
+	if s.by == MetricRequests {
+		a, b = s.prs[i].Requests, s.prs[j].Requests
+	}
+	if a != b {
+		return a > b
+	}
+	return s.prs[i].Prefix < s.prs[j].Prefix
+}
+
+// TopPrefixes returns up to k of the prefixes one segment longer than
+// prefix, in descending order by the bytes of values retrieved and stored,
+// or by requests for MetricRequests.  The empty prefix returns the top
+// level prefixes, and each may be expanded in turn, down to PrefixDepth
+// segments.  Activity is counted since the last reset, even in a Pool
+// configured with a sliding window.  TopPrefixes returns nil unless the
+// Pool was configured with a PrefixDepth, or if prefix is not tracked.
+func (p *Pool) TopPrefixes(prefix string, k int, by Metric) []PrefixReport {
+	if p.prefixTree == nil {
+		return nil
+	}
+	return p.prefixTree.top(prefix, k, by)
+}
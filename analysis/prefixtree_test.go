@@ -0,0 +1,74 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestTopPrefixes(t *testing.T) {
+	p := New(Config{Workers: 1, PrefixDepth: 2})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "user:1:profile", Size: 100},
+		{Type: model.EventGetHit, Key: "user:2:profile", Size: 100},
+		{Type: model.EventGetHit, Key: "user:2:avatar", Size: 50},
+		{Type: model.EventGetMiss, Key: "session:abc"},
+		{Type: model.EventSet, Key: "session:def", Size: 30},
+		{Type: model.EventGetHit, Key: "page", Size: 10},
+	})
+
+	top := p.TopPrefixes("", 10, MetricBytes)
+	if len(top) != 3 || top[0] != (PrefixReport{"user:", 3, 250, 2}) ||
+		top[1] != (PrefixReport{"session:", 2, 30, 2}) || top[2] != (PrefixReport{"page", 1, 10, 0}) {
+		t.Error("unexpected top level prefixes", top)
+	}
+	users := p.TopPrefixes("user:", 1, MetricRequests)
+	if len(users) != 1 || users[0] != (PrefixReport{"user:2:", 2, 150, 0}) {
+		t.Error("expected user:2: to be the busiest user, got", users)
+	}
+	if prs := p.TopPrefixes("user:2:", 10, MetricBytes); len(prs) != 0 {
+		t.Error("expected no prefixes beyond the depth, got", prs)
+	}
+	if prs := p.TopPrefixes("nothing:", 10, MetricBytes); prs != nil {
+		t.Error("expected nil for an untracked prefix, got", prs)
+	}
+
+	p.Reset()
+	if prs := p.TopPrefixes("", 10, MetricBytes); len(prs) != 0 {
+		t.Error("expected prefixes to be reset, got", prs)
+	}
+}
+
+func TestPrefixBreadth(t *testing.T) {
+	p := New(Config{Workers: 1, PrefixDepth: 3, PrefixBreadth: 2})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a:1", Size: 1},
+		{Type: model.EventGetHit, Key: "b:1", Size: 1},
+		{Type: model.EventGetHit, Key: "c:1", Size: 1},
+		{Type: model.EventGetHit, Key: "d:1", Size: 1},
+	})
+	top := p.TopPrefixes("", 10, MetricRequests)
+	if len(top) != 3 || top[0] != (PrefixReport{OtherPrefix, 2, 2, 0}) {
+		t.Error("expected prefixes beyond the breadth to be combined, got", top)
+	}
+}
+
+func TestPrefixShards(t *testing.T) {
+	p := New(Config{Workers: 4, PrefixDepth: 2})
+	defer p.Shutdown(context.Background())
+	var evts []model.Event
+	for _, key := range []string{"a:1", "a:2", "b:1", "c:1", "d:1", "e:1", "f:1"} {
+		evts = append(evts, model.Event{Type: model.EventGetHit, Key: key, Size: 1})
+	}
+	p.HandleEvents(evts)
+
+	top := p.TopPrefixes("", 10, MetricRequests)
+	if len(top) != 6 || top[0] != (PrefixReport{"a:", 2, 2, 2}) {
+		t.Error("expected top level prefixes from every shard, got", top)
+	}
+	if as := p.TopPrefixes("a:", 10, MetricRequests); len(as) != 2 {
+		t.Error("expected the children of a:, got", as)
+	}
+}
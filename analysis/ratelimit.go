@@ -0,0 +1,215 @@
+package analysis
+
+import (
+	"fmt"
+	"github.com/box/memsniff/protocol/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRateWindow is the window over which byte rates are measured if
+// Config.RateWindow is not positive.
+const DefaultRateWindow = 10 * time.Second
+
+// RateRule is a limit on the bandwidth of cache keys starting with Prefix,
+// as a candidate for rate limiting by clients.
+type RateRule struct {
+	Prefix string
+	// bytes per second of values returned and stored above which the rule
+	// is exceeded
+	BytesPerSec float64
+	// Combined applies the limit to all keys starting with Prefix
+	// together, reported as Prefix, rather than to each key separately.
+	Combined bool
+}
+
+// ParseRateRule parses a rule of the form prefix=bytesPerSec, limiting each
+// key starting with prefix, or prefix*=bytesPerSec, limiting all of them
+// together.
+func ParseRateRule(s string) (RateRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return RateRule{}, fmt.Errorf("rate rule %q is not of the form prefix=bytesPerSec", s)
+	}
+	rate, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil || rate <= 0 {
+		return RateRule{}, fmt.Errorf("rate rule %q does not have a positive rate", s)
+	}
+	prefix := s[:i]
+	combined := strings.HasSuffix(prefix, "*")
+	if combined {
+		prefix = prefix[:len(prefix)-1]
+	}
+	return RateRule{prefix, rate, combined}, nil
+}
+
+// rateKey identifies what a rule measures: a single key, or the prefix of
+// a Combined rule.
+type rateKey struct {
+	rule int
+	key  string
+}
+
+// rateSample is the bytes of a value counted toward a rateKey at a time.
+type rateSample struct {
+	rk    rateKey
+	bytes int
+	at    time.Time
+}
+
+// rateAlert is a rule exceeded by a key at an observed rate.
+type rateAlert struct {
+	rule RateRule
+	key  string
+	rate float64
+}
+
+// rateDetector finds keys whose bandwidth exceeds a RateRule.  Rates are
+// measured over a sliding window, approximated as in many rate limiters by
+// the bytes in the current fixed window plus the bytes in the previous
+// window weighted by how much of it the sliding window still overlaps.
+// This needs only two counts per key, yet does not miss a burst straddling
+// the boundary between fixed windows.  Time is measured by the timestamps
+// of events, so that captures replayed from a file are measured as they
+// were recorded.
+//
+// The counts are divided among shards by key, one for each worker of the
+// Pool, so that concurrent callers rarely wait for each other.
+// rateDetector is threadsafe.
+type rateDetector struct {
+	rules  []RateRule
+	window time.Duration
+	alert  func(rule RateRule, key string, bytesPerSec float64)
+	// current time for events without a timestamp, replaceable for testing
+	now func() time.Time
+
+	shards []rateShard
+}
+
+// rateShard counts the bytes of the rateKeys assigned to it.
+type rateShard struct {
+	mu sync.Mutex
+	// start of the current fixed window
+	start time.Time
+	// bytes in the previous and current fixed windows
+	prev, cur map[rateKey]int
+	// keys already alerted on in the current fixed window
+	alerted map[rateKey]bool
+}
+
+func newRateDetector(conf Config) *rateDetector {
+	window := conf.RateWindow
+	if window <= 0 {
+		window = DefaultRateWindow
+	}
+	shards := conf.Workers
+	if shards <= 0 {
+		shards = 1
+	}
+	rd := &rateDetector{
+		rules:  append([]RateRule(nil), conf.RateRules...),
+		window: window,
+		alert:  conf.OnRateExceeded,
+		now:    time.Now,
+		shards: make([]rateShard, shards),
+	}
+	for i := range rd.shards {
+		rd.shards[i].rotate(time.Time{})
+	}
+	return rd
+}
+
+// observe counts the bytes of values returned and stored in evts and
+// alerts on any rule exceeded, at most once per key in each fixed window.
+// The alerts are made after the counts are updated, so that a slow
+// callback does not hold up other callers.
+func (rd *rateDetector) observe(evts []model.Event) {
+	var now time.Time
+	perShard := make([][]rateSample, len(rd.shards))
+	for _, evt := range evts {
+		switch evt.Type {
+		case model.EventGetHit, model.EventSet:
+		default:
+			continue
+		}
+		at := evt.Timestamp
+		if at.IsZero() {
+			if now.IsZero() {
+				now = rd.now()
+			}
+			at = now
+		}
+		for i, rule := range rd.rules {
+			if !strings.HasPrefix(evt.Key, rule.Prefix) {
+				continue
+			}
+			rk := rateKey{i, evt.Key}
+			if rule.Combined {
+				rk.key = rule.Prefix
+			}
+			s := shardOf(rk.key, len(rd.shards))
+			perShard[s] = append(perShard[s], rateSample{rk, evt.Size, at})
+		}
+	}
+
+	var alerts []rateAlert
+	for i, samples := range perShard {
+		if len(samples) > 0 {
+			alerts = rd.shards[i].add(rd, samples, alerts)
+		}
+	}
+	for _, a := range alerts {
+		rd.alert(a.rule, a.key, a.rate)
+	}
+}
+
+// add counts samples toward the rules of rd, appending to alerts any rule
+// newly exceeded.
+func (rs *rateShard) add(rd *rateDetector, samples []rateSample, alerts []rateAlert) []rateAlert {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, s := range samples {
+		overlap := rs.advance(s.at, rd.window)
+		rs.cur[s.rk] += s.bytes
+		if rs.alerted[s.rk] {
+			continue
+		}
+		bytes := float64(rs.prev[s.rk])*overlap + float64(rs.cur[s.rk])
+		rate := bytes / rd.window.Seconds()
+		if rule := rd.rules[s.rk.rule]; rate > rule.BytesPerSec {
+			rs.alerted[s.rk] = true
+			alerts = append(alerts, rateAlert{rule, s.rk.key, rate})
+		}
+	}
+	return alerts
+}
+
+// advance moves the fixed windows forward to include now, and returns the
+// fraction of the previous window still within the sliding window ending
+// at now.
+func (rs *rateShard) advance(now time.Time, window time.Duration) float64 {
+	if elapsed := now.Sub(rs.start); elapsed >= 2*window {
+		rs.rotate(now)
+		rs.rotate(now)
+	} else if elapsed >= window {
+		rs.rotate(rs.start.Add(window))
+	}
+	overlap := 1 - float64(now.Sub(rs.start))/float64(window)
+	if overlap > 1 {
+		// an event captured slightly before the current window began,
+		// such as on another connection
+		overlap = 1
+	}
+	return overlap
+}
+
+// rotate begins a new fixed window at start, the current window becoming
+// the previous one.
+func (rs *rateShard) rotate(start time.Time) {
+	rs.start = start
+	rs.prev = rs.cur
+	rs.cur = make(map[rateKey]int)
+	rs.alerted = make(map[rateKey]bool)
+}
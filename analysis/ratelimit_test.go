@@ -0,0 +1,113 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+	"time"
+)
+
+func TestParseRateRule(t *testing.T) {
+	r, err := ParseRateRule("user:=1000")
+	if err != nil || r != (RateRule{"user:", 1000, false}) {
+		t.Error("expected per-key rule for user:, got", r, err)
+	}
+	r, err = ParseRateRule("a=b*=2.5")
+	if err != nil || r != (RateRule{"a=b", 2.5, true}) {
+		t.Error("expected combined rule for a=b, got", r, err)
+	}
+	for _, s := range []string{"user:", "user:=", "user:=-1", "user:=fast"} {
+		if _, err := ParseRateRule(s); err == nil {
+			t.Error("expected error parsing", s)
+		}
+	}
+}
+
+func TestRateRules(t *testing.T) {
+	type alert struct {
+		key  string
+		rate float64
+	}
+	var alerts []alert
+	rd := newRateDetector(Config{
+		RateRules: []RateRule{
+			{Prefix: "user:", BytesPerSec: 100},
+			{Prefix: "session:", BytesPerSec: 100, Combined: true},
+		},
+		RateWindow: 10 * time.Second,
+		OnRateExceeded: func(rule RateRule, key string, bytesPerSec float64) {
+			alerts = append(alerts, alert{key, bytesPerSec})
+		},
+	})
+	now := time.Unix(1500000000, 0)
+	rd.now = func() time.Time { return now }
+
+	rd.observe([]model.Event{
+		{Type: model.EventGetHit, Key: "user:1", Size: 600},
+		{Type: model.EventGetHit, Key: "user:2", Size: 600},
+		{Type: model.EventSet, Key: "session:1", Size: 600},
+		{Type: model.EventSet, Key: "session:2", Size: 600},
+		{Type: model.EventGetHit, Key: "other", Size: 5000},
+		{Type: model.EventGetMiss, Key: "user:3", Size: 5000},
+	})
+	if len(alerts) != 1 || alerts[0] != (alert{"session:", 120}) {
+		t.Fatal("expected only the combined session: keys to exceed 100 B/s, got", alerts)
+	}
+
+	// half of the previous window's 600 bytes still counts
+	alerts = nil
+	now = now.Add(15 * time.Second)
+	rd.observe([]model.Event{{Type: model.EventGetHit, Key: "user:1", Size: 800}})
+	if len(alerts) != 1 || alerts[0] != (alert{"user:1", 110}) {
+		t.Fatal("expected user:1 to exceed 100 B/s with the previous window, got", alerts)
+	}
+
+	// alerted on once per window
+	rd.observe([]model.Event{{Type: model.EventGetHit, Key: "user:1", Size: 800}})
+	if len(alerts) != 1 {
+		t.Error("expected no repeated alert within the window, got", alerts)
+	}
+
+	// after two windows without activity, earlier bytes are forgotten
+	alerts = nil
+	now = now.Add(20 * time.Second)
+	rd.observe([]model.Event{{Type: model.EventGetHit, Key: "user:1", Size: 800}})
+	if len(alerts) != 0 {
+		t.Error("expected no alert once earlier activity has left the window, got", alerts)
+	}
+}
+
+func TestRatesUseEventTimestamps(t *testing.T) {
+	var alerts []string
+	rd := newRateDetector(Config{
+		Workers:    4,
+		RateRules:  []RateRule{{Prefix: "user:", BytesPerSec: 100}},
+		RateWindow: 10 * time.Second,
+		OnRateExceeded: func(rule RateRule, key string, bytesPerSec float64) {
+			alerts = append(alerts, key)
+		},
+	})
+	rd.now = func() time.Time {
+		t.Fatal("expected event timestamps to be used instead of the clock")
+		return time.Time{}
+	}
+
+	// a file replayed quickly: 600 bytes recorded a minute apart never
+	// exceed the rate, however soon they are observed
+	t0 := time.Unix(1500000000, 0)
+	for i := 0; i < 5; i++ {
+		rd.observe([]model.Event{
+			{Type: model.EventGetHit, Key: "user:1", Size: 600, Timestamp: t0.Add(time.Duration(i) * time.Minute)},
+		})
+	}
+	if len(alerts) != 0 {
+		t.Error("expected no alert for activity spread out when recorded, got", alerts)
+	}
+
+	rd.observe([]model.Event{
+		{Type: model.EventGetHit, Key: "user:2", Size: 600, Timestamp: t0},
+		{Type: model.EventGetHit, Key: "user:2", Size: 600, Timestamp: t0.Add(time.Second)},
+	})
+	if len(alerts) != 1 || alerts[0] != "user:2" {
+		t.Error("expected user:2 to exceed 100 B/s, got", alerts)
+	}
+}
@@ -0,0 +1,115 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime"
+	"time"
+)
+
+// ErrShutdown is returned by Reconfigure once the Pool has been shut down.
+var ErrShutdown = errors.New("analysis pool is shut down")
+
+// Settings are the parts of a Pool's Config that may be changed while it is
+// running.
+type Settings struct {
+	// as for Config.Workers
+	Workers int
+	// as for Config.QueueSize
+	QueueSize int
+	// as for Config.WeightMode
+	WeightMode WeightMode
+}
+
+// Settings returns the current settings of this Pool.
+func (p *Pool) Settings() Settings {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	return Settings{
+		Workers:    len(p.workers),
+		QueueSize:  p.conf.QueueSize,
+		WeightMode: p.mode,
+	}
+}
+
+// Reconfigure replaces the workers of this Pool with new ones configured by
+// s, without losing the activity already recorded.  Defaults are applied to
+// s as by New.
+//
+// Events already queued are recorded by the old workers, all of whose keys
+// are then carried into every report until the next reset, or until they
+// leave the window of a Pool with a sliding window.  Their other state,
+// such as the busiest connections and when each key was last active, is
+// discarded.  Events passed to HandleEvents and reports requested while
+// Reconfigure is in progress wait for it to finish.
+//
+// Reporters choosing a Metric by the Pool's WeightMode must be restarted
+// to rank keys by the new WeightMode.
+func (p *Pool) Reconfigure(s Settings) error {
+	if s.Workers <= 0 {
+		s.Workers = runtime.GOMAXPROCS(0)
+	}
+	if s.QueueSize <= 0 {
+		s.QueueSize = DefaultQueueSize
+	}
+
+	p.shutdownLock.Lock()
+	defer p.shutdownLock.Unlock()
+	if p.shutdown {
+		return ErrShutdown
+	}
+	p.resetLock.Lock()
+	defer p.resetLock.Unlock()
+
+	// no more events can be queued, so this cannot wait forever
+	_ = p.drain(context.Background())
+	var allKeys []KeyReport
+	for i := range p.workers {
+		w := &p.workers[i]
+		// every key, not just the busiest ReportSize, since keys ranked
+		// lower by one worker may be among the busiest once merged
+		res, _ := w.query(context.Background(), math.MaxInt32, false)
+		allKeys = append(allKeys, res.keyReports(p.sizeBuckets)...)
+		batches, keys := w.dropped()
+		p.retired.BatchesDropped += batches
+		p.retired.KeysDropped += keys
+		p.retired.KeysEvicted += w.evicted()
+		w.close()
+	}
+	p.carriedLock.Lock()
+	p.carried = mergeKeys(append(allKeys, p.carriedKeys(false)...))
+	p.carriedAt = time.Now()
+	p.carriedLock.Unlock()
+
+	p.conf.Workers = s.Workers
+	p.conf.QueueSize = s.QueueSize
+	p.conf.WeightMode = s.WeightMode
+	p.mode = s.WeightMode
+	p.workers = make([]worker, s.Workers)
+	for i := range p.workers {
+		p.workers[i] = newWorker(p.conf, p.prefixes)
+	}
+	return nil
+}
+
+// carriedKeys returns the keys carried over from workers replaced by
+// Reconfigure that are still to be reported, and if reset is true, no longer
+// carries them.  The caller must hold carriedLock.
+func (p *Pool) carriedKeys(reset bool) []KeyReport {
+	keys := p.carried
+	if p.windowed && time.Since(p.carriedAt) > p.conf.Window {
+		keys = nil
+	}
+	if reset {
+		p.carried = nil
+	}
+	return keys
+}
+
+// takeCarried is like carriedKeys, acquiring carriedLock.
+func (p *Pool) takeCarried(reset bool) []KeyReport {
+	p.carriedLock.Lock()
+	defer p.carriedLock.Unlock()
+	return p.carriedKeys(reset)
+}
@@ -0,0 +1,70 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/protocol/model"
+	"testing"
+)
+
+func TestReconfigureCarriesActivity(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "b", Size: 20},
+	})
+
+	err := p.Reconfigure(Settings{Workers: 3, QueueSize: 16, WeightMode: WeightCount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := p.Settings()
+	if s != (Settings{Workers: 3, QueueSize: 16, WeightMode: WeightCount}) {
+		t.Error("expected new settings, got", s)
+	}
+
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 10}})
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 2 || keys[0].Name != "a" || keys[0].RequestsEstimate != 2 ||
+		keys[1].Name != "b" || keys[1].RequestsEstimate != 1 {
+		t.Fatal("expected activity from before and after reconfiguring, got", keys)
+	}
+
+	p.TopAndReset(10, MetricRequests)
+	if keys := p.Top(10, MetricRequests); len(keys) != 0 {
+		t.Error("expected carried activity to be reset, got", keys)
+	}
+}
+
+func TestReconfigureCarriesAllKeys(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 1})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "b", Size: 10},
+	})
+
+	if err := p.Reconfigure(Settings{Workers: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// b was outside the top ReportSize keys, but is now the busiest
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "b", Size: 10},
+		{Type: model.EventGetHit, Key: "b", Size: 10},
+	})
+	p.Wait()
+	keys := p.Top(1, MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "b" || keys[0].RequestsEstimate != 3 {
+		t.Error("expected b with all 3 requests, got", keys)
+	}
+}
+
+func TestReconfigureAfterShutdown(t *testing.T) {
+	p := New(Config{Workers: 1})
+	p.Shutdown(context.Background())
+	if err := p.Reconfigure(Settings{Workers: 2}); err != ErrShutdown {
+		t.Error("expected ErrShutdown, got", err)
+	}
+}
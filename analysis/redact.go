@@ -0,0 +1,47 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"regexp"
+)
+
+// RedactMask replaces each match of Config.RedactPatterns in a cache key.
+const RedactMask = "***"
+
+// newRedactor returns a function that masks each match of patterns in a
+// cache key, or nil if there are no patterns.
+func newRedactor(patterns []*regexp.Regexp) func(key string) string {
+	if len(patterns) == 0 {
+		return nil
+	}
+	rules := make([]NormalizeRule, len(patterns))
+	for i, re := range patterns {
+		// RedactMask contains no $, so is never expanded as a submatch
+		rules[i] = NormalizeRule{re, RedactMask}
+	}
+	return NewNormalizer(rules)
+}
+
+// redactEvents masks the keys of evts in place, marking those changed as
+// Redacted.
+func (p *Pool) redactEvents(evts []model.Event) {
+	for i := range evts {
+		if key := p.redact(evts[i].Key); key != evts[i].Key {
+			evts[i].Key = key
+			evts[i].Redacted = true
+		}
+	}
+}
+
+// Redact returns evts with their keys masked by Config.RedactPatterns, as
+// the Pool records them, so that events can be passed on to consumers that
+// must not see what was masked.  evts is not modified, and is returned as
+// is if the Pool has no patterns.
+func (p *Pool) Redact(evts []model.Event) []model.Event {
+	if p.redact == nil {
+		return evts
+	}
+	redacted := append([]model.Event(nil), evts...)
+	p.redactEvents(redacted)
+	return redacted
+}
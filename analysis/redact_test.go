@@ -0,0 +1,43 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"regexp"
+	"testing"
+)
+
+func TestRedactedKeysAggregated(t *testing.T) {
+	p := New(Config{
+		Workers:        2,
+		ReportSize:     100,
+		RedactPatterns: []*regexp.Regexp{regexp.MustCompile(`user=[^:]*`)},
+		NormalizeKey:   NewNormalizer(DefaultNormalizeRules),
+	})
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "profile:user=alice:v1", Size: 10},
+		{Type: model.EventGetHit, Key: "profile:user=bob:v2", Size: 10},
+		{Type: model.EventGetHit, Key: "config:v3", Size: 10},
+	}
+	p.HandleEvents(evts)
+	p.Wait()
+	if evts[0].Key != "profile:user=alice:v1" || evts[2].Key != "config:v3" {
+		t.Error("expected the caller's events not to be rewritten, got", evts)
+	}
+
+	krs := p.Top(100, MetricRequests)
+	if len(krs) != 2 {
+		t.Fatal("expected two keys, got", krs)
+	}
+	if krs[0].Name != "profile:***:v#" || krs[0].RequestsEstimate != 2 || !krs[0].Redacted {
+		t.Error("expected redacted keys to be combined and marked, got", krs[0])
+	}
+	if krs[1].Name != "config:v#" || krs[1].Redacted {
+		t.Error("expected unredacted key to be unmarked, got", krs[1])
+	}
+}
+
+func TestNoRedactPatterns(t *testing.T) {
+	if r := newRedactor(nil); r != nil {
+		t.Error("expected no redactor without patterns")
+	}
+}
@@ -1,21 +1,118 @@
 package analysis
 
 import (
+	"context"
+	"fmt"
 	"github.com/box/memsniff/hotlist"
+	"github.com/box/memsniff/protocol/model"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 // KeyReport contains activity information for a single cache key.
 type KeyReport struct {
-	// cache key
+	// cache key, or the address of the client if keys are tracked by
+	// DimensionClient
 	Name string
-	// size of the cache value in bytes
+	// address of the client, if keys are tracked by a Dimension including it
+	Client string
+	// cluster of the servers, if the Pool was configured with Clusters,
+	// and network interface, if configured with ByInterface
+	Cluster string
+	// average size of the cache value in bytes
 	Size int
-	// number of requests for this cache key
+	// number of requests for this cache key that returned a value, or whose
+	// outcome is unknown if only requests are captured
 	RequestsEstimate int
+	// number of requests for this cache key that did not return a value
+	MissesEstimate int
 	// amount of bandwidth consumed by traffic for this cache key in bytes
 	TrafficEstimate int
+	// maximum amount by which RequestsEstimate and TrafficEstimate may
+	// exceed the true counts, zero if the Pool counts keys exactly.  An
+	// approximate hotlist may be summarized as "~RequestsEstimate
+	// ±RequestsError".
+	RequestsError int
+	TrafficError  int
+	// number of storage commands for this cache key, if tracked
+	SetsEstimate int
+	// amount of bandwidth consumed by storage commands for this cache key in
+	// bytes, if tracked
+	SetTrafficEstimate int
+	// number of those the server did not store, such as an add of a key
+	// that already has a value, if tracked and the responses are captured
+	StoreFailuresEstimate int
+	// number of storage commands for this cache key by the TTL sent, as
+	// described for model.Event, if tracked.  Commands that keep the TTL,
+	// such as append, are not counted, nor TTLs beyond the first few
+	// distinct TTLs of the key.
+	SetTTLs map[int]int
+	// number of delete commands for this cache key, if tracked
+	DeletesEstimate int
+	// number of increments of this counter, if tracked
+	IncrsEstimate int
+	// sum of the deltas of those increments
+	IncrVolumeEstimate int
+	// number of decrements of this counter, if tracked
+	DecrsEstimate int
+	// sum of the deltas of those decrements
+	DecrVolumeEstimate int
+	// number of compare-and-swap commands for this cache key with a known
+	// outcome, if tracked
+	CASEstimate int
+	// number of those rejected because the value had been modified since
+	// the client read it, indicating contention between writers
+	CASConflictsEstimate int
+	// number of requests returning a value, by the value size bucket in
+	// Config.SizeBuckets, with a final bucket for larger values.  Nil unless
+	// the Pool was configured with SizeBuckets.
+	SizeHistogram []int
+	// number of commands modifying this cache key, if access is classified
+	WritesEstimate int
+	// whether this cache key is mostly read or mostly written, if
+	// classified
+	Access Access
+	// time of the most recent activity on this cache key, if tracked
+	LastSeen time.Time
+	// distinct original keys sampled from the family Name, in sorted
+	// order, if the Pool was configured with Examples
+	Examples []string
+	// number of distinct client addresses sending commands for this cache
+	// key, if tracked.  Up to 32 clients are counted exactly, and more are
+	// estimated to within a few percent.
+	ClientsEstimate int
+	// whether parts of Name were replaced with RedactMask, in which case
+	// this report may combine the activity of several distinct keys
+	Redacted bool
+}
+
+// StoreFailureRate returns the fraction of storage commands for this cache
+// key that the server did not store, or zero if none were counted.
+func (kr KeyReport) StoreFailureRate() float64 {
+	if kr.SetsEstimate == 0 {
+		return 0
+	}
+	return float64(kr.StoreFailuresEstimate) / float64(kr.SetsEstimate)
+}
+
+// Label returns a name for the activity kr reports for display: its Name,
+// preceded by its Cluster and Client when they are tracked, and followed by
+// its Examples if any.  A Client that is also the Name, as for
+// DimensionClient, is shown once.
+func (kr KeyReport) Label() string {
+	label := kr.Name
+	if kr.Client != "" && kr.Client != kr.Name {
+		label = strings.TrimSpace(kr.Client + " " + label)
+	}
+	if kr.Cluster != "" {
+		label = kr.Cluster + " " + label
+	}
+	if len(kr.Examples) > 0 {
+		label += " (e.g. " + strings.Join(kr.Examples, ", ") + ")"
+	}
+	return label
 }
 
 // Report represents key activity submitted to a Pool since the last call to
@@ -23,7 +120,8 @@ type KeyReport struct {
 type Report struct {
 	// when this report was generated
 	Timestamp time.Time
-	// key reports in descending order by TrafficEstimate
+	// key reports in descending order by TrafficEstimate, or by
+	// RequestsEstimate if the Pool ranks keys with WeightCount
 	Keys []KeyReport
 }
 
@@ -43,6 +141,96 @@ func (r Report) Swap(i, j int) {
 	r.Keys[i], r.Keys[j] = r.Keys[j], r.Keys[i]
 }
 
+// Metric is a measure of cache key activity by which keys can be ranked.
+type Metric int
+
+const (
+	// MetricBytes ranks keys by TrafficEstimate.
+	MetricBytes Metric = iota
+	// MetricRequests ranks keys by RequestsEstimate.
+	MetricRequests
+	// MetricAvgSize ranks keys by the average size of values returned.
+	MetricAvgSize
+	// MetricSets ranks keys by SetsEstimate.
+	MetricSets
+	// MetricDeletes ranks keys by DeletesEstimate.
+	MetricDeletes
+	// MetricIncrs ranks keys by IncrsEstimate.
+	MetricIncrs
+	// MetricDecrs ranks keys by DecrsEstimate.
+	MetricDecrs
+	// MetricCASConflicts ranks keys by CASConflictsEstimate.
+	MetricCASConflicts
+)
+
+// metricNames are the names accepted by ParseMetric.
+var metricNames = map[string]Metric{
+	"bytes":    MetricBytes,
+	"requests": MetricRequests,
+	"avgsize":  MetricAvgSize,
+	"sets":     MetricSets,
+	"deletes":  MetricDeletes,
+	"incrs":    MetricIncrs,
+	"decrs":    MetricDecrs,
+
+	"casconflicts": MetricCASConflicts,
+}
+
+// ParseMetric returns the Metric with the given name: one of bytes,
+// requests, avgsize, sets, deletes, incrs, decrs or casconflicts.
+func ParseMetric(name string) (Metric, error) {
+	m, ok := metricNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %q", name)
+	}
+	return m, nil
+}
+
+// value returns the measure of kr according to m.
+func (m Metric) value(kr KeyReport) int {
+	switch m {
+	case MetricRequests:
+		return kr.RequestsEstimate
+	case MetricSets:
+		return kr.SetsEstimate
+	case MetricDeletes:
+		return kr.DeletesEstimate
+	case MetricIncrs:
+		return kr.IncrsEstimate
+	case MetricDecrs:
+		return kr.DecrsEstimate
+	case MetricCASConflicts:
+		return kr.CASConflictsEstimate
+	case MetricAvgSize:
+		if kr.RequestsEstimate == 0 {
+			return 0
+		}
+		return kr.TrafficEstimate / kr.RequestsEstimate
+	default:
+		return kr.TrafficEstimate
+	}
+}
+
+// sortByMetric sorts keys in descending order by a Metric.  Keys of equal
+// value are ordered by Cluster, Client and Name, ascending, as hotlists
+// order their ties, so that the same keys always give the same result.
+func sortByMetric(keys []KeyReport, by Metric) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if va, vb := by.value(a), by.value(b); va != vb {
+			return vb < va
+		}
+		switch {
+		case a.Cluster != b.Cluster:
+			return a.Cluster < b.Cluster
+		case a.Client != b.Client:
+			return a.Client < b.Client
+		default:
+			return a.Name < b.Name
+		}
+	})
+}
+
 // Report returns a summary of activity recorded in this Pool since the last
 // call to Reset.
 //
@@ -55,41 +243,413 @@ func (r Report) Swap(i, j int) {
 // asynchronous operation across the workers in the pool, some information
 // may be carried over between successive reports, and some data may be
 // lost entirely.
+//
+// shouldReset is ignored if the Pool was configured with a sliding window,
+// since data ages out of the window instead.
+//
+// If the Pool was configured with a HistorySize, the report is also
+// retained for History.
 func (p *Pool) Report(shouldReset bool) Report {
-	allEntries := make([]hotlist.Entry, 0, p.reportSize*len(p.workers))
+	ret := Report{
+		Timestamp: time.Now(),
+		Keys:      p.collect(p.reportSize, shouldReset),
+	}
+
+	by := MetricBytes
+	if p.Settings().WeightMode == WeightCount {
+		by = MetricRequests
+	}
+	sortByMetric(ret.Keys, by)
+	ret.Keys = AtLeast(ret.Keys, by, p.minWeight)
+
+	if p.history != nil {
+		p.history.add(ret)
+	}
+	return ret
+}
+
+// Top returns up to k of the busiest keys recorded in this Pool since the
+// last call to Reset, in descending order by metric.
+//
+// Keys are selected from each worker according to the Pool's WeightMode, and
+// only then ranked by metric.  Ranking by a metric other than the one
+// corresponding to the WeightMode may therefore omit keys that would rank
+// highly if all keys were considered.
+func (p *Pool) Top(k int, by Metric) []KeyReport {
+	return p.top(k, by, false)
+}
+
+// TopAndReset is like Top, but also clears the activity recorded by each
+// worker as soon as its keys have been collected, so that no activity is
+// either reported twice or lost.  Workers are reset one at a time, so the
+// report is not a consistent snapshot across all keys.
+//
+// TopAndReset does not reset a Pool configured with a sliding window.
+func (p *Pool) TopAndReset(k int, by Metric) []KeyReport {
+	return p.top(k, by, true)
+}
+
+// TopContext is like Top, but gives up and returns ctx.Err() if ctx is done
+// before every worker has replied, such as when the client requesting the
+// keys has gone away.  Analysis is unaffected by abandoned requests.
+func (p *Pool) TopContext(ctx context.Context, k int, by Metric) ([]KeyReport, error) {
+	keys, err := p.collectContext(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	return AtLeast(rank(keys, k, by), by, p.minWeight), nil
+}
+
+func (p *Pool) top(k int, by Metric, shouldReset bool) []KeyReport {
+	return AtLeast(rank(p.collect(k, shouldReset), k, by), by, p.minWeight)
+}
+
+// AtLeast returns the leading keys measuring at least min by metric, given
+// keys in descending order by metric, as returned by Top.
+func AtLeast(keys []KeyReport, by Metric, min int) []KeyReport {
+	n := sort.Search(len(keys), func(i int) bool {
+		return by.value(keys[i]) < min
+	})
+	return keys[:n]
+}
+
+// rank returns up to k of keys in descending order by metric.
+func rank(keys []KeyReport, k int, by Metric) []KeyReport {
+	sortByMetric(keys, by)
+	if len(keys) > k {
+		keys = keys[:k]
+	}
+	return keys
+}
+
+// collect gathers up to k of the busiest keys from each worker, optionally
+// resetting each worker after its keys are gathered.  Activity for the same
+// cache key is merged into a single KeyReport.
+func (p *Pool) collect(k int, shouldReset bool) []KeyReport {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	var allKeys []KeyReport
 	for _, w := range p.workers {
-		workerEntries := w.top(p.reportSize)
-		if shouldReset {
-			w.reset()
+		var res topReply
+		if shouldReset && !p.windowed {
+			res = w.topAndReset(k)
+		} else {
+			res, _ = w.query(context.Background(), k, false)
 		}
-		allEntries = append(allEntries, workerEntries...)
+		allKeys = append(allKeys, res.keyReports(p.sizeBuckets)...)
 	}
+	allKeys = append(allKeys, p.takeCarried(shouldReset && !p.windowed)...)
+	if shouldReset && !p.windowed {
+		p.resetSummary()
+		p.resetPrefixes()
+	}
+	return p.finish(mergeKeys(allKeys))
+}
 
-	reportSize := len(allEntries)
-	if reportSize > p.reportSize {
-		reportSize = p.reportSize
+// collectContext is like collect without resetting, but gives up if ctx is
+// done before every worker has replied.
+func (p *Pool) collectContext(ctx context.Context, k int) ([]KeyReport, error) {
+	p.resetLock.RLock()
+	defer p.resetLock.RUnlock()
+	var allKeys []KeyReport
+	for _, w := range p.workers {
+		res, err := w.topContext(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		allKeys = append(allKeys, res.keyReports(p.sizeBuckets)...)
 	}
+	allKeys = append(allKeys, p.takeCarried(false)...)
+	return p.finish(mergeKeys(allKeys)), nil
+}
 
-	ret := Report{
-		Timestamp: time.Now(),
-		Keys:      make([]KeyReport, 0, reportSize),
+// finish completes merged KeyReports with the adjustments configured for
+// the Pool.
+func (p *Pool) finish(merged []KeyReport) []KeyReport {
+	if p.scale != 1 {
+		for i := range merged {
+			merged[i].scale(p.scale)
+		}
+	}
+	if p.readWriteRatio > 0 {
+		classifyAccess(merged, p.readWriteRatio)
 	}
+	return merged
+}
 
-	for _, e := range allEntries {
-		ret.Keys = append(ret.Keys, keyReport(e))
+// scale multiplies the estimates in kr by f, to account for sampling.
+func (kr *KeyReport) scale(f float64) {
+	for _, n := range []*int{
+		&kr.RequestsEstimate, &kr.MissesEstimate, &kr.TrafficEstimate,
+		&kr.RequestsError, &kr.TrafficError,
+		&kr.SetsEstimate, &kr.SetTrafficEstimate, &kr.StoreFailuresEstimate,
+		&kr.DeletesEstimate,
+		&kr.IncrsEstimate, &kr.IncrVolumeEstimate, &kr.DecrsEstimate,
+		&kr.DecrVolumeEstimate, &kr.CASEstimate, &kr.CASConflictsEstimate,
+		&kr.WritesEstimate,
+	} {
+		*n = int(float64(*n) * f)
+	}
+	if kr.SetTTLs != nil {
+		// maps may be shared with other KeyReports
+		ttls := make(map[int]int, len(kr.SetTTLs))
+		for ttl, n := range kr.SetTTLs {
+			ttls[ttl] = int(float64(n) * f)
+		}
+		kr.SetTTLs = ttls
 	}
+	if kr.SizeHistogram != nil {
+		h := make([]int, len(kr.SizeHistogram))
+		for i, n := range kr.SizeHistogram {
+			h[i] = int(float64(n) * f)
+		}
+		kr.SizeHistogram = h
+	}
+}
 
-	sort.Sort(ret)
+// mergeKeys combines KeyReports with the same Cluster, Client and Name by
+// summing their counts.
+// A cache key is tracked as several hotlist items when its value changes
+// size, and may be reported by more than one worker.
+func mergeKeys(krs []KeyReport) []KeyReport {
+	type mergeKey struct {
+		cluster, client, name string
+	}
+	merged := make([]KeyReport, 0, len(krs))
+	index := make(map[mergeKey]int, len(krs))
+	for _, kr := range krs {
+		mk := mergeKey{kr.Cluster, kr.Client, kr.Name}
+		i, ok := index[mk]
+		if !ok {
+			index[mk] = len(merged)
+			merged = append(merged, kr)
+			continue
+		}
+		m := &merged[i]
+		m.RequestsEstimate += kr.RequestsEstimate
+		m.MissesEstimate += kr.MissesEstimate
+		m.TrafficEstimate += kr.TrafficEstimate
+		m.RequestsError += kr.RequestsError
+		m.TrafficError += kr.TrafficError
+		m.SetsEstimate += kr.SetsEstimate
+		m.SetTrafficEstimate += kr.SetTrafficEstimate
+		m.StoreFailuresEstimate += kr.StoreFailuresEstimate
+		m.SetTTLs = addCounts(m.SetTTLs, kr.SetTTLs)
+		m.DeletesEstimate += kr.DeletesEstimate
+		m.IncrsEstimate += kr.IncrsEstimate
+		m.IncrVolumeEstimate += kr.IncrVolumeEstimate
+		m.DecrsEstimate += kr.DecrsEstimate
+		m.DecrVolumeEstimate += kr.DecrVolumeEstimate
+		m.CASEstimate += kr.CASEstimate
+		m.CASConflictsEstimate += kr.CASConflictsEstimate
+		m.WritesEstimate += kr.WritesEstimate
+		m.SizeHistogram = addHistograms(m.SizeHistogram, kr.SizeHistogram)
+		if kr.LastSeen.After(m.LastSeen) {
+			m.LastSeen = kr.LastSeen
+		}
+		m.Examples = mergeExamples(m.Examples, kr.Examples)
+		m.Redacted = m.Redacted || kr.Redacted
+		// the clients of different workers cannot be combined without
+		// counting some twice, so take the largest count
+		if kr.ClientsEstimate > m.ClientsEstimate {
+			m.ClientsEstimate = kr.ClientsEstimate
+		}
+		if m.RequestsEstimate > 0 {
+			m.Size = m.TrafficEstimate / m.RequestsEstimate
+		}
+	}
+	return merged
+}
 
-	return ret
+// keyReports converts the hotlists from a single worker into KeyReports.
+// Each type of event for the same key is reported separately, to be
+// combined by mergeKeys.
+//
+// If sizeBuckets is not nil, a size histogram is reported for each value
+// returned.  Hotlists track each size of a key's value as a separate item,
+// so the histogram needs no storage beyond the hotlists themselves.
+func keyReports(res topResult, sizeBuckets []int) []KeyReport {
+	var krs []KeyReport
+	for evtType, entries := range res {
+		if evtType == eventConnection {
+			continue
+		}
+		for _, e := range entries {
+			kr := keyReport(evtType, e)
+			if sizeBuckets != nil && evtType == model.EventGetHit {
+				kr.SizeHistogram = make([]int, len(sizeBuckets)+1)
+				kr.SizeHistogram[sizeBucket(sizeBuckets, kr.Size)] = kr.RequestsEstimate
+			}
+			krs = append(krs, kr)
+		}
+	}
+	return krs
 }
 
-func keyReport(e hotlist.Entry) KeyReport {
-	ki := e.Item().(keyInfo)
-	return KeyReport{
-		Name:             ki.name,
-		Size:             ki.size,
-		RequestsEstimate: e.Count(),
-		TrafficEstimate:  e.Count() * ki.size,
+// keyReports converts a worker's reply into KeyReports, as for keyReports,
+// noting when each key was last active if the worker tracks it.
+func (tr topReply) keyReports(sizeBuckets []int) []KeyReport {
+	krs := keyReports(tr.lists, sizeBuckets)
+	if tr.lastSeen != nil {
+		for i := range krs {
+			krs[i].LastSeen = tr.lastSeen[keyName{krs[i].Name, krs[i].Client, krs[i].Cluster, krs[i].Redacted}]
+		}
+	}
+	if tr.examples != nil {
+		for i := range krs {
+			krs[i].Examples = tr.examples[keyName{krs[i].Name, krs[i].Client, krs[i].Cluster, krs[i].Redacted}]
+		}
+	}
+	if tr.clients != nil {
+		for i := range krs {
+			krs[i].ClientsEstimate = tr.clients[keyName{krs[i].Name, krs[i].Client, krs[i].Cluster, krs[i].Redacted}]
+		}
+	}
+	if tr.ttls != nil {
+		for i := range krs {
+			// once for each key, though it may have sets of several sizes,
+			// so that mergeKeys counts them once
+			kn := keyName{krs[i].Name, krs[i].Client, krs[i].Cluster, krs[i].Redacted}
+			if krs[i].SetsEstimate > 0 {
+				krs[i].SetTTLs = tr.ttls[kn]
+				delete(tr.ttls, kn)
+			}
+		}
+	}
+	if tr.writes != nil {
+		for i := range krs {
+			// the write hotlist only counts the most written keys, so every
+			// key reported takes its writes from the worker's own count,
+			// once so that mergeKeys counts them once
+			kn := keyName{krs[i].Name, krs[i].Client, krs[i].Cluster, krs[i].Redacted}
+			krs[i].WritesEstimate = tr.writes[kn]
+			delete(tr.writes, kn)
+		}
+	}
+	return krs
+}
+
+// sizeBucket returns the index of the bucket in a histogram with the given
+// upper bounds that holds size.
+func sizeBucket(bounds []int, size int) int {
+	return sort.SearchInts(bounds, size)
+}
+
+// addCounts returns the sum of two sets of counts, either of which may be
+// nil.
+func addCounts(a, b map[int]int) map[int]int {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	sum := make(map[int]int, len(a)+len(b))
+	for k, n := range a {
+		sum[k] += n
+	}
+	for k, n := range b {
+		sum[k] += n
+	}
+	return sum
+}
+
+// addHistograms returns the sum of two histograms with the same buckets,
+// either of which may be nil.
+func addHistograms(a, b []int) []int {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	sum := make([]int, len(a))
+	for i := range a {
+		sum[i] = a[i] + b[i]
+	}
+	return sum
+}
+
+func keyReport(evtType model.EventType, e hotlist.Entry) KeyReport {
+	if kn, ok := e.Item().(keyName); ok {
+		kr := KeyReport{Name: kn.name, Client: kn.client, Cluster: kn.cluster, Redacted: kn.redacted}
+		switch evtType {
+		case model.EventGet:
+			kr.RequestsEstimate = e.Count()
+		case model.EventGetMiss:
+			kr.MissesEstimate = e.Count()
+		case model.EventDelete:
+			kr.DeletesEstimate = e.Count()
+		case model.EventCASStored, model.EventCASNotFound:
+			kr.CASEstimate = e.Count()
+		case model.EventCASExists:
+			kr.CASEstimate = e.Count()
+			kr.CASConflictsEstimate = e.Count()
+		case eventWrite:
+			kr.WritesEstimate = e.Count()
+		case eventStoreFailed:
+			kr.StoreFailuresEstimate = e.Count()
+		}
+		return kr
+	}
+
+	ki := itemKeyInfo(e.Item())
+	traffic := e.Weight()
+	trafficErr := e.ErrorWeight()
+	switch e.Item().(type) {
+	case countedKey, weightedKey:
+		// the hotlist accumulated request counts or computed weights, not
+		// bytes
+		traffic = e.Count() * ki.size
+		trafficErr = e.Error() * ki.size
+	}
+
+	kr := KeyReport{Name: ki.name, Client: ki.client, Cluster: ki.cluster, Redacted: ki.redacted}
+	switch evtType {
+	case model.EventSet:
+		kr.SetsEstimate = e.Count()
+		kr.SetTrafficEstimate = traffic
+	case model.EventIncr:
+		kr.IncrsEstimate = e.Count()
+		kr.IncrVolumeEstimate = traffic
+	case model.EventDecr:
+		kr.DecrsEstimate = e.Count()
+		kr.DecrVolumeEstimate = traffic
+	default:
+		kr.Size = ki.size
+		kr.RequestsEstimate = e.Count()
+		kr.TrafficEstimate = traffic
+		// the inherited weight belonged to other items, of other sizes
+		kr.RequestsError = e.Error()
+		kr.TrafficError = trafficErr
+	}
+	return kr
+}
+
+// ReportEvery calls Report(shouldReset) every interval and passes the
+// result to callback, until stop is called.  Driving all periodic reporting
+// from one ReportEvery keeps reporters on the same interval, rather than
+// each reading or resetting the Pool on its own schedule.
+//
+// callback is called from a single goroutine separate from the workers, so
+// a slow callback delays the next report but does not stall analysis.  A
+// callback already in progress when stop is called runs to completion.
+func (p *Pool) ReportEvery(interval time.Duration, shouldReset bool, callback func(Report)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				callback(p.Report(shouldReset))
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
 	}
 }
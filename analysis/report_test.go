@@ -0,0 +1,315 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/hotlist"
+	"github.com/box/memsniff/protocol/model"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortByMetric(t *testing.T) {
+	keys := []KeyReport{
+		{Name: "busy", RequestsEstimate: 100, TrafficEstimate: 1000},
+		{Name: "large", RequestsEstimate: 2, TrafficEstimate: 2000},
+		{Name: "medium", RequestsEstimate: 10, TrafficEstimate: 1500},
+	}
+	expected := map[Metric][]string{
+		MetricBytes:    {"large", "medium", "busy"},
+		MetricRequests: {"busy", "medium", "large"},
+		MetricAvgSize:  {"large", "medium", "busy"},
+	}
+	for by, names := range expected {
+		sortByMetric(keys, by)
+		for i, name := range names {
+			if keys[i].Name != name {
+				t.Error("metric", by, "expected", names, "got", keys)
+				break
+			}
+		}
+	}
+}
+
+func TestSortByMetricTies(t *testing.T) {
+	keys := []KeyReport{
+		{Name: "c", TrafficEstimate: 10},
+		{Name: "a", Cluster: "west", TrafficEstimate: 10},
+		{Name: "b", TrafficEstimate: 20},
+		{Name: "a", TrafficEstimate: 10},
+	}
+	sortByMetric(keys, MetricBytes)
+	var labels []string
+	for _, kr := range keys {
+		labels = append(labels, kr.Label())
+	}
+	if got := strings.Join(labels, ","); got != "b,a,c,west a" {
+		t.Error("expected ties ordered by cluster and name, got", got)
+	}
+}
+
+func TestMergeKeys(t *testing.T) {
+	merged := mergeKeys([]KeyReport{
+		{Name: "a", Size: 10, RequestsEstimate: 1, TrafficEstimate: 10},
+		{Name: "b", Size: 5, RequestsEstimate: 1, TrafficEstimate: 5},
+		{Name: "a", Size: 30, RequestsEstimate: 3, TrafficEstimate: 90},
+		{Name: "a", MissesEstimate: 2},
+	})
+	if len(merged) != 2 {
+		t.Fatal("expected 2 keys, got", merged)
+	}
+	a := merged[0]
+	if a.Name != "a" || a.RequestsEstimate != 4 || a.TrafficEstimate != 100 ||
+		a.MissesEstimate != 2 || a.Size != 25 {
+		t.Error("incorrect merge of a:", a)
+	}
+	if !reflect.DeepEqual(merged[1], KeyReport{Name: "b", Size: 5, RequestsEstimate: 1, TrafficEstimate: 5}) {
+		t.Error("b should be unchanged, got", merged[1])
+	}
+}
+
+func TestShutdownDrainsQueuedEvents(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10})
+	for i := 0; i < 5; i++ {
+		p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 10}})
+	}
+	rep, err := p.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Keys) != 1 || rep.Keys[0].RequestsEstimate != 5 {
+		t.Error("expected 5 requests for a, got", rep.Keys)
+	}
+
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "b", Size: 10}})
+	if dropped := p.Stats().EventsDropped; dropped != 1 {
+		t.Error("expected event after shutdown to be dropped, got", dropped)
+	}
+}
+
+func TestQueueDepths(t *testing.T) {
+	p := &Pool{workers: []worker{*testWorker(WeightBytes), *testWorker(WeightBytes)}}
+	// without running loops the queues are never consumed
+	for i := range p.workers {
+		p.workers[i].kisChan = make(chan []keyEvent, 4)
+	}
+	p.workers[1].kisChan <- nil
+	p.workers[1].kisChan <- nil
+	depths := p.QueueDepths()
+	if len(depths) != 2 || depths[0] != 0 || depths[1] != 2 {
+		t.Error("expected depths [0 2], got", depths)
+	}
+}
+
+func TestSizeHistogram(t *testing.T) {
+	w := testWorker(WeightBytes)
+	recordHits(w, "a", 1000, 3)
+	recordHits(w, "a", 2000000, 2)
+	recordHits(w, "a", 100, 1)
+	res := topResult{model.EventGetHit: w.lists[model.EventGetHit].Top(10)}
+	krs := mergeKeys(keyReports(res, []int{512, 4096}))
+	if len(krs) != 1 {
+		t.Fatal("expected 1 key, got", krs)
+	}
+	h := krs[0].SizeHistogram
+	if len(h) != 3 || h[0] != 1 || h[1] != 3 || h[2] != 2 {
+		t.Error("expected histogram [1 3 2], got", h)
+	}
+
+	krs = keyReports(res, nil)
+	if krs[0].SizeHistogram != nil {
+		t.Error("expected no histogram without buckets, got", krs[0].SizeHistogram)
+	}
+}
+
+func TestSampleRateScalesEstimates(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, SampleRate: 0.25})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetMiss, Key: "a"},
+	})
+	p.Wait()
+	keys := p.Top(10, MetricBytes)
+	if len(keys) != 1 {
+		t.Fatal("expected 1 key, got", keys)
+	}
+	kr := keys[0]
+	if kr.RequestsEstimate != 4 || kr.MissesEstimate != 4 || kr.TrafficEstimate != 40 || kr.Size != 10 {
+		t.Error("expected estimates scaled by 4, got", kr)
+	}
+}
+
+func TestMinWeight(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10, MinWeight: 2})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventGetHit, Key: "b", Size: 1},
+	})
+	p.Wait()
+	if keys := p.Top(10, MetricRequests); len(keys) != 1 || keys[0].Name != "a" {
+		t.Error("expected only a to reach the minimum, got", keys)
+	}
+	if keys := p.Report(false).Keys; len(keys) != 1 || keys[0].Name != "a" {
+		t.Error("expected only a in report, got", keys)
+	}
+	if keys := p.Top(10, MetricAvgSize); len(keys) != 0 {
+		t.Error("expected no key with an average size of 2, got", keys)
+	}
+}
+
+func TestEstimateError(t *testing.T) {
+	events := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "b", Size: 100},
+	}
+
+	exact := New(Config{Workers: 1, ReportSize: 10})
+	defer exact.Shutdown(context.Background())
+	exact.HandleEvents(events)
+	exact.Wait()
+	for _, kr := range exact.Top(10, MetricRequests) {
+		if kr.RequestsError != 0 || kr.TrafficError != 0 {
+			t.Error("expected no error from perfect hotlist, got", kr)
+		}
+	}
+
+	// with room for one key, b displaces a and inherits its count of 2
+	approx := New(Config{Workers: 1, ReportSize: 10, NewHotList: func() hotlist.HotList {
+		return hotlist.NewSpaceSaving(1)
+	}})
+	defer approx.Shutdown(context.Background())
+	approx.HandleEvents(events)
+	approx.Wait()
+	keys := approx.Top(10, MetricRequests)
+	if len(keys) != 1 {
+		t.Fatal("expected 1 key, got", keys)
+	}
+	kr := keys[0]
+	if kr.Name != "b" || kr.RequestsEstimate != 3 || kr.RequestsError != 2 || kr.TrafficError != 20 {
+		t.Error("expected b with 3 requests ±2 and 20 bytes of error, got", kr)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, HistorySize: 2})
+	if h := p.History(); len(h) != 0 {
+		t.Error("expected empty history, got", h)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: key, Size: 1}})
+		p.Wait()
+		p.Report(true)
+	}
+
+	h := p.History()
+	if len(h) != 2 {
+		t.Fatal("expected 2 retained reports, got", h)
+	}
+	for i, key := range []string{"b", "c"} {
+		if len(h[i].Keys) != 1 || h[i].Keys[0].Name != key {
+			t.Error("expected report", i, "to contain only", key, "got", h[i].Keys)
+		}
+	}
+	if h[1].Timestamp.Before(h[0].Timestamp) {
+		t.Error("expected reports oldest first, got", h[0].Timestamp, h[1].Timestamp)
+	}
+
+	if h := New(Config{Workers: 1}).History(); h != nil {
+		t.Error("expected no history without HistorySize, got", h)
+	}
+}
+
+func TestResetIsAtomicWithReports(t *testing.T) {
+	p := New(Config{Workers: 4, ReportSize: 100})
+	var evts []model.Event
+	for i := 0; i < 20; i++ {
+		evts = append(evts, model.Event{Type: model.EventGetHit, Key: strconv.Itoa(i), Size: 1})
+	}
+	p.HandleEvents(evts)
+	p.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Reset()
+	}()
+	for {
+		select {
+		case <-done:
+			if keys := p.Top(100, MetricRequests); len(keys) != 0 {
+				t.Error("expected no keys after reset, got", keys)
+			}
+			return
+		default:
+		}
+		if keys := p.Top(100, MetricRequests); len(keys) != 0 && len(keys) != len(evts) {
+			t.Fatal("expected all keys or none, got", len(keys))
+		}
+	}
+}
+
+func TestReportEvery(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10})
+	reports := make(chan Report)
+	stop := p.ReportEvery(time.Millisecond, true, func(rep Report) {
+		reports <- rep
+	})
+	defer stop()
+
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}})
+	p.Wait()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case rep := <-reports:
+			if len(rep.Keys) == 0 {
+				continue
+			}
+			if rep.Keys[0].Name != "a" {
+				t.Fatal("expected report of key a, got", rep.Keys)
+			}
+			// the Pool is reset after each report
+			if keys := (<-reports).Keys; len(keys) != 0 {
+				t.Error("expected empty report after reset, got", keys)
+			}
+			return
+		case <-deadline:
+			t.Fatal("timed out waiting for report")
+		}
+	}
+}
+
+func TestTopContextCancelled(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10})
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}})
+	p.Wait()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.TopContext(ctx, 10, MetricBytes); err != context.Canceled {
+		t.Error("expected cancellation, got", err)
+	}
+	keys, err := p.TopContext(context.Background(), 10, MetricBytes)
+	if err != nil || len(keys) != 1 || keys[0].Name != "a" {
+		t.Error("expected key a after cancelled request, got", keys, err)
+	}
+}
+
+func TestDefaultWorkers(t *testing.T) {
+	p := New(Config{ReportSize: 10})
+	if len(p.workers) != runtime.GOMAXPROCS(0) {
+		t.Error("expected a worker per CPU, got", len(p.workers))
+	}
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}})
+	p.Wait()
+	if krs := p.Top(10, MetricRequests); len(krs) != 1 {
+		t.Error("expected key recorded, got", krs)
+	}
+}
@@ -0,0 +1,157 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"sort"
+	"sync"
+)
+
+// DefaultScanWindow is the number of gets over which a connection's keys are
+// compared if Config.ScanWindow is not positive.
+const DefaultScanWindow = 200
+
+// maxScanConns bounds the number of connections whose recent keys are
+// remembered, and the number flagged as scanning.  Once exceeded, the
+// connections are forgotten and measurement starts over, so that closed
+// connections do not accumulate.
+const maxScanConns = 4096
+
+// ScanReport describes a client connection whose most recently measured gets
+// were almost all for different keys, as when iterating through a range of
+// keys.
+type ScanReport struct {
+	// client and server addresses and ports of the connection
+	Conn string
+	// client address
+	Client string
+	// number of gets measured
+	Requests int
+	// number of distinct keys among those gets
+	UniqueKeys int
+}
+
+// scanWindow accumulates the keys requested by a single connection.
+type scanWindow struct {
+	keys map[string]struct{}
+	gets int
+}
+
+// scanDetector flags connections that request nearly every key only once.
+// scanDetector is threadsafe.
+type scanDetector struct {
+	threshold float64
+	window    int
+	exclude   bool
+
+	mu      sync.Mutex
+	windows map[string]*scanWindow
+	// connections flagged at the end of their last complete window
+	scanning map[string]ScanReport
+}
+
+func newScanDetector(conf Config) *scanDetector {
+	window := conf.ScanWindow
+	if window <= 0 {
+		window = DefaultScanWindow
+	}
+	return &scanDetector{
+		threshold: conf.ScanThreshold,
+		window:    window,
+		exclude:   conf.ExcludeScans,
+		windows:   make(map[string]*scanWindow),
+		scanning:  make(map[string]ScanReport),
+	}
+}
+
+// observe measures the gets in evts, and returns evts without the events of
+// scanning connections if those are excluded.
+func (sd *scanDetector) observe(evts []model.Event) []model.Event {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	for _, evt := range evts {
+		if evt.Conn == "" || !isGet(evt.Type) {
+			continue
+		}
+		sw, ok := sd.windows[evt.Conn]
+		if !ok {
+			if len(sd.windows) >= maxScanConns {
+				sd.windows = make(map[string]*scanWindow)
+			}
+			sw = &scanWindow{keys: make(map[string]struct{}, sd.window)}
+			sd.windows[evt.Conn] = sw
+		}
+		sw.keys[evt.Key] = struct{}{}
+		sw.gets++
+		if sw.gets >= sd.window {
+			sd.complete(evt, sw)
+		}
+	}
+
+	if !sd.exclude || len(sd.scanning) == 0 {
+		return evts
+	}
+	kept := make([]model.Event, 0, len(evts))
+	for _, evt := range evts {
+		if _, ok := sd.scanning[evt.Conn]; !ok {
+			kept = append(kept, evt)
+		}
+	}
+	return kept
+}
+
+// complete flags or clears the connection of evt based on its full window
+// sw, and starts a new window.
+func (sd *scanDetector) complete(evt model.Event, sw *scanWindow) {
+	if float64(len(sw.keys)) >= sd.threshold*float64(sw.gets) {
+		if _, ok := sd.scanning[evt.Conn]; !ok && len(sd.scanning) >= maxScanConns {
+			sd.scanning = make(map[string]ScanReport)
+		}
+		sd.scanning[evt.Conn] = ScanReport{
+			Conn:       evt.Conn,
+			Client:     evt.Client,
+			Requests:   sw.gets,
+			UniqueKeys: len(sw.keys),
+		}
+	} else {
+		delete(sd.scanning, evt.Conn)
+	}
+	delete(sd.windows, evt.Conn)
+}
+
+// reports returns the connections currently flagged as scanning.
+func (sd *scanDetector) reports() []ScanReport {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	ret := make([]ScanReport, 0, len(sd.scanning))
+	for _, sr := range sd.scanning {
+		ret = append(ret, sr)
+	}
+	return ret
+}
+
+func (sd *scanDetector) reset() {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	sd.windows = make(map[string]*scanWindow)
+	sd.scanning = make(map[string]ScanReport)
+}
+
+// scansByConn sorts ScanReports by connection.
+type scansByConn []ScanReport
+
+func (s scansByConn) Len() int           { return len(s) }
+func (s scansByConn) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s scansByConn) Less(i, j int) bool { return s[i].Conn < s[j].Conn }
+
+// Scanning returns the client connections whose most recent ScanWindow gets
+// were for at least a ScanThreshold fraction of distinct keys, as when a
+// client iterates through a range of keys.  Scanning returns nil unless the
+// Pool was configured with a ScanThreshold.
+func (p *Pool) Scanning() []ScanReport {
+	if p.scans == nil {
+		return nil
+	}
+	srs := p.scans.reports()
+	sort.Sort(scansByConn(srs))
+	return srs
+}
@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"strconv"
+	"testing"
+)
+
+func scanEvents(conn string, keys ...string) []model.Event {
+	evts := make([]model.Event, len(keys))
+	for i, key := range keys {
+		evts[i] = model.Event{Type: model.EventGetHit, Key: key, Size: 1, Client: "10.0.0.1", Conn: conn}
+	}
+	return evts
+}
+
+func TestScanDetected(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 100, ScanThreshold: 0.9, ScanWindow: 10})
+	var scanned []string
+	for i := 0; i < 10; i++ {
+		scanned = append(scanned, "item:"+strconv.Itoa(i))
+	}
+	p.HandleEvents(scanEvents("scanner", scanned...))
+	p.HandleEvents(scanEvents("normal", "a", "b", "a", "a", "c", "b", "a", "a", "b", "a"))
+
+	srs := p.Scanning()
+	if len(srs) != 1 {
+		t.Fatal("expected one scanning connection, got", srs)
+	}
+	if srs[0].Conn != "scanner" || srs[0].Requests != 10 || srs[0].UniqueKeys != 10 {
+		t.Error("unexpected scan report", srs[0])
+	}
+
+	// a later window with repeated keys clears the flag
+	p.HandleEvents(scanEvents("scanner", "a", "a", "a", "a", "a", "a", "a", "a", "a", "a"))
+	if srs := p.Scanning(); len(srs) != 0 {
+		t.Error("expected no scanning connections, got", srs)
+	}
+
+	if srs := New(Config{Workers: 1}).Scanning(); srs != nil {
+		t.Error("expected nil without ScanThreshold, got", srs)
+	}
+}
+
+func TestExcludeScans(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 100, ScanThreshold: 0.9, ScanWindow: 4, ExcludeScans: true})
+	// the first window is recorded before the connection is flagged
+	p.HandleEvents(scanEvents("scanner", "w", "x", "y", "z"))
+	p.HandleEvents(scanEvents("scanner", "later"))
+	p.HandleEvents(scanEvents("normal", "hot"))
+	p.Wait()
+
+	names := map[string]bool{}
+	for _, kr := range p.Top(100, MetricRequests) {
+		names[kr.Name] = true
+	}
+	if names["later"] {
+		t.Error("expected keys from scanning connection to be excluded")
+	}
+	if !names["hot"] {
+		t.Error("expected keys from other connections to be recorded")
+	}
+}
@@ -0,0 +1,154 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultSeenKeys is the number of distinct keys remembered to count new
+// keys if Config.SeenKeys is not positive.
+const DefaultSeenKeys = 1 << 20
+
+const (
+	// bits of each bloom filter per key remembered
+	bloomBitsPerKey = 10
+	// number of bits set for each key, which for bloomBitsPerKey gives a
+	// false positive rate of about 1% when a filter is full
+	bloomHashes = 7
+)
+
+// bloom is a bloom filter of a fixed size.
+type bloom struct {
+	bits []uint64
+	// number of keys added
+	n int
+}
+
+func newBloom(keys int) *bloom {
+	words := (keys*bloomBitsPerKey + 63) / 64
+	return &bloom{bits: make([]uint64, words)}
+}
+
+// has returns whether a key with hashes h1 and h2 may have been added.
+func (b *bloom) has(h1, h2 uint64) bool {
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds a key with hashes h1 and h2.
+func (b *bloom) add(h1, h2 uint64) {
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+	b.n++
+}
+
+// seenKeys remembers recently seen cache keys in bounded memory, using a
+// pair of bloom filters.  Once the current filter holds its capacity of
+// keys it replaces the previous filter and a new one is started, so keys
+// not seen for two generations are forgotten and counted as new again.
+// A small fraction of new keys are mistaken for ones already seen.
+//
+// The keys are divided among shards, each with an equal share of the
+// capacity, so that concurrent callers rarely wait for each other.
+// seenKeys is threadsafe.
+type seenKeys struct {
+	// capacity of each shard
+	capacity int
+	shards   []seenShard
+}
+
+// seenShard remembers the keys assigned to it.
+type seenShard struct {
+	mu   sync.Mutex
+	cur  *bloom
+	prev *bloom
+}
+
+// seenHashes are the hashes of a key.
+type seenHashes struct {
+	h1, h2 uint64
+}
+
+func newSeenKeys(capacity, shards int) *seenKeys {
+	if capacity <= 0 {
+		capacity = DefaultSeenKeys
+	}
+	if shards <= 0 {
+		shards = 1
+	}
+	sk := &seenKeys{
+		capacity: (capacity + shards - 1) / shards,
+		shards:   make([]seenShard, shards),
+	}
+	for i := range sk.shards {
+		sk.shards[i].cur = newBloom(sk.capacity)
+		sk.shards[i].prev = newBloom(sk.capacity)
+	}
+	return sk
+}
+
+// add remembers the keys of evts and returns the number of them not seen
+// before.
+func (sk *seenKeys) add(evts []model.Event) int64 {
+	perShard := make([][]seenHashes, len(sk.shards))
+	for _, evt := range evts {
+		h1, h2 := keyHashes(evt.Key)
+		// the high bits, which barely affect the bits set in a filter
+		s := (h1 >> 32) % uint64(len(sk.shards))
+		perShard[s] = append(perShard[s], seenHashes{h1, h2})
+	}
+	var n int64
+	for i, hs := range perShard {
+		if len(hs) > 0 {
+			n += sk.shards[i].add(hs, sk.capacity)
+		}
+	}
+	return n
+}
+
+// add remembers the keys with hashes hs, returning the number of them not
+// seen before, and begins a new generation each time the current one
+// holds capacity keys.
+func (ss *seenShard) add(hs []seenHashes, capacity int) int64 {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var n int64
+	for _, h := range hs {
+		if ss.cur.has(h.h1, h.h2) {
+			continue
+		}
+		if !ss.prev.has(h.h1, h.h2) {
+			n++
+		}
+		// keys still in use are carried into the current generation
+		ss.cur.add(h.h1, h.h2)
+		if ss.cur.n >= capacity {
+			ss.prev = ss.cur
+			ss.cur = newBloom(capacity)
+		}
+	}
+	return n
+}
+
+// keyHashes returns two hashes of key for double hashing.
+func keyHashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 := h.Sum64()
+	// derive a second hash by mixing the first, as in splitmix64
+	h2 := h1 * 0x9e3779b97f4a7c15
+	h2 = (h2 ^ h2>>30) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ h2>>27) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h1, h2
+}
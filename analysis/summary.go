@@ -0,0 +1,167 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Summary contains aggregate activity across all cache keys over a period.
+type Summary struct {
+	// beginning of the period, when the Pool was created or last reset
+	Start time.Time
+	// length of the period
+	Duration time.Duration
+	// whether the period began when the Pool was created, regardless of
+	// resets, so that counts never decrease
+	Monotonic bool
+	// estimated number of keys requested by get commands
+	GetsEstimate int64
+	// estimated number of those keys that were not found
+	MissesEstimate int64
+	// estimated number of storage commands
+	SetsEstimate int64
+	// estimated bytes of values returned by gets and sent by storage commands
+	TrafficEstimate int64
+	// estimated number of keys seen for the first time, if tracked.  Keys
+	// are remembered across resets, up to Config.SeenKeys of them.
+	NewKeysEstimate int64
+	// whether new keys are tracked, as by Config.TrackNewKeys
+	NewKeysTracked bool
+}
+
+// GetsPerSecond returns the average rate of gets over the period.
+func (s Summary) GetsPerSecond() float64 {
+	return s.rate(s.GetsEstimate)
+}
+
+// SetsPerSecond returns the average rate of storage commands over the
+// period.
+func (s Summary) SetsPerSecond() float64 {
+	return s.rate(s.SetsEstimate)
+}
+
+// BytesPerSecond returns the average bandwidth of values over the period.
+func (s Summary) BytesPerSecond() float64 {
+	return s.rate(s.TrafficEstimate)
+}
+
+// NewKeysPerSecond returns the average rate at which previously unseen keys
+// appeared over the period.  A sudden rise may indicate a stampede of
+// requests for keys that cannot be cached, or an explosion of the key space.
+func (s Summary) NewKeysPerSecond() float64 {
+	return s.rate(s.NewKeysEstimate)
+}
+
+// MissRatio returns the fraction of gets over the period that were misses,
+// or 0 if there were no gets.
+func (s Summary) MissRatio() float64 {
+	if s.GetsEstimate == 0 {
+		return 0
+	}
+	return float64(s.MissesEstimate) / float64(s.GetsEstimate)
+}
+
+func (s Summary) rate(n int64) float64 {
+	secs := s.Duration.Seconds()
+	if secs <= 0 {
+		return 0
+	}
+	return float64(n) / secs
+}
+
+// summaryCounters accumulates the activity reported by Summary.
+// Counts must be accessed atomically.
+type summaryCounters struct {
+	gets    int64
+	misses  int64
+	sets    int64
+	traffic int64
+	newKeys int64
+
+	// recently seen keys, or nil if new keys are not tracked.  Keys are
+	// divided among shards by hash, so batches from concurrent callers of
+	// HandleEvents rarely wait for each other.
+	seen *seenKeys
+
+	// guards start
+	mu    sync.Mutex
+	start time.Time
+}
+
+func newSummaryCounters(conf Config) *summaryCounters {
+	sc := &summaryCounters{start: time.Now()}
+	if conf.TrackNewKeys {
+		sc.seen = newSeenKeys(conf.SeenKeys, conf.Workers)
+	}
+	return sc
+}
+
+// add counts evts.
+func (sc *summaryCounters) add(evts []model.Event) {
+	var gets, misses, sets, traffic int64
+	for _, evt := range evts {
+		switch evt.Type {
+		case model.EventGetHit:
+			gets++
+			traffic += int64(evt.Size)
+		case model.EventGetMiss:
+			gets++
+			misses++
+		case model.EventGet:
+			gets++
+		case model.EventSet:
+			sets++
+			traffic += int64(evt.Size)
+		}
+	}
+	atomic.AddInt64(&sc.gets, gets)
+	atomic.AddInt64(&sc.misses, misses)
+	atomic.AddInt64(&sc.sets, sets)
+	atomic.AddInt64(&sc.traffic, traffic)
+	if sc.seen != nil {
+		atomic.AddInt64(&sc.newKeys, sc.seen.add(evts))
+	}
+}
+
+// reset clears the counts and begins a new period.  Events counted
+// concurrently may be attributed to either period.  Keys already seen are
+// still remembered.
+func (sc *summaryCounters) reset() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.start = time.Now()
+	atomic.StoreInt64(&sc.gets, 0)
+	atomic.StoreInt64(&sc.misses, 0)
+	atomic.StoreInt64(&sc.sets, 0)
+	atomic.StoreInt64(&sc.traffic, 0)
+	atomic.StoreInt64(&sc.newKeys, 0)
+}
+
+func (sc *summaryCounters) summary(scale float64) Summary {
+	sc.mu.Lock()
+	start := sc.start
+	sc.mu.Unlock()
+	return Summary{
+		Start:           start,
+		Duration:        time.Since(start),
+		GetsEstimate:    int64(float64(atomic.LoadInt64(&sc.gets)) * scale),
+		MissesEstimate:  int64(float64(atomic.LoadInt64(&sc.misses)) * scale),
+		SetsEstimate:    int64(float64(atomic.LoadInt64(&sc.sets)) * scale),
+		TrafficEstimate: int64(float64(atomic.LoadInt64(&sc.traffic)) * scale),
+		NewKeysEstimate: int64(float64(atomic.LoadInt64(&sc.newKeys)) * scale),
+		NewKeysTracked:  sc.seen != nil,
+	}
+}
+
+// Summary returns aggregate activity across all keys recorded in this Pool
+// since it was last reset, including events dropped because workers could
+// not keep up.  If the Pool was configured with MonotonicSummary, activity
+// is instead counted since the Pool was created, as Prometheus counters
+// expect.
+func (p *Pool) Summary() Summary {
+	s := p.summary.summary(p.scale)
+	s.Monotonic = p.monotonicSummary
+	return s
+}
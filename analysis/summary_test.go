@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/protocol/model"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSummary(t *testing.T) {
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "b", Size: 20},
+		{Type: model.EventGetMiss, Key: "c"},
+		{Type: model.EventGetMiss, Key: "d"},
+		{Type: model.EventSet, Key: "a", Size: 5},
+		{Type: model.EventDelete, Key: "b"},
+	}
+	p := New(Config{Workers: 2, ReportSize: 10, SampleRate: 0.5})
+	p.HandleEvents(evts)
+
+	s := p.Summary()
+	if s.GetsEstimate != 8 || s.MissesEstimate != 4 || s.SetsEstimate != 2 || s.TrafficEstimate != 70 {
+		t.Error("unexpected summary", s)
+	}
+	if s.MissRatio() != 0.5 {
+		t.Error("expected miss ratio 0.5, got", s.MissRatio())
+	}
+
+	p.Report(true)
+	if s := p.Summary(); s.GetsEstimate != 0 || s.Monotonic {
+		t.Error("expected summary to reset with the report, got", s)
+	}
+
+	p = New(Config{Workers: 2, ReportSize: 10, MonotonicSummary: true})
+	p.HandleEvents(evts)
+	p.Report(true)
+	p.Reset()
+	if s := p.Summary(); s.GetsEstimate != 4 || !s.Monotonic {
+		t.Error("expected monotonic summary to survive resets, got", s)
+	}
+}
+
+func TestSummaryRates(t *testing.T) {
+	s := Summary{Duration: 2 * time.Second, GetsEstimate: 10, SetsEstimate: 4, TrafficEstimate: 100}
+	if s.GetsPerSecond() != 5 || s.SetsPerSecond() != 2 || s.BytesPerSecond() != 50 {
+		t.Error("unexpected rates", s.GetsPerSecond(), s.SetsPerSecond(), s.BytesPerSecond())
+	}
+	if (Summary{}).GetsPerSecond() != 0 || (Summary{}).MissRatio() != 0 {
+		t.Error("expected zero rates for an empty summary")
+	}
+}
+
+func TestNewKeys(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10, TrackNewKeys: true})
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventGetHit, Key: "b", Size: 1},
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+	})
+	if s := p.Summary(); s.NewKeysEstimate != 2 {
+		t.Error("expected 2 new keys, got", s.NewKeysEstimate)
+	}
+
+	// keys are remembered across resets
+	p.Report(true)
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventGetMiss, Key: "c"},
+	})
+	if s := p.Summary(); s.NewKeysEstimate != 1 {
+		t.Error("expected 1 new key after reset, got", s.NewKeysEstimate)
+	}
+
+	p = New(Config{Workers: 2, ReportSize: 10})
+	p.HandleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}})
+	if s := p.Summary(); s.NewKeysEstimate != 0 {
+		t.Error("expected new keys not to be tracked, got", s.NewKeysEstimate)
+	}
+}
+
+func TestSeenKeysBounded(t *testing.T) {
+	sk := newSeenKeys(100, 1)
+	evt := func(i int) []model.Event {
+		return []model.Event{{Key: "key:" + strconv.Itoa(i)}}
+	}
+	var n int64
+	for i := 0; i < 1000; i++ {
+		n += sk.add(evt(i))
+	}
+	// a few may be lost to false positives
+	if n < 980 {
+		t.Error("expected nearly all keys to be new, got", n)
+	}
+	if len(sk.shards[0].cur.bits) != len(newBloom(100).bits) {
+		t.Error("expected filter size to stay fixed")
+	}
+	// the most recent keys are still remembered, the oldest forgotten
+	if sk.add(evt(999)) != 0 {
+		t.Error("expected recent key to be remembered")
+	}
+	if sk.add(evt(0)) != 1 {
+		t.Error("expected old key to be forgotten")
+	}
+}
@@ -0,0 +1,70 @@
+package analysis
+
+// maxTTLsPerKey is the number of distinct TTLs tallied for each cache key.
+// Keys are usually stored with one TTL or a few, so the rest are only
+// likely to be sent by a client choosing TTLs at random.
+const maxTTLsPerKey = 16
+
+// ttlCounts tallies the TTLs sent with the storage commands on each cache
+// key, in bounded memory.  Keys are remembered in generations as by
+// lastSeen, so keys not stored for two generations are forgotten, and at
+// most maxTTLsPerKey distinct TTLs are tallied for each key.
+type ttlCounts struct {
+	capacity int
+	cur      map[keyName]map[int]int
+	prev     map[keyName]map[int]int
+}
+
+func newTTLCounts(capacity int) *ttlCounts {
+	if capacity <= 0 {
+		capacity = DefaultSeenKeys
+	}
+	return &ttlCounts{
+		capacity: capacity,
+		cur:      make(map[keyName]map[int]int),
+	}
+}
+
+// add counts a storage command on kn with ttl.
+func (tc *ttlCounts) add(kn keyName, ttl int) {
+	counts, ok := tc.cur[kn]
+	if !ok {
+		// keys still in use are carried into the current generation
+		counts = tc.prev[kn]
+		if counts == nil {
+			counts = make(map[int]int, 1)
+		}
+		tc.cur[kn] = counts
+		if len(tc.cur) >= tc.capacity {
+			tc.prev = tc.cur
+			tc.cur = make(map[keyName]map[int]int)
+		}
+	}
+	if _, ok := counts[ttl]; ok || len(counts) < maxTTLsPerKey {
+		counts[ttl]++
+	}
+}
+
+// get returns a copy of the TTLs tallied for kn, or nil if it has been
+// forgotten.
+func (tc *ttlCounts) get(kn keyName) map[int]int {
+	counts, ok := tc.cur[kn]
+	if !ok {
+		counts = tc.prev[kn]
+	}
+	if counts == nil {
+		return nil
+	}
+	// the worker goes on counting in the original
+	cp := make(map[int]int, len(counts))
+	for ttl, n := range counts {
+		cp[ttl] = n
+	}
+	return cp
+}
+
+// reset forgets all keys.
+func (tc *ttlCounts) reset() {
+	tc.cur = make(map[keyName]map[int]int)
+	tc.prev = nil
+}
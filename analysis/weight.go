@@ -0,0 +1,59 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// MaxWeight is the largest weight given to a single value by
+// Config.WeightFunc, so that hotlists can sum the weights of billions of
+// values without overflow.
+const MaxWeight = math.MaxInt32
+
+// WeightSizeLogSize weights a value of size bytes by size * log2(size), to
+// rank keys with large values above keys moving the same number of bytes
+// in small values.
+func WeightSizeLogSize(size int) int {
+	if size < 2 {
+		return size
+	}
+	return clampWeight(float64(size) * math.Log2(float64(size)))
+}
+
+// WeightPower returns a weight function giving a value of size bytes the
+// weight size^exp.  An exp greater than 1 favours keys with large values.
+func WeightPower(exp float64) func(size int) int {
+	return func(size int) int {
+		return clampWeight(math.Pow(float64(size), exp))
+	}
+}
+
+// ParseWeightFunc returns the weight function with the given name: linear,
+// for which it returns nil, sizelog for WeightSizeLogSize, or a positive
+// exponent for WeightPower.
+func ParseWeightFunc(name string) (func(size int) int, error) {
+	switch name {
+	case "linear":
+		return nil, nil
+	case "sizelog":
+		return WeightSizeLogSize, nil
+	}
+	exp, err := strconv.ParseFloat(name, 64)
+	if err != nil || !(exp > 0) || math.IsInf(exp, 0) {
+		return nil, fmt.Errorf("unknown weight function %q", name)
+	}
+	return WeightPower(exp), nil
+}
+
+// clampWeight converts w to an int between 0 and MaxWeight.
+func clampWeight(w float64) int {
+	switch {
+	case w > MaxWeight:
+		return MaxWeight
+	case w > 0:
+		return int(w)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,72 @@
+package analysis
+
+import (
+	"github.com/box/memsniff/hotlist"
+	"github.com/box/memsniff/protocol/model"
+	"math"
+	"testing"
+)
+
+func TestWeightSizeLogSize(t *testing.T) {
+	for size, want := range map[int]int{0: 0, 1: 1, 2: 2, 1024: 10240} {
+		if got := WeightSizeLogSize(size); got != want {
+			t.Error("expected weight", want, "for size", size, "got", got)
+		}
+	}
+	if got := WeightSizeLogSize(math.MaxInt32); got != MaxWeight {
+		t.Error("expected weight of huge value capped at MaxWeight, got", got)
+	}
+}
+
+func TestWeightPower(t *testing.T) {
+	square := WeightPower(2)
+	if got := square(100); got != 10000 {
+		t.Error("expected 10000, got", got)
+	}
+	if got := square(1 << 20); got != MaxWeight {
+		t.Error("expected overflowing weight capped at MaxWeight, got", got)
+	}
+}
+
+func TestParseWeightFunc(t *testing.T) {
+	if f, err := ParseWeightFunc("linear"); f != nil || err != nil {
+		t.Error("expected nil function for linear, got", err)
+	}
+	if f, err := ParseWeightFunc("1.5"); err != nil || f(100) != 1000 {
+		t.Error("expected exponent 1.5, got", err)
+	}
+	for _, name := range []string{"", "quadratic", "0", "-1", "NaN", "Inf"} {
+		if _, err := ParseWeightFunc(name); err == nil {
+			t.Error("expected error for", name)
+		}
+	}
+}
+
+func TestWeightFuncRanksLargeValues(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.weightFunc = WeightPower(2)
+	// the same traffic, in one large value or many small ones
+	recordHits(w, "large", 1000, 1)
+	recordHits(w, "small", 10, 100)
+
+	top := w.lists[model.EventGetHit].Top(1)
+	if len(top) != 1 || itemKeyInfo(top[0].Item()).name != "large" {
+		t.Fatal("expected large value to rank first, got", top)
+	}
+	krs := keyReports(topResult{model.EventGetHit: top}, nil)
+	if krs[0].TrafficEstimate != 1000 || krs[0].Size != 1000 {
+		t.Error("expected actual traffic to be reported, got", krs[0])
+	}
+}
+
+func TestWeightFuncOverflow(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.lists[model.EventSet] = hotlist.NewPerfect()
+	w.weightFunc = func(size int) int { return size * size * size }
+	w.record(keyEvent{evtType: model.EventSet, ki: keyInfo{name: "a", size: 1 << 21}})
+
+	top := w.lists[model.EventSet].Top(1)
+	if len(top) != 1 || top[0].Weight() != MaxWeight {
+		t.Error("expected overflowing weight capped at MaxWeight, got", top)
+	}
+}
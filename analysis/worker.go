@@ -1,23 +1,90 @@
 package analysis
 
 import (
+	"context"
 	"errors"
 	"github.com/box/memsniff/hotlist"
 	"github.com/box/memsniff/protocol/model"
+	"hash"
+	"hash/fnv"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
+// decayInterval is how often hotlists that implement hotlist.Decayer are aged.
+const decayInterval = time.Second
+
+// eventConnection is the pseudo event type under which a worker tracks
+// activity by connection.  It is recorded for every event that carries a
+// connection, in addition to the event itself.
+const eventConnection model.EventType = -1
+
+// eventStoreFailed is the pseudo event type under which a worker counts
+// storage commands the server did not store, when storage commands are
+// tracked.  It is recorded in addition to the event itself.
+const eventStoreFailed model.EventType = -3
+
 // worker accumulates usage data for a set of cache keys.
 type worker struct {
-	// hotlist of the busiest cache keys tracked by this worker
-	hl hotlist.HotList
+	// how keys are ranked in the hotlist
+	mode WeightMode
+	// weight of a value by its size in WeightBytes mode, or nil to weight
+	// values by size
+	weightFunc func(size int) int
+	// what activity is attributed to
+	dimension Dimension
+	// cluster of each server, or nil if activity is not attributed to
+	// clusters
+	clusters map[string]string
+	// whether activity is also attributed to the interface it was captured
+	// on
+	byInterface bool
+	// how often to rotate the hotlists if they implement hotlist.Rotator
+	rotateInterval time.Duration
+	// hotlists of the busiest cache keys tracked by this worker, by the type
+	// of event observed.  The map itself is not modified after creation.
+	lists map[model.EventType]hotlist.HotList
 	// channel for reports of cache key activity
-	kisChan chan []keyInfo
+	kisChan chan []keyEvent
+	// batches already recorded from kisChan, for reuse by handleEvents
+	free chan []keyEvent
+	// how long handleEvents waits for space in kisChan before dropping input
+	blockTimeout time.Duration
 	// channel for requests for the current contents of the hotlist
-	topRequest chan int
-	// channel for results of top() requests
-	topReply chan []hotlist.Entry
+	topRequest chan topQuery
 	// channel for requests to reset the hotlist to an empty state
 	resetRequest chan bool
+	// channel for requests for the memory held by the hotlists
+	memRequest chan chan WorkerMemStats
+	// counts of input discarded because the worker could not keep up
+	drops *workerDrops
+	// keys to track by prefix, or nil to track all keys
+	prefixes *prefixFilter
+	// when each key was last active, or nil if not tracked
+	lastSeen *lastSeen
+	// example original keys of each family, or nil if not retained
+	examples *examples
+	// distinct clients of each key, or nil if not tracked
+	fanout *fanout
+	// TTLs sent with the storage commands on each key, or nil if storage
+	// commands are not tracked
+	ttls *ttlCounts
+	// commands modifying each key, or nil if access is not classified
+	writes *writeCounts
+	// keys recorded and the sum of their lengths, to estimate the memory
+	// held by keys in the hotlists.  Accessed only by the worker goroutine.
+	recorded int64
+	keyBytes int64
+}
+
+// workerDrops counts input discarded by a worker.
+// Fields must be accessed atomically.
+type workerDrops struct {
+	// number of calls to handleEvents that returned errQueueFull
+	batches int64
+	// number of cache keys in those batches
+	keys int64
 }
 
 // keyInfo is the hotlist key for a cache key and value.
@@ -25,6 +92,17 @@ type worker struct {
 type keyInfo struct {
 	name string
 	size int
+	// client address, if tracked by the Dimension
+	client string
+	// cluster of the server, if tracked
+	cluster string
+	// whether parts of name were masked by Config.RedactPatterns
+	redacted bool
+}
+
+// keyName returns the keyName of the cache key ki is for.
+func (ki keyInfo) keyName() keyName {
+	return keyName{ki.name, ki.client, ki.cluster, ki.redacted}
 }
 
 // Weight implement hotlist.Item and gives each key weight equal to the size of
@@ -33,17 +111,286 @@ func (ki keyInfo) Weight() int {
 	return ki.size
 }
 
+// Less implements hotlist.OrderedItem, ordering keys by name, then by the
+// rest of their fields.  countedKey and weightedKey are ordered the same way.
+func (ki keyInfo) Less(other hotlist.Item) bool {
+	o := itemKeyInfo(other)
+	if ki.keyName() != o.keyName() {
+		return ki.keyName().less(o.keyName())
+	}
+	return ki.size < o.size
+}
+
+// Hash implements hotlist.HashedItem.  countedKey and weightedKey hash the
+// same way.
+func (ki keyInfo) Hash() uint64 {
+	h := fnv.New64a()
+	ki.keyName().write(h)
+	// writing to a Hash can never fail
+	_, _ = h.Write([]byte(strconv.Itoa(ki.size)))
+	return h.Sum64()
+}
+
+// WeightMode determines how cache keys are ranked against each other.
+type WeightMode int
+
+const (
+	// WeightBytes ranks keys by the total size of values transferred.
+	WeightBytes WeightMode = iota
+	// WeightCount ranks keys by the number of requests, regardless of the
+	// size of the value.
+	WeightCount
+)
+
+// Dimension determines what activity is attributed to.
+type Dimension int
+
+const (
+	// DimensionKey attributes activity to cache keys.
+	DimensionKey Dimension = iota
+	// DimensionClientKey attributes activity to each combination of client
+	// address and cache key.
+	DimensionClientKey
+	// DimensionClient attributes activity to client addresses, regardless of
+	// cache key.
+	DimensionClient
+)
+
+// weightedKey is the hotlist key for a cache key and value when values are
+// weighted by Config.WeightFunc rather than by size.  The weight is derived
+// from the size, so items remain comparable for equality.
+type weightedKey struct {
+	keyInfo
+	weight int
+}
+
+// Weight implements hotlist.Item and gives the value its computed weight.
+func (wk weightedKey) Weight() int {
+	return wk.weight
+}
+
+// countedKey is the hotlist key for a cache key and value when ranking by
+// request count.  Wrapping keyInfo keeps items comparable for equality while
+// overriding its weight.
+type countedKey struct {
+	keyInfo
+}
+
+// Weight implements hotlist.Item and gives each request unit weight.
+func (ck countedKey) Weight() int {
+	return 1
+}
+
+// item returns the hotlist item for ke.  Events that carry a value or delta
+// are weighted according to the weight mode, while other events are only
+// counted.
+func (w *worker) item(ke keyEvent) hotlist.Item {
+	switch ke.evtType {
+	case model.EventGetHit, model.EventSet, model.EventIncr, model.EventDecr, eventConnection:
+		if w.mode == WeightCount {
+			return countedKey{ke.ki}
+		}
+		if w.weightFunc != nil && (ke.evtType == model.EventGetHit || ke.evtType == model.EventSet) {
+			return weightedKey{ke.ki, w.weight(ke.ki.size)}
+		}
+		return ke.ki
+	default:
+		return ke.ki.keyName()
+	}
+}
+
+// itemKeyInfo recovers the keyInfo from a hotlist item created by item.
+func itemKeyInfo(it hotlist.Item) keyInfo {
+	switch it := it.(type) {
+	case countedKey:
+		return it.keyInfo
+	case weightedKey:
+		return it.keyInfo
+	default:
+		return it.(keyInfo)
+	}
+}
+
+// keyName is the hotlist key for events on a cache key that are counted
+// without regard to value size, such as a miss.
+type keyName struct {
+	name     string
+	client   string
+	cluster  string
+	redacted bool
+}
+
+// Weight implements hotlist.Item and gives each event unit weight.
+func (kn keyName) Weight() int {
+	return 1
+}
+
+// Less implements hotlist.OrderedItem.
+func (kn keyName) Less(other hotlist.Item) bool {
+	return kn.less(other.(keyName))
+}
+
+// Hash implements hotlist.HashedItem.
+func (kn keyName) Hash() uint64 {
+	h := fnv.New64a()
+	kn.write(h)
+	return h.Sum64()
+}
+
+// write writes the fields of kn to h, each terminated by a NUL byte so
+// that where one field ends is part of the hash.
+func (kn keyName) write(h hash.Hash64) {
+	redacted := "0"
+	if kn.redacted {
+		redacted = "1"
+	}
+	for _, f := range []string{kn.name, kn.client, kn.cluster, redacted} {
+		// writing to a Hash can never fail
+		_, _ = h.Write([]byte(f))
+		_, _ = h.Write([]byte{0})
+	}
+}
+
+// less orders keyNames by name, then client, cluster and whether redacted.
+func (kn keyName) less(o keyName) bool {
+	switch {
+	case kn.name != o.name:
+		return kn.name < o.name
+	case kn.client != o.client:
+		return kn.client < o.client
+	case kn.cluster != o.cluster:
+		return kn.cluster < o.cluster
+	default:
+		return !kn.redacted && o.redacted
+	}
+}
+
+// keyEvent is a single observation of cache key activity.
+type keyEvent struct {
+	evtType model.EventType
+	ki      keyInfo
+	// when the event was observed
+	seen time.Time
+	// the original key, if an example of the family of keys in ki
+	original string
+	// address of the client, if the clients of each key are counted
+	client string
+	// expiration time sent with a storage command, if it sets one
+	ttl    int
+	hasTTL bool
+}
+
+// topQuery is a request for the current contents of a worker's hotlists.
+// Each query carries its own reply channel so that concurrent callers
+// receive their own results.  The channel has room for the reply, so the
+// worker never waits for a caller that has given up.
+type topQuery struct {
+	k int
+	// if true, clear the hotlists immediately after taking the snapshot
+	reset bool
+	reply chan topReply
+}
+
+// topResult is a snapshot of the busiest keys tracked by a worker, by the
+// type of event observed.
+type topResult map[model.EventType][]hotlist.Entry
+
+// topReply answers a topQuery.
+type topReply struct {
+	lists topResult
+	// when each key in lists was last active, or nil if not tracked
+	lastSeen map[keyName]time.Time
+	// example original keys of each family in lists, or nil if not
+	// retained
+	examples map[keyName][]string
+	// distinct clients of each key in lists, or nil if not tracked
+	clients map[keyName]int
+	// TTLs sent with the storage commands on each key in lists, or nil if
+	// not tracked
+	ttls map[keyName]map[int]int
+	// commands modifying each key in lists, or nil if not counted
+	writes map[keyName]int
+}
+
 // errQueueFull is returned by handleGetResponse if the worker cannot keep
 // up with incoming calls.
 var errQueueFull = errors.New("analysis worker queue full")
 
-func newWorker() worker {
+func newWorker(conf Config, prefixes *prefixFilter) worker {
+	newHotList := conf.NewHotList
+	var rotateInterval time.Duration
+	if conf.Window > 0 {
+		newHotList = func() hotlist.HotList {
+			return hotlist.NewWindowed(conf.WindowBuckets, conf.NewHotList)
+		}
+		rotateInterval = conf.Window / time.Duration(conf.WindowBuckets)
+		if rotateInterval < time.Nanosecond {
+			// a window too short to divide still slides
+			rotateInterval = time.Nanosecond
+		}
+	}
+
+	lists := map[model.EventType]hotlist.HotList{
+		model.EventGetHit:  newHotList(),
+		model.EventGetMiss: newHotList(),
+	}
+	if conf.TrackSets {
+		lists[model.EventSet] = newHotList()
+		lists[eventStoreFailed] = newHotList()
+	}
+	if conf.TrackDeletes {
+		lists[model.EventDelete] = newHotList()
+	}
+	if conf.TrackCounters {
+		lists[model.EventIncr] = newHotList()
+		lists[model.EventDecr] = newHotList()
+	}
+	if conf.TrackConnections {
+		lists[eventConnection] = newHotList()
+	}
+	if conf.TrackCAS {
+		lists[model.EventCASStored] = newHotList()
+		lists[model.EventCASExists] = newHotList()
+		lists[model.EventCASNotFound] = newHotList()
+	}
+	if conf.ReadWriteRatio > 0 {
+		lists[eventWrite] = newHotList()
+	}
+	if conf.TrackGets {
+		lists[model.EventGet] = newHotList()
+	}
+
 	w := worker{
-		hl:           hotlist.NewPerfect(),
-		kisChan:      make(chan []keyInfo, 1024),
-		topRequest:   make(chan int),
-		topReply:     make(chan []hotlist.Entry),
-		resetRequest: make(chan bool),
+		mode:           conf.WeightMode,
+		weightFunc:     conf.WeightFunc,
+		dimension:      conf.Dimension,
+		clusters:       conf.Clusters,
+		byInterface:    conf.ByInterface,
+		rotateInterval: rotateInterval,
+		blockTimeout:   conf.BlockTimeout,
+		lists:          lists,
+		kisChan:        make(chan []keyEvent, conf.QueueSize),
+		free:           make(chan []keyEvent, conf.QueueSize+1),
+		topRequest:     make(chan topQuery),
+		resetRequest:   make(chan bool),
+		memRequest:     make(chan chan WorkerMemStats),
+		drops:          &workerDrops{},
+		prefixes:       prefixes,
+	}
+	if conf.TrackLastSeen {
+		w.lastSeen = newLastSeen(conf.MaxKeys)
+	}
+	if conf.Examples > 0 && conf.NormalizeKey != nil {
+		w.examples = newExamples(conf.Examples, conf.MaxKeys)
+	}
+	if conf.TrackFanout {
+		w.fanout = newFanout(conf.FanoutKeys)
+	}
+	if conf.TrackSets {
+		w.ttls = newTTLCounts(conf.MaxKeys)
+	}
+	if conf.ReadWriteRatio > 0 {
+		w.writes = newWriteCounts(conf.MaxKeys)
 	}
 	go w.loop()
 	return w
@@ -56,26 +403,199 @@ func newWorker() worker {
 func (w *worker) handleEvents(evts []model.Event) error {
 	// Make sure we copy r.Key before we return, since it may be a pointer
 	// into a buffer that will be overwritten.
-	kis := make([]keyInfo, 0, len(evts))
-	for i, evt := range evts {
-		if evt.Type == model.EventGetHit {
-			kis = kis[:i+1]
-			kis[i] = keyInfo{evt.Key, evt.Size}
+	kis := w.batch(len(evts))
+	_, trackConns := w.lists[eventConnection]
+	_, trackWrites := w.lists[eventWrite]
+	_, trackFailures := w.lists[eventStoreFailed]
+	var now time.Time
+	if w.lastSeen != nil {
+		now = time.Now()
+	}
+	for _, evt := range evts {
+		var client string
+		if w.fanout != nil {
+			client = evt.Client
+		}
+		if w.prefixes != nil && !w.prefixes.accept(evt.Key) {
+			continue
+		}
+		seen := evt.Timestamp
+		if seen.IsZero() {
+			seen = now
+		}
+		if _, ok := w.lists[evt.Type]; ok {
+			ke := keyEvent{evtType: evt.Type, ki: w.keyInfo(evt), seen: seen, original: evt.Original, client: client}
+			if evt.Type == model.EventSet && !evt.KeepsTTL {
+				ke.ttl, ke.hasTTL = evt.TTL, true
+			}
+			kis = append(kis, ke)
+		}
+		if trackWrites && isWrite(evt.Type) {
+			kis = append(kis, keyEvent{evtType: eventWrite, ki: w.keyInfo(evt), seen: seen})
+		}
+		if trackFailures && evt.Type == model.EventStoreFailed {
+			kis = append(kis, keyEvent{evtType: eventStoreFailed, ki: w.keyInfo(evt), seen: seen})
+		}
+		if trackConns && evt.Conn != "" {
+			kis = append(kis, keyEvent{evtType: eventConnection, ki: connInfo(evt), seen: seen})
 		}
 	}
 	select {
 	case w.kisChan <- kis:
 		return nil
 	default:
-		return errQueueFull
 	}
+	if w.blockTimeout > 0 {
+		timer := time.NewTimer(w.blockTimeout)
+		defer timer.Stop()
+		select {
+		case w.kisChan <- kis:
+			return nil
+		case <-timer.C:
+		}
+	}
+	atomic.AddInt64(&w.drops.batches, 1)
+	atomic.AddInt64(&w.drops.keys, int64(len(kis)))
+	w.release(kis)
+	return errQueueFull
+}
+
+// batch returns an empty batch for about n keyEvents, reusing one released
+// by the worker if available so that handling events does not allocate in
+// the steady state.  A reused batch that is too small grows as it is
+// filled, and is reused at its new size once released.
+func (w *worker) batch(n int) []keyEvent {
+	select {
+	case kis := <-w.free:
+		return kis[:0]
+	default:
+	}
+	return make([]keyEvent, 0, n)
+}
+
+// release makes kis available for reuse by batch.  kis must not be used
+// after it is released.
+func (w *worker) release(kis []keyEvent) {
+	// don't keep cache keys alive while the batch is unused
+	for i := range kis {
+		kis[i] = keyEvent{}
+	}
+	select {
+	case w.free <- kis:
+	default:
+	}
+}
+
+// keyInfo returns the keyInfo for evt according to the dimension and
+// clusters.
+func (w *worker) keyInfo(evt model.Event) keyInfo {
+	var ki keyInfo
+	switch w.dimension {
+	case DimensionClientKey:
+		ki = keyInfo{name: evt.Key, size: evt.Size, client: evt.Client, redacted: evt.Redacted}
+	case DimensionClient:
+		// named by the client, so that reports have a name to show
+		ki = keyInfo{name: evt.Client, size: evt.Size, client: evt.Client}
+	default:
+		ki = keyInfo{name: evt.Key, size: evt.Size, redacted: evt.Redacted}
+	}
+	ki.cluster = clusterOf(w.clusters, evt.Server)
+	if w.byInterface && evt.Interface != "" {
+		if w.clusters != nil {
+			ki.cluster += "@"
+		}
+		ki.cluster += evt.Interface
+	}
+	return ki
+}
+
+// weight returns the weight of a value of size bytes according to
+// weightFunc, guarding against functions that overflow.
+func (w *worker) weight(size int) int {
+	wt := w.weightFunc(size)
+	if wt < 0 || wt > MaxWeight {
+		// the function overflowed, as only the largest values can
+		return MaxWeight
+	}
+	return wt
+}
+
+// connInfo returns the keyInfo under which evt is tracked by connection.
+// Only values transferred count toward the size.
+func connInfo(evt model.Event) keyInfo {
+	ki := keyInfo{name: evt.Conn}
+	switch evt.Type {
+	case model.EventGetHit, model.EventSet:
+		ki.size = evt.Size
+	}
+	return ki
+}
+
+// dropped returns the number of batches and cache keys discarded by this
+// worker because its queue was full.
+// dropped is threadsafe.
+func (w *worker) dropped() (batches, keys int64) {
+	return atomic.LoadInt64(&w.drops.batches), atomic.LoadInt64(&w.drops.keys)
 }
 
-// top returns the current contents of the hotlist for this worker.
+// evicted returns the number of keys discarded by this worker's hotlists to
+// bound their memory consumption.
+// evicted is threadsafe.
+func (w *worker) evicted() int64 {
+	var n int64
+	for _, hl := range w.lists {
+		if e, ok := hl.(hotlist.Evicter); ok {
+			n += e.Evicted()
+		}
+	}
+	return n
+}
+
+// queueDepth returns the number of event batches waiting to be recorded.
+// queueDepth is threadsafe.
+func (w *worker) queueDepth() int {
+	return len(w.kisChan)
+}
+
+// top returns the current contents of the hotlists for this worker.
 // top is threadsafe.
-func (w *worker) top(k int) []hotlist.Entry {
-	w.topRequest <- k
-	return <-w.topReply
+func (w *worker) top(k int) topResult {
+	res, _ := w.query(context.Background(), k, false)
+	return res.lists
+}
+
+// topContext is like top, but gives up and returns ctx.Err() if ctx is done
+// before the worker replies.  The worker carries on recording events either
+// way.
+// topContext is threadsafe.
+func (w *worker) topContext(ctx context.Context, k int) (topReply, error) {
+	return w.query(ctx, k, false)
+}
+
+// topAndReset returns the current contents of the hotlists for this worker
+// and clears them, with no activity recorded in between.
+// topAndReset is threadsafe.
+func (w *worker) topAndReset(k int) topReply {
+	res, _ := w.query(context.Background(), k, true)
+	return res
+}
+
+func (w *worker) query(ctx context.Context, k int, reset bool) (topReply, error) {
+	if err := ctx.Err(); err != nil {
+		return topReply{}, err
+	}
+	reply := make(chan topReply, 1)
+	select {
+	case w.topRequest <- topQuery{k, reset, reply}:
+	case <-ctx.Done():
+		return topReply{}, ctx.Err()
+	}
+	select {
+	case res := <-reply:
+		return res, nil
+	case <-ctx.Done():
+		return topReply{}, ctx.Err()
+	}
 }
 
 // reset clear the contents of the hotlist for this worker.
@@ -92,21 +612,217 @@ func (w *worker) close() {
 }
 
 func (w *worker) loop() {
+	// all hotlists are created by the same constructor, so examine one
+	hl := w.lists[model.EventGetHit]
+	var decayTick <-chan time.Time
+	if _, ok := hl.(hotlist.Decayer); ok {
+		ticker := time.NewTicker(decayInterval)
+		defer ticker.Stop()
+		decayTick = ticker.C
+	}
+	var rotateTick <-chan time.Time
+	if _, ok := hl.(hotlist.Rotator); ok && w.rotateInterval > 0 {
+		ticker := time.NewTicker(w.rotateInterval)
+		defer ticker.Stop()
+		rotateTick = ticker.C
+	}
+
 	for {
 		select {
+		case now := <-decayTick:
+			for _, hl := range w.lists {
+				hl.(hotlist.Decayer).Decay(now)
+			}
+
+		case <-rotateTick:
+			for _, hl := range w.lists {
+				hl.(hotlist.Rotator).Rotate()
+			}
+
 		case kis, ok := <-w.kisChan:
 			if !ok {
 				return
 			}
-			for _, ki := range kis {
-				w.hl.AddWeighted(ki)
+			for _, ke := range kis {
+				w.record(ke)
 			}
+			w.release(kis)
 
-		case k := <-w.topRequest:
-			w.topReply <- w.hl.Top(k)
+		case q := <-w.topRequest:
+			res := make(topResult, len(w.lists))
+			for evtType, hl := range w.lists {
+				res[evtType] = hl.Top(q.k)
+				if q.reset {
+					hl.Reset()
+				}
+			}
+			q.reply <- topReply{res, w.lastSeenFor(res), w.examplesFor(res), w.clientsFor(res), w.ttlsFor(res), w.writesFor(res)}
+			if q.reset && w.lastSeen != nil {
+				w.lastSeen.reset()
+			}
+			if q.reset && w.examples != nil {
+				w.examples.reset()
+			}
+			if q.reset && w.fanout != nil {
+				w.fanout.reset()
+			}
+			if q.reset && w.ttls != nil {
+				w.ttls.reset()
+			}
+			if q.reset && w.writes != nil {
+				w.writes.reset()
+			}
+
+		case reply := <-w.memRequest:
+			reply <- w.memStats()
 
 		case <-w.resetRequest:
-			w.hl.Reset()
+			for _, hl := range w.lists {
+				hl.Reset()
+			}
+			if w.lastSeen != nil {
+				w.lastSeen.reset()
+			}
+			if w.examples != nil {
+				w.examples.reset()
+			}
+			if w.fanout != nil {
+				w.fanout.reset()
+			}
+			if w.ttls != nil {
+				w.ttls.reset()
+			}
+			if w.writes != nil {
+				w.writes.reset()
+			}
+		}
+	}
+}
+
+func (w *worker) record(ke keyEvent) {
+	w.lists[ke.evtType].AddWeighted(w.item(ke))
+	w.recorded++
+	w.keyBytes += int64(len(ke.ki.name) + len(ke.ki.client) + len(ke.ki.cluster))
+	if w.lastSeen != nil && ke.evtType != eventConnection {
+		w.lastSeen.add(ke.ki.keyName(), ke.seen)
+	}
+	if w.examples != nil && ke.original != "" {
+		w.examples.add(ke.ki.keyName(), ke.original)
+	}
+	if w.fanout != nil && ke.client != "" {
+		w.fanout.add(ke.ki.keyName(), ke.client)
+	}
+	if w.ttls != nil && ke.hasTTL {
+		w.ttls.add(ke.ki.keyName(), ke.ttl)
+	}
+	if w.writes != nil && ke.evtType == eventWrite {
+		w.writes.add(ke.ki.keyName())
+	}
+}
+
+// writesFor returns the commands modifying each cache key in res, or nil
+// if this worker does not count them.
+func (w *worker) writesFor(res topResult) map[keyName]int {
+	if w.writes == nil {
+		return nil
+	}
+	writes := make(map[keyName]int)
+	for evtType, entries := range res {
+		if evtType == eventConnection {
+			continue
+		}
+		for _, e := range entries {
+			kn, ok := e.Item().(keyName)
+			if !ok {
+				kn = itemKeyInfo(e.Item()).keyName()
+			}
+			writes[kn] = w.writes.get(kn)
+		}
+	}
+	return writes
+}
+
+// ttlsFor returns the TTLs sent with the storage commands on each cache key
+// in res, or nil if this worker does not track them.
+func (w *worker) ttlsFor(res topResult) map[keyName]map[int]int {
+	if w.ttls == nil {
+		return nil
+	}
+	ttls := make(map[keyName]map[int]int)
+	for _, e := range res[model.EventSet] {
+		kn := itemKeyInfo(e.Item()).keyName()
+		if counts := w.ttls.get(kn); counts != nil {
+			ttls[kn] = counts
+		}
+	}
+	return ttls
+}
+
+// clientsFor returns the number of distinct clients of each cache key in
+// res, or nil if this worker does not track them.
+func (w *worker) clientsFor(res topResult) map[keyName]int {
+	if w.fanout == nil {
+		return nil
+	}
+	clients := make(map[keyName]int)
+	for evtType, entries := range res {
+		if evtType == eventConnection {
+			continue
+		}
+		for _, e := range entries {
+			kn, ok := e.Item().(keyName)
+			if !ok {
+				kn = itemKeyInfo(e.Item()).keyName()
+			}
+			clients[kn] = w.fanout.get(kn)
+		}
+	}
+	return clients
+}
+
+// examplesFor returns the example original keys of each family in res, or
+// nil if this worker does not retain them.
+func (w *worker) examplesFor(res topResult) map[keyName][]string {
+	if w.examples == nil {
+		return nil
+	}
+	examples := make(map[keyName][]string)
+	for evtType, entries := range res {
+		if evtType == eventConnection {
+			continue
+		}
+		for _, e := range entries {
+			kn, ok := e.Item().(keyName)
+			if !ok {
+				kn = itemKeyInfo(e.Item()).keyName()
+			}
+			if keys := w.examples.get(kn); keys != nil {
+				examples[kn] = keys
+			}
+		}
+	}
+	return examples
+}
+
+// lastSeenFor returns when each cache key in res was last active, or nil if
+// this worker does not track it.
+func (w *worker) lastSeenFor(res topResult) map[keyName]time.Time {
+	if w.lastSeen == nil {
+		return nil
+	}
+	seen := make(map[keyName]time.Time)
+	for evtType, entries := range res {
+		if evtType == eventConnection {
+			continue
+		}
+		for _, e := range entries {
+			kn, ok := e.Item().(keyName)
+			if !ok {
+				ki := itemKeyInfo(e.Item())
+				kn = ki.keyName()
+			}
+			seen[kn] = w.lastSeen.get(kn)
 		}
 	}
+	return seen
 }
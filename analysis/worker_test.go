@@ -0,0 +1,640 @@
+package analysis
+
+import (
+	"context"
+	"github.com/box/memsniff/hotlist"
+	"github.com/box/memsniff/protocol/model"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWorker returns a worker without a running loop, so that tests can
+// drive it synchronously.
+func testWorker(mode WeightMode) *worker {
+	return &worker{
+		mode: mode,
+		lists: map[model.EventType]hotlist.HotList{
+			model.EventGetHit:  hotlist.NewPerfect(),
+			model.EventGetMiss: hotlist.NewPerfect(),
+		},
+		drops: &workerDrops{},
+	}
+}
+
+func recordHits(w *worker, key string, size int, n int) {
+	for i := 0; i < n; i++ {
+		w.record(keyEvent{evtType: model.EventGetHit, ki: keyInfo{name: key, size: size}})
+	}
+}
+
+func TestWeightBytesRanksBySize(t *testing.T) {
+	w := testWorker(WeightBytes)
+	recordHits(w, "small", 1, 10)
+	recordHits(w, "large", 1000, 1)
+
+	top := keyReports(topResult{model.EventGetHit: w.lists[model.EventGetHit].Top(2)}, nil)
+	if len(top) != 2 || top[0].Name != "large" {
+		t.Error("expected large key first, got", top)
+	}
+}
+
+func TestWeightCountRanksByRequests(t *testing.T) {
+	w := testWorker(WeightCount)
+	recordHits(w, "small", 1, 10)
+	recordHits(w, "large", 1000, 1)
+
+	top := keyReports(topResult{model.EventGetHit: w.lists[model.EventGetHit].Top(2)}, nil)
+	if len(top) != 2 || top[0].Name != "small" {
+		t.Error("expected small key first, got", top)
+	}
+	if top[1].TrafficEstimate != 1000 {
+		t.Error("expected traffic of 1000 for large key, got", top[1].TrafficEstimate)
+	}
+}
+
+func TestUntrackedEventsIgnored(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, 1)
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventSet, Key: "b", Size: 1},
+	}
+	if err := w.handleEvents(evts); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if kis := <-w.kisChan; len(kis) != 1 || kis[0].ki.name != "a" {
+		t.Error("expected only the hit to be queued, got", kis)
+	}
+}
+
+func TestMixedBatchHasNoEmptyKeys(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, 1)
+	evts := []model.Event{
+		{Type: model.EventUnknown, Key: "x"},
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventSet, Key: "y", Size: 5},
+		{Type: model.EventUnknown},
+		{Type: model.EventGetHit, Key: "b", Size: 2},
+	}
+	if err := w.handleEvents(evts); err != nil {
+		t.Fatal(err)
+	}
+	for _, ke := range <-w.kisChan {
+		w.record(ke)
+	}
+
+	top := w.lists[model.EventGetHit].Top(10)
+	if len(top) != 2 {
+		t.Fatal("expected 2 keys, got", top)
+	}
+	for _, e := range top {
+		if ki := itemKeyInfo(e.Item()); ki.name == "" || ki.size == 0 {
+			t.Error("found empty key in hotlist", ki)
+		}
+	}
+}
+
+func TestSetsReportedSeparately(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.lists[model.EventSet] = hotlist.NewPerfect()
+	recordHits(w, "a", 10, 2)
+	w.record(keyEvent{evtType: model.EventSet, ki: keyInfo{name: "a", size: 20}})
+
+	res := topResult{}
+	for evtType, hl := range w.lists {
+		res[evtType] = hl.Top(10)
+	}
+	krs := mergeKeys(keyReports(res, nil))
+	if len(krs) != 1 {
+		t.Fatal("expected one merged key, got", krs)
+	}
+	kr := krs[0]
+	if kr.RequestsEstimate != 2 || kr.TrafficEstimate != 20 || kr.SetsEstimate != 1 || kr.SetTrafficEstimate != 20 {
+		t.Error("unexpected report", kr)
+	}
+}
+
+func TestSetTTLs(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.lists[model.EventSet] = hotlist.NewPerfect()
+	w.ttls = newTTLCounts(0)
+	for i, ttl := range []int{0, 0, -1, 60, 0} {
+		ke := keyEvent{evtType: model.EventSet, ki: w.keyInfo(model.Event{Type: model.EventSet, Key: "a", Size: 10 + i%2})}
+		// the last is an append, which keeps the TTL
+		ke.ttl, ke.hasTTL = ttl, i < 4
+		w.record(ke)
+	}
+
+	res := topResult{model.EventSet: w.lists[model.EventSet].Top(10)}
+	krs := mergeKeys(topReply{lists: res, ttls: w.ttlsFor(res)}.keyReports(nil))
+	if len(krs) != 1 {
+		t.Fatal("expected one merged key, got", krs)
+	}
+	ttls := krs[0].SetTTLs
+	if len(ttls) != 3 || ttls[0] != 2 || ttls[-1] != 1 || ttls[60] != 1 {
+		t.Error("unexpected TTLs", ttls)
+	}
+	if krs[0].SetsEstimate != 5 {
+		t.Error("expected 5 sets, got", krs[0].SetsEstimate)
+	}
+}
+
+func TestTTLsBounded(t *testing.T) {
+	tc := newTTLCounts(0)
+	kn := keyName{name: "a"}
+	for ttl := 0; ttl < 2*maxTTLsPerKey; ttl++ {
+		tc.add(kn, ttl)
+	}
+	tc.add(kn, 0)
+	if ttls := tc.get(kn); len(ttls) != maxTTLsPerKey || ttls[0] != 2 {
+		t.Error("expected", maxTTLsPerKey, "TTLs with 0 counted twice, got", ttls)
+	}
+}
+
+func TestDeletesCounted(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.lists[model.EventDelete] = hotlist.NewPerfect()
+	for i := 0; i < 3; i++ {
+		w.record(keyEvent{evtType: model.EventDelete, ki: keyInfo{name: "a", size: 0}})
+	}
+
+	krs := keyReports(topResult{model.EventDelete: w.lists[model.EventDelete].Top(1)}, nil)
+	if len(krs) != 1 || krs[0].DeletesEstimate != 3 {
+		t.Error("expected 3 deletes of a, got", krs)
+	}
+}
+
+func TestCASConflicts(t *testing.T) {
+	w := testWorker(WeightBytes)
+	res := topResult{}
+	for _, evtType := range []model.EventType{model.EventCASStored, model.EventCASExists, model.EventCASNotFound} {
+		w.lists[evtType] = hotlist.NewPerfect()
+	}
+	for _, evtType := range []model.EventType{model.EventCASStored, model.EventCASExists, model.EventCASExists, model.EventCASNotFound} {
+		w.record(keyEvent{evtType: evtType, ki: keyInfo{name: "a", size: 10}})
+	}
+	for evtType, hl := range w.lists {
+		res[evtType] = hl.Top(10)
+	}
+	krs := mergeKeys(keyReports(res, nil))
+	if len(krs) != 1 {
+		t.Fatal("expected one merged key, got", krs)
+	}
+	if krs[0].CASEstimate != 4 || krs[0].CASConflictsEstimate != 2 {
+		t.Error("expected 4 cas with 2 conflicts, got", krs[0])
+	}
+}
+
+func TestCounterVolume(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.lists[model.EventIncr] = hotlist.NewPerfect()
+	w.record(keyEvent{evtType: model.EventIncr, ki: keyInfo{name: "a", size: 5}})
+	w.record(keyEvent{evtType: model.EventIncr, ki: keyInfo{name: "a", size: 5}})
+	w.record(keyEvent{evtType: model.EventIncr, ki: keyInfo{name: "a", size: 1}})
+
+	krs := mergeKeys(keyReports(topResult{model.EventIncr: w.lists[model.EventIncr].Top(10)}, nil))
+	if len(krs) != 1 || krs[0].IncrsEstimate != 3 || krs[0].IncrVolumeEstimate != 11 {
+		t.Error("expected 3 increments of a totalling 11, got", krs)
+	}
+}
+
+func TestClientDimension(t *testing.T) {
+	evt := model.Event{Type: model.EventGetHit, Key: "a", Size: 10, Client: "10.0.0.1"}
+	w := testWorker(WeightBytes)
+	for _, c := range []struct {
+		dim      Dimension
+		expected keyInfo
+	}{
+		{DimensionKey, keyInfo{name: "a", size: 10}},
+		{DimensionClientKey, keyInfo{name: "a", size: 10, client: "10.0.0.1"}},
+		{DimensionClient, keyInfo{name: "10.0.0.1", size: 10, client: "10.0.0.1"}},
+	} {
+		w.dimension = c.dim
+		if ki := w.keyInfo(evt); ki != c.expected {
+			t.Error("expected", c.expected, "for dimension", c.dim, "got", ki)
+		}
+	}
+}
+
+func TestClientDimensionReports(t *testing.T) {
+	p := New(Config{Workers: 4, ReportSize: 10, Dimension: DimensionClient})
+	defer p.Shutdown(context.Background())
+	var evts []model.Event
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		evts = append(evts, model.Event{Type: model.EventGetHit, Key: key, Size: 10, Client: "10.0.0.1"})
+	}
+	p.HandleEvents(evts)
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "10.0.0.1" || keys[0].RequestsEstimate != 6 {
+		t.Fatal("expected all activity reported for the client, got", keys)
+	}
+	if l := keys[0].Label(); l != "10.0.0.1" {
+		t.Error("expected label to name the client once, got", l)
+	}
+	nonEmpty := 0
+	for _, part := range p.partitionEvents(evts) {
+		if len(part) > 0 {
+			nonEmpty++
+		}
+	}
+	if nonEmpty != 1 {
+		t.Error("expected one client's events given to one worker, got", nonEmpty)
+	}
+}
+
+func TestClusters(t *testing.T) {
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.1"},
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.2"},
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.3"},
+	}
+	p := New(Config{Workers: 1, ReportSize: 10, Clusters: map[string]string{"10.0.0.1": "east", "10.0.0.2": "east"}})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents(evts)
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 2 || keys[0].Cluster != "east" || keys[0].RequestsEstimate != 2 ||
+		keys[1].Cluster != "10.0.0.3" || keys[1].RequestsEstimate != 1 {
+		t.Fatal("expected key a reported for each cluster, got", keys)
+	}
+	if l := keys[0].Label(); l != "east a" {
+		t.Error("expected label to include cluster, got", l)
+	}
+
+	flat := New(Config{Workers: 1, ReportSize: 10})
+	defer flat.Shutdown(context.Background())
+	flat.HandleEvents(evts)
+	flat.Wait()
+	keys = flat.Top(10, MetricRequests)
+	if len(keys) != 1 || keys[0].Cluster != "" || keys[0].RequestsEstimate != 3 {
+		t.Error("expected activity on all servers combined, got", keys)
+	}
+}
+
+func TestByInterface(t *testing.T) {
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.1", Interface: "eth0"},
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.1", Interface: "eth0"},
+		{Type: model.EventGetHit, Key: "a", Size: 10, Server: "10.0.0.1", Interface: "eth1"},
+	}
+	p := New(Config{Workers: 1, ReportSize: 10, ByInterface: true})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents(evts)
+	p.Wait()
+	keys := p.Top(10, MetricRequests)
+	if len(keys) != 2 || keys[0].Cluster != "eth0" || keys[0].RequestsEstimate != 2 ||
+		keys[1].Cluster != "eth1" || keys[1].RequestsEstimate != 1 {
+		t.Fatal("expected key a reported for each interface, got", keys)
+	}
+
+	both := New(Config{Workers: 1, ReportSize: 10, ByInterface: true, Clusters: map[string]string{"10.0.0.1": "east"}})
+	defer both.Shutdown(context.Background())
+	both.HandleEvents(evts)
+	both.Wait()
+	keys = both.Top(10, MetricRequests)
+	if len(keys) != 2 || keys[0].Cluster != "east@eth0" || keys[1].Cluster != "east@eth1" {
+		t.Error("expected cluster and interface, got", keys)
+	}
+}
+
+func TestStoreFailures(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, TrackSets: true})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventSet, Key: "a", Size: 10},
+		{Type: model.EventSet, Key: "a", Size: 10},
+		{Type: model.EventStoreFailed, Key: "a", Size: 10, Result: model.StoreNotStored},
+		{Type: model.EventSet, Key: "a", Size: 10},
+		{Type: model.EventStoreFailed, Key: "a", Size: 10, Result: model.StoreError},
+		{Type: model.EventSet, Key: "a", Size: 10},
+		{Type: model.EventSet, Key: "b", Size: 10},
+	})
+	p.Wait()
+	keys := p.Top(10, MetricSets)
+	if len(keys) != 2 || keys[0].Name != "a" || keys[0].SetsEstimate != 4 || keys[0].StoreFailuresEstimate != 2 ||
+		keys[1].Name != "b" || keys[1].StoreFailuresEstimate != 0 {
+		t.Fatal("expected 2 of 4 sets of a to fail, got", keys)
+	}
+	if r := keys[0].StoreFailureRate(); r != 0.5 {
+		t.Error("expected failure rate of 0.5, got", r)
+	}
+}
+
+func TestShortWindowRotates(t *testing.T) {
+	w := newWorker(Config{QueueSize: 1, NewHotList: hotlist.NewPerfect, Window: 3, WindowBuckets: 6}, nil)
+	defer w.close()
+	if w.rotateInterval != time.Nanosecond {
+		t.Error("expected buckets of at least 1ns, got", w.rotateInterval)
+	}
+}
+
+func TestConcurrentTop(t *testing.T) {
+	w := newWorker(Config{QueueSize: 1, NewHotList: hotlist.NewPerfect, WindowBuckets: 1}, nil)
+	defer w.close()
+	var evts []model.Event
+	for i := 0; i < 10; i++ {
+		evts = append(evts, model.Event{Type: model.EventGetHit, Key: string(rune('a' + i)), Size: i + 1})
+	}
+	if err := w.handleEvents(evts); err != nil {
+		t.Fatal(err)
+	}
+	for w.queueDepth() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// every caller asks for a different number of keys, so a reply
+	// delivered to the wrong caller has the wrong length
+	var wg sync.WaitGroup
+	for k := 1; k <= 10; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if n := len(w.top(k)[model.EventGetHit]); n != k {
+					t.Error("requested", k, "keys but got", n)
+					return
+				}
+			}
+		}(k)
+	}
+	wg.Wait()
+}
+
+func TestTopAndReset(t *testing.T) {
+	w := newWorker(Config{QueueSize: 1, NewHotList: hotlist.NewPerfect, WindowBuckets: 1}, nil)
+	defer w.close()
+	if err := w.handleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	for w.queueDepth() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := len(w.topAndReset(10).lists[model.EventGetHit]); n != 1 {
+		t.Error("expected 1 key before reset, got", n)
+	}
+	if n := len(w.top(10)[model.EventGetHit]); n != 0 {
+		t.Error("expected no keys after reset, got", n)
+	}
+}
+
+func TestAbandonedTopDoesNotBlockWorker(t *testing.T) {
+	w := newWorker(Config{QueueSize: 1, NewHotList: hotlist.NewPerfect, WindowBuckets: 1}, nil)
+	defer w.close()
+
+	// a query whose caller has gone away before reading the reply
+	w.topRequest <- topQuery{10, false, make(chan topReply, 1)}
+	// queries cancelled at every stage
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		if i%2 == 0 {
+			cancel()
+		} else {
+			go cancel()
+		}
+		if _, err := w.topContext(ctx, 10); err != nil && err != context.Canceled {
+			t.Error("unexpected error", err)
+		}
+	}
+
+	if err := w.handleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for {
+		res, err := w.topContext(ctx, 10)
+		if err != nil {
+			t.Fatal("worker stopped responding:", err)
+		}
+		if len(res.lists[model.EventGetHit]) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDroppedCounts(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, 1)
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1},
+		{Type: model.EventGetMiss, Key: "b"},
+	}
+	if err := w.handleEvents(evts); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if err := w.handleEvents(evts); err != errQueueFull {
+		t.Error("expected errQueueFull, got", err)
+	}
+	batches, keys := w.dropped()
+	if batches != 1 || keys != 2 {
+		t.Error("expected 1 batch and 2 keys dropped, got", batches, keys)
+	}
+}
+
+func TestBlockTimeoutWaitsForSpace(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, 1)
+	w.blockTimeout = time.Second
+	evts := []model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}}
+	if err := w.handleEvents(evts); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-w.kisChan
+	}()
+	if err := w.handleEvents(evts); err != nil {
+		t.Error("expected to wait for space, got", err)
+	}
+
+	w.blockTimeout = time.Millisecond
+	if err := w.handleEvents(evts); err != errQueueFull {
+		t.Error("expected errQueueFull after timeout, got", err)
+	}
+}
+
+func TestConnectionsTracked(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.lists[eventConnection] = hotlist.NewPerfect()
+	w.kisChan = make(chan []keyEvent, 1)
+	evts := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10, Conn: "c1"},
+		{Type: model.EventGetHit, Key: "b", Size: 20, Conn: "c1"},
+		{Type: model.EventGetMiss, Key: "c", Conn: "c1"},
+		{Type: model.EventSet, Key: "a", Size: 5, Conn: "c2"},
+	}
+	if err := w.handleEvents(evts); err != nil {
+		t.Fatal(err)
+	}
+	for _, ke := range <-w.kisChan {
+		w.record(ke)
+	}
+
+	var crs []ConnectionReport
+	for _, e := range w.lists[eventConnection].Top(10) {
+		crs = append(crs, connectionReport(e))
+	}
+	crs = mergeConnections(crs)
+	sort.Sort(connsByWeight{crs, WeightBytes})
+	if len(crs) != 2 {
+		t.Fatal("expected 2 connections, got", crs)
+	}
+	if crs[0] != (ConnectionReport{"c1", 3, 30}) || crs[1] != (ConnectionReport{"c2", 1, 5}) {
+		t.Error("unexpected connections", crs)
+	}
+	if n := len(w.lists[model.EventGetHit].Top(10)); n != 2 {
+		t.Error("expected keys to be tracked as well, got", n)
+	}
+}
+
+func TestLastSeen(t *testing.T) {
+	p := New(Config{Workers: 1, ReportSize: 10, TrackSets: true, TrackLastSeen: true})
+	t0 := time.Unix(1500000000, 0)
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 1, Timestamp: t0.Add(2 * time.Second)},
+		{Type: model.EventSet, Key: "a", Size: 1, Timestamp: t0.Add(3 * time.Second)},
+		// out of order, as from another interface
+		{Type: model.EventGetHit, Key: "a", Size: 1, Timestamp: t0.Add(time.Second)},
+		{Type: model.EventGetMiss, Key: "b", Timestamp: t0},
+	})
+	p.Wait()
+
+	seen := make(map[string]time.Time)
+	for _, kr := range p.TopAndReset(10, MetricRequests) {
+		seen[kr.Name] = kr.LastSeen
+	}
+	if !seen["a"].Equal(t0.Add(3*time.Second)) || !seen["b"].Equal(t0) {
+		t.Error("expected a last seen at", t0.Add(3*time.Second), "and b at", t0, "got", seen)
+	}
+
+	p.HandleEvents([]model.Event{{Type: model.EventGetMiss, Key: "b"}})
+	p.Wait()
+	krs := p.Top(10, MetricRequests)
+	if len(krs) != 1 || !krs[0].LastSeen.After(t0) {
+		t.Error("expected b last seen when handled after reset, got", krs)
+	}
+}
+
+func TestLastSeenForgetsIdleKeys(t *testing.T) {
+	ls := newLastSeen(2)
+	t0 := time.Unix(1500000000, 0)
+	ls.add(keyName{name: "a"}, t0)
+	ls.add(keyName{name: "b"}, t0)
+	ls.add(keyName{name: "c"}, t0)
+	ls.add(keyName{name: "a"}, t0.Add(-time.Second))
+	if got := ls.get(keyName{name: "a"}); !got.Equal(t0) {
+		t.Error("expected a carried into current generation unchanged, got", got)
+	}
+	ls.add(keyName{name: "d"}, t0)
+	if got := ls.get(keyName{name: "b"}); !got.IsZero() {
+		t.Error("expected b forgotten after two generations, got", got)
+	}
+}
+
+func TestBatchesNotShared(t *testing.T) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, 2)
+	w.free = make(chan []keyEvent, 2)
+	w.release(make([]keyEvent, 0, 4))
+
+	if err := w.handleEvents([]model.Event{{Type: model.EventGetHit, Key: "a", Size: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.handleEvents([]model.Event{{Type: model.EventGetHit, Key: "b", Size: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	first, second := <-w.kisChan, <-w.kisChan
+	if &first[:1][0] == &second[:1][0] {
+		t.Fatal("expected batches in flight to have separate buffers")
+	}
+	if first[0].ki.name != "a" || second[0].ki.name != "b" {
+		t.Error("expected each batch to keep its own keys, got", first, second)
+	}
+
+	w.release(first)
+	if err := w.handleEvents([]model.Event{{Type: model.EventGetHit, Key: "c", Size: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	third := <-w.kisChan
+	if &third[:1][0] != &first[:1][0] {
+		t.Error("expected a released batch to be reused")
+	}
+	if len(third) != 1 || third[0].ki.name != "c" || second[0].ki.name != "b" {
+		t.Error("expected reused batch to hold only new keys, got", third, second)
+	}
+}
+
+func BenchmarkHandleEvents(b *testing.B) {
+	w := testWorker(WeightBytes)
+	w.kisChan = make(chan []keyEvent, DefaultQueueSize)
+	w.free = make(chan []keyEvent, DefaultQueueSize+1)
+	done := make(chan bool)
+	go func() {
+		for kis := range w.kisChan {
+			w.release(kis)
+		}
+		close(done)
+	}()
+	evts := make([]model.Event, 64)
+	for i := range evts {
+		evts[i] = model.Event{Type: model.EventGetHit, Key: "key", Size: i}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for w.handleEvents(evts) != nil {
+		}
+	}
+	b.StopTimer()
+	close(w.kisChan)
+	<-done
+}
+
+func TestMemStats(t *testing.T) {
+	p := New(Config{Workers: 2, ReportSize: 10})
+	defer p.Shutdown(context.Background())
+	p.HandleEvents([]model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10},
+		{Type: model.EventGetHit, Key: "b", Size: 10},
+		{Type: model.EventGetMiss, Key: "c"},
+	})
+	p.Wait()
+	stats := p.MemStats()
+	if len(stats) != 2 {
+		t.Fatal("expected stats for each worker, got", stats)
+	}
+	keys, bytes := 0, 0
+	for _, ms := range stats {
+		keys += ms.Keys
+		bytes += ms.Bytes
+	}
+	if keys != 3 || bytes < 3*keyInfoBytes {
+		t.Error("expected 3 keys of at least", keyInfoBytes, "bytes, got", stats)
+	}
+}
+
+func TestKeyHash(t *testing.T) {
+	a := keyInfo{name: "a", size: 10, client: "10.0.0.1"}
+	if a.Hash() != (keyInfo{name: "a", size: 10, client: "10.0.0.1"}).Hash() {
+		t.Error("expected equal keys to hash alike")
+	}
+	for _, other := range []keyInfo{
+		{name: "a", size: 11, client: "10.0.0.1"},
+		{name: "a1", size: 10, client: "0.0.0.1"},
+		{name: "a", size: 10, client: "10.0.0.1", redacted: true},
+	} {
+		if a.Hash() == other.Hash() {
+			t.Error("expected", a, "and", other, "to hash differently")
+		}
+	}
+	if a.keyName().Hash() == (keyName{name: "a"}).Hash() {
+		t.Error("expected client to be hashed")
+	}
+}
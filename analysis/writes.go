@@ -0,0 +1,49 @@
+package analysis
+
+// writeCounts counts the commands modifying each cache key, in bounded
+// memory, so that a key's reads can be compared to its own writes whether
+// or not it is among the most written.  Keys are remembered in generations
+// as by lastSeen, so keys not written for two generations are forgotten.
+type writeCounts struct {
+	capacity int
+	cur      map[keyName]int
+	prev     map[keyName]int
+}
+
+func newWriteCounts(capacity int) *writeCounts {
+	if capacity <= 0 {
+		capacity = DefaultSeenKeys
+	}
+	return &writeCounts{
+		capacity: capacity,
+		cur:      make(map[keyName]int),
+	}
+}
+
+// add counts a write to kn.
+func (wc *writeCounts) add(kn keyName) {
+	n, ok := wc.cur[kn]
+	if !ok {
+		// keys still in use are carried into the current generation
+		n = wc.prev[kn]
+	}
+	wc.cur[kn] = n + 1
+	if !ok && len(wc.cur) >= wc.capacity {
+		wc.prev = wc.cur
+		wc.cur = make(map[keyName]int)
+	}
+}
+
+// get returns the writes counted to kn, or 0 if it has been forgotten.
+func (wc *writeCounts) get(kn keyName) int {
+	if n, ok := wc.cur[kn]; ok {
+		return n
+	}
+	return wc.prev[kn]
+}
+
+// reset forgets all keys.
+func (wc *writeCounts) reset() {
+	wc.cur = make(map[keyName]int)
+	wc.prev = nil
+}
@@ -0,0 +1,68 @@
+package assembly
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/box/memsniff/log"
+)
+
+// Direction is the direction of traffic captured between clients and
+// servers.
+type Direction int
+
+const (
+	// DirectionBoth decodes requests together with their responses,
+	// distinguishing hits from misses.
+	DirectionBoth Direction = iota
+	// DirectionRequests decodes requests alone, when only traffic from
+	// clients to servers is captured, such as from a one-way mirror port.
+	// Gets are reported as model.EventGet.
+	DirectionRequests
+)
+
+// ParseDirection returns the Direction with the given name: both or
+// requests.
+func ParseDirection(name string) (Direction, error) {
+	switch name {
+	case "both":
+		return DirectionBoth, nil
+	case "requests":
+		return DirectionRequests, nil
+	}
+	return 0, fmt.Errorf("unknown direction %q", name)
+}
+
+// noEventsPackets is the number of packets after which a Pool that has not
+// decoded any events warns that the traffic captured may not match its
+// Direction.
+const noEventsPackets = 10000
+
+// SetDirection sets the direction of traffic captured.  DirectionBoth is
+// the default.  Conversations over UDP are always decoded from requests
+// together with responses.  SetDirection must be called before any packets
+// are handled.
+func (p *Pool) SetDirection(d Direction) {
+	p.direction = d
+	for _, w := range p.workers {
+		w.sf.requestsOnly = d == DirectionRequests
+	}
+}
+
+// checkDecoding warns once if many packets have been handled without any
+// events being decoded, which silently produces empty reports when, for
+// example, responses are expected but not captured.
+func (p *Pool) checkDecoding(packets int) {
+	n := atomic.AddInt64(&p.packetsHandled, int64(packets))
+	if n < noEventsPackets || atomic.LoadInt64(&p.eventsDecoded) > 0 {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&p.warnedNoEvents, 0, 1) {
+		return
+	}
+	if p.direction == DirectionRequests {
+		log.Warn(p.Logger, "no events decoded from", n, "packets; check that traffic from clients to servers is captured")
+	} else {
+		log.Warn(p.Logger, "no events decoded from", n, "packets; if only traffic from clients to servers is captured, decode requests alone")
+	}
+}
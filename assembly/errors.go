@@ -0,0 +1,82 @@
+package assembly
+
+import (
+	"time"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+// errorSummaryInterval is how often protocol errors suppressed from the log
+// are summarized.
+const errorSummaryInterval = time.Minute
+
+// flowErrors counts protocol errors by conversation, so that a flow that
+// does not carry the expected protocol, such as other traffic on a
+// memcached port, is logged once and then summarized periodically rather
+// than logged for every packet.
+//
+// flowErrors is not threadsafe.
+type flowErrors struct {
+	logger log.Logger
+	// number of errors after which a conversation is no longer decoded, or
+	// zero to keep decoding
+	max int
+	// conversations with errors since the summary before last, by their
+	// connection
+	flows map[string]*flowErrorCount
+}
+
+type flowErrorCount struct {
+	// errors since the last summary, not counting any already logged
+	recent int
+	// errors over the life of the conversation
+	total int
+	last  error
+}
+
+func newFlowErrors(logger log.Logger) *flowErrors {
+	return &flowErrors{
+		logger: logger,
+		flows:  make(map[string]*flowErrorCount),
+	}
+}
+
+// record counts err for the conversation c on conn, abandoning the
+// conversation if it has reached the maximum.  Lost data is the fault of
+// the capture rather than the flow, so it is not counted.
+func (fe *flowErrors) record(conn string, c *model.Consumer, err error) {
+	if _, ok := err.(reader.ErrLostData); ok {
+		return
+	}
+	fc, ok := fe.flows[conn]
+	if !ok {
+		fc = &flowErrorCount{}
+		fe.flows[conn] = fc
+	}
+	fc.total++
+	fc.last = err
+	if fc.total == 1 {
+		log.Warn(fe.logger, "flow", conn+":", "decode error:", err)
+	} else {
+		fc.recent++
+	}
+	if fe.max > 0 && fc.total == fe.max {
+		log.Warn(fe.logger, "flow", conn+":", fc.total, "decode errors, no longer decoding")
+		c.Close()
+	}
+}
+
+// summarize logs the number of errors suppressed for each conversation
+// since the last summary, and forgets conversations that have had none.
+func (fe *flowErrors) summarize() {
+	for conn, fc := range fe.flows {
+		if fc.recent == 0 {
+			delete(fe.flows, conn)
+			continue
+		}
+		log.Warn(fe.logger, "flow", conn+":", fc.recent, "decode errors, suppressing, last:", fc.last)
+		fc.recent = 0
+	}
+}
@@ -0,0 +1,41 @@
+package assembly
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/protocol/model"
+)
+
+func TestFlowErrorsSummarized(t *testing.T) {
+	logger := &countingLogger{}
+	fe := newFlowErrors(logger)
+	fe.max = 3
+	c := model.New(nil, func([]model.Event) {})
+	errGarbage := errors.New("garbage")
+
+	fe.record("conn", c, reader.ErrLostData{})
+	if logger.n != 0 || len(fe.flows) != 0 {
+		t.Fatal("expected lost data not to count as an error")
+	}
+	for i := 0; i < 5; i++ {
+		fe.record("conn", c, errGarbage)
+	}
+	// the first error, then abandoning the flow
+	if logger.n != 2 {
+		t.Error("expected 2 messages, got", logger.n)
+	}
+	if _, ok := c.ClientReader.(*model.DummySource); !ok {
+		t.Error("expected flow no longer decoded after 3 errors")
+	}
+
+	fe.summarize()
+	if logger.n != 3 || fe.flows["conn"].recent != 0 {
+		t.Error("expected summary of suppressed errors, got", logger.n, "messages")
+	}
+	fe.summarize()
+	if logger.n != 3 || len(fe.flows) != 0 {
+		t.Error("expected quiet flow forgotten without logging, got", logger.n, "messages")
+	}
+}
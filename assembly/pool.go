@@ -1,58 +1,375 @@
 package assembly
 
 import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/box/memsniff/analysis"
 	"github.com/box/memsniff/decode"
 	"github.com/box/memsniff/log"
 )
 
-// Pool manages a set of workers each responsible for a set of TCP conversations (stream pairs).
+// DefaultIdleTimeout is how long a conversation may go without packets before
+// it is closed, if no idle timeout is given to New.
+const DefaultIdleTimeout = time.Minute
+
+// DefaultWorkers returns the number of workers created if none is given to
+// New: one for each CPU Go schedules goroutines on, as set by GOMAXPROCS.
+// Each worker handles its own flows, so more workers than CPUs only adds
+// contention.
+func DefaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// Pool manages a set of workers each responsible for a set of TCP conversations (stream pairs)
+// and UDP flows.
 type Pool struct {
-	Logger  log.Logger
+	// packets handled and events decoded from them, first for atomic
+	// alignment
+	packetsHandled int64
+	eventsDecoded  int64
+	// set once the Pool has warned that no events are being decoded
+	warnedNoEvents int32
+
+	Logger log.Logger
+	// MixFlowHash scrambles packet flow hashes before assigning flows to
+	// workers, so that hashes with little variation in their low bits are
+	// still spread evenly.
+	MixFlowHash bool
+	// one in this many flows is analyzed
+	sampleEvery uint64
+	// held for reading while packets are passed to workers, and for
+	// writing while Resize replaces them
+	mu      sync.RWMutex
 	workers []worker
+	// packets dispatched to each worker, including any dropped
+	packetCounts []int64
+	// *batch reused across calls to HandlePackets
+	batches sync.Pool
+	// receivers of decoded events
+	subs *subscribers
+
+	// settings from which workers are created
+	pools         map[int]*analysis.Pool
+	redis         map[int]bool
+	idleTimeout   time.Duration
+	interfaces    []string
+	maxFlowErrors int
+	direction     Direction
+	// how each conversation was decoded, if recorded
+	validation *validation
+}
+
+// batch holds the state of a single call to HandlePackets.
+type batch struct {
+	// packets assigned to each worker
+	perWorker [][]*decode.DecodedPacket
+	// workers still handling their packets
+	wg sync.WaitGroup
+}
+
+// New creates a new pool for reassembling TCP streams and UDP messages
+// with any of memcachePorts or redisPorts, and passing the events decoded
+// from them to pool.  Conversations with redisPorts are decoded as RESP,
+// and all others as the memcached binary or text protocol.
+//
+// Only about sampleRate of flows are analyzed, chosen by flow hash so that
+// every packet of a sampled flow is kept and reassembly is unaffected.
+// The analysis pool should be configured with a SampleRate of
+// SampleRate(sampleRate) so that it can scale its estimates accordingly.
+// A sampleRate that is not between 0 and 1 analyzes all flows.
+//
+// Conversations that see no packets for idleTimeout, as measured by packet
+// timestamps, are closed and their buffers freed.  DefaultIdleTimeout is used
+// if idleTimeout is not positive.
+//
+// DefaultWorkers are created if numWorkers is not positive.
+func New(logger log.Logger, pool *analysis.Pool, memcachePorts, redisPorts []int, numWorkers int, sampleRate float64, idleTimeout time.Duration) *Pool {
+	pools := make(map[int]*analysis.Pool, len(memcachePorts)+len(redisPorts))
+	for _, port := range memcachePorts {
+		pools[port] = pool
+	}
+	for _, port := range redisPorts {
+		pools[port] = pool
+	}
+	return NewPerPort(logger, pools, redisPorts, numWorkers, sampleRate, idleTimeout)
+}
+
+// SampleRate returns the fraction of flows actually analyzed by a Pool
+// created with sampleRate, which keeps one in a whole number of flows.
+func SampleRate(sampleRate float64) float64 {
+	return 1 / float64(sampleEvery(sampleRate))
+}
+
+func sampleEvery(sampleRate float64) uint64 {
+	if sampleRate > 0 && sampleRate < 1 {
+		return uint64(1/sampleRate + 0.5)
+	}
+	return 1
 }
 
-// New creates a new pool for reassembling TCP streams.
-func New(logger log.Logger, analysis *analysis.Pool, memcachePorts []int, numWorkers int) *Pool {
+// NewPerPort is like New, but passes the events from conversations with each
+// server port to the analysis pool for that port, so that instances on
+// the same host can be reported separately.  Every port in redisPorts must
+// also have an analysis pool.
+func NewPerPort(logger log.Logger, pools map[int]*analysis.Pool, redisPorts []int, numWorkers int, sampleRate float64, idleTimeout time.Duration) *Pool {
+	if numWorkers <= 0 {
+		numWorkers = DefaultWorkers()
+	}
 	p := &Pool{
-		logger,
-		make([]worker, numWorkers),
+		Logger:       logger,
+		sampleEvery:  sampleEvery(sampleRate),
+		workers:      make([]worker, numWorkers),
+		packetCounts: make([]int64, numWorkers),
+		subs:         &subscribers{},
+		pools:        pools,
+	}
+	p.idleTimeout = idleTimeout
+	if p.idleTimeout <= 0 {
+		p.idleTimeout = DefaultIdleTimeout
+	}
+	p.redis = make(map[int]bool, len(redisPorts))
+	for _, port := range redisPorts {
+		p.redis[port] = true
 	}
 	for i := 0; i < numWorkers; i++ {
-		p.workers[i] = newWorker(logger, analysis, memcachePorts)
+		p.workers[i] = p.newWorker()
+	}
+	p.batches.New = func() interface{} {
+		return &batch{}
 	}
 	return p
 }
 
-// HandlePackets partitions packets by connection and dispatches them to assembly workers.
-func (p *Pool) HandlePackets(dps []*decode.DecodedPacket) (err error) {
-	perWorker := p.partition(dps)
-	doneCh := make(chan struct{}, len(p.workers))
-	var batchesSent int
-	for i, packets := range perWorker {
+// newWorker returns a worker configured with the settings of this Pool.
+func (p *Pool) newWorker() worker {
+	w := newWorker(p.Logger, p.pools, p.redis, p.subs, p.idleTimeout)
+	w.sf.interfaces = p.interfaces
+	w.sf.errors.max = p.maxFlowErrors
+	w.sf.requestsOnly = p.direction == DirectionRequests
+	w.sf.decoded = &p.eventsDecoded
+	w.sf.validation = p.validation
+	return w
+}
+
+// SetInterfaces names the network interfaces packets are captured on, by
+// their CaptureInfo.InterfaceIndex, so that events and connections can be
+// attributed to them.  It must be called before HandlePackets, and only
+// when capturing on several interfaces.
+func (p *Pool) SetInterfaces(names []string) {
+	p.interfaces = names
+	for _, w := range p.workers {
+		w.sf.interfaces = names
+	}
+}
+
+// SetMaxFlowErrors stops decoding a conversation once it has produced n
+// protocol errors, such as when it carries traffic other than the expected
+// protocol, to save the work of decoding it.  Conversations are decoded
+// regardless of errors if n is not positive, the default.  Errors are
+// logged for each conversation when they start and summarized every
+// minute either way.  SetMaxFlowErrors must be called before any packets
+// are handled.
+func (p *Pool) SetMaxFlowErrors(n int) {
+	p.maxFlowErrors = n
+	for _, w := range p.workers {
+		w.sf.errors.max = n
+	}
+}
+
+// DropError is returned by HandlePackets when some packets were dropped
+// because assembly workers could not keep up.  Callers may slow capture in
+// response.
+type DropError struct {
+	// number of batches dropped, at most one for each worker
+	Batches int
+	// number of packets in those batches
+	Packets int
+	// the error returned by each worker that dropped a batch
+	Errs []error
+}
+
+func (e *DropError) Error() string {
+	return fmt.Sprintf("dropped %d packets in %d batches: %v", e.Packets, e.Batches, e.Errs[0])
+}
+
+// Unwrap returns the errors returned by the workers.
+func (e *DropError) Unwrap() []error {
+	return e.Errs
+}
+
+// HandlePackets partitions packets by connection and dispatches them to
+// assembly workers.  If any workers dropped their packets, each is logged
+// and HandlePackets returns a *DropError once the other workers are done.
+func (p *Pool) HandlePackets(dps []*decode.DecodedPacket) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	b := p.batches.Get().(*batch)
+	if len(b.perWorker) != len(p.workers) {
+		// left over from before Resize
+		b.perWorker = make([][]*decode.DecodedPacket, len(p.workers))
+	}
+	p.partition(b.perWorker, dps)
+	var dropped *DropError
+	for i, packets := range b.perWorker {
 		if len(packets) > 0 {
-			batchesSent++
-			err = p.workers[i].handlePackets(packets, doneCh)
+			atomic.AddInt64(&p.packetCounts[i], int64(len(packets)))
+			b.wg.Add(1)
+			err := p.workers[i].handlePackets(packets, &b.wg)
 			if err != nil {
-				p.Logger.Log(err)
+				b.wg.Done()
+				log.Warn(p.Logger, err)
+				if dropped == nil {
+					dropped = &DropError{}
+				}
+				dropped.Batches++
+				dropped.Packets += len(packets)
+				dropped.Errs = append(dropped.Errs, err)
 			}
 		}
 	}
-	for i := 0; i < batchesSent; i++ {
-		<-doneCh
+	b.wg.Wait()
+	p.checkDecoding(len(dps))
+	for i := range b.perWorker {
+		b.perWorker[i] = b.perWorker[i][:0]
+	}
+	p.batches.Put(b)
+	if dropped != nil {
+		return dropped
 	}
 	return nil
 }
 
-func (p *Pool) partition(dps []*decode.DecodedPacket) [][]*decode.DecodedPacket {
-	perWorker := make([][]*decode.DecodedPacket, len(p.workers))
+// Flush completes all TCP conversations and UDP requests in progress, as at
+// the end of a capture file, so that their data is analyzed.  Flush returns
+// once all workers have finished.
+func (p *Pool) Flush() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var wg sync.WaitGroup
+	wg.Add(len(p.workers))
+	for _, w := range p.workers {
+		w.flushAll(&wg)
+	}
+	wg.Wait()
+}
+
+// Workers returns the number of workers in this Pool.
+func (p *Pool) Workers() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.workers)
+}
+
+// Resize replaces the workers of this Pool with n new ones, or
+// DefaultWorkers if n is not positive, while packets continue to arrive.
+//
+// Flows are assigned to workers by hash, so resizing moves most flows to a
+// different worker, which has no record of their reassembly.  Resize
+// therefore first completes every conversation in progress, as Flush does,
+// delivering the data buffered for each to its decoder rather than
+// discarding it.  A conversation that continues is taken up by its new
+// worker mid-stream, as are conversations already open when capture
+// starts, so at most the message in flight when Resize is called is lost
+// from each.  Packets passed to HandlePackets while Resize is in progress
+// wait for it to finish.
+func (p *Pool) Resize(n int) {
+	if n <= 0 {
+		n = DefaultWorkers()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(p.workers))
+	for _, w := range p.workers {
+		w.flushAll(&wg)
+	}
+	wg.Wait()
+	for _, w := range p.workers {
+		w.close()
+	}
+
+	p.workers = make([]worker, n)
+	for i := range p.workers {
+		p.workers[i] = p.newWorker()
+	}
+	p.packetCounts = make([]int64, n)
+}
+
+// PacketCounts returns the number of packets dispatched to each worker, to
+// reveal whether traffic is spread evenly.  Counts start from zero when the
+// Pool is resized.
+func (p *Pool) PacketCounts() []int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	counts := make([]int64, len(p.packetCounts))
+	for i := range p.packetCounts {
+		counts[i] = atomic.LoadInt64(&p.packetCounts[i])
+	}
+	return counts
+}
+
+func (p *Pool) partition(perWorker [][]*decode.DecodedPacket, dps []*decode.DecodedPacket) {
 	for _, dp := range dps {
+		if !p.served(dp) || !p.sampled(dp) {
+			continue
+		}
 		s := p.slot(dp)
 		perWorker[s] = append(perWorker[s], dp)
 	}
-	return perWorker
 }
 
 func (p *Pool) slot(dp *decode.DecodedPacket) int {
-	return int(dp.FlowHash % uint64(len(p.workers)))
+	h := dp.FlowHash
+	if p.MixFlowHash {
+		h = fmix64(h)
+	}
+	return int(h % uint64(len(p.workers)))
+}
+
+// served returns whether dp is a TCP or UDP packet to or from one of the
+// server ports.  The capture filter selects packets by port, except those
+// decapsulated from a tunnel, which may belong to any conversation.
+func (p *Pool) served(dp *decode.DecodedPacket) bool {
+	var src, dst int
+	switch {
+	case dp.IsTCP():
+		src, dst = int(dp.TCP.SrcPort), int(dp.TCP.DstPort)
+	case dp.IsUDP():
+		src, dst = int(dp.UDP.SrcPort), int(dp.UDP.DstPort)
+	default:
+		return false
+	}
+	_, fromServer := p.pools[src]
+	_, toServer := p.pools[dst]
+	return fromServer || toServer
+}
+
+// sampled returns whether dp belongs to a flow chosen for analysis.
+func (p *Pool) sampled(dp *decode.DecodedPacket) bool {
+	if p.sampleEvery == 1 {
+		return true
+	}
+	// mix with a different seed than slot, so that the sampled flows are
+	// not concentrated on a few workers when sampleEvery and the number of
+	// workers share a factor
+	return fmix64(dp.FlowHash^sampleSeed)%p.sampleEvery == 0
+}
+
+// sampleSeed perturbs flow hashes used for sampling.
+const sampleSeed = 0x9e3779b97f4a7c15
+
+// fmix64 is the finalizer from MurmurHash3, which makes every bit of the
+// result depend on every bit of k.
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
 }
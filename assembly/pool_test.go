@@ -0,0 +1,852 @@
+package assembly
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/capture"
+	"github.com/box/memsniff/decode"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func TestMixFlowHashSpreadsLowEntropyHashes(t *testing.T) {
+	p := &Pool{workers: make([]worker, 8)}
+	// hashes differing only above the bits used by the modulo
+	slots := func() map[int]bool {
+		seen := make(map[int]bool)
+		for i := uint64(0); i < 64; i++ {
+			seen[p.slot(&decode.DecodedPacket{FlowHash: i << 8})] = true
+		}
+		return seen
+	}
+	if n := len(slots()); n != 1 {
+		t.Error("expected unmixed hashes to share a worker, got", n, "workers")
+	}
+	p.MixFlowHash = true
+	if n := len(slots()); n != len(p.workers) {
+		t.Error("expected mixed hashes to use all", len(p.workers), "workers, got", n)
+	}
+}
+
+// packetSource is a capture.PacketSource that returns its packets in a single
+// batch, then EOF.
+type packetSource struct {
+	packets []capture.PacketData
+}
+
+func (ps *packetSource) CollectPackets(pb *capture.PacketBuffer) error {
+	pb.Clear()
+	if len(ps.packets) == 0 {
+		return io.EOF
+	}
+	for _, pd := range ps.packets {
+		if err := pb.Append(pd); err != nil {
+			return err
+		}
+	}
+	ps.packets = nil
+	return nil
+}
+
+func (ps *packetSource) DiscardPacket() error {
+	return nil
+}
+
+func (ps *packetSource) Stats() (*pcap.Stats, error) {
+	return &pcap.Stats{}, nil
+}
+
+func tcpPacket(t testing.TB, srcIP, dstIP string, srcPort, dstPort int) capture.PacketData {
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		ACK:     true,
+	}
+	return tcpSegment(t, srcIP, dstIP, tcp, "get a\r\n")
+}
+
+// tcpSegment returns a packet carrying tcp and payload between srcIP and
+// dstIP.
+func tcpSegment(t testing.TB, srcIP, dstIP string, tcp *layers.TCP, payload string) capture.PacketData {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	var ip gopacket.NetworkLayer
+	var ipLayer gopacket.SerializableLayer
+	if strings.Contains(srcIP, ":") {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolTCP,
+			SrcIP:      net.ParseIP(srcIP),
+			DstIP:      net.ParseIP(dstIP),
+		}
+		ip, ipLayer = ip6, ip6
+	} else {
+		ip4 := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    net.ParseIP(srcIP),
+			DstIP:    net.ParseIP(dstIP),
+		}
+		ip, ipLayer = ip4, ip4
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ipLayer, tcp, gopacket.Payload(payload)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	return capture.PacketData{
+		Info: gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)},
+		Data: data,
+	}
+}
+
+func TestBidirectionalFlowUsesOneWorker(t *testing.T) {
+	src := &packetSource{packets: []capture.PacketData{
+		tcpPacket(t, "10.0.0.1", "10.0.0.2", 54321, 11211),
+		tcpPacket(t, "10.0.0.2", "10.0.0.1", 11211, 54321),
+	}}
+	var hashes []uint64
+	err := decode.ReadAll(&log.BufferLogger{}, src, func(dps []*decode.DecodedPacket) {
+		for _, dp := range dps {
+			if !dp.IsTCP() {
+				t.Fatal("packet not decoded as TCP")
+			}
+			hashes = append(hashes, dp.FlowHash)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 2 {
+		t.Fatal("expected 2 packets, got", len(hashes))
+	}
+	if hashes[0] != hashes[1] {
+		t.Error("request and response have different flow hashes", hashes)
+	}
+
+	for _, mix := range []bool{false, true} {
+		for n := 1; n <= 32; n++ {
+			p := &Pool{workers: make([]worker, n), MixFlowHash: mix}
+			req := p.slot(&decode.DecodedPacket{FlowHash: hashes[0]})
+			resp := p.slot(&decode.DecodedPacket{FlowHash: hashes[1]})
+			if req != resp {
+				t.Error("request and response assigned to workers", req, "and", resp, "of", n)
+			}
+		}
+	}
+}
+
+// decodePackets returns copies of the decoded form of packets.
+func decodePackets(t testing.TB, packets []capture.PacketData) []*decode.DecodedPacket {
+	var dps []*decode.DecodedPacket
+	err := decode.ReadAll(&log.BufferLogger{}, &packetSource{packets: packets}, func(batch []*decode.DecodedPacket) {
+		for _, dp := range batch {
+			c := *dp
+			dps = append(dps, &c)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dps
+}
+
+func BenchmarkHandlePackets(b *testing.B) {
+	var packets []capture.PacketData
+	for i := 0; i < 64; i++ {
+		packets = append(packets, tcpPacket(b, "10.0.0.1", "10.0.0.2", 40000+i, 11211))
+	}
+	dps := decodePackets(b, packets)
+	p := New(nil, analysis.New(analysis.Config{Workers: 1, ReportSize: 1}), []int{11211}, nil, 8, 1, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.HandlePackets(dps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestIPv6FlowHash(t *testing.T) {
+	dps := decodePackets(t, []capture.PacketData{
+		tcpPacket(t, "2001:db8::1", "2001:db8::2", 54321, 11211),
+		tcpPacket(t, "2001:db8::2", "2001:db8::1", 11211, 54321),
+		// differs from the first flow only in the high bytes of the addresses
+		tcpPacket(t, "2001:db9::1", "2001:db9::2", 54321, 11211),
+		tcpPacket(t, "2001:db8::1:0:0:1", "2001:db8::2", 54321, 11211),
+	})
+	if len(dps) != 4 {
+		t.Fatal("expected 4 packets, got", len(dps))
+	}
+	for _, dp := range dps {
+		if !dp.IsTCP() {
+			t.Fatal("packet not decoded as TCP")
+		}
+	}
+	if dps[0].FlowHash != dps[1].FlowHash {
+		t.Error("directions of one IPv6 flow hash differently", dps[0].FlowHash, dps[1].FlowHash)
+	}
+	if dps[0].FlowHash == dps[2].FlowHash || dps[0].FlowHash == dps[3].FlowHash || dps[2].FlowHash == dps[3].FlowHash {
+		t.Error("distinct IPv6 flows collide", dps[0].FlowHash, dps[2].FlowHash, dps[3].FlowHash)
+	}
+}
+
+func TestSampleWholeFlows(t *testing.T) {
+	p := New(nil, nil, nil, nil, 4, 0.25, 0)
+	var sampled int
+	for h := uint64(0); h < 10000; h++ {
+		dp := &decode.DecodedPacket{FlowHash: h}
+		if p.sampled(dp) {
+			sampled++
+		}
+	}
+	if sampled < 2000 || sampled > 3000 {
+		t.Error("expected about 2500 of 10000 flows sampled, got", sampled)
+	}
+
+	if all := New(nil, nil, nil, nil, 4, 1, 0); !all.sampled(&decode.DecodedPacket{FlowHash: 1}) {
+		t.Error("expected all flows sampled at rate 1")
+	}
+}
+
+func TestEffectiveSampleRate(t *testing.T) {
+	for _, c := range []struct{ rate, want float64 }{
+		{0.25, 0.25},
+		{0.3, 1.0 / 3},
+		{0.45, 0.5},
+		{1, 1},
+		{0, 1},
+	} {
+		if got := SampleRate(c.rate); got != c.want {
+			t.Error("expected sample rate", c.rate, "to keep", c.want, "of flows, got", got)
+		}
+	}
+}
+
+func TestPerPortRouting(t *testing.T) {
+	a := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	b := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	sf := &streamFactory{pools: map[int]*analysis.Pool{11211: a, 11212: b}}
+
+	for port, pool := range sf.pools {
+		server := layers.NewTCPPortEndpoint(layers.TCPPort(port))
+		client := layers.NewTCPPortEndpoint(54321)
+		ck := connectionKey{
+			netFlow:       gopacket.NewFlow(layers.EndpointIPv4, net.IP{10, 0, 0, 2}, net.IP{10, 0, 0, 1}),
+			transportFlow: gopacket.NewFlow(layers.EndpointTCPPort, server.Raw(), client.Raw()),
+		}
+		if !sf.IsFromServer(ck.transportFlow) {
+			t.Error("expected port", port, "to be a server port")
+		}
+		c := sf.createConsumer(ck)
+		c.AddEvent(model.Event{Type: model.EventGetMiss, Key: "k"})
+		c.FlushEvents()
+		pool.Wait()
+		if keys := pool.Top(10, analysis.MetricRequests); len(keys) != 1 || keys[0].MissesEstimate != 1 {
+			t.Error("expected event for port", port, "in its own pool, got", keys)
+		}
+	}
+	if sf.IsFromServer(gopacket.NewFlow(layers.EndpointTCPPort, layers.NewTCPPortEndpoint(11213).Raw(), layers.NewTCPPortEndpoint(54321).Raw())) {
+		t.Error("expected port 11213 not to be a server port")
+	}
+}
+
+func TestRetransmittedResponseCountedOnce(t *testing.T) {
+	client := func(seq uint32, syn bool) *layers.TCP {
+		return &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: seq, SYN: syn, ACK: !syn}
+	}
+	server := func(seq uint32, syn bool) *layers.TCP {
+		return &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: seq, SYN: syn, ACK: true}
+	}
+	response := "VALUE k 0 3\r\nabc\r\nEND\r\n"
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client(999, true), ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(4999, true), ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client(1000, false), "get k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(5000, false), response),
+		// retransmissions of the whole request and response, which would
+		// be a second hit if replayed
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client(1000, false), "get k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(5000, false), response),
+		// retransmission overlapping the end of the response
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(5000+uint32(len(response))-5, false), "END\r\n"),
+	})
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(10, analysis.MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "k" || keys[0].RequestsEstimate != 1 {
+		t.Error("expected a single hit on k, got", keys)
+	}
+}
+
+func TestRedisPortDispatch(t *testing.T) {
+	client := func(seq uint32, syn bool) *layers.TCP {
+		return &layers.TCP{SrcPort: 54321, DstPort: 6379, Seq: seq, SYN: syn, ACK: !syn}
+	}
+	server := func(seq uint32, syn bool) *layers.TCP {
+		return &layers.TCP{SrcPort: 6379, DstPort: 54321, Seq: seq, SYN: syn, ACK: true}
+	}
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client(999, true), ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(4999, true), ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client(1000, false), "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(5000, false), "$3\r\nabc\r\n"),
+	})
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, []int{6379}, 1, 1, 0)
+	ch := make(chan []model.Event, 1)
+	p.Subscribe(ch)
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(10, analysis.MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "k" || keys[0].RequestsEstimate != 1 || keys[0].Size != 3 {
+		t.Error("expected a single 3 byte hit on k, got", keys)
+	}
+	if evts := <-ch; len(evts) != 1 || !evts[0].LongKeys {
+		t.Error("expected Redis events to allow long keys, got", evts)
+	}
+}
+
+func TestResponseSplitAcrossPackets(t *testing.T) {
+	client := &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}
+	syn := &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}
+	synAck := &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}
+	server := func(seq uint32) *layers.TCP {
+		return &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: seq, ACK: true}
+	}
+	// the VALUE line is split in its header and again within its data
+	parts := []string{"VAL", "UE k 0 10\r\n01234", "56789\r\nEND\r\n"}
+	packets := []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", syn, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", synAck, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client, "get k\r\n"),
+	}
+	seq := uint32(5000)
+	for _, part := range parts {
+		packets = append(packets, tcpSegment(t, "10.0.0.2", "10.0.0.1", server(seq), part))
+		seq += uint32(len(part))
+	}
+	dps := decodePackets(t, packets)
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	// deliver each packet separately, as it would arrive from capture
+	for _, dp := range dps {
+		if err := p.HandlePackets([]*decode.DecodedPacket{dp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(10, analysis.MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "k" || keys[0].RequestsEstimate != 1 || keys[0].Size != 10 {
+		t.Error("expected a single 10 byte hit on k, got", keys)
+	}
+}
+
+func TestResponseAcrossSequenceWrap(t *testing.T) {
+	syn := &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}
+	client := &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}
+	// the response's sequence numbers pass 2^32 within its data
+	synAck := &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 0xfffffff0, SYN: true, ACK: true}
+	parts := []string{"VALUE k 0 10\r\n01234", "56789\r\nEND\r\n"}
+	packets := []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", syn, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", synAck, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", client, "get k\r\n"),
+	}
+	seq := synAck.Seq + 1
+	for _, part := range parts {
+		packets = append(packets, tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: seq, ACK: true}, part))
+		seq += uint32(len(part))
+	}
+	dps := decodePackets(t, packets)
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	for _, dp := range dps {
+		if err := p.HandlePackets([]*decode.DecodedPacket{dp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(10, analysis.MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "k" || keys[0].RequestsEstimate != 1 || keys[0].Size != 10 {
+		t.Error("expected a single 10 byte hit on k, got", keys)
+	}
+}
+
+func TestTruncatedPacket(t *testing.T) {
+	server := func(seq uint32) *layers.TCP {
+		return &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: seq, ACK: true}
+	}
+	parts := []string{"VALUE k 0 10\r\n01234", "56789\r\nEND\r\n"}
+	packets := []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(5000), parts[0]),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", server(5000+uint32(len(parts[0]))), parts[1]),
+	}
+	// the snaplen cut off the end of the first part of the value
+	pd := &packets[3]
+	pd.Data = pd.Data[:len(pd.Data)-3]
+	pd.Info.CaptureLength = len(pd.Data)
+	dps := decodePackets(t, packets)
+	if dps[3].Truncated != 3 {
+		t.Fatal("expected 3 bytes truncated, got", dps[3].Truncated)
+	}
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	ch := make(chan []model.Event, 10)
+	p.Subscribe(ch)
+	for _, dp := range dps {
+		if err := p.HandlePackets([]*decode.DecodedPacket{dp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Flush()
+	ap.Wait()
+
+	evts := <-ch
+	if len(evts) != 1 || evts[0].Type != model.EventGetHit || evts[0].Size != 10 || !evts[0].Truncated {
+		t.Error("expected a truncated 10 byte hit, got", evts)
+	}
+	keys := ap.Top(10, analysis.MetricRequests)
+	if len(keys) != 1 || keys[0].Name != "k" || keys[0].Size != 10 {
+		t.Error("expected a single 10 byte hit on k, got", keys)
+	}
+}
+
+func TestCoalescedSegment(t *testing.T) {
+	// with GRO, the responses to many pipelined gets arrive as a single
+	// segment larger than a reader buffers
+	var req, resp strings.Builder
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&req, "get k%d\r\n", i)
+		fmt.Fprintf(&resp, "VALUE k%d 0 %d\r\n%s\r\nEND\r\n", i, len(value), value)
+	}
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, req.String()),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, resp.String()),
+	})
+	if n := len(dps[3].TCP.Payload); n <= reader.BufferSize {
+		t.Fatal("expected a segment larger than the reader buffer, got", n)
+	}
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 100})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(100, analysis.MetricRequests)
+	if len(keys) != 50 {
+		t.Fatal("expected a hit on each of 50 keys, got", len(keys))
+	}
+	for _, kr := range keys {
+		if kr.RequestsEstimate != 1 || kr.Size != len(value) {
+			t.Error("expected a single hit of", len(value), "bytes, got", kr)
+		}
+	}
+}
+
+// countingLogger counts the messages logged to it.
+type countingLogger struct {
+	n int
+}
+
+func (l *countingLogger) Log(items ...interface{}) {
+	l.n++
+}
+
+func TestIdleHalfOpenConversationDiscarded(t *testing.T) {
+	logger := &countingLogger{}
+	sf := &streamFactory{
+		logger:   logger,
+		pools:    map[int]*analysis.Pool{11211: analysis.New(analysis.Config{Workers: 1})},
+		halfOpen: make(map[connectionKey]*model.Consumer),
+	}
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2})
+	transportFlow := gopacket.NewFlow(layers.EndpointTCPPort, layers.NewTCPPortEndpoint(54321).Raw(), layers.NewTCPPortEndpoint(11211).Raw())
+
+	// a conversation seen in both directions is no longer half open
+	client := sf.New(netFlow, transportFlow)
+	sf.New(netFlow.Reverse(), transportFlow.Reverse())
+	if len(sf.halfOpen) != 0 {
+		t.Fatal("expected no half open conversations, got", len(sf.halfOpen))
+	}
+	client.ReassemblyComplete()
+
+	// the server never answers this client
+	other := gopacket.NewFlow(layers.EndpointTCPPort, layers.NewTCPPortEndpoint(54322).Raw(), layers.NewTCPPortEndpoint(11211).Raw())
+	s := sf.New(netFlow, other)
+	if len(sf.halfOpen) != 1 {
+		t.Fatal("expected one half open conversation, got", len(sf.halfOpen))
+	}
+	sf.reaping = true
+	s.ReassemblyComplete()
+	if len(sf.halfOpen) != 0 {
+		t.Error("expected idle half open conversation to be discarded")
+	}
+	if logger.n != 1 {
+		t.Error("expected idle conversation to be logged once, got", logger.n)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	ch := make(chan []model.Event, 1)
+	p.Subscribe(ch)
+	full := make(chan []model.Event)
+	p.Subscribe(full)
+
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, "END\r\n"),
+	})
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := <-ch
+	if len(evts) != 1 || evts[0].Type != model.EventGetMiss || evts[0].Key != "k" || evts[0].Client != "10.0.0.1" {
+		t.Error("unexpected events", evts)
+	}
+	if n := p.SubscriberDrops(); n != 1 {
+		t.Error("expected 1 batch dropped for the unbuffered subscriber, got", n)
+	}
+
+	p.Unsubscribe(ch)
+	p.Unsubscribe(full)
+	p.Flush()
+	select {
+	case evts := <-ch:
+		t.Error("expected no events after unsubscribing, got", evts)
+	default:
+	}
+}
+
+func TestSubscribeRedacted(t *testing.T) {
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10,
+		RedactPatterns: []*regexp.Regexp{regexp.MustCompile(`secret`)}})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	ch := make(chan []model.Event, 1)
+	p.Subscribe(ch)
+
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get secret:k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, "END\r\n"),
+	})
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := <-ch
+	if len(evts) != 1 || evts[0].Key != "***:k" || !evts[0].Redacted {
+		t.Error("expected the key redacted before publishing, got", evts)
+	}
+}
+
+func TestInterfaceLabels(t *testing.T) {
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	p.SetInterfaces([]string{"eth0", "eth1"})
+	ch := make(chan []model.Event, 1)
+	p.Subscribe(ch)
+
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, "END\r\n"),
+	})
+	for _, dp := range dps {
+		dp.Info.InterfaceIndex = 1
+	}
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := <-ch
+	if len(evts) != 1 || evts[0].Interface != "eth1" || evts[0].Conn != "eth1: 10.0.0.1:54321 -> 10.0.0.2:11211" {
+		t.Error("expected event labeled with eth1, got", evts)
+	}
+}
+
+func TestEventTimestamps(t *testing.T) {
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	ch := make(chan []model.Event, 1)
+	p.Subscribe(ch)
+
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get k\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, "END\r\n"),
+	})
+	t0 := time.Unix(1500000000, 0)
+	for i, dp := range dps {
+		dp.Info.Timestamp = t0.Add(time.Duration(i) * time.Second)
+	}
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := <-ch
+	if want := t0.Add(3 * time.Second); len(evts) != 1 || !evts[0].Timestamp.Equal(want) {
+		t.Error("expected event timestamped by the response at", want, "got", evts)
+	}
+}
+
+func TestDefaultWorkers(t *testing.T) {
+	p := New(nil, analysis.New(analysis.Config{Workers: 1}), []int{11211}, nil, 0, 1, 0)
+	if len(p.workers) != runtime.GOMAXPROCS(0) {
+		t.Error("expected a worker per CPU, got", len(p.workers))
+	}
+}
+
+func TestZeroWorkersHandlePackets(t *testing.T) {
+	for _, numWorkers := range []int{0, 1} {
+		ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+		p := New(nil, ap, []int{11211}, nil, numWorkers, 1, 0)
+		dps := decodePackets(t, []capture.PacketData{
+			tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+			tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+			tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get k\r\n"),
+			tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, "END\r\n"),
+		})
+		if err := p.HandlePackets(dps); err != nil {
+			t.Fatal(err)
+		}
+		p.Flush()
+		ap.Wait()
+		if keys := ap.Top(10, analysis.MetricRequests); len(keys) != 1 || keys[0].MissesEstimate != 1 {
+			t.Error("expected a miss on k with", numWorkers, "workers, got", keys)
+		}
+	}
+}
+
+func TestResizeContinuesFlows(t *testing.T) {
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	before := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 4999, SYN: true, ACK: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get a\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5000, ACK: true}, "END\r\n"),
+	})
+	if err := p.HandlePackets(before); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Resize(3)
+	if n := p.Workers(); n != 3 {
+		t.Fatal("expected 3 workers, got", n)
+	}
+	after := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1007, ACK: true}, "get b\r\n"),
+		tcpSegment(t, "10.0.0.2", "10.0.0.1", &layers.TCP{SrcPort: 11211, DstPort: 54321, Seq: 5005, ACK: true}, "END\r\n"),
+	})
+	if err := p.HandlePackets(after); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(10, analysis.MetricRequests)
+	misses := make(map[string]int)
+	for _, kr := range keys {
+		misses[kr.Name] = kr.MissesEstimate
+	}
+	if len(misses) != 2 || misses["a"] != 1 || misses["b"] != 1 {
+		t.Error("expected a miss on a before resizing and b after, got", keys)
+	}
+}
+
+func TestRequestsOnly(t *testing.T) {
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10, WeightMode: analysis.WeightCount, TrackGets: true})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	p.SetDirection(DirectionRequests)
+	dps := decodePackets(t, []capture.PacketData{
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 999, SYN: true}, ""),
+		tcpSegment(t, "10.0.0.1", "10.0.0.2", &layers.TCP{SrcPort: 54321, DstPort: 11211, Seq: 1000, ACK: true}, "get k\r\n"),
+	})
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	ap.Wait()
+	if keys := ap.Top(10, analysis.MetricRequests); len(keys) != 1 || keys[0].Name != "k" || keys[0].RequestsEstimate != 1 {
+		t.Error("expected a get of k from the request alone, got", keys)
+	}
+}
+
+func TestWarnWhenNothingDecoded(t *testing.T) {
+	logger := &countingLogger{}
+	p := New(logger, nil, nil, nil, 1, 1, 0)
+	p.checkDecoding(noEventsPackets - 1)
+	if logger.n != 0 {
+		t.Error("expected no warning before", noEventsPackets, "packets")
+	}
+	p.checkDecoding(1)
+	p.checkDecoding(1)
+	if logger.n != 1 {
+		t.Error("expected a single warning, got", logger.n)
+	}
+
+	p = New(logger, nil, nil, nil, 1, 1, 0)
+	p.eventsDecoded = 1
+	p.checkDecoding(noEventsPackets)
+	if logger.n != 1 {
+		t.Error("expected no warning once events are decoded, got", logger.n-1)
+	}
+}
+
+func TestOtherPortsIgnored(t *testing.T) {
+	// packets decapsulated from a tunnel are not selected by port
+	dps := decodePackets(t, []capture.PacketData{
+		tcpPacket(t, "10.0.0.1", "10.0.0.2", 54321, 80),
+		tcpPacket(t, "10.0.0.1", "10.0.0.2", 54321, 11211),
+	})
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 1, 1, 0)
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	if counts := p.PacketCounts(); counts[0] != 1 {
+		t.Error("expected only the memcached packet dispatched, got", counts)
+	}
+}
+
+func TestSynthesizedSegments(t *testing.T) {
+	s := decode.NewSynthesizer(11211)
+	segs := []decode.Segment{
+		{Conn: 1, Data: []byte("get k\r\n")},
+		{Conn: 2, Data: []byte("get j\r\n")},
+		{Conn: 1, FromServer: true, Data: []byte("VALUE k 0 10\r\n01234")},
+		{Conn: 2, FromServer: true, Data: []byte("END\r\n")},
+		{Conn: 1, FromServer: true, Data: []byte("56789\r\nEND\r\n")},
+		{Conn: 1, Close: true},
+	}
+	dps := s.Packets(segs)
+	if dps[0].FlowHash != dps[2].FlowHash || dps[0].FlowHash == dps[1].FlowHash {
+		t.Error("expected a pseudo flow hash per conversation")
+	}
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 2, 1, 0)
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+	ap.Wait()
+
+	keys := ap.Top(10, analysis.MetricRequests)
+	if len(keys) != 2 || keys[0].Name != "k" || keys[0].RequestsEstimate != 1 || keys[0].Size != 10 ||
+		keys[1].Name != "j" || keys[1].MissesEstimate != 1 {
+		t.Error("expected a 10 byte hit on k and a miss on j, got", keys)
+	}
+}
+
+func TestHandlePacketsReportsDrops(t *testing.T) {
+	p := New(nil, nil, []int{11211}, nil, 1, 1, 0)
+	// a queue no worker reads from is always full
+	p.workers[0].wiCh = make(chan workItem)
+	dps := decodePackets(t, []capture.PacketData{
+		tcpPacket(t, "10.0.0.1", "10.0.0.2", 54321, 11211),
+		tcpPacket(t, "10.0.0.1", "10.0.0.2", 54321, 11211),
+	})
+	err := p.HandlePackets(dps)
+	de, ok := err.(*DropError)
+	if !ok {
+		t.Fatal("expected a DropError, got", err)
+	}
+	if de.Batches != 1 || de.Packets != 2 || !errors.Is(err, errQueueFull) {
+		t.Error("expected one full queue dropping 2 packets, got", de)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	s := decode.NewSynthesizer(11211)
+	dps := s.Packets([]decode.Segment{
+		{Conn: 1, Data: []byte("get k j\r\n")},
+		{Conn: 2, Data: []byte("get i\r\n")},
+		{Conn: 1, FromServer: true, Data: []byte("VALUE k 0 1\r\n0\r\nEND\r\n")},
+		{Conn: 2, FromServer: true, Data: []byte("VALUE i 0 x\r\n")},
+	})
+
+	ap := analysis.New(analysis.Config{Workers: 1, ReportSize: 10})
+	p := New(nil, ap, []int{11211}, nil, 2, 1, 0)
+	p.SetValidation()
+	if err := p.HandlePackets(dps); err != nil {
+		t.Fatal(err)
+	}
+	p.Flush()
+
+	fvs := p.Validation()
+	if len(fvs) != 2 {
+		t.Fatal("expected 2 conversations, got", fvs)
+	}
+	ok, bad := fvs[0], fvs[1]
+	if strings.Contains(ok.Conn, ":2]") {
+		ok, bad = bad, ok
+	}
+	if ok.Decoded[model.EventGetHit] != 1 || ok.Decoded[model.EventGetMiss] != 1 || ok.Failed != 0 {
+		t.Error("expected a hit and a miss decoded, got", ok)
+	}
+	if len(bad.Decoded) != 0 || bad.Failed != 1 || bad.LastError == nil {
+		t.Error("expected a decode error, got", bad)
+	}
+}
@@ -13,6 +13,8 @@ type Buffer struct {
 	cap     int
 	blocks  []block
 	discard int
+	// bytes of gaps passed over, never reset
+	lost int
 }
 
 func NewBuffer(cap int) *Buffer {
@@ -33,6 +35,12 @@ func (b *Buffer) Write(skip int, data []byte) error {
 		// starting mid-conversation
 		skip = 0
 	}
+	// a gap already being discarded is passed over immediately
+	if skip < b.discard {
+		b.lost += skip
+	} else {
+		b.lost += b.discard
+	}
 	if b.discard >= skip+len(data) {
 		// discard all of data
 		b.discard = b.discard - skip - len(data)
@@ -138,6 +146,7 @@ func (b *Buffer) Discard(n int) {
 			b.dropBlocks(i)
 			return
 		}
+		b.lost += block.gap
 		toDiscard -= l
 	}
 	b.buf.Reset()
@@ -146,6 +155,20 @@ func (b *Buffer) Discard(n int) {
 	b.discard += toDiscard
 }
 
+// Lost returns the number of bytes missing from the input stream that have
+// been passed over by discarding a gap.  Lost only increases, even across
+// calls to Reset, so that the bytes lost while reading part of the stream
+// are the difference between calls before and after.
+func (b *Buffer) Lost() int {
+	return b.lost
+}
+
+// Skipping returns the number of bytes yet to be discarded from future
+// writes.
+func (b *Buffer) Skipping() int {
+	return b.discard
+}
+
 func (b *Buffer) contiguousAvailable() (avail int, gap int) {
 	for _, block := range b.blocks {
 		if block.hasGap() {
@@ -211,9 +234,11 @@ func (b *block) discard(buf *Buffer, n int) {
 	buf.len -= n
 	if b.gap > n {
 		b.gap -= n
+		buf.lost += n
 		return
 	}
 	n -= b.gap
+	buf.lost += b.gap
 	b.gap = 0
 	b.dataLen -= n
 	buf.buf.Next(n)
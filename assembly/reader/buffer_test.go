@@ -89,6 +89,30 @@ func TestDiscardOverGap(t *testing.T) {
 	testReadN(t, b, "ld", 0)
 }
 
+func TestLost(t *testing.T) {
+	b := NewBuffer(128)
+	b.Write(0, []byte("hel"))
+	b.Write(2, []byte(" wo"))
+	b.Discard(4)
+	if n := b.Lost(); n != 1 {
+		t.Error("expected 1 byte lost, got", n)
+	}
+	b.Discard(2)
+	if n := b.Lost(); n != 2 {
+		t.Error("expected 2 bytes lost, got", n)
+	}
+	// gaps arriving while skipping are lost as they are passed over
+	b.Discard(5)
+	b.Write(3, []byte("ld"))
+	if n := b.Lost(); n != 5 {
+		t.Error("expected 5 bytes lost, got", n)
+	}
+	b.Reset()
+	if n := b.Lost(); n != 5 {
+		t.Error("expected lost bytes kept across Reset, got", n)
+	}
+}
+
 func TestDiscardMultipleBlocks(t *testing.T) {
 	b := NewBuffer(128)
 	b.Write(2, nil)
@@ -109,6 +133,21 @@ func TestDiscardBeforeWriteMultiple(t *testing.T) {
 	testReadN(t, b, "ell", 1)
 }
 
+func TestSkipping(t *testing.T) {
+	b := NewBuffer(128)
+	b.Write(0, []byte("he"))
+	b.Discard(5)
+	if n := b.Skipping(); n != 3 {
+		t.Error("expected 3 bytes skipping, got", n)
+	}
+	b.Write(0, []byte("llo wor"))
+	if n := b.Skipping(); n != 0 {
+		t.Error("expected no bytes skipping, got", n)
+	}
+
+	testReadN(t, b, " wor", 0)
+}
+
 func TestReadNHitsGap(t *testing.T) {
 	b := NewBuffer(128)
 	b.Write(0, []byte("hello"))
@@ -60,6 +60,18 @@ func (r *Reader) Discard(n int) (discarded int, err error) {
 	return n, nil
 }
 
+func (r *Reader) Lost() int {
+	return r.buf.Lost()
+}
+
+func (r *Reader) Buffered() int {
+	return r.buf.Len()
+}
+
+func (r *Reader) Skipping() int {
+	return r.buf.Skipping()
+}
+
 func (r *Reader) Read(p []byte) (n int, err error) {
 	out, err := r.ReadN(len(p))
 	if err == ErrShortRead {
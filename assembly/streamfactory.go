@@ -3,11 +3,16 @@ package assembly
 import (
 	"encoding/binary"
 	"fmt"
+	"net"
+	"sync/atomic"
 
 	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/assembly/reader"
 	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/mcbinary"
 	"github.com/box/memsniff/protocol/mctext"
 	"github.com/box/memsniff/protocol/model"
+	"github.com/box/memsniff/protocol/redis"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/tcpassembly"
@@ -38,37 +43,56 @@ func (c *connectionKey) DstString() string {
 }
 
 type streamFactory struct {
-	logger        log.Logger
-	analysis      *analysis.Pool
-	memcachePorts []int
+	logger log.Logger
+	// analysis pool for conversations with each memcached port
+	pools map[int]*analysis.Pool
+	// server ports speaking RESP rather than a memcached protocol
+	redis map[int]bool
+	// receivers of all decoded events, if any
+	subs *subscribers
+	// names of the network interfaces packets were captured on, by
+	// CaptureInfo.InterfaceIndex, if captured on several
+	interfaces []string
+	// interface index of the packet being assembled, which determines the
+	// interface of any conversation it starts
+	iface int
+
+	// protocol errors by conversation
+	errors *flowErrors
+	// whether only traffic from clients to servers is captured
+	requestsOnly bool
+	// count of events decoded, shared by all workers in a Pool, if counted
+	decoded *int64
+	// how each conversation was decoded, shared by all workers in a Pool,
+	// if recorded
+	validation *validation
 
 	halfOpen map[connectionKey]*model.Consumer
+	// whether streams are being closed for being idle, rather than ending
+	reaping bool
+	// payload of the truncated packet being assembled, if any, ending with
+	// padding bytes standing in for those not captured
+	padded  []byte
+	padding int
 }
 
 // IsFromServer returns true if we believe this packet is coming from the server.
 // Note that it will misidentify a client using a server port as a source ephemeral port.
 // For now we accept that possibility, but we could try to infer based on source IP as well.
 func (sf *streamFactory) IsFromServer(transportFlow gopacket.Flow) bool {
-	port := srcPort(transportFlow)
-	return isInPortlist(sf.memcachePorts, port)
+	_, ok := sf.pools[srcPort(transportFlow)]
+	return ok
 }
 
 func srcPort(transportFlow gopacket.Flow) int {
-	if transportFlow.EndpointType() != layers.EndpointTCPPort {
-		panic("non TCP flow")
+	switch transportFlow.EndpointType() {
+	case layers.EndpointTCPPort, layers.EndpointUDPPort:
+	default:
+		panic("non TCP or UDP flow")
 	}
 	return int(binary.BigEndian.Uint16(transportFlow.Src().Raw()))
 }
 
-func isInPortlist(ports []int, port int) bool {
-	for _, p := range ports {
-		if port == p {
-			return true
-		}
-	}
-	return false
-}
-
 func (sf *streamFactory) New(netFlow, transportFlow gopacket.Flow) tcpassembly.Stream {
 	ck := connectionKey{
 		netFlow:       netFlow,
@@ -88,22 +112,141 @@ func (sf *streamFactory) New(netFlow, transportFlow gopacket.Flow) tcpassembly.S
 		sf.halfOpen[ck] = c
 	}
 
-	var stream tcpassembly.Stream
+	s := &stream{sf: sf, ck: ck, c: c}
 	if fromServer {
-		stream = c.ServerStream()
+		s.Stream = c.ServerStream()
 	} else {
-		stream = c.ClientStream()
+		s.Stream = c.ClientStream()
 	}
+	return s
+}
+
+// stream is one direction of a TCP conversation.
+type stream struct {
+	tcpassembly.Stream
+	sf *streamFactory
+	// oriented from the server to the client
+	ck connectionKey
+	c  *model.Consumer
+}
+
+// Reassembled implements tcpassembly.Stream.  The padding of a truncated
+// packet is delivered as a gap, so that it is read as lost data rather than
+// as zeros.  A truncated packet that arrives out of order is buffered by the
+// assembler, and its padding is delivered as is.
+func (s *stream) Reassembled(rs []tcpassembly.Reassembly) {
+	if s.sf.padding > 0 {
+		rs = s.sf.unpad(rs)
+	}
+	s.Stream.Reassembled(rs)
+}
+
+// unpad returns rs with the padding removed from the end of the reassembly
+// delivering the truncated packet being assembled, followed by a gap in its
+// place.  The assembler passes the bytes of a packet delivered in order
+// without copying them, and only trims any already seen from the start.
+func (sf *streamFactory) unpad(rs []tcpassembly.Reassembly) []tcpassembly.Reassembly {
+	end := &sf.padded[len(sf.padded)-1]
+	for i, r := range rs {
+		if len(r.Bytes) == 0 || &r.Bytes[len(r.Bytes)-1] != end {
+			continue
+		}
+		gap := sf.padding
+		if gap > len(r.Bytes) {
+			gap = len(r.Bytes)
+		}
+		unpadded := make([]tcpassembly.Reassembly, 0, len(rs)+1)
+		unpadded = append(unpadded, rs[:i+1]...)
+		unpadded[i].Bytes = r.Bytes[:len(r.Bytes)-gap]
+		unpadded = append(unpadded, tcpassembly.Reassembly{Skip: gap, Seen: r.Seen})
+		return append(unpadded, rs[i+1:]...)
+	}
+	return rs
+}
 
-	return stream
+// ReassemblyComplete implements tcpassembly.Stream.  If the other direction
+// of the conversation was never seen, the conversation is discarded rather
+// than waiting forever for it.
+func (s *stream) ReassemblyComplete() {
+	s.Stream.ReassemblyComplete()
+	if s.sf.reaping {
+		log.Debug(s.sf.logger, "closed idle conversation", s.ck.String())
+	}
+	if s.sf.halfOpen[s.ck] == s.c {
+		delete(s.sf.halfOpen, s.ck)
+		s.c.Close()
+	}
 }
 
 func (sf *streamFactory) createConsumer(ck connectionKey) *model.Consumer {
-	return mctext.NewConsumer(nil, sf.analysis.HandleEvents)
+	// ck is oriented from the server to the client
+	port := srcPort(ck.transportFlow)
+	pool := sf.pools[port]
+	client := ck.netFlow.Dst().String()
+	server := ck.netFlow.Src().String()
+	conn := net.JoinHostPort(client, ck.transportFlow.Dst().String()) + " -> " +
+		net.JoinHostPort(ck.netFlow.Src().String(), ck.transportFlow.Src().String())
+	var iface string
+	if sf.iface >= 0 && sf.iface < len(sf.interfaces) {
+		iface = sf.interfaces[sf.iface]
+		conn = iface + ": " + conn
+	}
+	longKeys := sf.redis[port]
+	handler := func(evts []model.Event) {
+		for i := range evts {
+			evts[i].Client = client
+			evts[i].Server = server
+			evts[i].Conn = conn
+			evts[i].Interface = iface
+			evts[i].LongKeys = longKeys
+		}
+		if sf.decoded != nil {
+			atomic.AddInt64(sf.decoded, int64(len(evts)))
+		}
+		if sf.validation != nil {
+			sf.validation.decoded(conn, evts)
+		}
+		if sf.subs != nil {
+			// subscribers see keys only as analysis records them
+			sf.subs.publish(pool.Redact(evts))
+		}
+		pool.HandleEvents(evts)
+	}
+	c := model.New(nil, handler)
+	c.RequestsOnly = sf.requestsOnly
+	if sf.errors != nil || sf.validation != nil {
+		c.ErrorHandler = func(err error) {
+			if sf.validation != nil {
+				sf.validation.failed(conn, err)
+			}
+			if sf.errors != nil {
+				sf.errors.record(conn, c, err)
+			}
+		}
+	}
+	if sf.redis[port] {
+		redis.Attach(c)
+	} else {
+		c.Run = func() { detectProtocol(c) }
+	}
+	return c
 }
 
-func (sf *streamFactory) log(items ...interface{}) {
-	if sf.logger != nil {
-		sf.logger.Log(items...)
+// detectProtocol waits for the first byte from the client, then hands c
+// over to the decoder for the binary or text protocol as appropriate.
+func detectProtocol(c *model.Consumer) {
+	firstByte, err := c.ClientReader.PeekN(1)
+	if err != nil {
+		if _, ok := err.(reader.ErrLostData); ok {
+			// try again from the start of a later client packet
+			c.ClientReader.Truncate()
+		}
+		return
+	}
+	if firstByte[0] == mcbinary.MagicRequest {
+		mcbinary.Attach(c)
+	} else {
+		mctext.Attach(c)
 	}
+	c.Run()
 }
@@ -0,0 +1,78 @@
+package assembly
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/box/memsniff/protocol/model"
+)
+
+// subscribers fans out decoded events to channels registered with
+// Pool.Subscribe.  subscribers is threadsafe.
+type subscribers struct {
+	mu  sync.RWMutex
+	chs []chan<- []model.Event
+	// number of batches not delivered because a channel was full
+	dropped int64
+}
+
+func (s *subscribers) add(ch chan<- []model.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chs = append(s.chs, ch)
+}
+
+func (s *subscribers) remove(ch chan<- []model.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.chs {
+		if c == ch {
+			s.chs = append(s.chs[:i:i], s.chs[i+1:]...)
+			return
+		}
+	}
+}
+
+// publish sends a copy of evts to every subscriber with room for it.
+func (s *subscribers) publish(evts []model.Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.chs) == 0 || len(evts) == 0 {
+		return
+	}
+	// evts is reused by the consumer and modified by analysis, so
+	// subscribers share a copy
+	cp := append([]model.Event(nil), evts...)
+	for _, ch := range s.chs {
+		select {
+		case ch <- cp:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+// Subscribe registers ch to receive every batch of events decoded by the
+// Pool, before they are passed to analysis.  Keys are first masked by the
+// RedactPatterns of the analysis pool, if any.  The batches sent to ch are
+// shared with other subscribers and must not be modified.
+//
+// Sending never blocks, so a slow subscriber cannot stall the Pool: if ch
+// is full the batch is dropped for that subscriber and counted by
+// SubscriberDrops.  The capacity of ch determines how far a subscriber may
+// fall behind before losing events.
+func (p *Pool) Subscribe(ch chan<- []model.Event) {
+	p.subs.add(ch)
+}
+
+// Unsubscribe stops sending events to ch.  Once Unsubscribe returns no more
+// batches will be sent to ch, so it may be closed.
+func (p *Pool) Unsubscribe(ch chan<- []model.Event) {
+	p.subs.remove(ch)
+}
+
+// SubscriberDrops returns the number of batches of events not delivered to
+// subscribers because their channels were full.
+func (p *Pool) SubscriberDrops() int64 {
+	return atomic.LoadInt64(&p.subs.dropped)
+}
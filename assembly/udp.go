@@ -0,0 +1,157 @@
+package assembly
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/box/memsniff/decode"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// udpHeaderLen is the length of the frame header memcached prepends to each
+// UDP datagram.
+const udpHeaderLen = 8
+
+var errShortUDPFrame = errors.New("UDP datagram too short for memcache frame header")
+
+// udpFrame is a single memcached UDP datagram.
+type udpFrame struct {
+	// identifies the request a response belongs to, chosen by the client
+	requestID uint16
+	// position of this datagram in the message
+	seq uint16
+	// number of datagrams in the message
+	total   uint16
+	payload []byte
+}
+
+func parseUDPFrame(data []byte) (udpFrame, error) {
+	if len(data) < udpHeaderLen {
+		return udpFrame{}, errShortUDPFrame
+	}
+	return udpFrame{
+		requestID: binary.BigEndian.Uint16(data[0:2]),
+		seq:       binary.BigEndian.Uint16(data[2:4]),
+		total:     binary.BigEndian.Uint16(data[4:6]),
+		payload:   data[udpHeaderLen:],
+	}, nil
+}
+
+// udpKey identifies a single request and response over UDP.
+type udpKey struct {
+	// oriented from server to client, like TCP conversations
+	ck        connectionKey
+	requestID uint16
+}
+
+// udpConversation collects the datagrams of a single request and response.
+type udpConversation struct {
+	consumer *model.Consumer
+	// response datagrams by sequence number, held until all have arrived
+	// since they may be reordered
+	response [][]byte
+	received int
+	// when the last datagram arrived, which completes the response
+	lastSeen time.Time
+}
+
+// udpAssembler feeds memcached UDP traffic to protocol consumers.  Unlike
+// TCP, each request and its response form an independent conversation.
+//
+// udpAssembler is not threadsafe.
+type udpAssembler struct {
+	sf    *streamFactory
+	convs map[udpKey]*udpConversation
+}
+
+func newUDPAssembler(sf *streamFactory) *udpAssembler {
+	return &udpAssembler{
+		sf:    sf,
+		convs: make(map[udpKey]*udpConversation),
+	}
+}
+
+func (ua *udpAssembler) assemble(dp *decode.DecodedPacket) {
+	frame, err := parseUDPFrame(dp.UDP.Payload)
+	if err != nil {
+		log.Debug(ua.sf.logger, err)
+		return
+	}
+	transportFlow := dp.UDP.TransportFlow()
+	key := udpKey{
+		ck: connectionKey{
+			netFlow:       dp.NetFlow,
+			transportFlow: transportFlow,
+		},
+		requestID: frame.requestID,
+	}
+	fromServer := ua.sf.IsFromServer(transportFlow)
+	if !fromServer {
+		key.ck = key.ck.Reverse()
+	}
+
+	conv, ok := ua.convs[key]
+	if !ok {
+		conv = &udpConversation{consumer: ua.sf.createConsumer(key.ck)}
+		ua.convs[key] = conv
+	}
+	conv.lastSeen = dp.Info.Timestamp
+
+	if !fromServer {
+		conv.consumer.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: frame.payload, Seen: dp.Info.Timestamp}})
+		return
+	}
+
+	if conv.response == nil {
+		conv.response = make([][]byte, frame.total)
+	}
+	if int(frame.seq) >= len(conv.response) || conv.response[frame.seq] != nil {
+		log.Debug(ua.sf.logger, "unexpected UDP datagram", frame.seq, "of", frame.total)
+		return
+	}
+	// packet data is reused once this batch has been handled
+	conv.response[frame.seq] = append([]byte(nil), frame.payload...)
+	conv.received++
+	if conv.received == len(conv.response) {
+		ua.complete(key, conv)
+	}
+}
+
+// complete delivers the response for key and discards the conversation.
+func (ua *udpAssembler) complete(key udpKey, conv *udpConversation) {
+	server := conv.consumer.ServerStream()
+	for _, datagram := range conv.response {
+		if datagram == nil {
+			// the length of a lost datagram is unknown, so the rest of the
+			// response cannot be parsed
+			break
+		}
+		server.Reassembled([]tcpassembly.Reassembly{{Bytes: datagram, Seen: conv.lastSeen}})
+	}
+	conv.consumer.ClientStream().ReassemblyComplete()
+	server.ReassemblyComplete()
+	delete(ua.convs, key)
+}
+
+// flushOlderThan completes conversations that have not seen a datagram since
+// t, delivering whatever part of the response has arrived.
+func (ua *udpAssembler) flushOlderThan(t time.Time) (flushed int) {
+	for key, conv := range ua.convs {
+		if conv.lastSeen.Before(t) {
+			ua.complete(key, conv)
+			flushed++
+		}
+	}
+	return
+}
+
+// flushAll completes all conversations, delivering whatever part of each
+// response has arrived.
+func (ua *udpAssembler) flushAll() {
+	for key, conv := range ua.convs {
+		ua.complete(key, conv)
+	}
+}
@@ -0,0 +1,23 @@
+package assembly
+
+import (
+	"testing"
+)
+
+func TestParseUDPFrame(t *testing.T) {
+	data := []byte{0x12, 0x34, 0, 1, 0, 3, 0, 0, 'E', 'N', 'D'}
+	frame, err := parseUDPFrame(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.requestID != 0x1234 || frame.seq != 1 || frame.total != 3 {
+		t.Error("unexpected frame header", frame)
+	}
+	if string(frame.payload) != "END" {
+		t.Error("unexpected payload", string(frame.payload))
+	}
+
+	if _, err := parseUDPFrame(data[:udpHeaderLen-1]); err != errShortUDPFrame {
+		t.Error("expected errShortUDPFrame, got", err)
+	}
+}
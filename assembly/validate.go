@@ -0,0 +1,117 @@
+package assembly
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/protocol/model"
+)
+
+// FlowValidation tallies the outcome of decoding a single conversation, as
+// collected by a Pool with SetValidation.
+type FlowValidation struct {
+	// the conversation, as for model.Event.Conn
+	Conn string
+	// events decoded, by type, each of which is a command decoded
+	// successfully or a key in a command naming several
+	Decoded map[model.EventType]int
+	// errors that interrupted decoding, such as data that does not follow
+	// the protocol, after which the decoder resumes at the next command
+	Failed int
+	// gaps in the capture, which are not the fault of the decoder
+	Lost int
+	// the last error counted in Failed, if any
+	LastError error
+}
+
+// validation collects a FlowValidation for each conversation.  validation
+// is threadsafe.
+type validation struct {
+	mu    sync.Mutex
+	flows map[string]*FlowValidation
+}
+
+func newValidation() *validation {
+	return &validation{flows: make(map[string]*FlowValidation)}
+}
+
+// flow returns the tally for conn, which must be called with mu held.
+func (v *validation) flow(conn string) *FlowValidation {
+	fv, ok := v.flows[conn]
+	if !ok {
+		fv = &FlowValidation{Conn: conn, Decoded: make(map[model.EventType]int)}
+		v.flows[conn] = fv
+	}
+	return fv
+}
+
+// decoded counts evts, decoded from the conversation conn.
+func (v *validation) decoded(conn string, evts []model.Event) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fv := v.flow(conn)
+	for _, evt := range evts {
+		fv.Decoded[evt.Type]++
+	}
+}
+
+// failed counts err, which interrupted decoding of the conversation conn.
+func (v *validation) failed(conn string, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fv := v.flow(conn)
+	if _, ok := err.(reader.ErrLostData); ok {
+		fv.Lost++
+		return
+	}
+	fv.Failed++
+	fv.LastError = err
+}
+
+// results returns a copy of the tallies, in order by conversation.
+func (v *validation) results() []FlowValidation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fvs := make([]FlowValidation, 0, len(v.flows))
+	for _, fv := range v.flows {
+		cp := *fv
+		cp.Decoded = make(map[model.EventType]int, len(fv.Decoded))
+		for evtType, n := range fv.Decoded {
+			cp.Decoded[evtType] = n
+		}
+		fvs = append(fvs, cp)
+	}
+	sort.Sort(flowsByConn(fvs))
+	return fvs
+}
+
+type flowsByConn []FlowValidation
+
+func (s flowsByConn) Len() int           { return len(s) }
+func (s flowsByConn) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s flowsByConn) Less(i, j int) bool { return s[i].Conn < s[j].Conn }
+
+// SetValidation records how each conversation was decoded, to check which
+// traffic the decoders understand before trusting the statistics built
+// from it.  The tallies are returned by Validation, and are kept for every
+// conversation until the Pool is discarded, so SetValidation is meant for
+// reading capture files rather than for live capture.  SetValidation must
+// be called before any packets are handled.
+func (p *Pool) SetValidation() {
+	p.validation = newValidation()
+	for _, w := range p.workers {
+		w.sf.validation = p.validation
+	}
+}
+
+// Validation returns the tally for each conversation decoded since
+// SetValidation was called, in order by conversation, or nil if it has
+// not been called.  Conversations are decoded asynchronously, so the
+// tallies are only complete once Flush has returned.
+func (p *Pool) Validation() []FlowValidation {
+	if p.validation == nil {
+		return nil
+	}
+	return p.validation.results()
+}
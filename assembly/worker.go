@@ -2,6 +2,7 @@ package assembly
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/box/memsniff/analysis"
@@ -16,28 +17,43 @@ var (
 )
 
 type workItem struct {
-	dps    []*decode.DecodedPacket
-	doneCh chan<- struct{}
+	dps []*decode.DecodedPacket
+	// if true, complete all conversations after handling dps
+	flush bool
+	// marked done once the item has been handled
+	done *sync.WaitGroup
 }
 
 type worker struct {
-	logger    log.Logger
+	logger log.Logger
+	// reassembles TCP streams by sequence number, discarding bytes already
+	// delivered so that retransmitted segments are not parsed twice
 	assembler *tcpassembly.Assembler
+	udp       *udpAssembler
+	sf        *streamFactory
 	wiCh      chan workItem
+	// how long a conversation may go without packets before it is closed
+	idleTimeout time.Duration
 }
 
-func newWorker(logger log.Logger, analysis *analysis.Pool, memcachePorts []int) worker {
-	sf := streamFactory{
-		logger:        logger,
-		analysis:      analysis,
-		memcachePorts: memcachePorts,
+func newWorker(logger log.Logger, pools map[int]*analysis.Pool, redis map[int]bool, subs *subscribers, idleTimeout time.Duration) worker {
+	sf := &streamFactory{
+		logger: logger,
+		pools:  pools,
+		redis:  redis,
+		subs:   subs,
+		errors: newFlowErrors(logger),
 
 		halfOpen: make(map[connectionKey]*model.Consumer),
 	}
 	w := worker{
 		logger:    logger,
-		assembler: tcpassembly.NewAssembler(tcpassembly.NewStreamPool(&sf)),
+		assembler: tcpassembly.NewAssembler(tcpassembly.NewStreamPool(sf)),
+		udp:       newUDPAssembler(sf),
+		sf:        sf,
 		wiCh:      make(chan workItem, 128),
+
+		idleTimeout: idleTimeout,
 	}
 	// Don't let the Assembly buffer much data in an attempt to compensate for out-of-order
 	// and missing packets.  Just report the data as lost downstream and continue.
@@ -47,41 +63,95 @@ func newWorker(logger log.Logger, analysis *analysis.Pool, memcachePorts []int)
 	return w
 }
 
-func (w worker) handlePackets(dps []*decode.DecodedPacket, doneCh chan<- struct{}) error {
+func (w worker) handlePackets(dps []*decode.DecodedPacket, done *sync.WaitGroup) error {
 	select {
-	case w.wiCh <- workItem{dps, doneCh}:
+	case w.wiCh <- workItem{dps: dps, done: done}:
 		return nil
 	default:
 		return errQueueFull
 	}
 }
 
+// close stops this worker once previously queued packets have been handled.
+// No more packets may be passed to the worker.
+func (w worker) close() {
+	close(w.wiCh)
+}
+
+// flushAll completes all conversations in progress, delivering any data
+// buffered for them.  flushAll blocks until previously queued packets have
+// been handled.
+func (w worker) flushAll(done *sync.WaitGroup) {
+	w.wiCh <- workItem{flush: true, done: done}
+}
+
+// assembleTruncated assembles a TCP packet that was cut short by the capture
+// snaplen.  The payload is padded to the length declared by the packet, so
+// that the assembler does not wait for the missing bytes as if a packet were
+// lost, and the padding is delivered to the stream as a gap.
+func (w worker) assembleTruncated(dp *decode.DecodedPacket, ts time.Time) {
+	payload := dp.TCP.Payload
+	// the capture buffer following the payload belongs to other packets
+	padded := make([]byte, len(payload)+dp.Truncated)
+	copy(padded, payload)
+	dp.TCP.Payload = padded
+	w.sf.padded, w.sf.padding = padded, dp.Truncated
+	w.assembler.AssembleWithTimestamp(dp.NetFlow, &dp.TCP, ts)
+	w.sf.padded, w.sf.padding = nil, 0
+	dp.TCP.Payload = payload
+}
+
 func (w worker) loop() {
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	summary := time.NewTicker(errorSummaryInterval)
+	defer summary.Stop()
 	var mostRecent time.Time
 	for {
 		select {
 		case <-ticker.C:
-			f, c := w.assembler.FlushOlderThan(mostRecent.Add(-time.Minute))
+			// conversations that received a packet since the cutoff are
+			// left alone, even if in the middle of a response
+			cutoff := mostRecent.Add(-w.idleTimeout)
+			w.sf.reaping = true
+			f, c := w.assembler.FlushOlderThan(cutoff)
+			w.sf.reaping = false
 			if f > 0 || c > 0 {
-				w.log("Flushed", f, "Closed", c)
+				log.Debug(w.logger, "Flushed", f, "Closed", c)
 			}
+			if u := w.udp.flushOlderThan(cutoff); u > 0 {
+				log.Debug(w.logger, "Flushed", u, "UDP requests")
+			}
+
+		case <-summary.C:
+			w.sf.errors.summarize()
 
 		case wi, ok := <-w.wiCh:
 			if !ok {
+				w.sf.errors.summarize()
 				return
 			}
 			for _, dp := range wi.dps {
-				mostRecent = dp.Info.Timestamp
-				w.assembler.AssembleWithTimestamp(dp.NetFlow, &dp.TCP, mostRecent)
+				// packets captured on several interfaces are interleaved
+				// by batch, so time only moves forward.  Events are
+				// timestamped by the assembler with the time passed here.
+				if dp.Info.Timestamp.After(mostRecent) {
+					mostRecent = dp.Info.Timestamp
+				}
+				w.sf.iface = dp.Info.InterfaceIndex
+				if dp.IsUDP() {
+					w.udp.assemble(dp)
+				} else if dp.Truncated > 0 {
+					w.assembleTruncated(dp, mostRecent)
+				} else {
+					w.assembler.AssembleWithTimestamp(dp.NetFlow, &dp.TCP, mostRecent)
+				}
+			}
+			if wi.flush {
+				w.assembler.FlushAll()
+				w.udp.flushAll()
 			}
-			wi.doneCh <- struct{}{}
+			wi.done.Done()
 		}
 	}
 }
-
-func (w worker) log(items ...interface{}) {
-	if w.logger != nil {
-		w.logger.Log(items...)
-	}
-}
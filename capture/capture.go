@@ -5,6 +5,7 @@ package capture
 import (
 	"bytes"
 	"errors"
+	"github.com/box/memsniff/log"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/pcap"
 	"io"
@@ -12,9 +13,12 @@ import (
 	"time"
 )
 
-const (
-	snapLen = 65535
-)
+// DefaultSnapLen is the number of bytes captured from each packet if no
+// other snap length is given to New, enough for any packet.
+const DefaultSnapLen = 65535
+
+// maxBatchBytes is the space for packet data in each batch.
+const maxBatchBytes = 8 * 1024 * 1024
 
 var (
 	// ErrNoSource is returned when neither a network interface nor a file
@@ -47,8 +51,40 @@ type PacketSource interface {
 	StatProvider
 }
 
+// Sources combines the statistics of several PacketSources, such as those
+// returned by NewFanout.
+type Sources []PacketSource
+
+// Stats implements StatProvider by summing the statistics of each source.
+func (s Sources) Stats() (*pcap.Stats, error) {
+	var sum pcap.Stats
+	for _, src := range s {
+		st, err := src.Stats()
+		if err != nil {
+			return nil, err
+		}
+		sum.PacketsReceived += st.PacketsReceived
+		sum.PacketsDropped += st.PacketsDropped
+		sum.PacketsIfDropped += st.PacketsIfDropped
+	}
+	return &sum, nil
+}
+
 type source struct {
 	*pcap.Handle
+	// most bytes captured from a single packet
+	snapLen int
+	// a packet that did not fit in the previous batch
+	held *heldPacket
+}
+
+type heldPacket struct {
+	pd PacketData
+	ok bool
+}
+
+func newSource(handle *pcap.Handle, snapLen int) source {
+	return source{handle, snapLen, &heldPacket{}}
 }
 
 // New creates a PacketSource bound to the specified network interface or pcap
@@ -57,26 +93,66 @@ type source struct {
 // bufferSize determines the amount of kernel memory (in MiB) to allocate for
 // temporary storage. A larger bufferSize can reduce dropped packets as
 // revealed by Stats, but use caution as kernel memory is a precious resource.
-func New(netInterface string, infile string, bufferSize int, noDelay bool, ports []int) (PacketSource, error) {
+//
+// Only packets to or from one of ports are captured, as selected by a BPF
+// filter in the kernel.  At most snapLen bytes of each packet are captured
+// from a network interface, or DefaultSnapLen if snapLen is not positive.
+// A smaller snapLen allows more packets to be collected in each batch, at
+// the cost of truncating large cache values.
+//
+// If tunnels is set, packets encapsulated by VXLAN or GRE are also captured,
+// whatever the ports of the packets inside them, so that they can be
+// decapsulated by the decoder.  Every packet on an overlay network is then
+// captured, not only those of memcached.
+//
+// Packets from a file are paced by their timestamps, replayed replaySpeed
+// times faster than they were captured: 1 replays in real time, and 0 as
+// fast as possible.
+func New(netInterface string, infile string, bufferSize int, snapLen int, replaySpeed float64, ports []int, tunnels bool) (PacketSource, error) {
 	var err error
-	handle, err := makeHandle(netInterface, infile, bufferSize)
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+	handle, err := makeHandle(netInterface, infile, bufferSize, snapLen)
 	if err != nil {
 		return nil, err
 	}
-	bpf, err := portFilter(ports)
+	bpf, err := portFilter(ports, tunnels)
 	if err != nil {
 		return nil, err
 	}
 	if err = handle.SetBPFFilter(bpf); err != nil {
 		return nil, err
 	}
-	if !noDelay && infile != "" {
-		return newReplayer(source{handle}, 1000, 8*1024*1024), nil
+	if replaySpeed > 0 && infile != "" {
+		return newReplayer(newSource(handle, snapLen), 1000, maxBatchBytes, snapLen, replaySpeed), nil
 	}
-	return source{handle}, nil
+	return newSource(handle, snapLen), nil
 }
 
-func makeHandle(netInterface string, infile string, bufferSize int) (*pcap.Handle, error) {
+// NewAllTCP creates a PacketSource capturing all TCP traffic on
+// netInterface, regardless of port, such as to discover the ports servers
+// listen on.  bufferSize and snapLen are as for New.  The PacketSource
+// has a Close method to release the capture once it is no longer needed.
+func NewAllTCP(netInterface string, bufferSize int, snapLen int) (PacketSource, error) {
+	if netInterface == "" {
+		return nil, ErrNoSource
+	}
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+	handle, err := makeHandle(netInterface, "", bufferSize, snapLen)
+	if err != nil {
+		return nil, err
+	}
+	if err = handle.SetBPFFilter("tcp"); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	return newSource(handle, snapLen), nil
+}
+
+func makeHandle(netInterface string, infile string, bufferSize int, snapLen int) (*pcap.Handle, error) {
 	var src *pcap.Handle
 	var err error
 
@@ -84,7 +160,7 @@ func makeHandle(netInterface string, infile string, bufferSize int) (*pcap.Handl
 		return nil, ErrAmbiguousSource
 	}
 	if netInterface != "" {
-		src, err = newLiveCapture(netInterface, bufferSize)
+		src, err = newLiveCapture(netInterface, bufferSize, snapLen)
 		if err != nil {
 			return nil, err
 		}
@@ -101,21 +177,30 @@ func makeHandle(netInterface string, infile string, bufferSize int) (*pcap.Handl
 	return src, nil
 }
 
-func portFilter(ports []int) (string, error) {
+// VXLANPort is the UDP port to which VXLAN encapsulated packets are sent.
+const VXLANPort = 4789
+
+func portFilter(ports []int, tunnels bool) (string, error) {
 	if len(ports) < 1 {
 		return "", errors.New("need at least one port")
 	}
 
 	var filterExpr bytes.Buffer
-	filterExpr.WriteString("tcp port " + strconv.Itoa(ports[0]))
+	// match both TCP and UDP
+	filterExpr.WriteString("port " + strconv.Itoa(ports[0]))
 	for _, port := range ports[1:] {
-		filterExpr.WriteString(" or tcp port " + strconv.Itoa(port))
+		filterExpr.WriteString(" or port " + strconv.Itoa(port))
+	}
+	if tunnels {
+		filterExpr.WriteString(" or udp dst port " + strconv.Itoa(VXLANPort))
+		filterExpr.WriteString(" or ip proto " + strconv.Itoa(ipProtocolGRE))
+		filterExpr.WriteString(" or ip6 proto " + strconv.Itoa(ipProtocolGRE))
 	}
 
 	return filterExpr.String(), nil
 }
 
-func newLiveCapture(netInterface string, bufferSize int) (*pcap.Handle, error) {
+func newLiveCapture(netInterface string, bufferSize int, snapLen int) (*pcap.Handle, error) {
 	inactive, err := pcap.NewInactiveHandle(netInterface)
 	defer inactive.CleanUp()
 	if err != nil {
@@ -137,14 +222,37 @@ func newLiveCapture(netInterface string, bufferSize int) (*pcap.Handle, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = setHostTimestamps(inactive)
+	if err != nil {
+		// adapter clocks still give usable timestamps on a single interface
+		log.Warn(log.ConsoleLogger{}, "cannot use host timestamps on", netInterface+":", err)
+	}
 
 	return inactive.Activate()
 }
 
+// setHostTimestamps stamps packets with the host clock, which is shared by
+// every interface, rather than a clock on the network adapter, if the
+// interface allows a choice.
+func setHostTimestamps(inactive *pcap.InactiveHandle) error {
+	for _, ts := range inactive.SupportedTimestamps() {
+		if ts.String() == "host" {
+			return inactive.SetTimestampSource(ts)
+		}
+	}
+	return nil
+}
+
 func (s source) CollectPackets(pb *PacketBuffer) error {
 	pb.Clear()
+	if s.held.ok {
+		s.held.ok = false
+		if err := pb.Append(s.held.pd); err != nil {
+			return err
+		}
+	}
 	l := pb.PacketCap()
-	for i := 0; i < l && pb.BytesRemaining() >= snapLen; i++ {
+	for i := pb.PacketLen(); i < l && pb.BytesRemaining() >= s.snapLen; i++ {
 		// use ZeroCopyReadPacketData to avoid allocation, even though
 		// we copy the data later
 		buf, ci, err := s.ZeroCopyReadPacketData()
@@ -158,6 +266,13 @@ func (s source) CollectPackets(pb *PacketBuffer) error {
 		// Append makes a copy of the data, which is required because
 		// buf is overwritten on the next call to ZeroCopyReadPacketData.
 		err = pb.Append(PacketData{ci, buf})
+		if err == ErrBytesFull && i > 0 {
+			// a file may have been captured with a larger snaplen than
+			// ours: deliver the packet first in the next batch instead
+			s.held.pd = PacketData{ci, append(s.held.pd.Data[:0], buf...)}
+			s.held.ok = true
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,489 @@
+//go:build linux && ebpf && (amd64 || arm64)
+// +build linux
+// +build ebpf
+// +build amd64 arm64
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// ebpfPollTimeout bounds how long CollectPackets waits for packets,
+	// like the read timeout of a live pcap capture.
+	ebpfPollTimeout = 10 * time.Millisecond
+	// ebpfBlockSize is the size of each TPACKET_V3 ring block, which
+	// must hold at least one packet of DefaultSnapLen.
+	ebpfBlockSize = 1024 * 1024
+	// ebpfFrameSize is the frame size the kernel requires of a ring,
+	// although TPACKET_V3 packs packets of any size into each block.
+	ebpfFrameSize = 4096
+)
+
+// Linux definitions missing from package syscall.
+const (
+	bpfProgLoad             = 5
+	bpfProgTypeSocketFilter = 1
+	soAttachBPF             = 50
+	packetVersion           = 10
+	tpacketV3               = 2
+	tpStatusKernel          = 0
+	tpStatusUser            = 1
+	pollIn                  = 0x1
+)
+
+// ebpfSource is a PacketSource reading from an AF_PACKET socket whose
+// packets are selected by an eBPF socket filter, through a TPACKET_V3 ring
+// shared with the kernel.
+type ebpfSource struct {
+	fd        int
+	ring      []byte
+	numBlocks int
+	snapLen   int
+
+	// the block being read, whether it has been handed to us by the
+	// kernel, and the number and offset of its packets not yet read
+	block int
+	held  bool
+	left  uint32
+	next  uint32
+
+	// totals of the kernel's statistics, which it resets each time they
+	// are read, accessed atomically
+	received int64
+	dropped  int64
+}
+
+// NewEBPF creates a PacketSource capturing from netInterface through an
+// AF_PACKET socket, with an eBPF socket filter that selects TCP packets to
+// or from one of ports and copies at most snapLen bytes of each in the
+// kernel.  Packets are delivered through a ring of memory shared with the
+// kernel, so reading them takes no system call per packet, and keep their
+// Ethernet, IP and TCP headers, so they are decoded and assigned to flows
+// exactly as packets captured by New.
+//
+// bufferSize is the MiB of kernel memory allocated to the ring, and
+// snapLen and ports are as for New.  Packets encapsulated by VXLAN or GRE
+// are not selected, so NewEBPF fails if tunnels is set.  Only Ethernet and
+// loopback interfaces are supported, and IPv6 extension headers are not
+// followed.
+//
+// NewEBPF is only supported on Linux, by builds with the ebpf tag, and
+// fails if the kernel does not allow the filter to be loaded, in which case
+// New may be used instead.
+func NewEBPF(netInterface string, bufferSize int, snapLen int, ports []int, tunnels bool) (PacketSource, error) {
+	if netInterface == "" {
+		return nil, ErrNoSource
+	}
+	if len(ports) < 1 {
+		return nil, errors.New("need at least one port")
+	}
+	if tunnels {
+		return nil, errors.New("eBPF capture cannot select tunneled packets")
+	}
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+	iface, err := net.InterfaceByName(netInterface)
+	if err != nil {
+		return nil, err
+	}
+	hwType, err := interfaceType(netInterface)
+	if err != nil {
+		return nil, err
+	}
+	if hwType != syscall.ARPHRD_ETHER && hwType != syscall.ARPHRD_LOOPBACK {
+		return nil, fmt.Errorf("eBPF capture requires an Ethernet interface, not type %d", hwType)
+	}
+	numBlocks := bufferSize * 1024 * 1024 / ebpfBlockSize
+	if numBlocks < 1 {
+		numBlocks = 1
+	}
+
+	// no packets are received until the socket is bound, by which time
+	// the filter is in place
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	es := &ebpfSource{fd: fd, numBlocks: numBlocks, snapLen: snapLen}
+	// each packet sent on the loopback interface is also seen arriving
+	prog := portFilterProgram(ports, snapLen, hwType == syscall.ARPHRD_LOOPBACK)
+	if err := es.setUp(iface.Index, prog); err != nil {
+		es.Close()
+		return nil, err
+	}
+	return es, nil
+}
+
+// interfaceType returns the ARPHRD hardware type of netInterface.
+func interfaceType(netInterface string) (int, error) {
+	b, err := ioutil.ReadFile("/sys/class/net/" + netInterface + "/type")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// setUp attaches prog to the socket, maps its ring, and binds it to the
+// interface with index ifIndex.
+func (es *ebpfSource) setUp(ifIndex int, prog []bpfInsn) error {
+	progFd, err := loadSocketFilter(prog)
+	if err != nil {
+		return fmt.Errorf("loading eBPF filter: %v", err)
+	}
+	// the socket keeps the program loaded
+	err = syscall.SetsockoptInt(es.fd, syscall.SOL_SOCKET, soAttachBPF, progFd)
+	syscall.Close(progFd)
+	if err != nil {
+		return fmt.Errorf("attaching eBPF filter: %v", err)
+	}
+
+	if err := syscall.SetsockoptInt(es.fd, syscall.SOL_PACKET, packetVersion, tpacketV3); err != nil {
+		return err
+	}
+	req := struct {
+		blockSize, blockNr, frameSize, frameNr uint32
+		retireBlkTov, sizeofPriv, featureReq   uint32
+	}{
+		blockSize:    ebpfBlockSize,
+		blockNr:      uint32(es.numBlocks),
+		frameSize:    ebpfFrameSize,
+		frameNr:      uint32(ebpfBlockSize / ebpfFrameSize * es.numBlocks),
+		retireBlkTov: uint32(ebpfPollTimeout / time.Millisecond),
+	}
+	if err := setsockopt(es.fd, syscall.SOL_PACKET, syscall.PACKET_RX_RING, unsafe.Pointer(&req), unsafe.Sizeof(req)); err != nil {
+		return err
+	}
+	es.ring, err = syscall.Mmap(es.fd, 0, ebpfBlockSize*es.numBlocks, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+
+	// capture traffic between other hosts, as libpcap does
+	mreq := struct {
+		ifIndex int32
+		typ     uint16
+		alen    uint16
+		address [8]byte
+	}{ifIndex: int32(ifIndex), typ: syscall.PACKET_MR_PROMISC}
+	if err := setsockopt(es.fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, unsafe.Pointer(&mreq), unsafe.Sizeof(mreq)); err != nil {
+		return err
+	}
+	return syscall.Bind(es.fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifIndex})
+}
+
+func (es *ebpfSource) CollectPackets(pb *PacketBuffer) error {
+	pb.Clear()
+	l := pb.PacketCap()
+	for pb.PacketLen() < l && pb.BytesRemaining() >= es.snapLen {
+		buf, ci, err := es.nextPacket()
+		if err == pcap.NextErrorTimeoutExpired && pb.PacketLen() > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Append makes a copy of the data, which is required because
+		// the ring block holding buf is returned to the kernel once
+		// all its packets have been read.
+		if err = pb.Append(PacketData{ci, buf}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (es *ebpfSource) DiscardPacket() error {
+	_, _, err := es.nextPacket()
+	return err
+}
+
+func (es *ebpfSource) Stats() (*pcap.Stats, error) {
+	var st struct {
+		packets, drops, freezeQCount uint32
+	}
+	size := uint32(unsafe.Sizeof(st))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(es.fd), syscall.SOL_PACKET, syscall.PACKET_STATISTICS,
+		uintptr(unsafe.Pointer(&st)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	received := atomic.AddInt64(&es.received, int64(st.packets))
+	dropped := atomic.AddInt64(&es.dropped, int64(st.drops))
+	return &pcap.Stats{PacketsReceived: int(received), PacketsDropped: int(dropped)}, nil
+}
+
+// Close releases the ring and closes the socket.
+func (es *ebpfSource) Close() {
+	if es.ring != nil {
+		_ = syscall.Munmap(es.ring)
+		es.ring = nil
+	}
+	_ = syscall.Close(es.fd)
+}
+
+// nextPacket returns the next packet in the ring, waiting up to
+// ebpfPollTimeout for one to arrive, or pcap.NextErrorTimeoutExpired if
+// none does.  The data is only valid until nextPacket is next called.
+func (es *ebpfSource) nextPacket() ([]byte, gopacket.CaptureInfo, error) {
+	for es.left == 0 {
+		if es.held {
+			// every packet of the block has been read
+			atomic.StoreUint32(es.blockStatus(), tpStatusKernel)
+			es.held = false
+			es.block = (es.block + 1) % es.numBlocks
+		}
+		if atomic.LoadUint32(es.blockStatus())&tpStatusUser == 0 {
+			if err := es.wait(); err != nil {
+				return nil, gopacket.CaptureInfo{}, err
+			}
+			continue
+		}
+		// struct tpacket_block_desc with struct tpacket_hdr_v1
+		desc := es.ring[es.block*ebpfBlockSize:]
+		es.held = true
+		es.left = hostUint32(desc[12:])
+		es.next = hostUint32(desc[16:])
+	}
+
+	// struct tpacket3_hdr
+	hdr := es.ring[es.block*ebpfBlockSize+int(es.next):]
+	snapLen := hostUint32(hdr[12:])
+	mac := uint32(*(*uint16)(unsafe.Pointer(&hdr[24])))
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(int64(hostUint32(hdr[4:])), int64(hostUint32(hdr[8:]))),
+		CaptureLength: int(snapLen),
+		Length:        int(hostUint32(hdr[16:])),
+	}
+	es.left--
+	es.next += hostUint32(hdr)
+	return hdr[mac : mac+snapLen], ci, nil
+}
+
+// blockStatus returns the status word of the block being read, which is
+// shared with the kernel.
+func (es *ebpfSource) blockStatus() *uint32 {
+	return (*uint32)(unsafe.Pointer(&es.ring[es.block*ebpfBlockSize+8]))
+}
+
+// wait waits up to ebpfPollTimeout for the kernel to fill a block,
+// returning pcap.NextErrorTimeoutExpired if it does not.
+func (es *ebpfSource) wait() error {
+	pfd := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{fd: int32(es.fd), events: pollIn}
+	ts := syscall.NsecToTimespec(int64(ebpfPollTimeout))
+	n, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&pfd)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0)
+	if errno != 0 && errno != syscall.EINTR {
+		return errno
+	}
+	if n == 0 || errno == syscall.EINTR {
+		return pcap.NextErrorTimeoutExpired
+	}
+	return nil
+}
+
+// hostUint32 reads an integer in host byte order written by the kernel.
+func hostUint32(b []byte) uint32 {
+	return *(*uint32)(unsafe.Pointer(&b[0]))
+}
+
+// htons converts a short from host to network byte order, on the little
+// endian architectures eBPF capture is built for.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func setsockopt(fd, level, name int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name), uintptr(val), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// bpfInsn is struct bpf_insn, a single eBPF instruction, with the
+// destination register in the low bits of regs on little endian
+// architectures.
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// eBPF instruction classes, sizes, modes and operations.
+const (
+	bpfLD    = 0x00
+	bpfLDX   = 0x01
+	bpfJMP   = 0x05
+	bpfALU64 = 0x07
+
+	bpfW = 0x00
+	bpfH = 0x08
+	bpfB = 0x10
+
+	bpfABS = 0x20
+	bpfIND = 0x40
+	bpfMEM = 0x60
+
+	bpfK = 0x00
+	bpfX = 0x08
+
+	bpfAND = 0x50
+	bpfLSH = 0x60
+	bpfMOV = 0xb0
+
+	bpfJA   = 0x00
+	bpfJEQ  = 0x10
+	bpfJNE  = 0x50
+	bpfEXIT = 0x90
+)
+
+// eBPF registers used by portFilterProgram: r0 holds the result of packet
+// loads and the return value, r1 the context on entry, and r6 the context
+// for packet loads, while r7 and r8 survive them.
+const (
+	bpfR0 = 0
+	bpfR1 = 1
+	bpfR6 = 6
+	bpfR7 = 7
+	bpfR8 = 8
+)
+
+// bpfAsm assembles an eBPF program whose jumps name the labels of their
+// targets.
+type bpfAsm struct {
+	insns   []bpfInsn
+	targets map[int]string
+	labels  map[string]int
+}
+
+func (a *bpfAsm) emit(code, dst, src uint8, imm int32) {
+	a.insns = append(a.insns, bpfInsn{code: code, regs: src<<4 | dst, imm: imm})
+}
+
+func (a *bpfAsm) jump(code, dst uint8, imm int32, label string) {
+	if a.targets == nil {
+		a.targets = make(map[int]string)
+	}
+	a.targets[len(a.insns)] = label
+	a.emit(bpfJMP|code|bpfK, dst, 0, imm)
+}
+
+func (a *bpfAsm) label(name string) {
+	if a.labels == nil {
+		a.labels = make(map[string]int)
+	}
+	a.labels[name] = len(a.insns)
+}
+
+// assemble returns the program with the offset of each jump resolved.
+func (a *bpfAsm) assemble() []bpfInsn {
+	for i, label := range a.targets {
+		a.insns[i].off = int16(a.labels[label] - i - 1)
+	}
+	return a.insns
+}
+
+// portFilterProgram returns an eBPF socket filter keeping the first
+// snapLen bytes of IPv4 and IPv6 TCP packets to or from one of ports, and
+// dropping all other packets, including IP fragments other than the first.
+// Outgoing packets are also dropped if skipOutgoing is set.
+func portFilterProgram(ports []int, snapLen int, skipOutgoing bool) []bpfInsn {
+	var a bpfAsm
+	// packet loads read the socket buffer in r6
+	a.emit(bpfALU64|bpfMOV|bpfX, bpfR6, bpfR1, 0)
+	if skipOutgoing {
+		// struct __sk_buff pkt_type
+		a.insns = append(a.insns, bpfInsn{code: bpfLDX | bpfMEM | bpfW, regs: bpfR6<<4 | bpfR0, off: 4})
+		a.jump(bpfJEQ, bpfR0, syscall.PACKET_OUTGOING, "drop")
+	}
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, 12)
+	a.jump(bpfJEQ, bpfR0, etherTypeIPv4, "ipv4")
+	a.jump(bpfJEQ, bpfR0, etherTypeIPv6, "ipv6")
+	a.jump(bpfJA, 0, 0, "drop")
+
+	a.label("ipv4")
+	a.emit(bpfLD|bpfABS|bpfB, 0, 0, etherHeaderLen+9)
+	a.jump(bpfJNE, bpfR0, ipProtocolTCP, "drop")
+	// fragment offset
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, etherHeaderLen+6)
+	a.emit(bpfALU64|bpfAND|bpfK, bpfR0, 0, 0x1fff)
+	a.jump(bpfJNE, bpfR0, 0, "drop")
+	// header length
+	a.emit(bpfLD|bpfABS|bpfB, 0, 0, etherHeaderLen)
+	a.emit(bpfALU64|bpfAND|bpfK, bpfR0, 0, 0x0f)
+	a.emit(bpfALU64|bpfLSH|bpfK, bpfR0, 0, 2)
+	a.emit(bpfALU64|bpfMOV|bpfX, bpfR7, bpfR0, 0)
+	a.emit(bpfLD|bpfIND|bpfH, 0, bpfR7, etherHeaderLen)
+	a.emit(bpfALU64|bpfMOV|bpfX, bpfR8, bpfR0, 0)
+	a.emit(bpfLD|bpfIND|bpfH, 0, bpfR7, etherHeaderLen+2)
+	a.jump(bpfJA, 0, 0, "ports")
+
+	// extension headers are not followed
+	a.label("ipv6")
+	a.emit(bpfLD|bpfABS|bpfB, 0, 0, etherHeaderLen+6)
+	a.jump(bpfJNE, bpfR0, ipProtocolTCP, "drop")
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, etherHeaderLen+40)
+	a.emit(bpfALU64|bpfMOV|bpfX, bpfR8, bpfR0, 0)
+	a.emit(bpfLD|bpfABS|bpfH, 0, 0, etherHeaderLen+42)
+
+	// the source port is in r8 and the destination port in r0
+	a.label("ports")
+	for _, port := range ports {
+		a.jump(bpfJEQ, bpfR8, int32(port), "keep")
+		a.jump(bpfJEQ, bpfR0, int32(port), "keep")
+	}
+	a.label("drop")
+	a.emit(bpfALU64|bpfMOV|bpfK, bpfR0, 0, 0)
+	a.emit(bpfJMP|bpfEXIT, 0, 0, 0)
+	a.label("keep")
+	a.emit(bpfALU64|bpfMOV|bpfK, bpfR0, 0, int32(snapLen))
+	a.emit(bpfJMP|bpfEXIT, 0, 0, 0)
+	return a.assemble()
+}
+
+// loadSocketFilter loads prog into the kernel as a socket filter and
+// returns its file descriptor.
+func loadSocketFilter(prog []bpfInsn) (int, error) {
+	license := []byte("Apache-2.0\x00")
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+	}{
+		progType: bpfProgTypeSocketFilter,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&prog[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	fd, _, errno := syscall.Syscall(sysBPF, bpfProgLoad, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(fd), nil
+}
@@ -0,0 +1,7 @@
+//go:build ebpf
+// +build ebpf
+
+package capture
+
+// sysBPF is the number of the bpf(2) system call.
+const sysBPF = 280
@@ -0,0 +1,117 @@
+//go:build linux && ebpf && (amd64 || arm64)
+// +build linux
+// +build ebpf
+// +build amd64 arm64
+
+package capture
+
+import (
+	"bytes"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// serve accepts connections on l, discarding what they send.
+func serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, _ = ioutil.ReadAll(conn)
+			conn.Close()
+		}()
+	}
+}
+
+// send connects to l and writes data.
+func send(t *testing.T, l net.Listener, data string) {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEBPFSelectsPorts(t *testing.T) {
+	memcached, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	defer memcached.Close()
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	defer other.Close()
+	go serve(memcached)
+	go serve(other)
+
+	port := memcached.Addr().(*net.TCPAddr).Port
+	src, err := NewEBPF("lo", 1, 0, []int{port}, false)
+	if err != nil {
+		t.Skip("cannot capture with eBPF:", err)
+	}
+	defer src.(*ebpfSource).Close()
+
+	send(t, other, "get other\r\n")
+	send(t, memcached, "get key\r\n")
+
+	pb := NewPacketBuffer(100, maxBatchBytes)
+	requests := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		err := src.CollectPackets(pb)
+		if err == pcap.NextErrorTimeoutExpired {
+			if requests > 0 {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < pb.PacketLen(); i++ {
+			pd := pb.Packet(i)
+			pkt := gopacket.NewPacket(pd.Data, layers.LayerTypeEthernet, gopacket.Default)
+			tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+			if !ok {
+				t.Fatal("expected TCP packet, got", pkt)
+			}
+			if int(tcp.SrcPort) != port && int(tcp.DstPort) != port {
+				t.Error("expected packets to or from port", port, "got", tcp.SrcPort, "to", tcp.DstPort)
+			}
+			if pd.Info.CaptureLength != len(pd.Data) || pd.Info.Timestamp.IsZero() {
+				t.Error("unexpected capture info", pd.Info)
+			}
+			if bytes.Equal(tcp.Payload, []byte("get key\r\n")) {
+				requests++
+			}
+		}
+	}
+	// packets sent on the loopback interface are not seen twice
+	if requests != 1 {
+		t.Error("expected request to be captured once, got", requests)
+	}
+	if st, err := src.Stats(); err != nil || st.PacketsReceived == 0 {
+		t.Error("expected packets to be counted, got", st, err)
+	}
+}
+
+func TestEBPFRequiresPorts(t *testing.T) {
+	if _, err := NewEBPF("lo", 1, 0, nil, false); err == nil {
+		t.Error("expected error without ports")
+	}
+	if _, err := NewEBPF("lo", 1, 0, []int{11211}, true); err == nil {
+		t.Error("expected error capturing tunnels")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux || !ebpf || !(amd64 || arm64)
+// +build !linux !ebpf !amd64,!arm64
+
+package capture
+
+import (
+	"errors"
+)
+
+// NewEBPF is only supported on Linux, by builds with the ebpf tag, where it
+// creates a PacketSource whose packets are selected by an eBPF socket
+// filter in the kernel.  Otherwise it fails, and New may be used instead.
+func NewEBPF(netInterface string, bufferSize int, snapLen int, ports []int, tunnels bool) (PacketSource, error) {
+	return nil, errors.New("eBPF capture requires Linux and a build with the ebpf tag")
+}
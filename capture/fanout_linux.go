@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"errors"
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/pcap"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// fanoutPollTimeout bounds how long CollectPackets waits for packets,
+	// like the read timeout of a live pcap capture.
+	fanoutPollTimeout = 10 * time.Millisecond
+	// fanoutBlockSize is the size of each TPACKET_V3 ring block, which
+	// must hold at least one packet of DefaultSnapLen.
+	fanoutBlockSize = 1024 * 1024
+)
+
+// fanoutSource is a PacketSource reading from one AF_PACKET socket in a
+// fanout group.
+type fanoutSource struct {
+	tp      *afpacket.TPacket
+	match   portMatcher
+	snapLen int
+	// packets to or from a memcached port, accessed atomically
+	received int64
+}
+
+// NewFanout creates readers PacketSources that share the capture of
+// netInterface, each reading from its own AF_PACKET socket.  The sockets
+// join a kernel fanout group that hashes packets by flow, so that every
+// packet of a conversation is read by the same PacketSource and arrives in
+// order, while conversations are spread across readers.  Each PacketSource
+// should be read from its own goroutine.
+//
+// bufferSize is the MiB of kernel memory allocated to the ring of each
+// reader, and snapLen, ports and tunnels are as for New.  The kernel hashes
+// encapsulated packets by the flow of the tunnel rather than that of the
+// packet inside it.  AF_PACKET sockets receive
+// all traffic on the interface, so packets are selected by port as they are
+// read rather than in the kernel.  Stats reports no kernel drops, which
+// these sockets do not expose.
+//
+// NewFanout is only supported on Linux.
+func NewFanout(netInterface string, readers int, bufferSize int, snapLen int, ports []int, tunnels bool) ([]PacketSource, error) {
+	if netInterface == "" {
+		return nil, ErrNoSource
+	}
+	if len(ports) < 1 {
+		return nil, errors.New("need at least one port")
+	}
+	if readers < 1 {
+		return nil, errors.New("need at least one reader")
+	}
+	if snapLen <= 0 {
+		snapLen = DefaultSnapLen
+	}
+	numBlocks := bufferSize * 1024 * 1024 / fanoutBlockSize
+	if numBlocks < 1 {
+		numBlocks = 1
+	}
+	match := newPortMatcher(ports, tunnels)
+	// fanout groups are shared by every socket joining with the same id,
+	// even across processes
+	id := uint16(os.Getpid())
+
+	srcs := make([]PacketSource, 0, readers)
+	for i := 0; i < readers; i++ {
+		tp, err := afpacket.NewTPacket(
+			afpacket.OptInterface(netInterface),
+			afpacket.TPacketVersion3,
+			afpacket.OptBlockSize(fanoutBlockSize),
+			afpacket.OptNumBlocks(numBlocks),
+			afpacket.OptPollTimeout(fanoutPollTimeout),
+		)
+		if err == nil {
+			// reassemble IP fragments so that they hash alike
+			err = tp.SetFanout(afpacket.FanoutHashWithDefrag, id)
+			if err != nil {
+				tp.Close()
+			}
+		}
+		if err != nil {
+			for _, src := range srcs {
+				src.(*fanoutSource).tp.Close()
+			}
+			return nil, err
+		}
+		srcs = append(srcs, &fanoutSource{tp: tp, match: match, snapLen: snapLen})
+	}
+	return srcs, nil
+}
+
+func (fs *fanoutSource) CollectPackets(pb *PacketBuffer) error {
+	pb.Clear()
+	l := pb.PacketCap()
+	for pb.PacketLen() < l && pb.BytesRemaining() >= fs.snapLen {
+		buf, ci, err := fs.tp.ZeroCopyReadPacketData()
+		if err == afpacket.ErrTimeout {
+			if pb.PacketLen() > 0 {
+				return nil
+			}
+			return pcap.NextErrorTimeoutExpired
+		}
+		if err != nil {
+			return err
+		}
+		if !fs.match.match(buf) {
+			continue
+		}
+		atomic.AddInt64(&fs.received, 1)
+		if len(buf) > fs.snapLen {
+			buf = buf[:fs.snapLen]
+			ci.CaptureLength = fs.snapLen
+		}
+		// Append makes a copy of the data, which is required because
+		// buf is overwritten on the next call to ZeroCopyReadPacketData.
+		if err = pb.Append(PacketData{ci, buf}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *fanoutSource) DiscardPacket() error {
+	for {
+		buf, _, err := fs.tp.ZeroCopyReadPacketData()
+		if err == afpacket.ErrTimeout {
+			return pcap.NextErrorTimeoutExpired
+		}
+		if err != nil {
+			return err
+		}
+		// only packets that would have been collected count as dropped
+		if fs.match.match(buf) {
+			atomic.AddInt64(&fs.received, 1)
+			return nil
+		}
+	}
+}
+
+func (fs *fanoutSource) Stats() (*pcap.Stats, error) {
+	return &pcap.Stats{PacketsReceived: int(atomic.LoadInt64(&fs.received))}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package capture
+
+import (
+	"errors"
+)
+
+// NewFanout is only supported on Linux, where it creates readers
+// PacketSources sharing the capture of netInterface through an AF_PACKET
+// fanout group.
+func NewFanout(netInterface string, readers int, bufferSize int, snapLen int, ports []int, tunnels bool) ([]PacketSource, error) {
+	return nil, errors.New("fanout capture requires Linux")
+}
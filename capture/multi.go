@@ -0,0 +1,42 @@
+package capture
+
+// interfaceSource is a PacketSource that labels each packet it collects with
+// the index of the network interface it was captured from.
+type interfaceSource struct {
+	PacketSource
+	index int
+}
+
+// NewMulti creates a PacketSource for each of netInterfaces, as for New, so
+// that traffic arriving on several interfaces can be analyzed together.
+// Each PacketSource should be read from its own goroutine.
+//
+// The CaptureInfo.InterfaceIndex of every packet is set to the index of its
+// interface in netInterfaces, so that packets can still be attributed to
+// their interface once merged.  Live captures are stamped by the host clock,
+// which every interface shares, so timestamps are comparable across them.
+func NewMulti(netInterfaces []string, bufferSize int, snapLen int, ports []int, tunnels bool) ([]PacketSource, error) {
+	if len(netInterfaces) == 0 {
+		return nil, ErrNoSource
+	}
+	srcs := make([]PacketSource, 0, len(netInterfaces))
+	for i, netInterface := range netInterfaces {
+		src, err := New(netInterface, "", bufferSize, snapLen, 0, ports, tunnels)
+		if err != nil {
+			for _, src := range srcs {
+				src.(interfaceSource).PacketSource.(source).Close()
+			}
+			return nil, err
+		}
+		srcs = append(srcs, interfaceSource{src, i})
+	}
+	return srcs, nil
+}
+
+func (is interfaceSource) CollectPackets(pb *PacketBuffer) error {
+	err := is.PacketSource.CollectPackets(pb)
+	for i := 0; i < pb.PacketLen(); i++ {
+		pb.cis[i].InterfaceIndex = is.index
+	}
+	return err
+}
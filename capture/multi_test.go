@@ -0,0 +1,32 @@
+package capture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInterfaceIndexLabeled(t *testing.T) {
+	ts := &testSource{}
+	ts.AddPacket(time.Now(), []byte{0})
+	ts.AddPacket(time.Now(), []byte{1})
+
+	uut := interfaceSource{ts, 2}
+	buf := NewPacketBuffer(10, 1024)
+	if err := uut.CollectPackets(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.PacketLen() != 2 {
+		t.Fatal("expected 2 packets, got", buf.PacketLen())
+	}
+	for i := 0; i < buf.PacketLen(); i++ {
+		if idx := buf.Packet(i).Info.InterfaceIndex; idx != 2 {
+			t.Error("expected interface index 2, got", idx)
+		}
+	}
+}
+
+func TestNewMultiRequiresInterface(t *testing.T) {
+	if _, err := NewMulti(nil, 8, 0, []int{11211}, false); err != ErrNoSource {
+		t.Error("expected ErrNoSource, got", err)
+	}
+}
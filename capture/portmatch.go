@@ -0,0 +1,93 @@
+package capture
+
+import (
+	"encoding/binary"
+)
+
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	ipProtocolTCP  = 6
+	ipProtocolUDP  = 17
+	ipProtocolGRE  = 47
+	etherHeaderLen = 14
+)
+
+// portMatcher selects TCP and UDP packets to or from a set of ports by
+// inspecting their headers directly, for captures that cannot install a BPF
+// filter in the kernel.
+type portMatcher struct {
+	ports map[uint16]struct{}
+	// whether VXLAN and GRE encapsulated packets also match
+	tunnels bool
+}
+
+func newPortMatcher(ports []int, tunnels bool) portMatcher {
+	pm := portMatcher{ports: make(map[uint16]struct{}, len(ports)), tunnels: tunnels}
+	for _, port := range ports {
+		pm.ports[uint16(port)] = struct{}{}
+	}
+	return pm
+}
+
+// match returns whether the Ethernet frame data holds a TCP or UDP packet to
+// or from one of the ports, or an encapsulated packet if tunnels match.  IP
+// fragments other than the first, which have no transport header, never
+// match.
+func (pm portMatcher) match(data []byte) bool {
+	if len(data) < etherHeaderLen {
+		return false
+	}
+	etherType := binary.BigEndian.Uint16(data[12:])
+	off := etherHeaderLen
+	for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+		if len(data) < off+4 {
+			return false
+		}
+		etherType = binary.BigEndian.Uint16(data[off+2:])
+		off += 4
+	}
+
+	var proto byte
+	switch etherType {
+	case etherTypeIPv4:
+		if len(data) < off+20 {
+			return false
+		}
+		if binary.BigEndian.Uint16(data[off+6:])&0x1fff != 0 {
+			// fragment offset
+			return false
+		}
+		proto = data[off+9]
+		off += int(data[off]&0x0f) * 4
+	case etherTypeIPv6:
+		if len(data) < off+40 {
+			return false
+		}
+		// extension headers are not followed
+		proto = data[off+6]
+		off += 40
+	default:
+		return false
+	}
+
+	if proto == ipProtocolGRE {
+		return pm.tunnels
+	}
+	if proto != ipProtocolTCP && proto != ipProtocolUDP {
+		return false
+	}
+	if len(data) < off+4 {
+		return false
+	}
+	srcPort := binary.BigEndian.Uint16(data[off:])
+	dstPort := binary.BigEndian.Uint16(data[off+2:])
+	if pm.tunnels && proto == ipProtocolUDP && dstPort == VXLANPort {
+		return true
+	}
+	_, src := pm.ports[srcPort]
+	_, dst := pm.ports[dstPort]
+	return src || dst
+}
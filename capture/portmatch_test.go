@@ -0,0 +1,85 @@
+package capture
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+func frame(t *testing.T, vlan bool, ip gopacket.NetworkLayer, transport gopacket.SerializableLayer) []byte {
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2},
+	}
+	ls := []gopacket.SerializableLayer{eth}
+	nextType := layers.EthernetTypeIPv4
+	if _, ok := ip.(*layers.IPv6); ok {
+		nextType = layers.EthernetTypeIPv6
+	}
+	if vlan {
+		eth.EthernetType = layers.EthernetTypeDot1Q
+		ls = append(ls, &layers.Dot1Q{VLANIdentifier: 7, Type: nextType})
+	} else {
+		eth.EthernetType = nextType
+	}
+	ls = append(ls, ip.(gopacket.SerializableLayer), transport, gopacket.Payload("get a\r\n"))
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ipv4(proto layers.IPProtocol) *layers.IPv4 {
+	return &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: proto,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+}
+
+func TestPortMatcher(t *testing.T) {
+	pm := newPortMatcher([]int{11211, 11212}, false)
+	ip6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolUDP,
+		HopLimit:   64,
+		SrcIP:      net.ParseIP("fd00::1"),
+		DstIP:      net.ParseIP("fd00::2"),
+	}
+	fragment := ipv4(layers.IPProtocolTCP)
+	fragment.FragOffset = 100
+
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{"tcp request", frame(t, false, ipv4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 40000, DstPort: 11211}), true},
+		{"tcp response", frame(t, false, ipv4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 11212, DstPort: 40000}), true},
+		{"other port", frame(t, false, ipv4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 40000, DstPort: 80}), false},
+		{"vlan", frame(t, true, ipv4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 40000, DstPort: 11211}), true},
+		{"udp over ipv6", frame(t, false, ip6, &layers.UDP{SrcPort: 40000, DstPort: 11211}), true},
+		{"later fragment", frame(t, false, fragment, &layers.TCP{SrcPort: 40000, DstPort: 11211}), false},
+		{"truncated", frame(t, false, ipv4(layers.IPProtocolTCP), &layers.TCP{SrcPort: 40000, DstPort: 11211})[:30], false},
+	} {
+		if pm.match(tc.data) != tc.expected {
+			t.Error(tc.name, "expected match", tc.expected)
+		}
+	}
+}
+
+func TestPortMatcherTunnels(t *testing.T) {
+	vxlan := frame(t, false, ipv4(layers.IPProtocolUDP), &layers.UDP{SrcPort: 40000, DstPort: VXLANPort})
+	gre := frame(t, false, ipv4(layers.IPProtocolGRE), &layers.GRE{Protocol: layers.EthernetTypeIPv4})
+	if pm := newPortMatcher([]int{11211}, false); pm.match(vxlan) || pm.match(gre) {
+		t.Error("expected tunnels not to match unless enabled")
+	}
+	if pm := newPortMatcher([]int{11211}, true); !pm.match(vxlan) || !pm.match(gre) {
+		t.Error("expected tunnels to match when enabled")
+	}
+}
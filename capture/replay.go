@@ -8,8 +8,9 @@ import (
 )
 
 // replayer throttles results from a PacketSource according to the Timestamp
-// accompanying each packet.  It is most useful for recreating the input rate
-// of a previously captured pcap file.
+// accompanying each packet, scaled by a speed factor.  It is most useful for
+// recreating the input rate of a previously captured pcap file, or a
+// multiple of it.
 type replayer struct {
 	// A Logger instance for debugging.  No logging is done if nil.
 	Logger log.Logger
@@ -26,26 +27,44 @@ type replayer struct {
 	received int
 	dropped  int
 	src      PacketSource
+	// most bytes in a single packet from src
+	snapLen int
+	// capture time replayed per unit of wall time
+	speed float64
 }
 
 // replayerTimeout emulates the default behavior of pcap.ReadPacketData,
 // waiting up to 10 ms to assemble a batch of packets.
 const replayerTimeout = -pcap.BlockForever
 
-func newReplayer(src PacketSource, batchSize int, maxBytes int) *replayer {
+// newReplayer returns a replayer returning packets from src speed times
+// faster than they were captured.
+func newReplayer(src PacketSource, batchSize int, maxBytes int, snapLen int, speed float64) *replayer {
 	return &replayer{
-		buf: NewPacketBuffer(batchSize, maxBytes),
-		src: src,
+		buf:     NewPacketBuffer(batchSize, maxBytes),
+		src:     src,
+		snapLen: snapLen,
+		speed:   speed,
 	}
 }
 
+// elapsed returns the capture time replayed since the first packet.
+func (r *replayer) elapsed() time.Duration {
+	return r.captureTime(time.Since(r.start))
+}
+
+// captureTime returns the capture time replayed in wall time d.
+func (r *replayer) captureTime(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * r.speed)
+}
+
 func (r *replayer) CollectPackets(pb *PacketBuffer) error {
 	pb.Clear()
 	if r.start.IsZero() {
 		r.start = time.Now()
 	}
 
-	elapsed := time.Since(r.start)
+	elapsed := r.elapsed()
 	r.dropExpired(elapsed)
 	for r.cursor >= r.buf.PacketLen() {
 		err := r.fill()
@@ -56,8 +75,8 @@ func (r *replayer) CollectPackets(pb *PacketBuffer) error {
 	}
 
 	l := r.buf.PacketLen()
-	writeUntil := r.first.Add(elapsed + replayerTimeout)
-	for ; r.cursor < l && pb.BytesRemaining() >= snapLen; r.cursor++ {
+	writeUntil := r.first.Add(elapsed + r.captureTime(replayerTimeout))
+	for ; r.cursor < l && pb.BytesRemaining() >= r.snapLen; r.cursor++ {
 		p := r.buf.Packet(r.cursor)
 		r.received++
 		if p.Info.Timestamp.After(writeUntil) {
@@ -76,7 +95,7 @@ func (r *replayer) CollectPackets(pb *PacketBuffer) error {
 }
 
 func (r *replayer) dropExpired(elapsed time.Duration) {
-	dropUntil := r.first.Add(elapsed).Add(replayerTimeout / -2)
+	dropUntil := r.first.Add(elapsed).Add(r.captureTime(replayerTimeout / -2))
 	for ; r.cursor < r.buf.PacketLen(); r.cursor++ {
 		p := r.buf.Packet(r.cursor)
 		if p.Info.Timestamp.After(dropUntil) {
@@ -96,8 +115,8 @@ func (r *replayer) DiscardPacket() error {
 
 	p := r.buf.Packet(r.cursor)
 	offset := p.Info.Timestamp.Sub(r.first)
-	elapsed := time.Since(r.start)
-	if offset > elapsed+replayerTimeout {
+	elapsed := r.elapsed()
+	if offset > elapsed+r.captureTime(replayerTimeout) {
 		time.Sleep(replayerTimeout)
 		return pcap.NextErrorTimeoutExpired
 	}
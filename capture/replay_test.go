@@ -53,7 +53,7 @@ func TestPacing(t *testing.T) {
 	ts.AddPacket(start, []byte{0})
 	ts.AddPacket(start.Add(delay), []byte{1})
 
-	uut := newReplayer(ts, 1000, 8*1024*1024)
+	uut := newReplayer(ts, 1000, 8*1024*1024, DefaultSnapLen, 1)
 	uut.Logger = log.ConsoleLogger{}
 	buf := NewPacketBuffer(1000, 8*1024*1024)
 
@@ -85,7 +85,7 @@ func TestDrop(t *testing.T) {
 	ts.AddPacket(start, []byte{0})
 	ts.AddPacket(start.Add(delay), []byte{1})
 
-	uut := newReplayer(ts, 1000, 8*1024*1024)
+	uut := newReplayer(ts, 1000, 8*1024*1024, DefaultSnapLen, 1)
 	buf := NewPacketBuffer(1000, 8*1024*1024)
 
 	err := uut.CollectPackets(buf)
@@ -106,3 +106,33 @@ func TestDrop(t *testing.T) {
 		t.Error("expected a dropped packet")
 	}
 }
+
+func TestReplaySpeed(t *testing.T) {
+	start := time.Time{}.Add(time.Hour)
+	delay := 20 * replayerTimeout
+	ts := &testSource{}
+	ts.AddPacket(start, []byte{0})
+	ts.AddPacket(start.Add(delay), []byte{1})
+
+	uut := newReplayer(ts, 1000, 8*1024*1024, DefaultSnapLen, 5)
+	buf := NewPacketBuffer(1000, 8*1024*1024)
+
+	if err := uut.CollectPackets(buf); buf.PacketLen() != 1 {
+		t.Error("expected first packet immediately:", err)
+	}
+
+	// at 5x, half the delay has been replayed after a tenth of it
+	uut.start = time.Now().Add(-delay / 10)
+	if err := uut.CollectPackets(buf); buf.PacketLen() != 0 || err != pcap.NextErrorTimeoutExpired {
+		t.Error("got", buf.PacketLen(), "packet too early:", err)
+	}
+
+	// and all of it after a fifth
+	uut.start = time.Now().Add(-delay / 5)
+	if err := uut.CollectPackets(buf); buf.PacketLen() != 1 {
+		t.Error("expected second packet after a fifth of the delay:", err)
+	}
+	if s, _ := uut.Stats(); s.PacketsDropped != 0 {
+		t.Error("expected no dropped packets, got", s.PacketsDropped)
+	}
+}
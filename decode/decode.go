@@ -7,11 +7,19 @@ import (
 	"github.com/google/gopacket/layers"
 )
 
-const (
-	batchSize = 1000
-)
+// DefaultBatchSize is the number of packets decoded together if no other
+// batch size is given to NewPool.
+const DefaultBatchSize = 1000
+
+// maxBatchBytes is the space for packet data in each batch.
+const maxBatchBytes = 8 * 1024 * 1024
 
-// DecodedPacket holds the broken down structure of a decoded TCP packet.
+// DecodedPacket holds the broken down structure of a decoded TCP or UDP packet.
+//
+// Packets encapsulated by VXLAN or GRE, as on an overlay network, are
+// decoded through to the inner packet, whose layers replace those of the
+// outer packet, so that flows are hashed and reassembled by the addresses
+// and ports of the inner packet.
 type DecodedPacket struct {
 	Info gopacket.CaptureInfo
 
@@ -23,10 +31,21 @@ type DecodedPacket struct {
 	dot1q     layers.Dot1Q
 	ipv4      layers.IPv4
 	ipv6      layers.IPv6
+	gre       layers.GRE
+	vxlan     vxlan
 	TCP       layers.TCP
+	UDP       layers.UDP
 	Payload   gopacket.Payload
-	FlowHash  uint64
-	NetFlow   gopacket.Flow
+	// FlowHash is the same for both directions of a TCP or UDP flow, so
+	// that a request and its response can be assigned to the same worker.
+	// It covers the full source and destination addresses of both IPv4 and
+	// IPv6 flows.
+	FlowHash uint64
+	NetFlow  gopacket.Flow
+	// Truncated is the number of bytes at the end of the TCP payload that
+	// were not captured, such as beyond the snaplen, though the packet
+	// declared them.
+	Truncated int
 }
 
 func newDecodedPacket() *DecodedPacket {
@@ -37,7 +56,10 @@ func newDecodedPacket() *DecodedPacket {
 	dp.ethParser.AddDecodingLayer(&dp.dot1q)
 	dp.ethParser.AddDecodingLayer(&dp.ipv4)
 	dp.ethParser.AddDecodingLayer(&dp.ipv6)
+	dp.ethParser.AddDecodingLayer(&dp.gre)
+	dp.ethParser.AddDecodingLayer(&dp.vxlan)
 	dp.ethParser.AddDecodingLayer(&dp.TCP)
+	dp.ethParser.AddDecodingLayer(&dp.UDP)
 	dp.ethParser.AddDecodingLayer(&dp.Payload)
 
 	dp.loParser = gopacket.NewDecodingLayerParser(dp.lo.LayerType())
@@ -45,7 +67,10 @@ func newDecodedPacket() *DecodedPacket {
 	dp.loParser.AddDecodingLayer(&dp.dot1q)
 	dp.loParser.AddDecodingLayer(&dp.ipv4)
 	dp.loParser.AddDecodingLayer(&dp.ipv6)
+	dp.loParser.AddDecodingLayer(&dp.gre)
+	dp.loParser.AddDecodingLayer(&dp.vxlan)
 	dp.loParser.AddDecodingLayer(&dp.TCP)
+	dp.loParser.AddDecodingLayer(&dp.UDP)
 	dp.loParser.AddDecodingLayer(&dp.Payload)
 
 	return dp
@@ -53,12 +78,25 @@ func newDecodedPacket() *DecodedPacket {
 
 // IsTCP returns true if dp was successfully decoded as a TCP packet.
 func (dp *DecodedPacket) IsTCP() bool {
-	for _, lt := range dp.decoded {
-		if lt == layers.LayerTypeTCP {
-			return true
+	return dp.transport() == layers.LayerTypeTCP
+}
+
+// IsUDP returns true if dp was successfully decoded as a UDP packet.
+func (dp *DecodedPacket) IsUDP() bool {
+	return dp.transport() == layers.LayerTypeUDP
+}
+
+// transport returns the innermost transport layer decoded, which for an
+// encapsulated packet is that of the inner packet rather than the UDP
+// carrying it, or LayerTypeZero if there is none.
+func (dp *DecodedPacket) transport() gopacket.LayerType {
+	for i := len(dp.decoded) - 1; i >= 0; i-- {
+		switch lt := dp.decoded[i]; lt {
+		case layers.LayerTypeTCP, layers.LayerTypeUDP:
+			return lt
 		}
 	}
-	return false
+	return gopacket.LayerTypeZero
 }
 
 // decode parses a single packet from raw byte data and updates the decoded
@@ -71,7 +109,7 @@ func (dp *DecodedPacket) decode(d *decoder, ci gopacket.CaptureInfo, data []byte
 	dp.Payload = dp.Payload[:0]
 	parser := dp.ethParser
 	err := parser.DecodeLayers(data, &dp.decoded)
-	if !dp.IsTCP() {
+	if !dp.IsTCP() && !dp.IsUDP() {
 		parser = dp.loParser
 		err = parser.DecodeLayers(data, &dp.decoded)
 	}
@@ -90,10 +128,20 @@ func (dp *DecodedPacket) decode(d *decoder, ci gopacket.CaptureInfo, data []byte
 		case layers.LayerTypeIPv6:
 			dp.NetFlow = dp.ipv6.NetworkFlow()
 		case layers.LayerTypeTCP:
+			// FastHash matches for a flow and its reverse, so the
+			// combination does too
 			dp.FlowHash = hashCombine(dp.NetFlow.FastHash(), dp.TCP.TransportFlow().FastHash())
+		case layers.LayerTypeUDP:
+			dp.FlowHash = hashCombine(dp.NetFlow.FastHash(), dp.UDP.TransportFlow().FastHash())
 		default:
 		}
 	}
+	dp.Truncated = 0
+	if ci.CaptureLength < ci.Length && dp.IsTCP() {
+		// only the end of a packet is cut off, which is its payload
+		// if the headers were captured
+		dp.Truncated = ci.Length - ci.CaptureLength
+	}
 }
 
 // Handler is a user-provided function for processing a single packet.
@@ -106,7 +154,7 @@ type decoder struct {
 	decoded       []*DecodedPacket
 }
 
-func newDecoder(logger log.Logger, handler Handler) *decoder {
+func newDecoder(logger log.Logger, handler Handler, batchSize int) *decoder {
 	d := &decoder{
 		logger:  logger,
 		handler: handler,
@@ -0,0 +1,99 @@
+package decode
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+// serialize returns the bytes of ls, computing lengths and checksums.
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+// innerLayers returns the layers of a TCP packet from 10.1.0.1 to a
+// memcached on 10.1.0.2 carrying payload.
+func innerLayers(t *testing.T, payload string) []gopacket.SerializableLayer {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{10, 1, 0, 1},
+		DstIP:    net.IP{10, 1, 0, 2},
+	}
+	tcp := &layers.TCP{SrcPort: 54321, DstPort: 11211, ACK: true}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	return []gopacket.SerializableLayer{ip, tcp, gopacket.Payload(payload)}
+}
+
+// outerLayers returns the Ethernet and IPv4 layers of a packet between two
+// tunnel endpoints carrying proto.
+func outerLayers(proto layers.IPProtocol) []gopacket.SerializableLayer {
+	return []gopacket.SerializableLayer{
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		&layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: proto,
+			SrcIP:    net.IP{192, 168, 0, 1},
+			DstIP:    net.IP{192, 168, 0, 2},
+		},
+	}
+}
+
+func decodeBytes(t *testing.T, data []byte) *DecodedPacket {
+	d := newDecoder(testLogger{t}, nil, 1)
+	dp := newDecodedPacket()
+	dp.decode(d, gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}, data)
+	return dp
+}
+
+func TestDecapsulate(t *testing.T) {
+	inner := innerLayers(t, "get a\r\n")
+	plain := decodeBytes(t, serialize(t, append(outerLayers(layers.IPProtocolTCP)[:1], inner...)...))
+
+	frame := serialize(t, append([]gopacket.SerializableLayer{&layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 1, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 1, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}}, inner...)...)
+	vxlanHeader := []byte{0x08, 0, 0, 0, 0, 0, 42, 0}
+	outer := outerLayers(layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: 49152, DstPort: 4789}
+	if err := udp.SetNetworkLayerForChecksum(outer[1].(*layers.IPv4)); err != nil {
+		t.Fatal(err)
+	}
+	vx := serialize(t, append(outer, udp, gopacket.Payload(append(vxlanHeader, frame...)))...)
+
+	gre := serialize(t, append(outerLayers(layers.IPProtocolGRE),
+		append([]gopacket.SerializableLayer{&layers.GRE{Protocol: layers.EthernetTypeIPv4}}, inner...)...)...)
+
+	for name, data := range map[string][]byte{"vxlan": vx, "gre": gre} {
+		dp := decodeBytes(t, data)
+		if !dp.IsTCP() || dp.IsUDP() {
+			t.Error(name, "expected inner TCP packet, got layers", dp.decoded)
+			continue
+		}
+		if dp.NetFlow != plain.NetFlow || dp.TCP.DstPort != 11211 {
+			t.Error(name, "expected inner flow", plain.NetFlow, "got", dp.NetFlow, dp.TCP.TransportFlow())
+		}
+		if dp.FlowHash != plain.FlowHash {
+			t.Error(name, "expected flow hash of inner packet")
+		}
+		if string(dp.TCP.Payload) != "get a\r\n" {
+			t.Error(name, "expected inner payload, got", dp.TCP.Payload)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package decode
+
+import (
+	"errors"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// vxlanHeaderLen is the length of the VXLAN header preceding the
+// encapsulated Ethernet frame.
+const vxlanHeaderLen = 8
+
+var errShortVXLAN = errors.New("VXLAN header truncated")
+
+// vxlan decodes the VXLAN header of a UDP datagram sent to the VXLAN port,
+// so that the Ethernet frame it carries is decoded in turn.  The vendored
+// layers.VXLAN cannot be used with a DecodingLayerParser.
+type vxlan struct {
+	layers.BaseLayer
+}
+
+func (v *vxlan) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < vxlanHeaderLen {
+		df.SetTruncated()
+		return errShortVXLAN
+	}
+	v.Contents = data[:vxlanHeaderLen]
+	v.Payload = data[vxlanHeaderLen:]
+	return nil
+}
+
+func (v *vxlan) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeVXLAN
+}
+
+func (v *vxlan) NextLayerType() gopacket.LayerType {
+	return layers.LayerTypeEthernet
+}
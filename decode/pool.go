@@ -32,7 +32,14 @@ type Pool struct {
 // NewPool creates a new Pool of workers.  As packets are captured and decoded,
 // handler is invoked.  handler is invoked from multiple worker gorountines
 // concurrently and thus must be threadsafe.
-func NewPool(logger log.Logger, numWorkers int, src capture.PacketSource, handler Handler) *Pool {
+//
+// Each worker collects up to batchSize packets from src at a time, or
+// DefaultBatchSize if batchSize is not positive.  Larger batches reduce
+// per-packet overhead, but hold up to 8 MiB of packet data regardless.
+func NewPool(logger log.Logger, numWorkers int, batchSize int, src capture.PacketSource, handler Handler) *Pool {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
 	p := &Pool{
 		logger:     logger,
 		numWorkers: numWorkers,
@@ -41,8 +48,8 @@ func NewPool(logger log.Logger, numWorkers int, src capture.PacketSource, handle
 	}
 
 	for i := 0; i < numWorkers; i++ {
-		decoder := newDecoder(logger, handler)
-		p.startWorker(p.readyQ, decoder.decodeBatch, 1000, 8*1024*1024, i)
+		decoder := newDecoder(logger, handler, batchSize)
+		p.startWorker(p.readyQ, decoder.decodeBatch, batchSize, maxBatchBytes, i)
 	}
 
 	return p
@@ -111,3 +118,25 @@ func (p *Pool) sendToWorker(w *worker) error {
 	w.work()
 	return nil
 }
+
+// ReadAll decodes every packet from src in capture order on the calling
+// goroutine, invoking handler for each batch, until src is exhausted.
+// Unlike a Pool, no packets are dropped when handler is slow, which makes
+// ReadAll suitable for offline analysis of capture files.
+func ReadAll(logger log.Logger, src capture.PacketSource, handler Handler) error {
+	d := newDecoder(logger, handler, DefaultBatchSize)
+	pb := capture.NewPacketBuffer(DefaultBatchSize, maxBatchBytes)
+	for {
+		err := src.CollectPackets(pb)
+		if pb.PacketLen() > 0 {
+			d.decodeBatch(pb)
+		}
+		switch err {
+		case nil, pcap.NextErrorTimeoutExpired:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
@@ -2,6 +2,7 @@ package decode
 
 import (
 	"github.com/box/memsniff/capture"
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/pcap"
 	"io"
 	"runtime"
@@ -41,7 +42,7 @@ func TestSeparateGoroutine(t *testing.T) {
 		}
 	}
 
-	p := NewPool(testLogger{t}, 8, nil, handler)
+	p := NewPool(testLogger{t}, 8, 0, nil, handler)
 	w := <-p.readyQ
 	_ = w.buf().Append(capture.PacketData{})
 	w.work()
@@ -68,7 +69,7 @@ func (es emptySource) Stats() (*pcap.Stats, error) {
 func TestGoroutineCount(t *testing.T) {
 	workers := 4
 	before := runtime.NumGoroutine()
-	p := NewPool(testLogger{t}, workers, &emptySource{}, nil)
+	p := NewPool(testLogger{t}, workers, 0, &emptySource{}, nil)
 	after := runtime.NumGoroutine()
 	if after != before+workers {
 		t.Error("NewPool started", after-before, "new goroutines instead of", workers)
@@ -82,3 +83,62 @@ func TestGoroutineCount(t *testing.T) {
 		t.Error("Pool left behind", afterRun-before, "goroutines")
 	}
 }
+
+// batchSource is a capture.PacketSource that returns each of its batches in
+// turn, then EOF.
+type batchSource struct {
+	emptySource
+	batches [][]capture.PacketData
+}
+
+func (bs *batchSource) CollectPackets(pb *capture.PacketBuffer) error {
+	pb.Clear()
+	if len(bs.batches) == 0 {
+		return io.EOF
+	}
+	for _, pd := range bs.batches[0] {
+		if err := pb.Append(pd); err != nil {
+			return err
+		}
+	}
+	bs.batches = bs.batches[1:]
+	return nil
+}
+
+// TestReadAll checks that ReadAll handles every packet in order.
+func TestReadAll(t *testing.T) {
+	src := &batchSource{batches: [][]capture.PacketData{
+		{{Info: gopacket.CaptureInfo{Length: 1}}, {Info: gopacket.CaptureInfo{Length: 2}}},
+		{{Info: gopacket.CaptureInfo{Length: 3}}},
+	}}
+	var seen []int
+	handler := func(dps []*DecodedPacket) {
+		for _, dp := range dps {
+			seen = append(seen, dp.Info.Length)
+		}
+	}
+
+	if err := ReadAll(testLogger{t}, src, handler); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 || seen[0] != 1 || seen[1] != 2 || seen[2] != 3 {
+		t.Error("expected packets 1, 2, 3 in order, got", seen)
+	}
+}
+
+// TestBatchSize checks that workers collect batches of the configured size.
+func TestBatchSize(t *testing.T) {
+	p := NewPool(testLogger{t}, 1, 2, &emptySource{}, nil)
+	w := <-p.readyQ
+	if c := w.buf().PacketCap(); c != 2 {
+		t.Error("expected batches of 2 packets, got", c)
+	}
+	w.close()
+
+	p = NewPool(testLogger{t}, 1, 0, &emptySource{}, nil)
+	w = <-p.readyQ
+	if c := w.buf().PacketCap(); c != DefaultBatchSize {
+		t.Error("expected default batch size, got", c)
+	}
+	w.close()
+}
@@ -0,0 +1,156 @@
+package decode
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Conversations that are not carried over TCP or UDP, such as those with a
+// memcached listening on a Unix domain socket, cannot be captured with
+// pcap.  Their data can instead be observed in the processes at either end,
+// for example by tracing the read and write system calls made on the socket
+// with strace, or with an eBPF program attached to those calls or to the
+// client library.  Such a source reports each read or write as a Segment,
+// which a Synthesizer presents as a DecodedPacket of a TCP conversation so
+// that it is reassembled and decoded like captured traffic.
+
+// Segment is data sent in one direction of a conversation that was observed
+// other than by capturing packets.
+type Segment struct {
+	// when the data was sent
+	Timestamp time.Time
+	// identifies the conversation, such as by the process ID and file
+	// descriptor of the client end of the socket
+	Conn uint64
+	// whether Data was sent by the server rather than the client
+	FromServer bool
+	// data sent, which must not be modified until the DecodedPackets
+	// returned for it have been handled
+	Data []byte
+	// whether the conversation ended after Data was sent
+	Close bool
+}
+
+// synthClientPort is the port of the client end of every synthetic
+// conversation, which are distinguished by the client address instead.
+const synthClientPort = 49152
+
+// Flags and length of the synthetic TCP header.
+const (
+	tcpFIN       = 0x01
+	tcpSYN       = 0x02
+	tcpACK       = 0x10
+	tcpHeaderLen = 20
+)
+
+// synthClientPrefix is the unique local IPv6 prefix of the addresses given
+// to the client end of synthetic conversations.
+var synthClientPrefix = []byte{0xfd, 0x6d, 0x65, 0x6d, 0x73, 0x6e, 0x69, 0x66}
+
+// Synthesizer presents Segments as the DecodedPackets of TCP conversations
+// with a server listening on ::1 at a given port, so that the port selects
+// the protocol used to decode them as for captured traffic.  The client end
+// of each conversation is given an address in fd6d:656d:736e:6966::/64
+// derived from Segment.Conn, which also determines the pseudo flow hash of
+// its packets.
+//
+// A Synthesizer is not threadsafe.
+type Synthesizer struct {
+	port  layers.TCPPort
+	conns map[uint64]*synthConn
+	dps   []*DecodedPacket
+}
+
+// synthConn holds the next TCP sequence number in each direction of a
+// synthetic conversation, indexed by whether it is from the server.
+type synthConn struct {
+	started [2]bool
+	nextSeq [2]uint32
+}
+
+// NewSynthesizer returns a Synthesizer for conversations with a server
+// speaking the protocol expected on port.
+func NewSynthesizer(port int) *Synthesizer {
+	return &Synthesizer{
+		port:  layers.TCPPort(port),
+		conns: make(map[uint64]*synthConn),
+	}
+}
+
+// Packets returns a DecodedPacket for each of segs in order, suitable for
+// a Handler.  The packets returned are reused by the next call to Packets.
+func (s *Synthesizer) Packets(segs []Segment) []*DecodedPacket {
+	for len(s.dps) < len(segs) {
+		s.dps = append(s.dps, newDecodedPacket())
+	}
+	dps := s.dps[:len(segs)]
+	for i, seg := range segs {
+		s.packet(dps[i], seg)
+	}
+	return dps
+}
+
+// packet fills in dp as a TCP packet carrying seg.
+func (s *Synthesizer) packet(dp *DecodedPacket, seg Segment) {
+	c, ok := s.conns[seg.Conn]
+	if !ok {
+		c = &synthConn{}
+		s.conns[seg.Conn] = c
+	}
+	if seg.Close {
+		delete(s.conns, seg.Conn)
+	}
+
+	client := make(net.IP, net.IPv6len)
+	copy(client, synthClientPrefix)
+	binary.BigEndian.PutUint64(client[8:], seg.Conn)
+	dp.ipv6 = layers.IPv6{Version: 6, NextHeader: layers.IPProtocolTCP, HopLimit: 64}
+	srcPort, dstPort := uint16(synthClientPort), uint16(s.port)
+	dp.ipv6.SrcIP, dp.ipv6.DstIP = client, net.IPv6loopback
+	dir := 0
+	if seg.FromServer {
+		srcPort, dstPort = dstPort, srcPort
+		dp.ipv6.SrcIP, dp.ipv6.DstIP = dp.ipv6.DstIP, dp.ipv6.SrcIP
+		dir = 1
+	}
+
+	// The first segment in each direction opens it with a SYN, which takes
+	// a sequence number following its data.
+	flags := byte(tcpACK)
+	if seg.Close {
+		flags |= tcpFIN
+	}
+	seq := c.nextSeq[dir]
+	c.nextSeq[dir] += uint32(len(seg.Data))
+	if !c.started[dir] {
+		c.started[dir] = true
+		flags |= tcpSYN
+		c.nextSeq[dir]++
+	}
+
+	// layers.TCP only records its flow when decoded, so decode a header
+	hdr := make([]byte, tcpHeaderLen)
+	binary.BigEndian.PutUint16(hdr[0:], srcPort)
+	binary.BigEndian.PutUint16(hdr[2:], dstPort)
+	binary.BigEndian.PutUint32(hdr[4:], seq)
+	hdr[12] = tcpHeaderLen / 4 << 4
+	hdr[13] = flags
+	// a well formed header cannot fail to decode
+	_ = dp.TCP.DecodeFromBytes(hdr, gopacket.NilDecodeFeedback)
+	dp.TCP.Payload = seg.Data
+	dp.Payload = seg.Data
+	dp.Truncated = 0
+
+	dp.Info = gopacket.CaptureInfo{
+		Timestamp:     seg.Timestamp,
+		CaptureLength: len(seg.Data),
+		Length:        len(seg.Data),
+	}
+	dp.decoded = append(dp.decoded[:0], layers.LayerTypeIPv6, layers.LayerTypeTCP)
+	dp.NetFlow = dp.ipv6.NetworkFlow()
+	dp.FlowHash = hashCombine(dp.NetFlow.FastHash(), dp.TCP.TransportFlow().FastHash())
+}
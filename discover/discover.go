@@ -0,0 +1,162 @@
+// Package discover finds the ports memcached servers listen on from the
+// traffic they carry, for when the ports are not known in advance.
+package discover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/box/memsniff/capture"
+	"github.com/box/memsniff/decode"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/mcbinary"
+)
+
+// DefaultWindow is a reasonable length of time to watch traffic for.
+const DefaultWindow = 10 * time.Second
+
+// minRequests is the number of requests a port must receive, and answer at
+// least once, to be reported as a server port.  Requiring several keeps a
+// stray packet that happens to resemble the protocol from being mistaken
+// for a server.
+const minRequests = 3
+
+// binary protocol header length, and the longest key memcached permits
+const (
+	binaryHeaderLen = 24
+	maxKeyLen       = 250
+)
+
+// text protocol commands and replies, each followed by a space or the end
+// of the line
+var (
+	textCommands = [][]byte{
+		[]byte("get"), []byte("gets"), []byte("gat"), []byte("gats"),
+		[]byte("set"), []byte("add"), []byte("replace"), []byte("append"),
+		[]byte("prepend"), []byte("cas"), []byte("delete"), []byte("incr"),
+		[]byte("decr"), []byte("touch"), []byte("stats"), []byte("version"),
+	}
+	textReplies = [][]byte{
+		[]byte("VALUE"), []byte("END"), []byte("STORED"), []byte("NOT_STORED"),
+		[]byte("EXISTS"), []byte("NOT_FOUND"), []byte("DELETED"), []byte("TOUCHED"),
+		[]byte("STAT"), []byte("VERSION"), []byte("ERROR"), []byte("CLIENT_ERROR"),
+		[]byte("SERVER_ERROR"),
+	}
+)
+
+// Ports watches the TCP traffic from src for window and returns the ports,
+// in ascending order, of servers seen answering requests in the memcached
+// text or binary protocol.  src should capture TCP traffic on all ports, as
+// from capture.NewAllTCP.
+func Ports(logger log.Logger, src capture.PacketSource, window time.Duration) ([]int, error) {
+	d := newDetector()
+	ws := &windowSource{PacketSource: src, deadline: time.Now().Add(window)}
+	err := decode.ReadAll(logger, ws, func(dps []*decode.DecodedPacket) {
+		for _, dp := range dps {
+			if dp.IsTCP() {
+				d.observe(int(dp.TCP.SrcPort), int(dp.TCP.DstPort), dp.TCP.Payload)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.ports(), nil
+}
+
+// windowSource is a PacketSource that ends once its deadline has passed.
+type windowSource struct {
+	capture.PacketSource
+	deadline time.Time
+}
+
+func (ws *windowSource) CollectPackets(pb *capture.PacketBuffer) error {
+	if time.Now().After(ws.deadline) {
+		pb.Clear()
+		return io.EOF
+	}
+	return ws.PacketSource.CollectPackets(pb)
+}
+
+// detector counts payloads resembling memcached requests and responses by
+// the port of the presumed server.
+//
+// detector is not threadsafe.
+type detector struct {
+	// requests sent to each port
+	requests map[int]int
+	// responses sent from each port
+	responses map[int]int
+}
+
+func newDetector() *detector {
+	return &detector{
+		requests:  make(map[int]int),
+		responses: make(map[int]int),
+	}
+}
+
+// observe classifies the payload of a TCP segment from srcPort to dstPort.
+func (d *detector) observe(srcPort, dstPort int, payload []byte) {
+	switch {
+	case isRequest(payload):
+		d.requests[dstPort]++
+	case isResponse(payload):
+		d.responses[srcPort]++
+	}
+}
+
+// ports returns the ports that have received enough requests and sent at
+// least one response, in ascending order.
+func (d *detector) ports() []int {
+	var ports []int
+	for port, n := range d.requests {
+		if n >= minRequests && d.responses[port] > 0 {
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// isRequest returns whether payload begins like a memcached request.
+func isRequest(payload []byte) bool {
+	return isBinary(payload, mcbinary.MagicRequest) || isText(payload, textCommands)
+}
+
+// isResponse returns whether payload begins like a memcached response.
+func isResponse(payload []byte) bool {
+	return isBinary(payload, mcbinary.MagicResponse) || isText(payload, textReplies)
+}
+
+// isBinary returns whether payload begins with a plausible binary protocol
+// header with the given magic byte.
+func isBinary(payload []byte, magic byte) bool {
+	if len(payload) < binaryHeaderLen || payload[0] != magic {
+		return false
+	}
+	keyLen := int(binary.BigEndian.Uint16(payload[2:4]))
+	extrasLen := int(payload[4])
+	bodyLen := int(binary.BigEndian.Uint32(payload[8:12]))
+	// the data type is always raw bytes
+	return payload[5] == 0 && keyLen <= maxKeyLen && keyLen+extrasLen <= bodyLen
+}
+
+// isText returns whether payload begins with a line starting with one of
+// words, followed by a space or the end of the line.
+func isText(payload []byte, words [][]byte) bool {
+	eol := bytes.Index(payload, []byte("\r\n"))
+	if eol < 0 {
+		return false
+	}
+	line := payload[:eol]
+	for _, w := range words {
+		if bytes.HasPrefix(line, w) && (len(line) == len(w) || line[len(w)] == ' ') {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package discover
+
+import (
+	"reflect"
+	"testing"
+)
+
+func binaryHeader(magic byte, keyLen, extrasLen, bodyLen int) []byte {
+	h := make([]byte, binaryHeaderLen)
+	h[0] = magic
+	h[3] = byte(keyLen)
+	h[4] = byte(extrasLen)
+	h[11] = byte(bodyLen)
+	return h
+}
+
+func TestGrammar(t *testing.T) {
+	requests := []string{"get foo\r\n", "gets a b\r\n", "set k 0 0 1\r\nx\r\n", "version\r\n", string(binaryHeader(0x80, 3, 0, 3))}
+	for _, p := range requests {
+		if !isRequest([]byte(p)) {
+			t.Errorf("expected %q to be a request", p)
+		}
+	}
+	responses := []string{"END\r\n", "VALUE k 0 1\r\nx\r\nEND\r\n", "STORED\r\n", string(binaryHeader(0x81, 0, 4, 9))}
+	for _, p := range responses {
+		if !isResponse([]byte(p)) {
+			t.Errorf("expected %q to be a response", p)
+		}
+	}
+	others := []string{"GET / HTTP/1.1\r\n", "getaway\r\n", "get foo", "ENDED\r\n", "", string(binaryHeader(0x80, 3, 0, 2))}
+	for _, p := range others {
+		if isRequest([]byte(p)) || isResponse([]byte(p)) {
+			t.Errorf("expected %q not to match", p)
+		}
+	}
+}
+
+func TestDetectorPorts(t *testing.T) {
+	d := newDetector()
+	for i := 0; i < minRequests; i++ {
+		// a server on a nonstandard port
+		d.observe(40000+i, 22122, []byte("get k\r\n"))
+		d.observe(22122, 40000+i, []byte("END\r\n"))
+		// requests that are never answered
+		d.observe(40000+i, 8080, []byte("get k\r\n"))
+	}
+	// too few requests to be sure
+	d.observe(40100, 11211, []byte("get k\r\n"))
+	d.observe(11211, 40100, []byte("END\r\n"))
+
+	if got := d.ports(); !reflect.DeepEqual(got, []int{22122}) {
+		t.Error("expected port 22122 detected, got", got)
+	}
+}
@@ -0,0 +1,84 @@
+package hotlist
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Evicter is implemented by HotLists that discard items to bound their
+// memory consumption.
+type Evicter interface {
+	// Evicted returns the number of items discarded since the HotList was
+	// created.  Evicted is threadsafe.
+	Evicted() int64
+}
+
+// boundedPerfectHotlist counts items exactly until it holds more than
+// maxItems, then evicts the lightest.
+type boundedPerfectHotlist struct {
+	counts   map[Item]int
+	maxItems int
+	// accessed atomically
+	evicted int64
+}
+
+// NewBoundedPerfect returns an implementation of HotList that tracks all
+// items added exactly, like NewPerfect, until more than maxItems are
+// tracked.  The lightest tenth of the items by total weight are then
+// evicted (least frequently used, weighted), so Top is only approximate
+// once the bound has been reached.  The item just added is never evicted,
+// so that a new item can displace an old one however small maxItems is.
+// The returned HotList implements Evicter to report how often eviction has
+// happened.
+func NewBoundedPerfect(maxItems int) HotList {
+	if maxItems < 1 {
+		panic("bounded perfect hotlist size must be positive")
+	}
+	return &boundedPerfectHotlist{
+		counts:   make(map[Item]int),
+		maxItems: maxItems,
+	}
+}
+
+func (hl *boundedPerfectHotlist) AddWeighted(x Item) {
+	hl.AddNWeighted(x, 1)
+}
+
+func (hl *boundedPerfectHotlist) AddNWeighted(x Item, n int) {
+	hl.counts[x] += n
+	if len(hl.counts) > hl.maxItems {
+		hl.evict(x)
+	}
+}
+
+// evict discards the lightest items other than newest, leaving nine tenths
+// of maxItems so that the cost of sorting is spread over many additions.
+func (hl *boundedPerfectHotlist) evict(newest Item) {
+	ordered := make(descByTotalWeight, 0, len(hl.counts))
+	for item, count := range hl.counts {
+		if item != newest {
+			ordered = append(ordered, itemCount{item: item, count: count, totalWeight: item.Weight() * count})
+		}
+	}
+	sort.Sort(ordered)
+	// newest holds one of the places kept
+	keep := hl.maxItems - hl.maxItems/10 - 1
+	for _, ic := range ordered[keep:] {
+		delete(hl.counts, ic.item)
+	}
+	atomic.AddInt64(&hl.evicted, int64(len(ordered)-keep))
+}
+
+func (hl *boundedPerfectHotlist) Evicted() int64 {
+	return atomic.LoadInt64(&hl.evicted)
+}
+
+func (hl *boundedPerfectHotlist) Reset() {
+	for k := range hl.counts {
+		delete(hl.counts, k)
+	}
+}
+
+func (hl *boundedPerfectHotlist) Top(k int) []Entry {
+	return orderedTop(k, hl.counts)
+}
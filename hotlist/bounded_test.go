@@ -0,0 +1,43 @@
+package hotlist
+
+import (
+	"testing"
+)
+
+func TestBoundedPerfectEvictsLightest(t *testing.T) {
+	hl := NewBoundedPerfect(10)
+	for i := 0; i < 10; i++ {
+		hl.AddNWeighted(testItem{string(rune('a' + i)), 1}, 100+i)
+	}
+	if n := hl.(Evicter).Evicted(); n != 0 {
+		t.Error("expected no evictions at the bound, got", n)
+	}
+
+	hl.AddWeighted(testItem{"z", 1})
+	if n := hl.(Evicter).Evicted(); n != 2 {
+		t.Error("expected 2 evictions, got", n)
+	}
+	top := hl.Top(20)
+	if len(top) != 9 {
+		t.Fatal("expected 9 entries after eviction, got", len(top))
+	}
+	// z is lightest, but was just added
+	names := make(map[string]bool)
+	for _, e := range top {
+		names[e.Item().(testItem).name] = true
+	}
+	if !names["z"] || names["a"] || names["b"] {
+		t.Error("expected a and b evicted and z kept, got", top)
+	}
+}
+
+func TestBoundedPerfectKeepsNewest(t *testing.T) {
+	hl := NewBoundedPerfect(2)
+	hl.AddNWeighted(testItem{"a", 1}, 5)
+	hl.AddNWeighted(testItem{"b", 1}, 3)
+	hl.AddWeighted(testItem{"c", 1})
+	top := hl.Top(3)
+	if len(top) != 2 || top[0].Item() != (testItem{"a", 1}) || top[1].Item() != (testItem{"c", 1}) {
+		t.Error("expected b evicted in favor of c, got", top)
+	}
+}
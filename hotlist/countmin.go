@@ -0,0 +1,179 @@
+package hotlist
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// countMinHotlist estimates item counts with a count-min sketch and retains
+// the items with the highest estimated total weight as candidates for Top.
+type countMinHotlist struct {
+	width int
+	depth int
+	// cells[i] is row i of the sketch
+	cells [][]int
+	// min-heap of the heaviest items seen, by estimated total weight
+	candidates candidateHeap
+	// position of each candidate item in candidates
+	index map[Item]int
+	// total count of all items added since the last Reset
+	total int
+}
+
+// NewCountMin returns an implementation of HotList that uses a fixed amount of
+// memory regardless of the number of distinct items added, at the cost of
+// accuracy.
+//
+// Counts are tracked in a count-min sketch of depth rows of width counters.
+// Estimated counts are never lower than the true count, but may be higher
+// when items collide in the sketch: with probability 1-(1/e)^depth an
+// estimate exceeds the true count by no more than e/width times the total
+// number of items added.  Since items occurring less often than that are
+// indistinguishable from noise, up to width of the heaviest items are
+// retained as candidates for Top.  The Error of each Entry reports this
+// probabilistic bound.
+func NewCountMin(width, depth int) HotList {
+	if width < 1 || depth < 1 {
+		panic("count-min sketch dimensions must be positive")
+	}
+	cells := make([][]int, depth)
+	for i := range cells {
+		cells[i] = make([]int, width)
+	}
+	hl := &countMinHotlist{
+		width: width,
+		depth: depth,
+		cells: cells,
+		index: make(map[Item]int),
+	}
+	hl.candidates.index = hl.index
+	return hl
+}
+
+func (hl *countMinHotlist) AddWeighted(x Item) {
+	hl.AddNWeighted(x, 1)
+}
+
+func (hl *countMinHotlist) AddNWeighted(x Item, n int) {
+	hl.total += n
+	h1, h2 := itemHashes(x)
+	est := -1
+	for i, row := range hl.cells {
+		// Kirsch-Mitzenmacher double hashing to derive a hash for each row
+		col := (h1 + uint32(i)*h2) % uint32(hl.width)
+		row[col] += n
+		if est < 0 || row[col] < est {
+			est = row[col]
+		}
+	}
+	hl.updateCandidate(x, est)
+}
+
+func (hl *countMinHotlist) updateCandidate(x Item, count int) {
+	ic := itemCount{item: x, count: count, totalWeight: count * x.Weight()}
+	if i, ok := hl.index[x]; ok {
+		hl.candidates.items[i] = ic
+		heap.Fix(&hl.candidates, i)
+		return
+	}
+	if len(hl.candidates.items) < hl.width {
+		heap.Push(&hl.candidates, ic)
+		return
+	}
+	if lightest := hl.candidates.items[0]; lightest.totalWeight < ic.totalWeight {
+		delete(hl.index, lightest.item)
+		hl.candidates.items[0] = ic
+		hl.index[x] = 0
+		heap.Fix(&hl.candidates, 0)
+	}
+}
+
+func (hl *countMinHotlist) Reset() {
+	for _, row := range hl.cells {
+		for i := range row {
+			row[i] = 0
+		}
+	}
+	for k := range hl.index {
+		delete(hl.index, k)
+	}
+	hl.candidates.items = hl.candidates.items[:0]
+	hl.total = 0
+}
+
+// Top returns the k items with the highest estimated total weight.  Counts
+// and weights are estimates that may exceed the true values.
+func (hl *countMinHotlist) Top(k int) []Entry {
+	ordered := make(descByTotalWeight, len(hl.candidates.items))
+	copy(ordered, hl.candidates.items)
+	sortTop(ordered, k)
+	if len(ordered) < k {
+		k = len(ordered)
+	}
+
+	bound := int(math.Ceil(math.E * float64(hl.total) / float64(hl.width)))
+	entries := make([]Entry, k)
+	for i, ic := range ordered[:k] {
+		ic.err = bound
+		if ic.err > ic.count {
+			ic.err = ic.count
+		}
+		ic.errWeight = ic.err * ic.item.Weight()
+		entries[i] = ic
+	}
+	return entries
+}
+
+// HashedItem is an Item that hashes itself, so that a count-min sketch need
+// not format it.
+type HashedItem interface {
+	Item
+	// Hash returns a 64-bit hash of the item, equal for equal items.
+	Hash() uint64
+}
+
+// itemHashes returns two independent 32-bit hashes of x.
+func itemHashes(x Item) (uint32, uint32) {
+	var sum uint64
+	if hx, ok := x.(HashedItem); ok {
+		sum = hx.Hash()
+	} else {
+		h := fnv.New64a()
+		// writing to a Hash can never fail
+		_, _ = fmt.Fprintf(h, "%#v", x)
+		sum = h.Sum64()
+	}
+	// an odd second hash visits every column when width is a power of two
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// candidateHeap is a min-heap of itemCounts by total weight that keeps an
+// index of item positions up to date.
+type candidateHeap struct {
+	items []itemCount
+	index map[Item]int
+}
+
+func (h *candidateHeap) Len() int           { return len(h.items) }
+func (h *candidateHeap) Less(i, j int) bool { return h.items[i].totalWeight < h.items[j].totalWeight }
+
+func (h *candidateHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].item] = i
+	h.index[h.items[j].item] = j
+}
+
+func (h *candidateHeap) Push(x interface{}) {
+	ic := x.(itemCount)
+	h.index[ic.item] = len(h.items)
+	h.items = append(h.items, ic)
+}
+
+func (h *candidateHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, last.item)
+	return last
+}
@@ -0,0 +1,77 @@
+package hotlist
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestCountMinFindsHeavyHitters(t *testing.T) {
+	hl := NewCountMin(64, 4)
+	for i := 0; i < 1000; i++ {
+		hl.AddWeighted(testItem{"noise" + strconv.Itoa(i), 1})
+	}
+	hl.AddNWeighted(testItem{"heavy", 1}, 500)
+	hl.AddNWeighted(testItem{"heavier", 2}, 500)
+
+	top := hl.Top(2)
+	if len(top) != 2 {
+		t.Fatal("expected 2 entries, got", len(top))
+	}
+	if top[0].Item() != (testItem{"heavier", 2}) || top[1].Item() != (testItem{"heavy", 1}) {
+		t.Error("expected heavier then heavy, got", top[0].Item(), top[1].Item())
+	}
+}
+
+func TestCountMinNeverUnderestimates(t *testing.T) {
+	hl := NewCountMin(16, 2)
+	for i := 0; i < 100; i++ {
+		hl.AddNWeighted(testItem{strconv.Itoa(i), 1}, i+1)
+	}
+	for _, e := range hl.Top(100) {
+		actual, _ := strconv.Atoi(e.Item().(testItem).name)
+		if e.Count() < actual+1 {
+			t.Error("estimate", e.Count(), "lower than actual count", actual+1)
+		}
+	}
+}
+
+func TestCountMinReset(t *testing.T) {
+	hl := NewCountMin(16, 2)
+	hl.AddWeighted(testItem{"a", 1})
+	hl.Reset()
+	if len(hl.Top(10)) != 0 {
+		t.Error("expected no entries after Reset")
+	}
+	hl.AddWeighted(testItem{"b", 1})
+	top := hl.Top(10)
+	if len(top) != 1 || top[0].Count() != 1 {
+		t.Error("expected a single entry with count 1, got", top)
+	}
+}
+
+// hashedItem hashes itself to a fixed value.
+type hashedItem struct {
+	name string
+}
+
+func (hi hashedItem) Weight() int {
+	return 1
+}
+
+func (hi hashedItem) Hash() uint64 {
+	return 0x0000000500000003
+}
+
+func TestCountMinUsesItemHash(t *testing.T) {
+	if h1, h2 := itemHashes(hashedItem{"a"}); h1 != 3 || h2 != 5 {
+		t.Error("expected hashes from Hash, got", h1, h2)
+	}
+	// items hashing alike share every counter
+	hl := NewCountMin(64, 4)
+	hl.AddNWeighted(hashedItem{"a"}, 3)
+	hl.AddWeighted(hashedItem{"b"})
+	top := hl.Top(2)
+	if len(top) != 2 || top[0].Count() != 4 {
+		t.Error("expected b estimated with a's counters, got", top)
+	}
+}
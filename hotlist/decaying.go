@@ -0,0 +1,121 @@
+package hotlist
+
+import (
+	"math"
+	"time"
+)
+
+// Decayer is implemented by HotLists whose contents lose weight over time.
+type Decayer interface {
+	// Decay ages the contents of the HotList to the time now.  Items added
+	// after a call to Decay are considered to have occurred at now.
+	Decay(now time.Time)
+}
+
+// decayPrune is the decayed count below which an item is forgotten.
+const decayPrune = 0.01
+
+// decayingHotlist tracks exponentially decayed counts of items.
+//
+// Rather than scaling down every count on each call to Decay, new additions
+// are scaled up by the amount that older data would have decayed since
+// landmark.  All counts are periodically renormalized to keep the scale from
+// growing without bound.
+type decayingHotlist struct {
+	halfLife time.Duration
+	// the time at which scale was last reset to 1
+	landmark time.Time
+	// multiplier for new additions relative to data added at landmark
+	scale float64
+	items map[Item]*decayedCount
+}
+
+type decayedCount struct {
+	count  float64
+	weight float64
+}
+
+// NewDecaying returns an implementation of HotList whose counts decay
+// exponentially, halving every halfLife.  Recent activity thus dominates the
+// results of Top, and items that stop occurring eventually age out entirely.
+//
+// Time advances only when Decay is called, so the owner of the HotList
+// should call Decay periodically.  Counts reported by Top are rounded to the
+// nearest integer.
+func NewDecaying(halfLife time.Duration) HotList {
+	if halfLife <= 0 {
+		panic("half-life must be positive")
+	}
+	return &decayingHotlist{
+		halfLife: halfLife,
+		scale:    1,
+		items:    make(map[Item]*decayedCount),
+	}
+}
+
+func (hl *decayingHotlist) AddWeighted(x Item) {
+	hl.AddNWeighted(x, 1)
+}
+
+func (hl *decayingHotlist) AddNWeighted(x Item, n int) {
+	dc, ok := hl.items[x]
+	if !ok {
+		dc = &decayedCount{}
+		hl.items[x] = dc
+	}
+	dc.count += float64(n) * hl.scale
+	dc.weight += float64(n*x.Weight()) * hl.scale
+}
+
+func (hl *decayingHotlist) Decay(now time.Time) {
+	if hl.landmark.IsZero() {
+		hl.landmark = now
+		return
+	}
+	halfLives := float64(now.Sub(hl.landmark)) / float64(hl.halfLife)
+	hl.scale = math.Exp2(halfLives)
+	if halfLives >= 1 {
+		hl.renormalize(now)
+	}
+}
+
+// renormalize rescales all counts relative to now, forgetting items that
+// have decayed to insignificance.
+func (hl *decayingHotlist) renormalize(now time.Time) {
+	for x, dc := range hl.items {
+		dc.count /= hl.scale
+		dc.weight /= hl.scale
+		if dc.count < decayPrune {
+			delete(hl.items, x)
+		}
+	}
+	hl.landmark = now
+	hl.scale = 1
+}
+
+func (hl *decayingHotlist) Reset() {
+	for k := range hl.items {
+		delete(hl.items, k)
+	}
+}
+
+func (hl *decayingHotlist) Top(k int) []Entry {
+	ordered := make(descByTotalWeight, 0, len(hl.items))
+	for x, dc := range hl.items {
+		ordered = append(ordered, itemCount{
+			item:        x,
+			count:       int(math.Floor(dc.count/hl.scale + 0.5)),
+			totalWeight: int(math.Floor(dc.weight/hl.scale + 0.5)),
+		})
+	}
+	sortTop(ordered, k)
+	if len(ordered) < k {
+		k = len(ordered)
+	}
+
+	entries := make([]Entry, k)
+	for i, ic := range ordered[:k] {
+		entries[i] = ic
+	}
+	return entries
+}
@@ -0,0 +1,46 @@
+package hotlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecayingHalvesCounts(t *testing.T) {
+	start := time.Unix(1000, 0)
+	hl := NewDecaying(time.Minute)
+	hl.(Decayer).Decay(start)
+	hl.AddNWeighted(testItem{"a", 2}, 100)
+
+	hl.(Decayer).Decay(start.Add(time.Minute))
+	top := hl.Top(1)
+	if len(top) != 1 || top[0].Count() != 50 || top[0].Weight() != 100 {
+		t.Error("expected count 50 and weight 100 after one half-life, got", top)
+	}
+}
+
+func TestDecayingFavorsRecent(t *testing.T) {
+	start := time.Unix(1000, 0)
+	hl := NewDecaying(time.Minute)
+	hl.(Decayer).Decay(start)
+	hl.AddNWeighted(testItem{"old", 1}, 100)
+
+	hl.(Decayer).Decay(start.Add(90 * time.Second))
+	hl.AddNWeighted(testItem{"new", 1}, 40)
+
+	top := hl.Top(2)
+	if len(top) != 2 || top[0].Item() != (testItem{"new", 1}) {
+		t.Error("expected new first, got", top)
+	}
+}
+
+func TestDecayingAgesOut(t *testing.T) {
+	start := time.Unix(1000, 0)
+	hl := NewDecaying(time.Second)
+	hl.(Decayer).Decay(start)
+	hl.AddWeighted(testItem{"a", 1})
+
+	hl.(Decayer).Decay(start.Add(time.Minute))
+	if len(hl.Top(1)) != 0 {
+		t.Error("expected stale item to age out")
+	}
+}
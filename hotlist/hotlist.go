@@ -2,6 +2,7 @@
 package hotlist
 
 import (
+	"fmt"
 	"sort"
 )
 
@@ -10,25 +11,54 @@ type Item interface {
 	Weight() int
 }
 
+// OrderedItem is an Item that orders itself among items of equal weight,
+// so that Top need not format it.
+type OrderedItem interface {
+	Item
+	// Less reports whether the item sorts before other, which is an item
+	// of the same type.
+	Less(other Item) bool
+}
+
 // HotList tracks the frequency of items added to it, discarding infrequent
 // items and potentially retaining items based on relative weights.
 type HotList interface {
 	AddWeighted(x Item)
 	AddNWeighted(x Item, n int)
 	Reset()
+	// Top returns up to k entries in descending order by total weight.
+	// Entries of equal weight are ordered ascending by Less if their items
+	// are OrderedItems, or otherwise by the formatting of their items with
+	// fmt.Sprint, so that the same contents always give the same result.
+	// For items that are structs formatting orders first by their first
+	// field, such as a key name.
 	Top(k int) []Entry
 }
 
 // Entry represents the number of times an Item has occurred.
 type Entry interface {
 	Item() Item
+	// Count returns the number of times Item was added.
 	Count() int
+	// Weight returns the total weight accumulated by Item, which is
+	// the weight of Item multiplied by its Count.
+	Weight() int
+	// Error returns the maximum amount by which Count may exceed the true
+	// number of times Item was added.  Exact implementations always return
+	// zero.
+	Error() int
+	// ErrorWeight returns the maximum amount by which Weight may exceed the
+	// true total weight of Item, which is the weight inherited along with
+	// the count reported by Error.
+	ErrorWeight() int
 }
 
 type itemCount struct {
 	item        Item
 	count       int
 	totalWeight int
+	err         int
+	errWeight   int
 }
 
 func (ic itemCount) Item() Item {
@@ -39,21 +69,87 @@ func (ic itemCount) Count() int {
 	return ic.count
 }
 
+func (ic itemCount) Weight() int {
+	return ic.totalWeight
+}
+
+func (ic itemCount) Error() int {
+	return ic.err
+}
+
+func (ic itemCount) ErrorWeight() int {
+	return ic.errWeight
+}
+
 type descByTotalWeight []itemCount
 
 func (cs descByTotalWeight) Len() int           { return len(cs) }
 func (cs descByTotalWeight) Less(i, j int) bool { return cs[j].totalWeight < cs[i].totalWeight }
 func (cs descByTotalWeight) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
 
+// sortTop sorts ordered in descending order by total weight, ordering items
+// of equal weight as described for HotList.Top.  Comparing items may be
+// expensive, so ties are only broken among the entries that could be among
+// the first k.
+func sortTop(ordered descByTotalWeight, k int) {
+	sort.Sort(ordered)
+	for start := 0; start < k && start < len(ordered); {
+		end := start + 1
+		for end < len(ordered) && ordered[end].totalWeight == ordered[start].totalWeight {
+			end++
+		}
+		if end-start > 1 {
+			sortTies(ordered[start:end])
+		}
+		start = end
+	}
+}
+
+// byLabel sorts itemCounts in ascending order by the formatting of their
+// items.
+type byLabel struct {
+	ics    []itemCount
+	labels []string
+}
+
+func (b byLabel) Len() int           { return len(b.ics) }
+func (b byLabel) Less(i, j int) bool { return b.labels[i] < b.labels[j] }
+func (b byLabel) Swap(i, j int) {
+	b.ics[i], b.ics[j] = b.ics[j], b.ics[i]
+	b.labels[i], b.labels[j] = b.labels[j], b.labels[i]
+}
+
+// sortTies sorts itemCounts of equal weight in ascending order by their
+// items.
+func sortTies(ics []itemCount) {
+	for _, ic := range ics {
+		if _, ok := ic.item.(OrderedItem); !ok {
+			sortByLabel(ics)
+			return
+		}
+	}
+	sort.Slice(ics, func(i, j int) bool {
+		return ics[i].item.(OrderedItem).Less(ics[j].item)
+	})
+}
+
+func sortByLabel(ics []itemCount) {
+	labels := make([]string, len(ics))
+	for i, ic := range ics {
+		labels[i] = fmt.Sprint(ic.item)
+	}
+	sort.Sort(byLabel{ics, labels})
+}
+
 func orderedTop(k int, unordered map[Item]int) []Entry {
 	if len(unordered) < k {
 		k = len(unordered)
 	}
 	ordered := make(descByTotalWeight, 0, len(unordered))
 	for item, count := range unordered {
-		ordered = append(ordered, itemCount{item, count, item.Weight() * count})
+		ordered = append(ordered, itemCount{item: item, count: count, totalWeight: item.Weight() * count})
 	}
-	sort.Sort(ordered)
+	sortTop(ordered, k)
 
 	entries := make([]Entry, 0, len(ordered))
 	for _, ic := range ordered {
@@ -0,0 +1,96 @@
+package hotlist
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	name   string
+	weight int
+}
+
+func (ti testItem) Weight() int {
+	return ti.weight
+}
+
+func TestEntryCountAndWeight(t *testing.T) {
+	hl := NewPerfect()
+	hl.AddWeighted(testItem{"a", 10})
+	hl.AddNWeighted(testItem{"a", 10}, 2)
+	hl.AddWeighted(testItem{"b", 1})
+
+	top := hl.Top(2)
+	if len(top) != 2 {
+		t.Fatal("expected 2 entries, got", len(top))
+	}
+	if top[0].Item() != (testItem{"a", 10}) {
+		t.Error("expected a first, got", top[0].Item())
+	}
+	if top[0].Count() != 3 {
+		t.Error("expected count 3, got", top[0].Count())
+	}
+	if top[0].Weight() != 30 {
+		t.Error("expected weight 30, got", top[0].Weight())
+	}
+	if top[1].Count() != 1 || top[1].Weight() != 1 {
+		t.Error("expected count 1 and weight 1, got", top[1].Count(), top[1].Weight())
+	}
+}
+
+func TestTopBreaksTiesByItem(t *testing.T) {
+	hotlists := map[string]func() HotList{
+		"perfect":     NewPerfect,
+		"bounded":     func() HotList { return NewBoundedPerfect(100) },
+		"countmin":    func() HotList { return NewCountMin(1024, 4) },
+		"decaying":    func() HotList { return NewDecaying(time.Hour) },
+		"spacesaving": func() HotList { return NewSpaceSaving(100) },
+		"windowed":    func() HotList { return NewWindowed(2, NewPerfect) },
+	}
+	for name, newHotList := range hotlists {
+		for trial := 0; trial < 10; trial++ {
+			hl := newHotList()
+			hl.AddWeighted(testItem{"z", 100})
+			for _, key := range []string{"d", "b", "e", "a", "c"} {
+				hl.AddWeighted(testItem{key, 5})
+			}
+
+			var got []string
+			for _, e := range hl.Top(4) {
+				got = append(got, e.Item().(testItem).name)
+			}
+			if strings.Join(got, "") != "zabc" {
+				t.Error(name, "expected z then ties in order a, b, c, got", got)
+				break
+			}
+		}
+	}
+}
+
+// reverseItem orders itself by name, descending.
+type reverseItem struct {
+	name string
+}
+
+func (ri reverseItem) Weight() int {
+	return 1
+}
+
+func (ri reverseItem) Less(other Item) bool {
+	return other.(reverseItem).name < ri.name
+}
+
+func TestTopOrdersTiesByLess(t *testing.T) {
+	hl := NewPerfect()
+	for _, key := range []string{"b", "c", "a"} {
+		hl.AddWeighted(reverseItem{key})
+	}
+	var got []string
+	for _, e := range hl.Top(3) {
+		got = append(got, e.Item().(reverseItem).name)
+	}
+	if strings.Join(got, "") != "cba" {
+		t.Error("expected ties ordered by Less, got", got)
+	}
+}
@@ -0,0 +1,66 @@
+package hotlist
+
+// Sizer is implemented by HotLists that can estimate the memory they hold,
+// to watch the growth of unbounded implementations.
+type Sizer interface {
+	// Len returns the number of items tracked.
+	Len() int
+	// Bytes returns an estimate of the memory held by the HotList: any
+	// fixed allocation, such as a sketch, and its bookkeeping for each
+	// item tracked.  Memory referred to by the items themselves, such as
+	// the contents of strings, is not included.
+	Bytes() int
+}
+
+// Estimated bytes held per item, allowing for the unused capacity of Go maps
+// at their typical load.
+const (
+	// an entry in a map[Item]int
+	countEntryBytes = 48
+	// an entry in a map[Item]*decayedCount, and the decayedCount
+	decayedEntryBytes = countEntryBytes + 16
+	// an itemCount in a candidateHeap, and its entry in the index
+	candidateBytes = 40 + countEntryBytes
+	// a counter in a count-min sketch
+	cellBytes = 8
+)
+
+func (hl perfectHotlist) Len() int   { return len(hl) }
+func (hl perfectHotlist) Bytes() int { return len(hl) * countEntryBytes }
+
+func (hl *boundedPerfectHotlist) Len() int   { return len(hl.counts) }
+func (hl *boundedPerfectHotlist) Bytes() int { return len(hl.counts) * countEntryBytes }
+
+func (hl *decayingHotlist) Len() int   { return len(hl.items) }
+func (hl *decayingHotlist) Bytes() int { return len(hl.items) * decayedEntryBytes }
+
+func (hl *spaceSavingHotlist) Len() int   { return hl.counters.Len() }
+func (hl *spaceSavingHotlist) Bytes() int { return hl.counters.Len() * candidateBytes }
+
+func (hl *countMinHotlist) Len() int { return hl.candidates.Len() }
+func (hl *countMinHotlist) Bytes() int {
+	return hl.width*hl.depth*cellBytes + hl.candidates.Len()*candidateBytes
+}
+
+// Len returns the number of items tracked in each bucket, summed, so an
+// item active in several buckets is counted in each, as its memory is.
+// Buckets that do not implement Sizer are not counted.
+func (hl *windowedHotlist) Len() int {
+	n := 0
+	for _, b := range hl.buckets {
+		if s, ok := b.(Sizer); ok {
+			n += s.Len()
+		}
+	}
+	return n
+}
+
+func (hl *windowedHotlist) Bytes() int {
+	n := 0
+	for _, b := range hl.buckets {
+		if s, ok := b.(Sizer); ok {
+			n += s.Bytes()
+		}
+	}
+	return n
+}
@@ -0,0 +1,36 @@
+package hotlist
+
+import (
+	"testing"
+)
+
+func TestSizer(t *testing.T) {
+	hls := map[string]HotList{
+		"perfect":     NewPerfect(),
+		"bounded":     NewBoundedPerfect(100),
+		"decaying":    NewDecaying(1),
+		"spacesaving": NewSpaceSaving(2),
+		"countmin":    NewCountMin(16, 2),
+		"windowed":    NewWindowed(2, NewPerfect),
+	}
+	for name, hl := range hls {
+		s, ok := hl.(Sizer)
+		if !ok {
+			t.Error(name, "does not implement Sizer")
+			continue
+		}
+		empty := s.Bytes()
+		hl.AddWeighted(testItem{"a", 1})
+		hl.AddWeighted(testItem{"b", 1})
+		hl.AddWeighted(testItem{"b", 1})
+		if s.Len() != 2 {
+			t.Error(name, "expected 2 items, got", s.Len())
+		}
+		if s.Bytes() <= empty {
+			t.Error(name, "expected bytes to grow from", empty, "got", s.Bytes())
+		}
+	}
+	if b := NewCountMin(16, 2).(Sizer).Bytes(); b != 16*2*cellBytes {
+		t.Error("expected the sketch to be counted, got", b)
+	}
+}
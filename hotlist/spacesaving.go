@@ -0,0 +1,88 @@
+package hotlist
+
+import (
+	"container/heap"
+)
+
+// spaceSavingHotlist implements the Space-Saving algorithm of Metwally et al,
+// tracking a fixed number of counters and reassigning the lightest counter
+// when a new item arrives.
+type spaceSavingHotlist struct {
+	capacity int
+	// min-heap of tracked items by total weight
+	counters candidateHeap
+	// position of each tracked item in counters
+	index map[Item]int
+}
+
+// NewSpaceSaving returns an implementation of HotList that tracks at most
+// capacity items.
+//
+// Once capacity items are being tracked, adding an untracked item evicts the
+// item with the lowest total weight, and the new item inherits the evicted
+// count and weight.  Counts are therefore never lower than the true count,
+// and the Error of each Entry is the inherited count by which it may be
+// inflated.  Any item whose true total weight exceeds 1/capacity of the
+// total weight added is guaranteed to be tracked.
+func NewSpaceSaving(capacity int) HotList {
+	if capacity < 1 {
+		panic("space-saving capacity must be positive")
+	}
+	hl := &spaceSavingHotlist{
+		capacity: capacity,
+		index:    make(map[Item]int),
+	}
+	hl.counters.index = hl.index
+	return hl
+}
+
+func (hl *spaceSavingHotlist) AddWeighted(x Item) {
+	hl.AddNWeighted(x, 1)
+}
+
+func (hl *spaceSavingHotlist) AddNWeighted(x Item, n int) {
+	if i, ok := hl.index[x]; ok {
+		hl.counters.items[i].count += n
+		hl.counters.items[i].totalWeight += n * x.Weight()
+		heap.Fix(&hl.counters, i)
+		return
+	}
+
+	ic := itemCount{item: x, count: n, totalWeight: n * x.Weight()}
+	if len(hl.counters.items) < hl.capacity {
+		heap.Push(&hl.counters, ic)
+		return
+	}
+
+	lightest := hl.counters.items[0]
+	ic.count += lightest.count
+	ic.totalWeight += lightest.totalWeight
+	ic.err = lightest.count
+	ic.errWeight = lightest.totalWeight
+	delete(hl.index, lightest.item)
+	hl.counters.items[0] = ic
+	hl.index[x] = 0
+	heap.Fix(&hl.counters, 0)
+}
+
+func (hl *spaceSavingHotlist) Reset() {
+	for k := range hl.index {
+		delete(hl.index, k)
+	}
+	hl.counters.items = hl.counters.items[:0]
+}
+
+func (hl *spaceSavingHotlist) Top(k int) []Entry {
+	ordered := make(descByTotalWeight, len(hl.counters.items))
+	copy(ordered, hl.counters.items)
+	sortTop(ordered, k)
+	if len(ordered) < k {
+		k = len(ordered)
+	}
+
+	entries := make([]Entry, k)
+	for i, ic := range ordered[:k] {
+		entries[i] = ic
+	}
+	return entries
+}
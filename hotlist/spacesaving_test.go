@@ -0,0 +1,61 @@
+package hotlist
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSpaceSavingFindsHeavyHitters(t *testing.T) {
+	hl := NewSpaceSaving(10)
+	for i := 0; i < 1000; i++ {
+		hl.AddWeighted(testItem{"noise" + strconv.Itoa(i), 1})
+		if i%2 == 0 {
+			hl.AddWeighted(testItem{"heavy", 1})
+		}
+	}
+
+	top := hl.Top(1)
+	if len(top) != 1 || top[0].Item() != (testItem{"heavy", 1}) {
+		t.Fatal("expected heavy first, got", top)
+	}
+	if top[0].Count()-top[0].Error() > 500 || top[0].Count() < 500 {
+		t.Error("count", top[0].Count(), "with error", top[0].Error(), "does not bound 500")
+	}
+}
+
+func TestSpaceSavingCapacity(t *testing.T) {
+	hl := NewSpaceSaving(3)
+	for i := 0; i < 10; i++ {
+		hl.AddWeighted(testItem{strconv.Itoa(i), 1})
+	}
+	top := hl.Top(10)
+	if len(top) != 3 {
+		t.Error("expected 3 entries, got", len(top))
+	}
+	for _, e := range top {
+		if e.Count()-e.Error() != 1 {
+			t.Error("expected guaranteed count 1, got", e.Count()-e.Error())
+		}
+	}
+}
+
+func TestPerfectHasNoError(t *testing.T) {
+	hl := NewPerfect()
+	hl.AddNWeighted(testItem{"a", 1}, 5)
+	if e := hl.Top(1)[0]; e.Error() != 0 {
+		t.Error("expected zero error, got", e.Error())
+	}
+}
+
+func TestSpaceSavingErrorWeight(t *testing.T) {
+	hl := NewSpaceSaving(1)
+	hl.AddNWeighted(testItem{"light", 10}, 2)
+	hl.AddWeighted(testItem{"heavy", 100})
+	e := hl.Top(1)[0]
+	if e.Error() != 2 || e.ErrorWeight() != 20 {
+		t.Error("expected error of 2 items weighing 20, got", e.Error(), e.ErrorWeight())
+	}
+	if e.Weight()-e.ErrorWeight() != 100 {
+		t.Error("expected guaranteed weight 100, got", e.Weight()-e.ErrorWeight())
+	}
+}
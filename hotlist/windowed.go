@@ -0,0 +1,137 @@
+package hotlist
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Rotator is implemented by HotLists that divide activity into discrete time
+// buckets.
+type Rotator interface {
+	// Rotate starts a new bucket for items added in the future, discarding
+	// the oldest bucket.
+	Rotate()
+}
+
+// windowedHotlist tracks items in a ring of buckets, each itself a HotList.
+type windowedHotlist struct {
+	buckets   []HotList
+	newBucket func() HotList
+	// index of the bucket receiving new items
+	current int
+	// guards buckets against concurrent calls to Evicted
+	mu sync.Mutex
+	// items evicted by buckets since discarded
+	retiredEvicted int64
+}
+
+// NewWindowed returns an implementation of HotList that reports only items
+// added within a sliding window, divided into numBuckets buckets created by
+// newBucket.
+//
+// Time advances only when Rotate is called, so the owner of the HotList should
+// call Rotate every window/numBuckets.  Each call discards the oldest bucket,
+// releasing its memory, and Top reports the combined activity of the
+// remaining buckets.  The HotList also implements Decayer, and Rotate and
+// Decay are passed on to buckets that implement them, so that buckets
+// whose contents age continue to age within the window.  It implements
+// Evicter too, counting the items evicted by buckets that implement it.
+func NewWindowed(numBuckets int, newBucket func() HotList) HotList {
+	if numBuckets < 1 {
+		panic("window must have at least one bucket")
+	}
+	hl := &windowedHotlist{
+		buckets:   make([]HotList, numBuckets),
+		newBucket: newBucket,
+	}
+	for i := range hl.buckets {
+		hl.buckets[i] = newBucket()
+	}
+	return hl
+}
+
+func (hl *windowedHotlist) AddWeighted(x Item) {
+	hl.buckets[hl.current].AddWeighted(x)
+}
+
+func (hl *windowedHotlist) AddNWeighted(x Item, n int) {
+	hl.buckets[hl.current].AddNWeighted(x, n)
+}
+
+func (hl *windowedHotlist) Rotate() {
+	hl.current = (hl.current + 1) % len(hl.buckets)
+	// replace rather than Reset, so the memory held by a large bucket
+	// can be reclaimed
+	hl.mu.Lock()
+	if e, ok := hl.buckets[hl.current].(Evicter); ok {
+		hl.retiredEvicted += e.Evicted()
+	}
+	hl.buckets[hl.current] = hl.newBucket()
+	hl.mu.Unlock()
+	for i, b := range hl.buckets {
+		if r, ok := b.(Rotator); ok && i != hl.current {
+			r.Rotate()
+		}
+	}
+}
+
+func (hl *windowedHotlist) Decay(now time.Time) {
+	for _, b := range hl.buckets {
+		if d, ok := b.(Decayer); ok {
+			d.Decay(now)
+		}
+	}
+}
+
+func (hl *windowedHotlist) Evicted() int64 {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+	n := hl.retiredEvicted
+	for _, b := range hl.buckets {
+		if e, ok := b.(Evicter); ok {
+			n += e.Evicted()
+		}
+	}
+	return n
+}
+
+func (hl *windowedHotlist) Reset() {
+	for _, b := range hl.buckets {
+		b.Reset()
+	}
+}
+
+// Top returns the k items with the highest total weight across all buckets.
+// Approximate bucket implementations contribute only the items they retain.
+func (hl *windowedHotlist) Top(k int) []Entry {
+	combined := make(map[Item]*itemCount)
+	for _, b := range hl.buckets {
+		for _, e := range b.Top(math.MaxInt32) {
+			ic, ok := combined[e.Item()]
+			if !ok {
+				ic = &itemCount{item: e.Item()}
+				combined[e.Item()] = ic
+			}
+			ic.count += e.Count()
+			ic.totalWeight += e.Weight()
+			ic.err += e.Error()
+			ic.errWeight += e.ErrorWeight()
+		}
+	}
+
+	ordered := make(descByTotalWeight, 0, len(combined))
+	for _, ic := range combined {
+		ordered = append(ordered, *ic)
+	}
+	sortTop(ordered, k)
+	if len(ordered) < k {
+		k = len(ordered)
+	}
+
+	entries := make([]Entry, k)
+	for i, ic := range ordered[:k] {
+		entries[i] = ic
+	}
+	return entries
+}
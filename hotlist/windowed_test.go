@@ -0,0 +1,76 @@
+package hotlist
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowedCombinesBuckets(t *testing.T) {
+	hl := NewWindowed(3, NewPerfect)
+	hl.AddNWeighted(testItem{"a", 1}, 2)
+	hl.(Rotator).Rotate()
+	hl.AddNWeighted(testItem{"a", 1}, 3)
+	hl.AddWeighted(testItem{"b", 1})
+
+	top := hl.Top(2)
+	if len(top) != 2 || top[0].Item() != (testItem{"a", 1}) || top[0].Count() != 5 {
+		t.Error("expected a with count 5 first, got", top)
+	}
+}
+
+func TestWindowedExpiresOldest(t *testing.T) {
+	hl := NewWindowed(2, NewPerfect)
+	hl.AddWeighted(testItem{"old", 1})
+	hl.(Rotator).Rotate()
+	hl.AddWeighted(testItem{"new", 1})
+	hl.(Rotator).Rotate()
+
+	top := hl.Top(2)
+	if len(top) != 1 || top[0].Item() != (testItem{"new", 1}) {
+		t.Error("expected only new to remain, got", top)
+	}
+}
+
+func TestWindowedDecaysBuckets(t *testing.T) {
+	start := time.Unix(1000, 0)
+	hl := NewWindowed(2, func() HotList { return NewDecaying(time.Minute) })
+	hl.(Decayer).Decay(start)
+	hl.AddNWeighted(testItem{"a", 1}, 100)
+
+	hl.(Decayer).Decay(start.Add(time.Minute))
+	top := hl.Top(1)
+	if len(top) != 1 || top[0].Count() != 50 {
+		t.Error("expected count 50 after one half-life, got", top)
+	}
+}
+
+func TestWindowedRotatesBuckets(t *testing.T) {
+	hl := NewWindowed(2, func() HotList { return NewWindowed(2, NewPerfect) })
+	hl.AddWeighted(testItem{"a", 1})
+	hl.(Rotator).Rotate()
+	// the bucket holding a has rotated once, so a remains
+	top := hl.Top(1)
+	if len(top) != 1 || top[0].Item() != (testItem{"a", 1}) {
+		t.Fatal("expected a to remain, got", top)
+	}
+	hl.(Rotator).Rotate()
+	if top := hl.Top(1); len(top) != 0 {
+		t.Error("expected a to expire, got", top)
+	}
+}
+
+func TestWindowedCountsEvictions(t *testing.T) {
+	hl := NewWindowed(2, func() HotList { return NewBoundedPerfect(10) })
+	for i := 0; i < 11; i++ {
+		hl.AddWeighted(testItem{string(rune('a' + i)), 1})
+	}
+	if n := hl.(Evicter).Evicted(); n != 2 {
+		t.Fatal("expected 2 items evicted, got", n)
+	}
+	// evictions by discarded buckets are still counted
+	hl.(Rotator).Rotate()
+	hl.(Rotator).Rotate()
+	if n := hl.(Evicter).Evicted(); n != 2 {
+		t.Error("expected 2 items evicted after rotation, got", n)
+	}
+}
@@ -0,0 +1,88 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Field is a key/value pair attached to a log message.  A JSONLogger emits
+// each Field as a separate member of the message's JSON object, while text
+// loggers print it as key=value.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// KV returns a Field for inclusion among the items of a log message.
+func KV(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+func (f Field) String() string {
+	return fmt.Sprintf("%s=%v", f.Key, f.Value)
+}
+
+// JSONLogger writes each message as a line of JSON with its level, time
+// and text, plus any Fields among its items, for ingestion by log
+// aggregators:
+//
+//	{"level":"info","ts":"2017-07-14T02:40:00.000000001Z","msg":"Flushed 3","port":11211}
+type JSONLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+	// current time, replaceable for testing
+	now func() time.Time
+}
+
+// NewJSONLogger creates a JSONLogger writing to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w, now: time.Now}
+}
+
+// Log writes a message at LevelInfo.
+func (j *JSONLogger) Log(items ...interface{}) {
+	j.LogLevel(LevelInfo, items...)
+}
+
+// LogLevel writes a message at level.  Fields named level, ts or msg do not
+// replace those members.
+func (j *JSONLogger) LogLevel(level Level, items ...interface{}) {
+	rec := map[string]interface{}{}
+	var text []interface{}
+	for _, item := range items {
+		if f, ok := item.(Field); ok {
+			rec[f.Key] = jsonValue(f.Value)
+		} else {
+			text = append(text, item)
+		}
+	}
+	rec["level"] = level.String()
+	rec["ts"] = j.now().UTC().Format(time.RFC3339Nano)
+	rec["msg"] = strings.TrimSuffix(fmt.Sprintln(text...), "\n")
+	line, err := json.Marshal(rec)
+	if err != nil {
+		// values were checked by jsonValue, so only a broken key is left
+		line = []byte(fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error()))
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(append(line, '\n'))
+}
+
+// jsonValue returns v if it can be encoded as JSON, or otherwise its text.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
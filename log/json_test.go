@@ -0,0 +1,28 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	j := NewJSONLogger(&buf)
+	j.now = func() time.Time { return time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC) }
+	Warn(j, "flow", "decode errors", KV("count", 3), KV("err", errors.New("bad")))
+	j.Log("plain")
+
+	want := `{"count":3,"err":"bad","level":"warn","msg":"flow decode errors","ts":"2017-07-14T02:40:00Z"}` + "\n" +
+		`{"level":"info","msg":"plain","ts":"2017-07-14T02:40:00Z"}` + "\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, buf.String())
+	}
+}
+
+func TestFieldText(t *testing.T) {
+	if s := KV("n", 2).String(); s != "n=2" {
+		t.Error("expected field printed as key=value, got", s)
+	}
+}
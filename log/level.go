@@ -0,0 +1,118 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	// LevelDebug is for detail useful only when diagnosing memsniff itself,
+	// such as reassembly of individual conversations.
+	LevelDebug Level = iota
+	// LevelInfo is for routine messages.  Messages passed to Log are at
+	// LevelInfo.
+	LevelInfo
+	// LevelWarn is for conditions that may affect the accuracy of results,
+	// such as dropped input.
+	LevelWarn
+	// LevelError is for failures.
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level with the given name: debug, info, warn or
+// error.
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(l), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// LevelLogger is a Logger that distinguishes messages by severity.  Log is
+// equivalent to LogLevel at LevelInfo.
+type LevelLogger interface {
+	Logger
+	LogLevel(level Level, items ...interface{})
+}
+
+// Debug logs items to l at LevelDebug.
+func Debug(l Logger, items ...interface{}) {
+	logLevel(l, LevelDebug, items)
+}
+
+// Info logs items to l at LevelInfo.
+func Info(l Logger, items ...interface{}) {
+	logLevel(l, LevelInfo, items)
+}
+
+// Warn logs items to l at LevelWarn.
+func Warn(l Logger, items ...interface{}) {
+	logLevel(l, LevelWarn, items)
+}
+
+// Error logs items to l at LevelError.
+func Error(l Logger, items ...interface{}) {
+	logLevel(l, LevelError, items)
+}
+
+// logLevel passes items to l at level.  Loggers that do not implement
+// LevelLogger receive messages other than LevelInfo marked with the level.
+// No logging is done if l is nil.
+func logLevel(l Logger, level Level, items []interface{}) {
+	switch l := l.(type) {
+	case nil:
+	case LevelLogger:
+		l.LogLevel(level, items...)
+	default:
+		l.Log(withLevel(level, items)...)
+	}
+}
+
+// withLevel marks items with level unless it is LevelInfo, so that messages
+// passed to Log appear as before.
+func withLevel(level Level, items []interface{}) []interface{} {
+	if level == LevelInfo {
+		return items
+	}
+	return append([]interface{}{strings.ToUpper(level.String()) + ":"}, items...)
+}
+
+// LevelFilter is a Logger that discards messages below a minimum Level,
+// passing the rest to an underlying Logger.
+type LevelFilter struct {
+	min Level
+	l   Logger
+}
+
+// NewLevelFilter creates a LevelFilter passing messages of at least min
+// to l.
+func NewLevelFilter(l Logger, min Level) *LevelFilter {
+	return &LevelFilter{min, l}
+}
+
+// Log passes its message to the underlying Logger at LevelInfo.
+func (f *LevelFilter) Log(items ...interface{}) {
+	f.LogLevel(LevelInfo, items...)
+}
+
+// LogLevel passes its message to the underlying Logger if level is at
+// least the minimum.
+func (f *LevelFilter) LogLevel(level Level, items ...interface{}) {
+	if level >= f.min {
+		logLevel(f.l, level, items)
+	}
+}
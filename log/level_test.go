@@ -0,0 +1,49 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+)
+
+// plainLogger implements only Logger.
+type plainLogger struct {
+	msgs []string
+}
+
+func (l *plainLogger) Log(items ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprint(items...))
+}
+
+func TestLevelFilter(t *testing.T) {
+	pl := &plainLogger{}
+	f := NewLevelFilter(NewContext(pl, "ctx "), LevelInfo)
+	Debug(f, "hidden")
+	f.Log("log")
+	Warn(f, "warn")
+	Error(nil, "ignored")
+
+	want := []string{"ctx log", "WARN:ctx warn"}
+	if fmt.Sprint(pl.msgs) != fmt.Sprint(want) {
+		t.Error("expected", want, "got", pl.msgs)
+	}
+}
+
+func TestBufferLoggerKeepsLevels(t *testing.T) {
+	b := &BufferLogger{}
+	Debug(b, "debug")
+	b.Log("info")
+	pl := &plainLogger{}
+	b.WriteTo(NewLevelFilter(pl, LevelInfo))
+	if len(pl.msgs) != 1 || pl.msgs[0] != "info" {
+		t.Error("expected only the info message, got", pl.msgs)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARN"); err != nil || l != LevelWarn {
+		t.Error("expected LevelWarn, got", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
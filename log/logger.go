@@ -10,7 +10,9 @@ func init() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 }
 
-// Logger is a general-purpose interface for displaying messages.
+// Logger is a general-purpose interface for displaying messages.  Loggers
+// that also implement LevelLogger receive the severity of each message
+// logged with Debug, Info, Warn or Error.
 type Logger interface {
 	Log(items ...interface{})
 }
@@ -24,26 +26,43 @@ func (l ConsoleLogger) Log(items ...interface{}) {
 	log.Println(items...)
 }
 
+// LogLevel sends a message to the default Go logger, marked with its level
+// unless it is LevelInfo.
+func (l ConsoleLogger) LogLevel(level Level, items ...interface{}) {
+	log.Println(withLevel(level, items)...)
+}
+
 // BufferLogger stores all log messages.  It is primarily useful during startup
 // when the eventual Logger implementation to use is not yet known.
 type BufferLogger struct {
 	sync.Mutex
-	buf [][]interface{}
+	buf []bufferedMessage
+}
+
+type bufferedMessage struct {
+	level Level
+	items []interface{}
 }
 
 // Log records a formatted log message for future retrieval.
 func (b *BufferLogger) Log(items ...interface{}) {
+	b.LogLevel(LevelInfo, items...)
+}
+
+// LogLevel records a formatted log message and its level for future
+// retrieval.
+func (b *BufferLogger) LogLevel(level Level, items ...interface{}) {
 	b.Lock()
 	defer b.Unlock()
-	b.buf = append(b.buf, items)
+	b.buf = append(b.buf, bufferedMessage{level, items})
 }
 
 // WriteTo sends recorded log messages to another Logger in order.
 func (b *BufferLogger) WriteTo(l Logger) {
 	b.Lock()
 	defer b.Unlock()
-	for _, items := range b.buf {
-		l.Log(items...)
+	for _, m := range b.buf {
+		logLevel(l, m.level, m.items)
 	}
 }
 
@@ -62,6 +81,17 @@ func (p *ProxyLogger) Log(items ...interface{}) {
 	p.l.Log(items...)
 }
 
+// LogLevel forwards its message and level to the underlying Logger
+// implementation.  Panics if called before the first call to SetLogger.
+func (p *ProxyLogger) LogLevel(level Level, items ...interface{}) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.l == nil {
+		panic("log: ProxyLogger used before SetLogger")
+	}
+	logLevel(p.l, level, items)
+}
+
 // SetLogger assigns an underlying Logger implementation to this ProxyLogger.
 func (p *ProxyLogger) SetLogger(l Logger) {
 	p.Lock()
@@ -89,3 +119,9 @@ func (c *ContextLogger) Log(items ...interface{}) {
 	args := append([]interface{}{c.context}, items...)
 	c.l.Log(args...)
 }
+
+// LogLevel is like Log, passing level to the underlying Logger.
+func (c *ContextLogger) LogLevel(level Level, items ...interface{}) {
+	args := append([]interface{}{c.context}, items...)
+	logLevel(c.l, level, args)
+}
@@ -3,42 +3,149 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/box/memsniff/analysis"
 	"github.com/box/memsniff/assembly"
 	"github.com/box/memsniff/capture"
 	"github.com/box/memsniff/decode"
+	"github.com/box/memsniff/discover"
+	"github.com/box/memsniff/hotlist"
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/presentation"
+	"github.com/box/memsniff/protocol/model"
+	"github.com/box/memsniff/report"
+	"github.com/box/memsniff/report/api"
+	csvreport "github.com/box/memsniff/report/csv"
+	jsonreport "github.com/box/memsniff/report/json"
+	"github.com/box/memsniff/report/otlp"
+	"github.com/box/memsniff/report/prometheus"
+	"github.com/box/memsniff/report/statsd"
+	"github.com/box/memsniff/report/stream"
+	"github.com/box/memsniff/report/term"
 	flag "github.com/spf13/pflag"
 )
 
 var (
-	netInterface = flag.StringP("interface", "i", "", "network interface to sniff")
-	infile       = flag.StringP("read", "r", "", "file to read (- for stdin)")
-	bufferSize   = flag.IntP("buffersize", "b", 8, "MiB of kernel buffer for packet data")
-	ports        = flag.IntSliceP("ports", "p", []int{11211}, "memcached ports to listen on")
+	netInterfaces = flag.StringSliceP("interface", "i", nil, "network interface to sniff (repeatable, to merge traffic from several)")
+	infile        = flag.StringP("read", "r", "", "file to read (- for stdin)")
+	bufferSize    = flag.IntP("buffersize", "b", 8, "MiB of kernel buffer for packet data")
+	snapLen       = flag.Int("snaplen", capture.DefaultSnapLen, "bytes captured from each packet, with larger values truncated")
+	batchSize     = flag.Int("batchsize", decode.DefaultBatchSize, "packets decoded together by each decode worker")
+	fanout        = flag.Int("fanout", 0, "capture with this many AF_PACKET sockets sharing the interface by flow, on Linux (0 to capture with libpcap)")
+	useEBPF       = flag.Bool("ebpf", false, "select memcached packets with an eBPF filter in the kernel, on Linux in builds with the ebpf tag, falling back to libpcap where unsupported")
+	tunnels       = flag.Bool("tunnels", false, "also capture VXLAN and GRE encapsulated traffic, analyzing the memcached conversations inside it")
+	ports         = flag.IntSliceP("ports", "p", []int{11211}, "memcached ports to listen on")
+	redisPorts    = flag.IntSlice("redisports", nil, "Redis ports to listen on")
+	directionName = flag.String("direction", "both", "traffic captured: both directions, or requests from clients alone, reporting gets without hits or misses ranked by count")
+	discoverFor   = flag.Duration("discover", 0, "watch all TCP traffic on --interface for this long to find the memcached ports, in place of --ports (0 to disable)")
 
-	assemblyWorkers = flag.Int("assemblyworkers", 8, "number of TCP assembly workers")
+	assemblyWorkers = flag.Int("assemblyworkers", 8, "number of TCP assembly workers (0 for one per CPU)")
 	decodeWorkers   = flag.Int("decodeworkers", 8, "number of decode workers")
-	analysisWorkers = flag.Int("analysisworkers", 32, "number of analysis workers")
+	analysisWorkers = flag.Int("analysisworkers", 32, "number of analysis workers (0 for one per CPU)")
+	analysisQueue   = flag.Int("analysisqueue", analysis.DefaultQueueSize, "number of event batches each analysis worker can queue")
+	idleTimeout     = flag.Duration("idletimeout", assembly.DefaultIdleTimeout, "close conversations that see no packets for this long")
+	maxFlowErrors   = flag.Int("maxflowerrors", 0, "stop decoding a conversation after this many protocol errors (0 to keep decoding)")
+	mixFlows        = flag.Bool("mixflowhash", true, "scramble flow hashes before spreading conversations over assembly workers, for hashes that vary little in their low bits")
+	sampleRate      = flag.Float64("samplerate", 1, "fraction of connections to analyze, with estimates scaled to match")
 	profiles        = flag.StringSlice("profile", []string{}, "profile types to store (one or more of cpu, heap, block)")
 
 	filter     = flag.StringP("filter", "f", "", "regex pattern of cache keys to track")
+	allowPfx   = flag.StringSlice("allowprefix", []string{}, "only track cache keys starting with this prefix (repeatable)")
+	denyPfx    = flag.StringSlice("denyprefix", []string{}, "do not track cache keys starting with this prefix, even if allowed (repeatable)")
 	reportSize = flag.IntP("top", "t", 100, "number of keys to report")
+	minWeight  = flag.Int("minweight", 0, "leave out keys with fewer than this many bytes, or requests with --bycount, even if fewer keys are reported")
 	interval   = flag.IntP("interval", "n", 1, "report top keys every this many seconds")
 	cumulative = flag.Bool("cumulative", false, "accumulate keys over all time instead of an interval")
+	window     = flag.Duration("window", 0, "report keys active within a sliding window of this length instead of an interval")
+	buckets    = flag.Int("windowbuckets", analysis.DefaultWindowBuckets, "number of buckets the sliding window is divided into")
+	byCount    = flag.Bool("bycount", false, "rank keys by number of requests instead of bandwidth")
+	weightName = flag.String("weight", "linear", "weight each value by its size (linear), size*log2(size) (sizelog), or size raised to this exponent, to favour keys with large values")
+	dimension  = flag.String("dimension", "key", "attribute activity to each key, client, or clientkey combination")
+	normalize  = flag.Bool("normalize", false, "report families of keys by collapsing runs of digits to #")
+	normRules  = flag.StringSlice("normalizerule", []string{}, "rewrite keys with a pattern=replacement rule before reporting (repeatable)")
+	pfxDepth   = flag.Int("prefixdepth", 0, "aggregate activity by up to this many leading segments of keys, served at /prefixes with --http (0 to disable)")
+	pfxBreadth = flag.Int("prefixbreadth", analysis.DefaultPrefixBreadth, "longer prefixes tracked under each prefix with --prefixdepth, beyond which they are combined")
+	pfxDelims  = flag.String("prefixdelimiters", analysis.DefaultPrefixDelimiters, "characters ending each segment of a key with --prefixdepth")
+	examples   = flag.Int("examples", 0, "report up to this many example keys sampled from each family of keys rewritten by --normalize or --normalizerule")
+	redactions = flag.StringSlice("redact", []string{}, "replace matches of this regex pattern in keys with *** before tracking (repeatable)")
+	trackSets  = flag.Bool("sets", false, "also track keys by storage commands (set, add, replace, append, prepend)")
+	trackDels  = flag.Bool("deletes", false, "also track keys by delete commands")
+	trackArith = flag.Bool("counters", false, "also track keys by incr and decr commands")
+	trackCAS   = flag.Bool("cas", false, "also track keys by cas commands and their conflicts")
+	rwRatio    = flag.Float64("rwratio", 0, "classify each key as read- or write-dominated at this many gets per write (0 to disable)")
+	oversize   = flag.Int("oversize", 0, "log values larger than this many bytes, at most once a minute per key (0 to disable)")
+	rateRules  = flag.StringSlice("ratelimit", []string{}, "log keys starting with prefix whose values exceed this many bytes per second, with a prefix=bytesPerSec rule, or prefix*=bytesPerSec to limit the keys together (repeatable)")
+	clusters   = flag.StringSlice("cluster", []string{}, "report activity separately for each cluster of servers, naming the cluster of a server with an address=name mapping (repeatable); servers not named are reported under their address")
+	byServer   = flag.Bool("byserver", false, "report activity separately for each server, as with --cluster")
+	byIface    = flag.Bool("byinterface", false, "with several --interface, report activity separately for each interface, as with --cluster")
+	flushReset = flag.Bool("resetonflush", false, "clear all results whenever a flush_all is seen, so reports cover activity since the cache was emptied")
+	rateWindow = flag.Duration("ratewindow", analysis.DefaultRateWindow, "sliding window over which --ratelimit rates are measured")
+	lastSeen   = flag.Bool("lastseen", false, "also report when each key was last active")
+	keyClients = flag.Bool("clients", false, "also report the number of distinct clients of each key, served at /top with --http")
+	clientKeys = flag.Int("clientkeys", analysis.DefaultFanoutKeys, "number of keys whose clients each analysis worker counts for --clients")
+	trackConns = flag.Bool("connections", false, "also track the busiest client connections, served at /connections with --http")
+	scanThresh = flag.Float64("scanthreshold", 0, "flag connections whose gets are at least this fraction distinct keys as scanning, served at /scans with --http (0 to disable)")
+	skipScans  = flag.Bool("excludescans", false, "with --scanthreshold, leave keys from scanning connections out of the top keys")
+	newKeys    = flag.Bool("newkeys", false, "count keys not seen before, reported as memsniff_new_keys_total with --prometheus")
+	seenKeys   = flag.Int("seenkeys", analysis.DefaultSeenKeys, "number of distinct keys remembered by --newkeys")
+	sizeBounds = flag.IntSlice("sizebuckets", nil, "report a histogram of value sizes with these ascending bucket upper bounds in bytes")
 
-	noDelay = flag.Bool("nodelay", false, "replay from file at maximum speed instead of rate of original capture")
+	hotlistType = flag.String("hotlist", "perfect", "key tracking method (perfect, countmin, spacesaving or decaying)")
+	hotlistSize = flag.Int("hotlistsize", 10000, "number of keys tracked per analysis worker by spacesaving")
+	maxKeys     = flag.Int("maxkeys", 0, "number of keys tracked per analysis worker by perfect before the least active are evicted (0 for unlimited)")
+	maxKeyLen   = flag.Int("maxkeylen", analysis.DefaultMaxKeyLength, "truncate memcached keys longer than this many bytes, counted as memsniff_keys_truncated_total with --prometheus; Redis keys are not limited")
+	longKeys    = flag.Bool("rejectlongkeys", false, "discard events on keys longer than --maxkeylen instead of truncating them, counted as memsniff_keys_rejected_total")
+	sketchWidth = flag.Int("sketchwidth", 4096, "number of counters per row of the countmin sketch")
+	sketchDepth = flag.Int("sketchdepth", 4, "number of rows in the countmin sketch")
+	halfLife    = flag.Duration("halflife", time.Minute, "time for activity to lose half its weight with decaying")
+
+	jsonOut    = flag.String("json", "", "write top keys as newline-delimited JSON to this file every interval (- for stdout)")
+	csvOut     = flag.String("csv", "", "write top keys as CSV to this file every interval (- for stdout)")
+	csvColumns = flag.StringSlice("csvcolumns", csvreport.DefaultColumns, "columns written with --csv (timestamp, rank, key, client, cluster, requests, misses, bytes, avg_size)")
+	promAddr   = flag.String("prometheus", "", "serve Prometheus metrics at /metrics on this address (e.g. :9876)")
+	promTotals = flag.Bool("monotonictotals", false, "report traffic totals since startup instead of since the last interval, as Prometheus counters")
+	promLabels = flag.Int("prometheuslabels", 20, "number of keys reported individually to Prometheus, with the rest combined")
+	apiAddr    = flag.String("http", "", "serve the top keys as JSON at /top, settings to view and change at /config, and hotlist memory at /memstats, on this address (e.g. :9877)")
+	streamAddr = flag.String("stream", "", "stream the top keys every interval to clients connecting to this TCP address (e.g. :9878)")
+	statsdAddr = flag.String("statsd", "", "send gauges for top keys to the statsd daemon at this host:port every interval")
+	statsdKeys = flag.Int("statsdkeys", 20, "number of keys sent to statsd, up to --top")
+	otlpURL    = flag.String("otlp", "", "push metrics to the OpenTelemetry collector at this OTLP/HTTP URL every interval (e.g. http://localhost:4318)")
+	otlpKeys   = flag.Int("otlpkeys", 20, "number of keys pushed individually with --otlp, with the rest combined")
+	roundBytes = flag.Int("roundbytes", 0, "round byte counts sent to --json, --csv, --prometheus, --http, --stream, --statsd and --otlp to the nearest multiple of this many bytes, e.g. 1024 for whole kilobytes (0 for exact counts)")
+
+	noDelay = flag.Bool("nodelay", false, "replay from file at maximum speed instead of rate of original capture, like --replayspeed 0")
+	speed   = flag.Float64("replayspeed", 1, "replay from file this many times faster than the original capture (0 for maximum speed)")
 	noGui   = flag.Bool("nogui", false, "disable interactive interface")
+	table   = flag.Bool("table", false, "show a refreshing table of request and byte rates instead of the interactive interface")
+	offline = flag.Bool("offline", false, "analyze the entire file given by --read, print the top keys and exit")
+	perPort = flag.Bool("perport", false, "with --offline, report the top keys for each server port separately")
+	dryRun  = flag.Bool("validate", false, "with --offline, report how many commands were decoded and how many decode errors occurred in each conversation, instead of the top keys")
+
+	logLevel  = flag.String("loglevel", "info", "minimum level of log messages shown: debug, info, warn or error")
+	logFormat = flag.String("logformat", "text", "format of log messages written to stderr: text, or json for one object per line")
 
 	displayVersion = flag.Bool("version", false, "display version information")
 )
 
+// shutdownTimeout bounds how long analysis may take to record queued
+// events before the final report.
+const shutdownTimeout = 10 * time.Second
+
 var logger = &log.ProxyLogger{}
 
 func main() {
@@ -54,28 +161,303 @@ func main() {
 
 	buffered := &log.BufferLogger{}
 	logger.SetLogger(buffered)
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	var console log.Logger = log.NewLevelFilter(log.ConsoleLogger{}, level)
+	switch *logFormat {
+	case "text":
+	case "json":
+		console = log.NewLevelFilter(log.NewJSONLogger(os.Stderr), level)
+	default:
+		(&log.ConsoleLogger{}).Log("unknown --logformat", *logFormat)
+		os.Exit(1)
+	}
+	if *discoverFor > 0 {
+		if err := discoverPorts(console); err != nil {
+			(&log.ConsoleLogger{}).Log(err)
+			os.Exit(2)
+		}
+	}
+
+	direction, err := assembly.ParseDirection(*directionName)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	weightMode := analysis.WeightBytes
+	if *byCount || direction == assembly.DirectionRequests {
+		// gets decoded from requests alone have no value size
+		weightMode = analysis.WeightCount
+	}
+	weightFunc, err := analysis.ParseWeightFunc(*weightName)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	newHotList, err := hotlistFactory(*hotlistType)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	dim, err := parseDimension(*dimension)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	normalizeKey, err := keyNormalizer()
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	redactPatterns, err := compilePatterns(*redactions)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	limits, err := parseRateRules(*rateRules)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	clusterNames, err := parseClusters(*clusters, *byServer)
+	if err != nil {
+		(&log.ConsoleLogger{}).Log(err)
+		os.Exit(1)
+	}
+	if *promLabels < 0 || *otlpKeys < 0 {
+		(&log.ConsoleLogger{}).Log("--prometheuslabels and --otlpkeys must not be negative")
+		os.Exit(1)
+	}
+	conf := analysis.Config{
+		Workers:     *analysisWorkers,
+		ReportSize:  *reportSize,
+		MinWeight:   *minWeight,
+		WeightMode:  weightMode,
+		WeightFunc:  weightFunc,
+		Dimension:   dim,
+		Clusters:    clusterNames,
+		ByInterface: *byIface,
+		QueueSize:   *analysisQueue,
+		NewHotList:  newHotList,
+		MaxKeys:     *maxKeys,
 
-	analysisPool := analysis.New(*analysisWorkers, *reportSize)
+		MaxKeyLength:   *maxKeyLen,
+		RejectLongKeys: *longKeys,
+
+		ResetOnFlush: *flushReset,
+
+		BlockTimeout: blockTimeout(),
+
+		NormalizeKey:   normalizeKey,
+		Examples:       *examples,
+		RedactPatterns: redactPatterns,
+
+		PrefixDepth:      *pfxDepth,
+		PrefixBreadth:    *pfxBreadth,
+		PrefixDelimiters: *pfxDelims,
+
+		AllowPrefixes: *allowPfx,
+		DenyPrefixes:  *denyPfx,
+
+		Window:        *window,
+		WindowBuckets: *buckets,
+
+		TrackSets:     *trackSets,
+		TrackDeletes:  *trackDels,
+		TrackCounters: *trackArith,
+		TrackCAS:      *trackCAS,
+		TrackGets:     direction == assembly.DirectionRequests,
+
+		ReadWriteRatio: *rwRatio,
+		TrackLastSeen:  *lastSeen,
+		TrackFanout:    *keyClients,
+		FanoutKeys:     *clientKeys,
+
+		OversizeThreshold: *oversize,
+		OnOversize: func(key string, size int) {
+			log.Warn(logger, "oversized value", log.KV("key", key), log.KV("size", size))
+		},
+
+		RateRules: limits,
+		OnRateExceeded: func(rule analysis.RateRule, key string, bytesPerSec float64) {
+			log.Warn(logger, "rate limit exceeded", log.KV("key", key), log.KV("bytes_per_sec", int(bytesPerSec)), log.KV("limit", int(rule.BytesPerSec)))
+		},
+		RateWindow: *rateWindow,
+
+		TrackConnections: *trackConns,
+
+		ScanThreshold: *scanThresh,
+		ExcludeScans:  *skipScans,
+
+		MonotonicSummary: *promTotals,
+		TrackNewKeys:     *newKeys,
+		SeenKeys:         *seenKeys,
+
+		SampleRate:  assembly.SampleRate(*sampleRate),
+		SizeBuckets: *sizeBounds,
+	}
+	analysisPool := analysis.New(conf)
 	if err := analysisPool.SetFilterPattern(*filter); err != nil {
 		(&log.ConsoleLogger{}).Log(err)
 		os.Exit(1)
 	}
+	reported := roundedPool{analysisPool, *roundBytes}
+	// fed by the Pool every interval once capture starts
+	var reporters []reporter
+
+	if *jsonOut != "" {
+		jw, err := startJSONReport()
+		if err != nil {
+			(&log.ConsoleLogger{}).Log(err)
+			os.Exit(1)
+		}
+		reporters = append(reporters, jw)
+	}
+
+	if *csvOut != "" {
+		cw, err := startCSVReport()
+		if err != nil {
+			(&log.ConsoleLogger{}).Log(err)
+			os.Exit(1)
+		}
+		reporters = append(reporters, cw)
+	}
+
+	if *promAddr != "" {
+		ph := prometheus.NewHandler(analysisPool, *reportSize, *promLabels)
+		reporters = append(reporters, ph)
+		handleHTTP(*promAddr, "/metrics", ph)
+	}
+	if *apiAddr != "" {
+		handleHTTP(*apiAddr, "/top", api.NewTopHandler(reported, rankMetric(weightMode)))
+		if *trackConns {
+			handleHTTP(*apiAddr, "/connections", api.NewConnectionsHandler(analysisPool))
+		}
+		if *scanThresh > 0 {
+			handleHTTP(*apiAddr, "/scans", api.NewScansHandler(analysisPool))
+		}
+		if *pfxDepth > 0 {
+			handleHTTP(*apiAddr, "/prefixes", api.NewPrefixesHandler(analysisPool, rankMetric(weightMode)))
+		}
+		handleHTTP(*apiAddr, "/memstats", api.NewMemStatsHandler(analysisPool))
+	}
+	startHTTP()
+	if *streamAddr != "" {
+		s, err := startStream()
+		if err != nil {
+			(&log.ConsoleLogger{}).Log(err)
+			os.Exit(1)
+		}
+		reporters = append(reporters, s)
+	}
+	if *statsdAddr != "" {
+		emitter, err := statsd.New(logger, *statsdAddr, *statsdKeys)
+		if err != nil {
+			(&log.ConsoleLogger{}).Log(err)
+			os.Exit(1)
+		}
+		reporters = append(reporters, emitter)
+		go emitter.Run()
+	}
+	if *otlpURL != "" {
+		exporter, err := otlp.New(logger, analysisPool, *otlpURL, otlpResource(), time.Duration(*interval)*time.Second, *reportSize, *otlpKeys)
+		if err != nil {
+			(&log.ConsoleLogger{}).Log(err)
+			os.Exit(1)
+		}
+		reporters = append(reporters, exporter)
+		go exporter.Run()
+	}
 
-	packetSource, err := capture.New(*netInterface, *infile, *bufferSize, *noDelay, *ports)
+	if *offline && *infile == "" {
+		(&log.ConsoleLogger{}).Log("--offline requires --read")
+		os.Exit(1)
+	}
+	if *perPort && !*offline {
+		(&log.ConsoleLogger{}).Log("--perport requires --offline")
+		os.Exit(1)
+	}
+	if *dryRun && !*offline {
+		(&log.ConsoleLogger{}).Log("--validate requires --offline")
+		os.Exit(1)
+	}
+	if *fanout > 0 && len(*netInterfaces) != 1 {
+		(&log.ConsoleLogger{}).Log("--fanout requires a single --interface")
+		os.Exit(1)
+	}
+	if *useEBPF && (len(*netInterfaces) != 1 || *infile != "" || *fanout > 0) {
+		(&log.ConsoleLogger{}).Log("--ebpf requires a single --interface, without --fanout")
+		os.Exit(1)
+	}
+	packetSources, err := openPacketSources()
 	if err != nil {
 		(&log.ConsoleLogger{}).Log(err)
 		os.Exit(2)
 	}
 
-	decodePool := decode.NewPool(logger, *decodeWorkers, packetSource, packetHandler(analysisPool))
+	analysisPools := map[int]*analysis.Pool{}
+	for _, port := range serverPorts() {
+		analysisPools[port] = analysisPool
+		if *perPort {
+			analysisPools[port] = analysis.New(conf)
+			// the pattern has already been validated
+			_ = analysisPools[port].SetFilterPattern(*filter)
+		}
+	}
+	assemblyPool := assembly.NewPerPort(logger, analysisPools, *redisPorts, *assemblyWorkers, *sampleRate, *idleTimeout)
+	assemblyPool.MixFlowHash = *mixFlows
+	assemblyPool.SetMaxFlowErrors(*maxFlowErrors)
+	if *dryRun {
+		assemblyPool.SetValidation()
+	}
+	assemblyPool.SetDirection(direction)
+	if len(*netInterfaces) > 1 {
+		assemblyPool.SetInterfaces(*netInterfaces)
+	}
+	if *apiAddr != "" && !*offline {
+		// served alongside /top, which is already being served
+		handleHTTP(*apiAddr, "/config", api.NewConfigHandler(analysisPool, assemblyPool))
+		handleHTTP(*apiAddr, "/packets", api.NewPacketsHandler(assemblyPool))
+	}
+	if *offline {
+		logger.SetLogger(console)
+		buffered.WriteTo(logger)
+		if err := runOffline(packetSources[0], assemblyPool, analysisPools); err != nil {
+			logger.Log(err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	go resetOnSignal(analysisPool)
+
+	// each source is decoded separately, sharing the decode workers
+	workersPerSource := *decodeWorkers / len(packetSources)
+	if workersPerSource < 1 {
+		workersPerSource = 1
+	}
+	decodePools := make([]*decode.Pool, len(packetSources))
+	var decoding sync.WaitGroup
+	for i, src := range packetSources {
+		decodePools[i] = decode.NewPool(logger, workersPerSource, *batchSize, src, packetHandler(assemblyPool))
+		decoding.Add(1)
+		go func(p *decode.Pool) {
+			p.Run()
+			decoding.Done()
+		}(decodePools[i])
+	}
 	eofChan := make(chan struct{}, 1)
 	go func() {
-		decodePool.Run()
+		decoding.Wait()
 		eofChan <- struct{}{}
 	}()
 
 	if *noGui {
-		logger.SetLogger(log.ConsoleLogger{})
+		startReports(analysisPool, reporters)
+		logger.SetLogger(console)
 		buffered.WriteTo(logger)
 
 		exitChan := make(chan os.Signal, 1)
@@ -84,26 +466,477 @@ func main() {
 		case <-exitChan:
 		case <-eofChan:
 		}
+	} else if *table {
+		renderer := term.New(os.Stdout, *reportSize, !*cumulative && *window == 0)
+		startReports(analysisPool, append(reporters, renderer))
+		exitChan := make(chan os.Signal, 1)
+		signal.Notify(exitChan, os.Interrupt)
+		go func() {
+			select {
+			case <-exitChan:
+			case <-eofChan:
+			}
+			renderer.Close()
+		}()
+
+		err := renderer.Run()
+		// log messages would have been overwritten by the table
+		logger.SetLogger(console)
+		buffered.WriteTo(logger)
+		if err != nil {
+			logger.Log(err)
+		}
 	} else {
-		updateInterval := time.Duration(*interval) * time.Second
-		statProvider := statGenerator(packetSource, decodePool, analysisPool)
-		cui := presentation.New(analysisPool, updateInterval, *cumulative, statProvider)
+		statProvider := statGenerator(capture.Sources(packetSources), decodePools, analysisPool)
+		cui := presentation.New(statProvider)
+		startReports(analysisPool, append(reporters, cui))
 
-		logger.SetLogger(cui)
-		go buffered.WriteTo(cui)
+		ui := log.NewLevelFilter(cui, level)
+		logger.SetLogger(ui)
+		go buffered.WriteTo(ui)
 
 		err := cui.Run()
 		if err != nil {
-			logger.SetLogger(log.ConsoleLogger{})
+			logger.SetLogger(console)
 			buffered.WriteTo(logger)
 			logger.Log(err)
 		}
 	}
 }
 
+// openPacketSources opens the capture requested on the command line, which
+// is read from several sources when capturing with --fanout or on several
+// interfaces.  Capture with --ebpf falls back to libpcap if it is not
+// supported.
+func openPacketSources() ([]capture.PacketSource, error) {
+	if *fanout > 0 {
+		return capture.NewFanout((*netInterfaces)[0], *fanout, *bufferSize, *snapLen, serverPorts(), *tunnels)
+	}
+	if *useEBPF {
+		src, err := capture.NewEBPF((*netInterfaces)[0], *bufferSize, *snapLen, serverPorts(), *tunnels)
+		if err == nil {
+			return []capture.PacketSource{src}, nil
+		}
+		log.Warn(logger, "cannot capture with eBPF, using libpcap:", err)
+	}
+	if len(*netInterfaces) > 1 {
+		if *infile != "" {
+			return nil, capture.ErrAmbiguousSource
+		}
+		return capture.NewMulti(*netInterfaces, *bufferSize, *snapLen, serverPorts(), *tunnels)
+	}
+	var netInterface string
+	if len(*netInterfaces) == 1 {
+		netInterface = (*netInterfaces)[0]
+	}
+	replaySpeed := *speed
+	if *noDelay {
+		replaySpeed = 0
+	}
+	packetSource, err := capture.New(netInterface, *infile, *bufferSize, *snapLen, replaySpeed, serverPorts(), *tunnels)
+	if err != nil {
+		return nil, err
+	}
+	return []capture.PacketSource{packetSource}, nil
+}
+
+// discoverPorts replaces the memcached ports to listen on with those found by
+// watching all TCP traffic on the interface for the --discover window.
+func discoverPorts(l log.Logger) error {
+	if len(*netInterfaces) != 1 || *infile != "" {
+		return errors.New("--discover requires a single --interface")
+	}
+	src, err := capture.NewAllTCP((*netInterfaces)[0], *bufferSize, *snapLen)
+	if err != nil {
+		return err
+	}
+	defer src.(interface{ Close() }).Close()
+	log.Info(l, "watching traffic for", *discoverFor, "to find memcached ports")
+	found, err := discover.Ports(l, src, *discoverFor)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return errors.New("no memcached traffic found")
+	}
+	log.Info(l, "found memcached ports", found)
+	*ports = found
+	return nil
+}
+
+// serverPorts returns the memcached and Redis ports to listen on.
+func serverPorts() []int {
+	return append(append([]int(nil), *ports...), *redisPorts...)
+}
+
+// resetOnSignal clears the activity recorded by analysisPool each time the
+// process receives SIGUSR1, so statistics can be restarted without
+// restarting memsniff.
+func resetOnSignal(analysisPool *analysis.Pool) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1)
+	for range sigChan {
+		analysisPool.Reset()
+		logger.Log("reset statistics at", time.Now().Format(time.RFC3339))
+	}
+}
+
+// blockTimeout returns how long to wait for analysis to catch up before
+// dropping events.  Live capture cannot slow the network down, but a file
+// can be read more slowly without losing data.
+func blockTimeout() time.Duration {
+	if *infile == "" {
+		return 0
+	}
+	return time.Second
+}
+
+// runOffline analyzes every packet from packetSource without dropping any,
+// then prints the busiest keys to stdout.  With --perport, the busiest keys
+// for each port are printed separately.  With --validate, the outcome of
+// decoding each conversation is printed instead.
+func runOffline(packetSource capture.PacketSource, assemblyPool *assembly.Pool, analysisPools map[int]*analysis.Pool) error {
+	err := decode.ReadAll(logger, packetSource, packetHandler(assemblyPool))
+	if err != nil {
+		return err
+	}
+	// conversations still open at the end of the capture
+	assemblyPool.Flush()
+
+	if *dryRun {
+		return printValidation(assemblyPool.Validation())
+	}
+	if !*perPort {
+		return printOffline(analysisPools[serverPorts()[0]])
+	}
+	ports := make([]int, 0, len(analysisPools))
+	for port := range analysisPools {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	for i, port := range ports {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("port %d:\n", port)
+		if err := printOffline(analysisPools[port]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printOffline waits for analysisPool to record all events, then prints its
+// busiest keys to stdout.
+func printOffline(analysisPool *analysis.Pool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	rep, err := analysisPool.Shutdown(ctx)
+	if err != nil {
+		logger.Log("report may be incomplete:", err)
+	}
+	keys := rep.Keys
+	if len(keys) > *reportSize {
+		keys = keys[:*reportSize]
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Key\tRequests (est)\tSize\tBandwidth (est)\tMisses (est)")
+	for _, kr := range keys {
+		name := kr.Label()
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", name, kr.RequestsEstimate, kr.Size, kr.TrafficEstimate, kr.MissesEstimate)
+	}
+	return tw.Flush()
+}
+
+// printValidation prints the outcome of decoding each conversation in fvs
+// to stdout, with the number of events of each type decoded from it.
+func printValidation(fvs []assembly.FlowValidation) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Conversation\tDecoded\tFailed\tLost\tEvents\tLast error")
+	for _, fv := range fvs {
+		evtTypes := make([]int, 0, len(fv.Decoded))
+		decoded := 0
+		for evtType, n := range fv.Decoded {
+			evtTypes = append(evtTypes, int(evtType))
+			decoded += n
+		}
+		sort.Ints(evtTypes)
+		counts := make([]string, len(evtTypes))
+		for i, evtType := range evtTypes {
+			t := model.EventType(evtType)
+			counts[i] = fmt.Sprintf("%s=%d", t, fv.Decoded[t])
+		}
+		lastErr := ""
+		if fv.LastError != nil {
+			lastErr = fv.LastError.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\n", fv.Conn, decoded, fv.Failed, fv.Lost, strings.Join(counts, " "), lastErr)
+	}
+	return tw.Flush()
+}
+
+// roundedPool is an analysis.Pool whose busiest keys are reported with
+// their byte counts rounded by report.RoundBytes.
+type roundedPool struct {
+	*analysis.Pool
+	granularity int
+}
+
+func (p roundedPool) Top(k int, by analysis.Metric) []analysis.KeyReport {
+	return report.RoundBytes(p.Pool.Top(k, by), p.granularity)
+}
+
+func (p roundedPool) TopAndReset(k int, by analysis.Metric) []analysis.KeyReport {
+	return report.RoundBytes(p.Pool.TopAndReset(k, by), p.granularity)
+}
+
+// reporter is fed the periodic reports of the analysis Pool by
+// startReports.
+type reporter interface {
+	Report(rep analysis.Report)
+}
+
+// startReports passes a report of the busiest keys in analysisPool to each
+// of reporters every interval, from a single loop so that every reporter
+// sees the same intervals.  The Pool is reset after each report unless
+// activity is cumulative.  Byte counts are rounded by the roundbytes flag.
+// Nothing is started without reporters, leaving the Pool to be reset only
+// on request.
+func startReports(analysisPool *analysis.Pool, reporters []reporter) {
+	if len(reporters) == 0 {
+		return
+	}
+	analysisPool.ReportEvery(time.Duration(*interval)*time.Second, !*cumulative, func(rep analysis.Report) {
+		rep.Keys = report.RoundBytes(rep.Keys, *roundBytes)
+		for _, r := range reporters {
+			r.Report(rep)
+		}
+	})
+}
+
+// startJSONReport writes reports of the busiest keys to the file named by
+// the json flag in the background.
+func startJSONReport() (*jsonreport.Writer, error) {
+	out := os.Stdout
+	if *jsonOut != "-" {
+		var err error
+		out, err = os.OpenFile(*jsonOut, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	jw := jsonreport.New(out, *reportSize)
+	go func() {
+		if err := jw.Run(); err != nil {
+			logger.Log("JSON report stopped:", err)
+		}
+	}()
+	return jw, nil
+}
+
+// startStream serves reports of the busiest keys to clients of the address
+// given by the stream flag in the background.
+func startStream() (*stream.Server, error) {
+	l, err := net.Listen("tcp", *streamAddr)
+	if err != nil {
+		return nil, err
+	}
+	s := stream.NewServer(*reportSize)
+	go func() {
+		if err := s.Serve(l); err != nil {
+			logger.Log("stream server on", *streamAddr, "stopped:", err)
+		}
+	}()
+	return s, nil
+}
+
+// startCSVReport writes reports of the busiest keys to the file named by
+// the csv flag in the background.
+func startCSVReport() (*csvreport.Writer, error) {
+	out := os.Stdout
+	if *csvOut != "-" {
+		var err error
+		out, err = os.OpenFile(*csvOut, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	cw, err := csvreport.New(out, *reportSize, *csvColumns)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := cw.Run(); err != nil {
+			logger.Log("CSV report stopped:", err)
+		}
+	}()
+	return cw, nil
+}
+
+// httpMuxes holds the handlers to serve on each address.
+var httpMuxes = make(map[string]*http.ServeMux)
+
+// handleHTTP registers handler for pattern on addr, to be served once
+// startHTTP is called.  Handlers may share an address.
+func handleHTTP(addr, pattern string, handler http.Handler) {
+	mux, ok := httpMuxes[addr]
+	if !ok {
+		mux = http.NewServeMux()
+		httpMuxes[addr] = mux
+	}
+	mux.Handle(pattern, handler)
+}
+
+// startHTTP serves all handlers registered with handleHTTP in the
+// background.
+func startHTTP() {
+	for addr, mux := range httpMuxes {
+		addr, mux := addr, mux
+		go func() {
+			logger.Log("HTTP server on", addr, "stopped:", http.ListenAndServe(addr, mux))
+		}()
+	}
+}
+
+// rankMetric returns the Metric corresponding to weightMode.
+func rankMetric(weightMode analysis.WeightMode) analysis.Metric {
+	if weightMode == analysis.WeightCount {
+		return analysis.MetricRequests
+	}
+	return analysis.MetricBytes
+}
+
+func hotlistFactory(name string) (func() hotlist.HotList, error) {
+	switch name {
+	case "perfect":
+		// analysis chooses a perfect hotlist, bounded by maxkeys
+		return nil, nil
+	case "countmin":
+		if *sketchWidth < 1 || *sketchDepth < 1 {
+			return nil, errors.New("--sketchwidth and --sketchdepth must be positive")
+		}
+		return func() hotlist.HotList {
+			return hotlist.NewCountMin(*sketchWidth, *sketchDepth)
+		}, nil
+	case "spacesaving":
+		return func() hotlist.HotList {
+			return hotlist.NewSpaceSaving(*hotlistSize)
+		}, nil
+	case "decaying":
+		return func() hotlist.HotList {
+			return hotlist.NewDecaying(*halfLife)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown hotlist type %q", name)
+	}
+}
+
+func parseDimension(name string) (analysis.Dimension, error) {
+	switch name {
+	case "key":
+		return analysis.DimensionKey, nil
+	case "client":
+		return analysis.DimensionClient, nil
+	case "clientkey":
+		return analysis.DimensionClientKey, nil
+	default:
+		return 0, fmt.Errorf("unknown dimension %q", name)
+	}
+}
+
+// keyNormalizer returns the key normalization requested on the command line,
+// or nil if keys should be reported unchanged.
+func keyNormalizer() (func(string) string, error) {
+	var rules []analysis.NormalizeRule
+	for _, s := range *normRules {
+		r, err := analysis.ParseNormalizeRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	if *normalize {
+		rules = append(rules, analysis.DefaultNormalizeRules...)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return analysis.NewNormalizer(rules), nil
+}
+
+// parseRateRules parses each of rules given to --ratelimit.
+func parseRateRules(rules []string) ([]analysis.RateRule, error) {
+	res := make([]analysis.RateRule, len(rules))
+	for i, s := range rules {
+		r, err := analysis.ParseRateRule(s)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = r
+	}
+	return res, nil
+}
+
+// parseClusters parses the address=name mappings given to --cluster.  It
+// returns nil, combining the activity of all servers, unless mappings are
+// given or byServer is true.
+func parseClusters(mappings []string, byServer bool) (map[string]string, error) {
+	if len(mappings) == 0 && !byServer {
+		return nil, nil
+	}
+	res := make(map[string]string, len(mappings))
+	for _, m := range mappings {
+		i := strings.IndexByte(m, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("cluster %q is not an address=name mapping", m)
+		}
+		ip := net.ParseIP(m[:i])
+		if ip == nil || i == len(m)-1 {
+			return nil, fmt.Errorf("cluster %q is not an address=name mapping", m)
+		}
+		res[ip.String()] = m[i+1:]
+	}
+	return res, nil
+}
+
+// otlpResource returns attributes identifying the source of metrics pushed
+// with --otlp.
+func otlpResource() map[string]string {
+	attrs := make(map[string]string)
+	if host, err := os.Hostname(); err == nil {
+		attrs["host.name"] = host
+	}
+	if len(*netInterfaces) > 0 {
+		attrs["memsniff.interface"] = strings.Join(*netInterfaces, ",")
+	}
+	if *infile != "" {
+		attrs["memsniff.file"] = *infile
+	}
+	portNames := make([]string, len(serverPorts()))
+	for i, port := range serverPorts() {
+		portNames[i] = strconv.Itoa(port)
+	}
+	attrs["memsniff.ports"] = strings.Join(portNames, ",")
+	return attrs
+}
+
+// compilePatterns compiles each of patterns as a regular expression.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, s := range patterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
 var stats presentation.Stats
 
-func statGenerator(captureProvider capture.StatProvider, decodePool *decode.Pool, analysisPool *analysis.Pool) presentation.StatProvider {
+func statGenerator(captureProvider capture.StatProvider, decodePools []*decode.Pool, analysisPool *analysis.Pool) presentation.StatProvider {
 	return func() presentation.Stats {
 		captureStats, err := captureProvider.Stats()
 		if err == nil {
@@ -111,9 +944,13 @@ func statGenerator(captureProvider capture.StatProvider, decodePool *decode.Pool
 			stats.PacketsDroppedKernel = captureStats.PacketsIfDropped + captureStats.PacketsDropped
 		}
 
-		decodeStats := decodePool.Stats()
-		stats.PacketsCaptured = decodeStats.PacketsCaptured
-		stats.PacketsDroppedParser = decodeStats.PacketsDropped
+		stats.PacketsCaptured = 0
+		stats.PacketsDroppedParser = 0
+		for _, decodePool := range decodePools {
+			decodeStats := decodePool.Stats()
+			stats.PacketsCaptured += decodeStats.PacketsCaptured
+			stats.PacketsDroppedParser += decodeStats.PacketsDropped
+		}
 
 		analysisStats := analysisPool.Stats()
 		stats.ResponsesParsed = int(analysisStats.EventsHandled)
@@ -126,11 +963,11 @@ func statGenerator(captureProvider capture.StatProvider, decodePool *decode.Pool
 	}
 }
 
-func packetHandler(analysisPool *analysis.Pool) func(dps []*decode.DecodedPacket) {
-	pool := assembly.New(logger, analysisPool, *ports, *assemblyWorkers)
+func packetHandler(pool *assembly.Pool) func(dps []*decode.DecodedPacket) {
 	return func(dps []*decode.DecodedPacket) {
 		err := pool.HandlePackets(dps)
-		if err != nil {
+		if _, ok := err.(*assembly.DropError); err != nil && !ok {
+			// drops are already logged by the pool
 			logger.Log(err)
 		}
 	}
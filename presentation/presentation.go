@@ -5,7 +5,7 @@ package presentation
 import (
 	"fmt"
 	"github.com/box/memsniff/analysis"
-	"time"
+	"github.com/box/memsniff/report"
 )
 
 // UIHandler is the external API for an interactive user interface.
@@ -15,16 +15,18 @@ type UIHandler interface {
 	Run() error
 	// Log displays a log message to the user.
 	Log(items ...interface{})
+	// Report displays a periodic report of cache activity, unless updates
+	// are paused.  It does not block, so it may be called by
+	// analysis.Pool.ReportEvery.
+	Report(rep analysis.Report)
 }
 
 type uiContext struct {
-	analysis     *analysis.Pool
-	interval     time.Duration
 	statProvider StatProvider
 	messages     []string
 	msgChan      chan string
+	reports      report.Queue
 	prevReport   analysis.Report
-	cumulative   bool
 	paused       bool
 }
 
@@ -50,14 +52,12 @@ type Stats struct {
 type StatProvider func() Stats
 
 // New returns a UIHandler that is ready to run
-func New(analysisPool *analysis.Pool, interval time.Duration, cumulative bool, statProvider StatProvider) UIHandler {
+func New(statProvider StatProvider) UIHandler {
 	return &uiContext{
-		analysis:     analysisPool,
-		interval:     interval,
 		statProvider: statProvider,
 		msgChan:      make(chan string, 128),
+		reports:      report.NewQueue(),
 		prevReport:   analysis.Report{},
-		cumulative:   cumulative,
 		paused:       false,
 	}
 }
@@ -69,3 +69,7 @@ func (u uiContext) Run() error {
 func (u uiContext) Log(items ...interface{}) {
 	u.msgChan <- fmt.Sprintln(items...)
 }
+
+func (u uiContext) Report(rep analysis.Report) {
+	u.reports.Add(rep)
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 	"strconv"
-	"time"
 )
 
 const (
@@ -35,8 +34,6 @@ func (u *uiContext) runTermbox() error {
 }
 
 func (u *uiContext) eventLoop() error {
-	updateTick := time.NewTicker(u.interval)
-	defer updateTick.Stop()
 	events := termboxEvents()
 	if err := u.update(); err != nil {
 		return err
@@ -44,7 +41,10 @@ func (u *uiContext) eventLoop() error {
 
 	for {
 		select {
-		case <-updateTick.C:
+		case rep := <-u.reports:
+			if !u.paused {
+				u.prevReport = rep
+			}
 			if err := u.update(); err != nil {
 				return err
 			}
@@ -125,6 +125,7 @@ func renderHeader() {
 	renderText(8, 0, "Requests (est)")
 	renderText(9, 0, "Size")
 	renderText(10, 0, "Bandwidth (est)")
+	renderText(11, 0, "Misses (est)")
 	renderLine(0, 12, 1, '-')
 }
 
@@ -135,10 +136,12 @@ func renderReport(rep analysis.Report) {
 		if y > lastY {
 			break
 		}
-		renderText(0, y, kr.Name)
+		name := kr.Label()
+		renderText(0, y, name)
 		renderText(8, y, strconv.Itoa(kr.RequestsEstimate))
 		renderText(9, y, strconv.Itoa(kr.Size))
 		renderText(10, y, strconv.Itoa(kr.TrafficEstimate))
+		renderText(11, y, strconv.Itoa(kr.MissesEstimate))
 	}
 }
 
@@ -207,12 +210,6 @@ func (u *uiContext) update() error {
 		return err
 	}
 
-	// Continue to clear the accumulated data every interval even when paused
-	// so we don't get a big burst of data on unpause.
-	rep := u.analysis.Report(!u.cumulative)
-	if !u.paused {
-		u.prevReport = rep
-	}
 	renderHeader()
 	renderReport(u.prevReport)
 	u.renderFooter(u.prevReport)
@@ -0,0 +1,378 @@
+package mcbinary
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+const (
+	debuglevel = 0
+
+	headerLen = 24
+	// longest key permitted by memcached
+	maxKeyLen = 250
+
+	// MagicRequest is the first byte of every binary protocol request.
+	MagicRequest = 0x80
+	// MagicResponse is the first byte of every binary protocol response.
+	MagicResponse = 0x81
+
+	statusKeyNotFound = 0x0001
+	statusKeyExists   = 0x0002
+	statusNotStored   = 0x0005
+)
+
+// request opcodes
+const (
+	opGet       = 0x00
+	opSet       = 0x01
+	opAdd       = 0x02
+	opReplace   = 0x03
+	opDelete    = 0x04
+	opIncrement = 0x05
+	opDecrement = 0x06
+	opQuit      = 0x07
+	opFlush     = 0x08
+	opGetQ      = 0x09
+	opNoop      = 0x0a
+	opVersion   = 0x0b
+	opGetK      = 0x0c
+	opGetKQ     = 0x0d
+	opAppend    = 0x0e
+	opPrepend   = 0x0f
+	opStat      = 0x10
+	opSetQ      = 0x11
+	opAddQ      = 0x12
+	opReplaceQ  = 0x13
+	opDeleteQ   = 0x14
+	opIncrQ     = 0x15
+	opDecrQ     = 0x16
+	opQuitQ     = 0x17
+	opFlushQ    = 0x18
+	opAppendQ   = 0x19
+	opPrependQ  = 0x1a
+	opGAT       = 0x1d
+	opGATQ      = 0x1e
+	opGATK      = 0x23
+	opGATKQ     = 0x24
+)
+
+var errProtocolDesync = errors.New("protocol desync in binary conversation")
+
+// header is the fixed-length header common to requests and responses.
+type header struct {
+	magic     byte
+	opcode    byte
+	keyLen    int
+	extrasLen int
+	// vbucket id for requests, status for responses
+	status  uint16
+	bodyLen int
+	opaque  uint32
+}
+
+func parseHeader(b []byte) header {
+	return header{
+		magic:     b[0],
+		opcode:    b[1],
+		keyLen:    int(binary.BigEndian.Uint16(b[2:4])),
+		extrasLen: int(b[4]),
+		status:    binary.BigEndian.Uint16(b[6:8]),
+		bodyLen:   int(binary.BigEndian.Uint32(b[8:12])),
+		opaque:    binary.BigEndian.Uint32(b[12:16]),
+	}
+}
+
+func (h header) valueLen() int {
+	return h.bodyLen - h.extrasLen - h.keyLen
+}
+
+// pendingRequest is a request that may still receive a response.
+type pendingRequest struct {
+	opcode byte
+	opaque uint32
+	key    string
+	// expiration time set by get-and-touch
+	ttl int
+	// size of the value sent by a storage command
+	size int
+}
+
+// Consumer generates events based on a memcached binary protocol conversation.
+//
+// Requests are read from the client until one is seen that always receives
+// a response, then responses are read from the server until that response
+// arrives.  Quiet requests in between only receive a response on a hit or an
+// error; the server answers requests in order, so a quiet get that was
+// passed over without a response is a miss.
+type Consumer struct {
+	*model.Consumer
+	hdr     header
+	pending []pendingRequest
+}
+
+// NewConsumer returns a Consumer for a connection known to use the binary
+// protocol.
+func NewConsumer(logger log.Logger, handler model.EventHandler) *model.Consumer {
+	mc := model.New(logger, handler)
+	Attach(mc)
+	return mc
+}
+
+// Attach decodes the conversation buffered in mc as the binary protocol,
+// starting with the next client request.
+func Attach(mc *model.Consumer) {
+	c := &Consumer{Consumer: mc}
+	mc.Run = c.run
+	mc.State = c.readRequestHeader
+}
+
+func (c *Consumer) run() {
+	for {
+		err := c.State()
+		switch err {
+		case nil:
+			continue
+		case reader.ErrShortRead, io.EOF:
+			return
+		default:
+			// without framing there is no way to find the next request
+			c.log(2, "abandoning connection after error:", err)
+			c.EndBatch()
+			c.Consumer.Close()
+			c.ReportError(err)
+			return
+		}
+	}
+}
+
+func (c *Consumer) readRequestHeader() error {
+	b, err := c.ClientReader.ReadN(headerLen)
+	if err != nil {
+		return err
+	}
+	c.hdr = parseHeader(b)
+	if c.hdr.magic != MagicRequest || !c.validLengths() {
+		return errProtocolDesync
+	}
+	c.State = c.readRequestBody
+	return nil
+}
+
+func (c *Consumer) readRequestBody() error {
+	b, err := c.ClientReader.ReadN(c.hdr.extrasLen + c.hdr.keyLen)
+	if err != nil {
+		return err
+	}
+	extras := b[:c.hdr.extrasLen]
+	key := string(b[c.hdr.extrasLen:])
+	c.handleRequest(extras, key)
+	_, err = c.ClientReader.Discard(c.hdr.valueLen())
+	if err != nil {
+		return err
+	}
+
+	req := pendingRequest{opcode: c.hdr.opcode, opaque: c.hdr.opaque, key: key, size: c.hdr.valueLen()}
+	if isTouch(req.opcode) && len(extras) >= 4 {
+		req.ttl = int(int32(binary.BigEndian.Uint32(extras[:4])))
+	}
+	if c.RequestsOnly {
+		if isGet(req.opcode) {
+			c.addEvent(model.Event{Type: model.EventGet, Key: key, TTL: req.ttl})
+		}
+		c.State = c.readRequestHeader
+		return nil
+	}
+	c.pending = append(c.pending, req)
+	if isQuiet(c.hdr.opcode) {
+		c.State = c.readRequestHeader
+	} else {
+		c.State = c.readResponseHeader
+	}
+	return nil
+}
+
+func (c *Consumer) handleRequest(extras []byte, key string) {
+	switch c.hdr.opcode {
+	case opSet, opAdd, opReplace, opSetQ, opAddQ, opReplaceQ:
+		evt := model.Event{Type: model.EventSet, Key: key, Size: c.hdr.valueLen()}
+		// extras are flags followed by expiration
+		if len(extras) >= 8 {
+			evt.TTL = int(int32(binary.BigEndian.Uint32(extras[4:8])))
+		}
+		c.addEvent(evt)
+	case opAppend, opPrepend, opAppendQ, opPrependQ:
+		// no extras, and the expiration is left unchanged
+		c.addEvent(model.Event{Type: model.EventSet, Key: key, Size: c.hdr.valueLen(), KeepsTTL: true})
+	case opDelete, opDeleteQ:
+		c.addEvent(model.Event{Type: model.EventDelete, Key: key})
+	case opFlush, opFlushQ:
+		c.addEvent(model.Event{Type: model.EventFlush})
+	case opIncrement, opIncrQ, opDecrement, opDecrQ:
+		if len(extras) < 8 {
+			return
+		}
+		evtType := model.EventIncr
+		if c.hdr.opcode == opDecrement || c.hdr.opcode == opDecrQ {
+			evtType = model.EventDecr
+		}
+		delta := binary.BigEndian.Uint64(extras[:8])
+		c.addEvent(model.Event{Type: evtType, Key: key, Size: model.DeltaSize(delta)})
+	}
+}
+
+func (c *Consumer) readResponseHeader() error {
+	b, err := c.ServerReader.ReadN(headerLen)
+	if err != nil {
+		return err
+	}
+	c.hdr = parseHeader(b)
+	if c.hdr.magic != MagicResponse || !c.validLengths() {
+		return errProtocolDesync
+	}
+	// deliver events for all gets answered by this response together
+	c.BeginBatch()
+	c.State = c.readResponseBody
+	return nil
+}
+
+func (c *Consumer) readResponseBody() error {
+	b, err := c.ServerReader.ReadN(c.hdr.extrasLen + c.hdr.keyLen)
+	if err != nil {
+		return err
+	}
+	key := string(b[c.hdr.extrasLen:])
+	_, err = c.ServerReader.Discard(c.hdr.valueLen())
+	if err != nil {
+		return err
+	}
+
+	i := c.matchResponse(key)
+	if i < 0 {
+		return errProtocolDesync
+	}
+	// quiet gets skipped by the server before this response were misses
+	for _, req := range c.pending[:i] {
+		if isGet(req.opcode) {
+			c.addEvent(model.Event{Type: model.EventGetMiss, Key: req.key, TTL: req.ttl})
+		}
+	}
+	req := c.pending[i]
+	if isGet(req.opcode) {
+		switch c.hdr.status {
+		case 0:
+			c.addEvent(model.Event{Type: model.EventGetHit, Key: req.key, Size: c.hdr.valueLen(), TTL: req.ttl})
+		case statusKeyNotFound:
+			c.addEvent(model.Event{Type: model.EventGetMiss, Key: req.key, TTL: req.ttl})
+		}
+	}
+	if isStore(req.opcode) && c.hdr.status != 0 {
+		// the EventSet was emitted with the request
+		c.addEvent(model.Event{Type: model.EventStoreFailed, Key: req.key, Size: req.size, Result: storeResult(c.hdr.status)})
+	}
+
+	if i == len(c.pending)-1 {
+		// the request that always receives a response has been answered
+		c.pending = c.pending[:0]
+		c.EndBatch()
+		c.State = c.readRequestHeader
+	} else {
+		c.pending = c.pending[i+1:]
+		c.State = c.readResponseHeader
+	}
+	return nil
+}
+
+// matchResponse returns the index of the pending request answered by the
+// current response, or -1 if none match.  Clients often leave the opaque
+// field zero, so the key is also compared when the server returns one.
+func (c *Consumer) matchResponse(key string) int {
+	for i, req := range c.pending {
+		if req.opcode != c.hdr.opcode || req.opaque != c.hdr.opaque {
+			continue
+		}
+		if key != "" && key != req.key {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// validLengths returns whether the lengths in the current header are
+// consistent with each other.
+func (c *Consumer) validLengths() bool {
+	return c.hdr.keyLen <= maxKeyLen && c.hdr.valueLen() >= 0
+}
+
+func isGet(opcode byte) bool {
+	switch opcode {
+	case opGet, opGetQ, opGetK, opGetKQ, opGAT, opGATQ, opGATK, opGATKQ:
+		return true
+	default:
+		return false
+	}
+}
+
+// isStore returns whether opcode is a storage command, reported as an
+// EventSet.
+func isStore(opcode byte) bool {
+	switch opcode {
+	case opSet, opAdd, opReplace, opAppend, opPrepend,
+		opSetQ, opAddQ, opReplaceQ, opAppendQ, opPrependQ:
+		return true
+	default:
+		return false
+	}
+}
+
+// storeResult returns the result of a storage command that failed with
+// status.
+func storeResult(status uint16) model.StoreResult {
+	switch status {
+	case statusKeyNotFound:
+		return model.StoreNotFound
+	case statusKeyExists:
+		return model.StoreExists
+	case statusNotStored:
+		return model.StoreNotStored
+	default:
+		return model.StoreError
+	}
+}
+
+// isTouch returns whether opcode is a get that also sets the expiration.
+func isTouch(opcode byte) bool {
+	switch opcode {
+	case opGAT, opGATQ, opGATK, opGATKQ:
+		return true
+	default:
+		return false
+	}
+}
+
+// isQuiet returns whether opcode only receives a response in some cases.
+func isQuiet(opcode byte) bool {
+	switch opcode {
+	case opGetQ, opGetKQ, opSetQ, opAddQ, opReplaceQ, opDeleteQ, opIncrQ,
+		opDecrQ, opQuitQ, opFlushQ, opAppendQ, opPrependQ, opGATQ, opGATKQ:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Consumer) addEvent(evt model.Event) {
+	c.Consumer.AddEvent(evt)
+}
+
+func (c *Consumer) log(level int, items ...interface{}) {
+	if c.Logger != nil && debuglevel >= level {
+		c.Logger.Log(items...)
+	}
+}
@@ -0,0 +1,187 @@
+package mcbinary
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+func TestBinaryGet(t *testing.T) {
+	client := [][]byte{
+		packet(MagicRequest, opGet, 0, 1, nil, "key1", ""),
+		packet(MagicRequest, opGet, 0, 2, nil, "key2", ""),
+	}
+	server := [][]byte{
+		packet(MagicResponse, opGet, 0, 1, make([]byte, 4), "", "hello"),
+		packet(MagicResponse, opGet, statusKeyNotFound, 2, nil, "", "Not found"),
+	}
+	testReadBinary(t, client, server, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key2"},
+	})
+}
+
+func TestBinaryQuietMultiGet(t *testing.T) {
+	client := [][]byte{
+		packet(MagicRequest, opGetKQ, 0, 0, nil, "key1", ""),
+		packet(MagicRequest, opGetKQ, 0, 0, nil, "key2", ""),
+		packet(MagicRequest, opGetKQ, 0, 0, nil, "key3", ""),
+		packet(MagicRequest, opNoop, 0, 0, nil, "", ""),
+	}
+	server := [][]byte{
+		packet(MagicResponse, opGetKQ, 0, 0, make([]byte, 4), "key2", "world"),
+		packet(MagicResponse, opNoop, 0, 0, nil, "", ""),
+	}
+	testReadBinary(t, client, server, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetHit, Key: "key2", Size: 5},
+		{Type: model.EventGetMiss, Key: "key3"},
+	})
+}
+
+func TestBinaryGetAndTouch(t *testing.T) {
+	exptime := make([]byte, 4)
+	binary.BigEndian.PutUint32(exptime, 300)
+	client := [][]byte{
+		packet(MagicRequest, opGATKQ, 0, 0, exptime, "key1", ""),
+		packet(MagicRequest, opGAT, 0, 0, exptime, "key2", ""),
+	}
+	server := [][]byte{
+		packet(MagicResponse, opGAT, 0, 0, make([]byte, 4), "", "hi"),
+	}
+	testReadBinary(t, client, server, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1", TTL: 300},
+		{Type: model.EventGetHit, Key: "key2", Size: 2, TTL: 300},
+	})
+}
+
+func TestBinaryStorage(t *testing.T) {
+	delta := make([]byte, 20)
+	binary.BigEndian.PutUint64(delta, 3)
+	setExtras := make([]byte, 8)
+	binary.BigEndian.PutUint32(setExtras[4:], 60)
+	client := [][]byte{
+		packet(MagicRequest, opSetQ, 0, 0, setExtras, "key1", "abc"),
+		packet(MagicRequest, opDelete, 0, 0, nil, "key2", ""),
+		packet(MagicRequest, opDecrement, 0, 0, delta, "key3", ""),
+	}
+	server := [][]byte{
+		packet(MagicResponse, opDelete, 0, 0, nil, "", ""),
+		packet(MagicResponse, opDecrement, 0, 0, nil, "", "\x00\x00\x00\x00\x00\x00\x00\x07"),
+	}
+	testReadBinary(t, client, server, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 3, TTL: 60},
+		{Type: model.EventDelete, Key: "key2"},
+		{Type: model.EventDecr, Key: "key3", Size: 3},
+	})
+}
+
+func TestBinaryLargeDelta(t *testing.T) {
+	delta := make([]byte, 20)
+	binary.BigEndian.PutUint64(delta, 1<<63+1)
+	client := [][]byte{
+		packet(MagicRequest, opIncrement, 0, 0, delta, "key1", ""),
+	}
+	server := [][]byte{
+		packet(MagicResponse, opIncrement, 0, 0, nil, "", "\x80\x00\x00\x00\x00\x00\x00\x01"),
+	}
+	// deltas beyond an int are clamped rather than wrapping negative
+	testReadBinary(t, client, server, []model.Event{
+		{Type: model.EventIncr, Key: "key1", Size: int(^uint(0) >> 1)},
+	})
+}
+
+func TestBinaryStoreFailures(t *testing.T) {
+	client := [][]byte{
+		packet(MagicRequest, opAddQ, 0, 1, make([]byte, 8), "key1", "abc"),
+		packet(MagicRequest, opSetQ, 0, 2, make([]byte, 8), "key2", "de"),
+		packet(MagicRequest, opAppend, 0, 3, nil, "key3", "f"),
+	}
+	server := [][]byte{
+		packet(MagicResponse, opAddQ, statusKeyExists, 1, nil, "", "Data exists for key."),
+		packet(MagicResponse, opAppend, statusNotStored, 3, nil, "", "Not stored."),
+	}
+	testReadBinary(t, client, server, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 3},
+		{Type: model.EventSet, Key: "key2", Size: 2},
+		{Type: model.EventSet, Key: "key3", Size: 1, KeepsTTL: true},
+		{Type: model.EventStoreFailed, Key: "key1", Size: 3, Result: model.StoreExists},
+		{Type: model.EventStoreFailed, Key: "key3", Size: 1, Result: model.StoreNotStored},
+	})
+}
+
+func TestBinaryRequestsOnly(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	r.RequestsOnly = true
+	for _, p := range [][]byte{
+		packet(MagicRequest, opGetQ, 0, 1, nil, "key1", ""),
+		packet(MagicRequest, opGet, 0, 2, nil, "key2", ""),
+		packet(MagicRequest, opDelete, 0, 3, nil, "key3", ""),
+	} {
+		r.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: p}})
+	}
+	r.ClientStream().ReassemblyComplete()
+
+	expected := []model.Event{
+		{Type: model.EventGet, Key: "key1"},
+		{Type: model.EventGet, Key: "key2"},
+		{Type: model.EventDelete, Key: "key3"},
+	}
+	if len(evts) != len(expected) {
+		t.Fatal("expected", expected, "got", evts)
+	}
+	for i := range evts {
+		if evts[i] != expected[i] {
+			t.Error("expected", expected[i], "got", evts[i])
+		}
+	}
+}
+
+func testReadBinary(t *testing.T, client, server [][]byte, expected []model.Event) {
+	handler := func(evts []model.Event) {
+		for _, e := range evts {
+			if len(expected) == 0 {
+				t.Error("Unexpected", e)
+				continue
+			}
+			if e != expected[0] {
+				t.Error("Expected", expected[0], "got", e)
+			}
+			expected = expected[1:]
+		}
+	}
+	r := NewConsumer(&log.ConsoleLogger{}, handler)
+
+	for _, p := range client {
+		r.ClientStream().Reassembled([]tcpassembly.Reassembly{{Bytes: p}})
+	}
+	for _, p := range server {
+		r.ServerStream().Reassembled([]tcpassembly.Reassembly{{Bytes: p}})
+	}
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	if len(expected) > 0 {
+		t.Error("Expected", expected, "events but never received")
+	}
+}
+
+func packet(magic, opcode byte, status uint16, opaque uint32, extras []byte, key, value string) []byte {
+	p := make([]byte, headerLen, headerLen+len(extras)+len(key)+len(value))
+	p[0] = magic
+	p[1] = opcode
+	binary.BigEndian.PutUint16(p[2:4], uint16(len(key)))
+	p[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(p[6:8], status)
+	binary.BigEndian.PutUint32(p[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(p[12:16], opaque)
+	p = append(p, extras...)
+	p = append(p, key...)
+	return append(p, value...)
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/box/memsniff/assembly/reader"
 	"github.com/box/memsniff/log"
@@ -18,7 +19,7 @@ const (
 )
 
 var (
-	asciiRe, _        = regexp.Compile(`^[a-zA-Z]+$`)
+	asciiRe, _        = regexp.Compile(`^[a-zA-Z_]+$`)
 	errProtocolDesync = errors.New("protocol desync while reading command")
 )
 
@@ -27,15 +28,33 @@ type Consumer struct {
 	*model.Consumer
 	cmd  string
 	args []string
+	// when the current command was seen, to measure response latency
+	requested time.Time
+	// index of the first requested key not yet matched against a response
+	nextKey int
+	// expiration time set by the current get-and-touch command
+	touchTTL int
+	// the storage command awaiting a response
+	store model.Event
+	// a get hit whose value has not yet been completely received
+	hit        model.Event
+	hitPending bool
+	// bytes lost from the server stream before the pending hit's value
+	hitLost int
 }
 
 func NewConsumer(logger log.Logger, handler model.EventHandler) *model.Consumer {
-	c := Consumer{
-		Consumer: model.New(logger, handler),
-	}
-	c.Consumer.Run = c.run
-	c.Consumer.State = c.peekMagicByte
-	return c.Consumer
+	mc := model.New(logger, handler)
+	Attach(mc)
+	return mc
+}
+
+// Attach decodes the conversation buffered in mc as the text protocol,
+// starting with the next client command.
+func Attach(mc *model.Consumer) {
+	c := &Consumer{Consumer: mc}
+	mc.Run = c.run
+	mc.State = c.peekMagicByte
 }
 
 func (c *Consumer) run() {
@@ -49,9 +68,12 @@ func (c *Consumer) run() {
 		default:
 			// data lost or protocol error, try to resync at the next command
 			c.log(2, "trying to resync after error:", err)
+			c.hitPending = false
+			c.EndBatch()
 			c.ClientReader.Reset()
 			c.ServerReader.Reset()
 			c.State = c.readCommand
+			c.ReportError(err)
 			return
 		}
 	}
@@ -80,13 +102,22 @@ func (c *Consumer) peekMagicByte() error {
 
 func (c *Consumer) readCommand() error {
 	c.args = c.args[:0]
-	c.ServerReader.Truncate()
+	c.nextKey = 0
+	c.touchTTL = 0
+	c.hitPending = false
 	c.log(3, "reading command")
 	pos, err := c.ClientReader.IndexAny(" \n")
 	if err != nil {
+		// With no complete command waiting, data from the server cannot
+		// answer any command seen.  Pipelined commands are already
+		// buffered, so their responses are kept to be read in order.
+		c.ServerReader.Truncate()
 		return err
 	}
 
+	// pipelined commands wait in ClientReader, so the command is timed
+	// from when it arrived rather than when it is read
+	c.requested = c.RequestSeen()
 	cmd, err := c.ClientReader.ReadN(pos + 1)
 	if err != nil {
 		return err
@@ -98,7 +129,12 @@ func (c *Consumer) readCommand() error {
 		return errProtocolDesync
 	}
 
-	if c.commandState() != nil {
+	if state := c.commandState(); state != nil {
+		if cmd[len(cmd)-1] == '\n' {
+			// no arguments follow
+			c.State = state
+			return nil
+		}
 		c.State = c.readArgs
 		return nil
 	}
@@ -112,8 +148,16 @@ func (c *Consumer) commandState() model.State {
 	switch c.cmd {
 	case "get", "gets":
 		return c.handleGet
+	case "gat", "gats":
+		return c.handleGat
 	case "set", "add", "replace", "append", "prepend", "cas":
 		return c.handleSet
+	case "delete":
+		return c.handleDelete
+	case "incr", "decr":
+		return c.handleArith
+	case "flush_all":
+		return c.handleFlush
 	case "quit":
 		return c.handleQuit
 	default:
@@ -122,9 +166,10 @@ func (c *Consumer) commandState() model.State {
 }
 
 func (c *Consumer) readArgs() error {
-	c.ServerReader.Truncate()
 	pos, err := c.ClientReader.IndexAny(" \n")
 	if err != nil {
+		// as for readCommand
+		c.ServerReader.Truncate()
 		return err
 	}
 	word, err := c.ClientReader.ReadN(pos + 1)
@@ -145,7 +190,25 @@ func (c *Consumer) handleGet() error {
 	if len(c.args) < 1 {
 		return c.discardResponse()
 	}
+	// deliver events for all requested keys together
+	c.BeginBatch()
+	if c.RequestsOnly {
+		for _, key := range c.args {
+			c.addEvent(model.Event{Type: model.EventGet, Key: key, TTL: c.touchTTL})
+		}
+		c.EndBatch()
+		c.State = c.readCommand
+		return nil
+	}
 	for {
+		if c.hitPending {
+			if c.ServerReader.Skipping() > 0 {
+				return reader.ErrShortRead
+			}
+			c.hitPending = false
+			c.hit.Truncated = c.ServerReader.Lost() > c.hitLost
+			c.addResponseEvent(c.hit)
+		}
 		c.log(3, "awaiting server reply to get for", len(c.args), "keys")
 		line, err := c.ServerReader.ReadLine()
 		if err != nil {
@@ -154,31 +217,80 @@ func (c *Consumer) handleGet() error {
 		c.log(3, "server reply:", string(line))
 		fields := bytes.Split(line, []byte(" "))
 		if len(fields) >= 4 && bytes.Equal(fields[0], []byte("VALUE")) {
-			key := fields[1]
+			key := string(fields[1])
 			size, err := strconv.Atoi(string(fields[3]))
 			if err != nil {
 				return err
 			}
-			evt := model.Event{
+			c.addMissesBefore(key)
+			// the value may span several packets, so the hit is only
+			// reported once all of it has arrived
+			c.hit = model.Event{
 				Type: model.EventGetHit,
-				Key:  string(key),
+				Key:  key,
 				Size: size,
+				TTL:  c.touchTTL,
 			}
-			// c.log("sending event:", evt)
-			c.addEvent(evt)
-			// c.log("discarding value")
+			c.hitPending = true
+			c.hitLost = c.ServerReader.Lost()
 			_, err = c.ServerReader.Discard(size + len(crlf))
 			if err != nil {
 				return err
 			}
-			// c.log("discarded value")
 		} else {
+			if bytes.Equal(line, []byte("END")) {
+				c.addRemainingMisses()
+			}
+			c.EndBatch()
 			c.State = c.readCommand
 			return nil
 		}
 	}
 }
 
+// handleGat handles get-and-touch, which is read like a get once the
+// expiration time preceding the keys is removed.
+func (c *Consumer) handleGat() error {
+	if len(c.args) < 2 {
+		return c.discardResponse()
+	}
+	ttl, err := strconv.Atoi(c.args[0])
+	if err != nil {
+		return c.discardResponse()
+	}
+	c.touchTTL = ttl
+	c.args = append(c.args[:0], c.args[1:]...)
+	// the keys must not be shifted again if the response is incomplete
+	c.State = c.handleGet
+	return nil
+}
+
+// addMissesBefore emits an EventGetMiss for each requested key that the
+// server skipped before returning a value for key.  The server returns
+// values in the order they were requested.  If key was not requested
+// (e.g. after a desync), no misses are emitted.
+func (c *Consumer) addMissesBefore(key string) {
+	for i := c.nextKey; i < len(c.args); i++ {
+		if c.args[i] != key {
+			continue
+		}
+		for _, missed := range c.args[c.nextKey:i] {
+			c.addResponseEvent(model.Event{Type: model.EventGetMiss, Key: missed, TTL: c.touchTTL})
+		}
+		c.nextKey = i + 1
+		return
+	}
+}
+
+// addRemainingMisses emits an EventGetMiss for every requested key not yet
+// returned by the server.
+func (c *Consumer) addRemainingMisses() {
+	for _, missed := range c.args[c.nextKey:] {
+		c.addResponseEvent(model.Event{Type: model.EventGetMiss, Key: missed, TTL: c.touchTTL})
+	}
+	c.nextKey = len(c.args)
+}
+
 func (c *Consumer) handleSet() error {
 	if len(c.args) < 4 {
 		return c.discardResponse()
@@ -187,12 +299,125 @@ func (c *Consumer) handleSet() error {
 	if err != nil {
 		return c.discardResponse()
 	}
+	evt := model.Event{Type: model.EventSet, Key: c.args[0], Size: size}
+	if c.cmd == "append" || c.cmd == "prepend" {
+		// the expiration sent is ignored
+		evt.KeepsTTL = true
+	} else {
+		evt.TTL, err = strconv.Atoi(c.args[2])
+		if err != nil {
+			return c.discardResponse()
+		}
+	}
+	c.store = evt
+	// skip the data block so it is not mistaken for the next command
 	c.log(3, "discarding", size+len(crlf), "from client")
 	_, err = c.ClientReader.Discard(size + len(crlf))
 	if err != nil {
 		return err
 	}
-	c.log(3, "discarding response from server")
+	if c.cmd != "cas" {
+		// reported now, so that it counts even if the response is never
+		// seen, but the outcome of a cas is unknown without a response
+		c.addEvent(evt)
+	}
+	if c.args[len(c.args)-1] == "noreply" || c.RequestsOnly {
+		c.State = c.readCommand
+		return nil
+	}
+	c.State = c.readStoreResponse
+	return nil
+}
+
+// readStoreResponse emits an event for the outcome of a cas, or for a
+// storage command that failed.
+func (c *Consumer) readStoreResponse() error {
+	line, err := c.ServerReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	evt := c.store
+	evt.Result = storeResult(line)
+	switch {
+	case c.cmd != "cas":
+		evt.Type = model.EventUnknown
+		if evt.Result.Failed() {
+			evt.Type = model.EventStoreFailed
+		}
+	case evt.Result == model.StoreStored:
+		evt.Type = model.EventCASStored
+	case evt.Result == model.StoreExists:
+		evt.Type = model.EventCASExists
+	case evt.Result == model.StoreNotFound:
+		evt.Type = model.EventCASNotFound
+	default:
+		evt.Type = model.EventUnknown
+	}
+	if evt.Type != model.EventUnknown {
+		c.addResponseEvent(evt)
+	}
+	c.State = c.readCommand
+	return nil
+}
+
+// storeResult returns the result of a storage command given the server's
+// response line.
+func storeResult(line []byte) model.StoreResult {
+	switch {
+	case bytes.Equal(line, []byte("STORED")):
+		return model.StoreStored
+	case bytes.Equal(line, []byte("NOT_STORED")):
+		return model.StoreNotStored
+	case bytes.Equal(line, []byte("EXISTS")):
+		return model.StoreExists
+	case bytes.Equal(line, []byte("NOT_FOUND")):
+		return model.StoreNotFound
+	case bytes.HasSuffix(bytes.SplitN(line, []byte(" "), 2)[0], []byte("ERROR")):
+		// ERROR, CLIENT_ERROR or SERVER_ERROR with a message
+		return model.StoreError
+	default:
+		return model.StoreUnknown
+	}
+}
+
+func (c *Consumer) handleDelete() error {
+	if len(c.args) < 1 {
+		return c.discardResponse()
+	}
+	c.addEvent(model.Event{Type: model.EventDelete, Key: c.args[0]})
+	if c.args[len(c.args)-1] == "noreply" {
+		c.State = c.readCommand
+		return nil
+	}
+	return c.discardResponse()
+}
+
+func (c *Consumer) handleArith() error {
+	if len(c.args) < 2 {
+		return c.discardResponse()
+	}
+	delta, err := strconv.ParseUint(c.args[1], 10, 64)
+	if err != nil {
+		return c.discardResponse()
+	}
+	evtType := model.EventIncr
+	if c.cmd == "decr" {
+		evtType = model.EventDecr
+	}
+	c.addEvent(model.Event{Type: evtType, Key: c.args[0], Size: model.DeltaSize(delta)})
+	if c.args[len(c.args)-1] == "noreply" {
+		c.State = c.readCommand
+		return nil
+	}
+	return c.discardResponse()
+}
+
+func (c *Consumer) handleFlush() error {
+	c.addEvent(model.Event{Type: model.EventFlush})
+	if len(c.args) > 0 && c.args[len(c.args)-1] == "noreply" {
+		c.State = c.readCommand
+		return nil
+	}
 	return c.discardResponse()
 }
 
@@ -210,6 +435,10 @@ func (c *Consumer) handleUnknown() error {
 }
 
 func (c *Consumer) discardResponse() error {
+	if c.RequestsOnly {
+		c.State = c.readCommand
+		return nil
+	}
 	c.State = c.discardResponse
 	c.log(3, "discarding response from server")
 	line, err := c.ServerReader.ReadLine()
@@ -225,6 +454,15 @@ func (c *Consumer) addEvent(evt model.Event) {
 	c.Consumer.AddEvent(evt)
 }
 
+// addResponseEvent adds evt, completed by the server's response to the
+// current command, with the time the server took to respond.
+func (c *Consumer) addResponseEvent(evt model.Event) {
+	if seen := c.Seen(); !c.requested.IsZero() && !seen.Before(c.requested) {
+		evt.Latency = seen.Sub(c.requested)
+	}
+	c.addEvent(evt)
+}
+
 func (c *Consumer) log(level int, items ...interface{}) {
 	if c.Logger != nil && debuglevel >= level {
 		c.Logger.Log(items...)
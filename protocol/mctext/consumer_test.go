@@ -1,7 +1,10 @@
 package mctext
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/box/memsniff/log"
 	"github.com/box/memsniff/protocol/model"
@@ -16,8 +19,8 @@ func TestTextMulti(t *testing.T) {
 		"world",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key1", 5},
-		{model.EventGetHit, "key2", 5},
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetHit, Key: "key2", Size: 5},
 	})
 }
 
@@ -27,7 +30,7 @@ func TestTextEmptyValue(t *testing.T) {
 		"",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key3|foo", 0},
+		{Type: model.EventGetHit, Key: "key3|foo", Size: 0},
 	})
 }
 
@@ -38,20 +41,354 @@ func TestTextIncompleteHeader(t *testing.T) {
 		"VALUE ",
 	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key1", 5},
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
 	})
 }
 
 func TestTextIncompleteBody(t *testing.T) {
 	lines := []string{
 		"VALUE key1 42 5",
+		"world",
+		"VALUE key2 42 5",
 		"wor",
 	}
+	// the value of key2 never arrives in full
+	testReadText(t, lines, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+	})
+}
+
+func TestTextMisses(t *testing.T) {
+	lines := []string{
+		"VALUE key2 0 5",
+		"hello",
+		"END",
+	}
+	testReadText(t, lines, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetHit, Key: "key2", Size: 5},
+		{Type: model.EventGetMiss, Key: "key3"},
+	})
+}
+
+func TestTextAllMisses(t *testing.T) {
+	lines := []string{
+		"END",
+	}
 	testReadText(t, lines, []model.Event{
-		{model.EventGetHit, "key1", 5},
+		{Type: model.EventGetMiss, Key: "key1"},
+		{Type: model.EventGetMiss, Key: "key2"},
+		{Type: model.EventGetMiss, Key: "key3"},
 	})
 }
 
+func TestTextMultiSingleBatch(t *testing.T) {
+	var keys []string
+	var lines []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprint("key", i)
+		keys = append(keys, key)
+		if i%2 == 0 {
+			lines = append(lines, "VALUE "+key+" 0 1", "x")
+		}
+	}
+	lines = append(lines, "END")
+
+	var batches [][]model.Event
+	handler := func(evts []model.Event) {
+		if len(evts) > 0 {
+			batches = append(batches, append([]model.Event(nil), evts...))
+		}
+	}
+	r := NewConsumer(&log.ConsoleLogger{}, handler)
+	r.ClientStream().Reassembled(reassemblyString("get " + strings.Join(keys, " ") + "\r\n"))
+	for _, l := range lines {
+		r.ServerStream().Reassembled(reassemblyString(l + "\r\n"))
+	}
+
+	if len(batches) != 1 || len(batches[0]) != 20 {
+		t.Fatal("expected a single batch of 20 events, got", batches)
+	}
+	for i, e := range batches[0] {
+		if e.Key != keys[i] || (e.Type == model.EventGetHit) != (i%2 == 0) {
+			t.Error("unexpected event", i, e)
+		}
+	}
+}
+
+func TestTextGat(t *testing.T) {
+	client := []string{
+		"gat 60 key1 key2 key3",
+		"gats 0 key4",
+	}
+	server := []string{
+		"VALUE key2 0 5",
+		"hello",
+		"END",
+		"VALUE key4 0 2 7",
+		"hi",
+		"END",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1", TTL: 60},
+		{Type: model.EventGetHit, Key: "key2", Size: 5, TTL: 60},
+		{Type: model.EventGetMiss, Key: "key3", TTL: 60},
+		{Type: model.EventGetHit, Key: "key4", Size: 2},
+	})
+}
+
+func TestTextSet(t *testing.T) {
+	client := []string{
+		"set key1 0 0 5",
+		"hello",
+		"add key2 0 300 3 noreply",
+		"abc",
+		"replace key5 0 -1 1",
+		"x",
+		"cas key3 0 0 2 99",
+		"hi",
+		"add key6 0 0 1",
+		"y",
+		"append key7 0 0 1",
+		"z",
+		"get key4",
+	}
+	server := []string{
+		"STORED",
+		"STORED",
+		"STORED",
+		"NOT_STORED",
+		"SERVER_ERROR out of memory storing object",
+		"END",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 5},
+		{Type: model.EventSet, Key: "key2", Size: 3, TTL: 300},
+		{Type: model.EventSet, Key: "key5", Size: 1, TTL: -1},
+		{Type: model.EventCASStored, Key: "key3", Size: 2, Result: model.StoreStored},
+		{Type: model.EventSet, Key: "key6", Size: 1},
+		{Type: model.EventStoreFailed, Key: "key6", Size: 1, Result: model.StoreNotStored},
+		{Type: model.EventSet, Key: "key7", Size: 1, KeepsTTL: true},
+		{Type: model.EventStoreFailed, Key: "key7", Size: 1, KeepsTTL: true, Result: model.StoreError},
+		{Type: model.EventGetMiss, Key: "key4"},
+	})
+}
+
+func TestTextCAS(t *testing.T) {
+	client := []string{
+		"cas key1 0 0 2 99",
+		"hi",
+		"cas key2 0 60 3 100",
+		"abc",
+		"cas key3 0 0 1 101",
+		"x",
+		"cas key4 0 0 1 102 noreply",
+		"y",
+		"get key5",
+	}
+	server := []string{
+		"STORED",
+		"EXISTS",
+		"NOT_FOUND",
+		"END",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventCASStored, Key: "key1", Size: 2, Result: model.StoreStored},
+		{Type: model.EventCASExists, Key: "key2", Size: 3, TTL: 60, Result: model.StoreExists},
+		{Type: model.EventCASNotFound, Key: "key3", Size: 1, Result: model.StoreNotFound},
+		{Type: model.EventGetMiss, Key: "key5"},
+	})
+}
+
+func TestTextPipelined(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	// all three requests are sent before any response, and the responses
+	// arrive together
+	r.ClientStream().Reassembled(reassemblyString("get a b\r\nget c\r\nget d e\r\n"))
+	r.ServerStream().Reassembled(reassemblyString("VALUE b 0 2\r\nhi\r\nEND\r\n" +
+		"END\r\n" +
+		"VALUE d 0 3\r\nabc\r\nVALUE e 0 1\r\nx\r\nEND\r\n"))
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	expected := []model.Event{
+		{Type: model.EventGetMiss, Key: "a"},
+		{Type: model.EventGetHit, Key: "b", Size: 2},
+		{Type: model.EventGetMiss, Key: "c"},
+		{Type: model.EventGetHit, Key: "d", Size: 3},
+		{Type: model.EventGetHit, Key: "e", Size: 1},
+	}
+	if fmt.Sprint(evts) != fmt.Sprint(expected) {
+		t.Error("expected", expected, "got", evts)
+	}
+}
+
+func TestTextPipelinedLatency(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	start := time.Unix(1000, 0)
+	for i, req := range []string{"get a b\r\n", "get c\r\n", "get d\r\n"} {
+		r.ClientStream().Reassembled([]tcpassembly.Reassembly{{
+			Bytes: []byte(req),
+			Seen:  start.Add(time.Duration(i) * time.Millisecond),
+		}})
+	}
+	r.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("VALUE b 0 2\r\nhi\r\nEND\r\nEND\r\nVALUE d 0 1\r\nx\r\nEND\r\n"),
+		Seen:  start.Add(10 * time.Millisecond),
+	}})
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	// each response is timed from its own request
+	expected := map[string]time.Duration{
+		"a": 10 * time.Millisecond,
+		"b": 10 * time.Millisecond,
+		"c": 9 * time.Millisecond,
+		"d": 8 * time.Millisecond,
+	}
+	if len(evts) != len(expected) {
+		t.Fatal("expected", len(expected), "events, got", evts)
+	}
+	for _, evt := range evts {
+		if evt.Latency != expected[evt.Key] {
+			t.Error("expected latency", expected[evt.Key], "for", evt.Key, "got", evt.Latency)
+		}
+	}
+}
+
+func TestTextStoreLatency(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	start := time.Unix(1000, 0)
+	r.ClientStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("add k 0 0 1\r\nx\r\n"),
+		Seen:  start,
+	}})
+	r.ServerStream().Reassembled([]tcpassembly.Reassembly{{
+		Bytes: []byte("NOT_STORED\r\n"),
+		Seen:  start.Add(time.Millisecond),
+	}})
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	// the set is reported from the request alone
+	if len(evts) != 2 || evts[0].Latency != 0 || evts[1].Type != model.EventStoreFailed || evts[1].Latency != time.Millisecond {
+		t.Error("expected a set and a failure after 1ms, got", evts)
+	}
+}
+
+func TestTextTruncatedValue(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	r.ClientStream().Reassembled(reassemblyString("get a b\r\n"))
+	// the end of the first value was not captured
+	r.ServerStream().Reassembled([]tcpassembly.Reassembly{
+		{Bytes: []byte("VALUE a 0 10\r\nhell")},
+		{Skip: 6},
+		{Bytes: []byte("\r\nVALUE b 0 2\r\nhi\r\nEND\r\n")},
+	})
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	expected := []model.Event{
+		{Type: model.EventGetHit, Key: "a", Size: 10, Truncated: true},
+		{Type: model.EventGetHit, Key: "b", Size: 2},
+	}
+	if fmt.Sprint(evts) != fmt.Sprint(expected) {
+		t.Error("expected", expected, "got", evts)
+	}
+}
+
+func TestTextDelete(t *testing.T) {
+	client := []string{
+		"delete key1",
+		"delete key2 noreply",
+		"delete key3",
+	}
+	server := []string{
+		"DELETED",
+		"NOT_FOUND",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventDelete, Key: "key1"},
+		{Type: model.EventDelete, Key: "key2"},
+		{Type: model.EventDelete, Key: "key3"},
+	})
+}
+
+func TestTextFlushAll(t *testing.T) {
+	client := []string{
+		"flush_all",
+		"flush_all 30 noreply",
+		"get key1",
+	}
+	server := []string{
+		"OK",
+		"END",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventFlush},
+		{Type: model.EventFlush},
+		{Type: model.EventGetMiss, Key: "key1"},
+	})
+}
+
+func TestTextIncrDecr(t *testing.T) {
+	client := []string{
+		"incr key1 5",
+		"decr key2 2 noreply",
+		"incr key3 abc",
+		"get key4",
+		"incr key5 18446744073709551615",
+	}
+	server := []string{
+		"6",
+		"CLIENT_ERROR invalid numeric delta argument",
+		"END",
+		"18446744073709551615",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventIncr, Key: "key1", Size: 5},
+		{Type: model.EventDecr, Key: "key2", Size: 2},
+		{Type: model.EventGetMiss, Key: "key4"},
+		// deltas beyond an int are clamped
+		{Type: model.EventIncr, Key: "key5", Size: int(^uint(0) >> 1)},
+	})
+}
+
+func TestTextRequestsOnly(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	r.RequestsOnly = true
+	for _, l := range []string{"get a b", "set k 0 60 5", "hello", "cas c 0 0 5 99", "world", "delete d", "gat 30 e"} {
+		r.ClientStream().Reassembled(reassemblyString(l + "\r\n"))
+	}
+	r.ClientStream().ReassemblyComplete()
+
+	expected := []model.Event{
+		{Type: model.EventGet, Key: "a"},
+		{Type: model.EventGet, Key: "b"},
+		{Type: model.EventSet, Key: "k", Size: 5, TTL: 60},
+		{Type: model.EventDelete, Key: "d"},
+		{Type: model.EventGet, Key: "e", TTL: 30},
+	}
+	if fmt.Sprint(evts) != fmt.Sprint(expected) {
+		t.Error("expected", expected, "got", evts)
+	}
+}
+
 func TestClientOverrun(t *testing.T) {
 	r := NewConsumer(&log.ConsoleLogger{}, nil)
 	var data [1024]byte
@@ -68,6 +405,10 @@ func TestServerOverrun(t *testing.T) {
 }
 
 func testReadText(t *testing.T, lines []string, expected []model.Event) {
+	testReadConversation(t, []string{"get key1 key2 key3"}, lines, expected)
+}
+
+func testReadConversation(t *testing.T, client, server []string, expected []model.Event) {
 	handler := func(evts []model.Event) {
 		for _, e := range evts {
 			if e != expected[0] {
@@ -78,8 +419,10 @@ func testReadText(t *testing.T, lines []string, expected []model.Event) {
 	}
 	r := NewConsumer(&log.ConsoleLogger{}, handler)
 
-	r.ClientStream().Reassembled(reassemblyString("get key1 key2 key3\r\n"))
-	for _, l := range lines {
+	for _, l := range client {
+		r.ClientStream().Reassembled(reassemblyString(l + "\r\n"))
+	}
+	for _, l := range server {
 		r.ServerStream().Reassembled(reassemblyString(l + "\r\n"))
 	}
 	r.ClientStream().ReassemblyComplete()
@@ -3,6 +3,7 @@ package model
 import (
 	"io"
 	"sync"
+	"time"
 
 	"github.com/box/memsniff/assembly/reader"
 	"github.com/box/memsniff/log"
@@ -19,8 +20,112 @@ const (
 	EventGetHit
 	// EventGetMiss is a data retrieval that did not result in data.
 	EventGetMiss
+	// EventSet is a storage command that sent data to the server.
+	EventSet
+	// EventDelete is a request to remove a key from the datastore.
+	EventDelete
+	// EventIncr is an increment of a counter.  Size is the delta.
+	EventIncr
+	// EventDecr is a decrement of a counter.  Size is the delta.
+	EventDecr
+	// EventCASStored is a compare-and-swap that stored its value.
+	EventCASStored
+	// EventCASExists is a compare-and-swap rejected because the value was
+	// modified since the client read it.
+	EventCASExists
+	// EventCASNotFound is a compare-and-swap on a key with no value.
+	EventCASNotFound
+	// EventGet is a data retrieval whose outcome is unknown, because
+	// responses are not being captured.
+	EventGet
+	// EventFlush is a request to invalidate every item on the server, such
+	// as memcached's flush_all.  It has no Key.
+	EventFlush
+	// EventStoreFailed is the response to a storage command that did not
+	// store its value, with the reason in Result.  The EventSet for the
+	// command was emitted when the request was read, so that it is counted
+	// even if no response is seen.
+	EventStoreFailed
 )
 
+// String returns a short name for t, such as "get_hit".
+func (t EventType) String() string {
+	switch t {
+	case EventGetHit:
+		return "get_hit"
+	case EventGetMiss:
+		return "get_miss"
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventIncr:
+		return "incr"
+	case EventDecr:
+		return "decr"
+	case EventCASStored:
+		return "cas_stored"
+	case EventCASExists:
+		return "cas_exists"
+	case EventCASNotFound:
+		return "cas_not_found"
+	case EventGet:
+		return "get"
+	case EventFlush:
+		return "flush"
+	case EventStoreFailed:
+		return "store_failed"
+	default:
+		return "unknown"
+	}
+}
+
+// StoreResult is the outcome of a storage command, as reported by the
+// server.
+type StoreResult int
+
+const (
+	// StoreUnknown is the result of a storage command whose response was
+	// not seen, such as one sent with noreply.
+	StoreUnknown StoreResult = iota
+	// StoreStored is the result of a storage command that stored its value.
+	StoreStored
+	// StoreNotStored is the result of an add or replace whose condition
+	// was not met, or an append or prepend to a missing value.
+	StoreNotStored
+	// StoreExists is the result of a cas on a value modified since the
+	// client read it.
+	StoreExists
+	// StoreNotFound is the result of a cas on a missing value.
+	StoreNotFound
+	// StoreError is the result of a storage command the server rejected.
+	StoreError
+)
+
+// String returns the response line for r, such as "NOT_STORED", or the
+// empty string for StoreUnknown.
+func (r StoreResult) String() string {
+	switch r {
+	case StoreStored:
+		return "STORED"
+	case StoreNotStored:
+		return "NOT_STORED"
+	case StoreExists:
+		return "EXISTS"
+	case StoreNotFound:
+		return "NOT_FOUND"
+	case StoreError:
+		return "ERROR"
+	default:
+		return ""
+	}
+}
+
+// Failed returns whether r is a known result other than StoreStored.
+func (r StoreResult) Failed() bool {
+	return r != StoreUnknown && r != StoreStored
+}
+
 var (
 	bufferPool = sync.Pool{New: func() interface{} { return reader.New() }}
 	eofSource  = &DummySource{}
@@ -32,8 +137,65 @@ type Event struct {
 	Type EventType
 	// Datastore key affected by this event.
 	Key string
+	// Original is the key as it was before being rewritten, such as into
+	// the family of related keys it belongs to, if it has been rewritten
+	// and the original is needed.
+	Original string
+	// Redacted is set if parts of Key have been masked, such as to keep
+	// personal data out of reports.
+	Redacted bool
+	// LongKeys is set for events from a datastore whose keys may be longer
+	// than memcached's limit of 250 bytes, such as Redis.
+	LongKeys bool
 	// Size of the datastore value affected by this event.
 	Size int
+	// TTL is the expiration time sent with a storage or get-and-touch
+	// command, as given by the client: zero never expires, a negative value
+	// expires immediately, and values over 30 days are absolute Unix times.
+	// TTL is zero for other commands, and for those that leave the
+	// expiration unchanged, as marked by KeepsTTL.
+	TTL int
+	// KeepsTTL is set for storage commands that leave the expiration of the
+	// value unchanged, such as append and prepend.
+	KeepsTTL bool
+	// Result is the outcome of a compare-and-swap, or why a storage command
+	// failed for EventStoreFailed.  It is StoreUnknown for other events.
+	Result StoreResult
+	// Client is the network address of the client that made the request,
+	// if known.
+	Client string
+	// Server is the network address of the server the request was made
+	// to, if known.
+	Server string
+	// Conn identifies the connection the request was made on by the client
+	// and server addresses and ports, if known.
+	Conn string
+	// Interface is the name of the network interface the request was
+	// captured on, if captured on several.
+	Interface string
+	// Truncated is set if the value was not captured in full, such as
+	// when packets were cut short by the capture snaplen.  Size is still
+	// the size declared in the conversation.
+	Truncated bool
+	// Timestamp is when the packet completing this event was captured, if
+	// known: the time of capture for live traffic, or as recorded in a
+	// capture file.
+	Timestamp time.Time
+	// Latency is the time from the capture of the request to the capture
+	// of the response completing this event, if known.  It is zero for
+	// events reported without waiting for a response.
+	Latency time.Duration
+}
+
+// DeltaSize returns delta as the Size of an EventIncr or EventDecr.
+// memcached deltas are unsigned 64-bit integers, so those too large for an
+// int are clamped to the largest int rather than dropped.
+func DeltaSize(delta uint64) int {
+	const maxInt = int(^uint(0) >> 1)
+	if delta > uint64(maxInt) {
+		return maxInt
+	}
+	return int(delta)
 }
 
 // EventHandler consumes a batch of events.
@@ -45,6 +207,19 @@ type Reader interface {
 	// If Discard skips fewer than n bytes, it also returns an error.
 	Discard(n int) (discarded int, err error)
 
+	// Skipping returns the number of bytes passed to Discard that have not
+	// yet arrived, and will be skipped as they do.
+	Skipping() int
+
+	// Lost returns the number of bytes missing from the stream, such as
+	// from packets that were not captured or were captured only in part,
+	// that have been discarded.  It only increases.
+	Lost() int
+
+	// Buffered returns the number of bytes waiting to be read, including
+	// gaps not yet passed over.
+	Buffered() int
+
 	// ReadN returns the next n bytes.
 	//
 	// If EOF is encountered before reading n bytes, the available bytes are returned
@@ -100,11 +275,37 @@ type Consumer struct {
 	ClientReader ConsumerSource
 	// ServerReader exposes data send by the server to the client.
 	ServerReader ConsumerSource
+	// ErrorHandler, if not nil, receives each error that interrupts
+	// decoding of the conversation, such as data that does not follow the
+	// protocol.
+	ErrorHandler func(err error)
+	// RequestsOnly decodes requests without waiting for responses, when
+	// only traffic from the client to the server is captured.  Retrievals
+	// are reported as EventGet, and events that can only be known from a
+	// response, such as the outcome of a compare-and-swap, are not
+	// reported.
+	RequestsOnly bool
 
 	Run   func()
 	State State
 
 	eventBuf []Event
+	// whether events are being held until EndBatch
+	batching bool
+	// when the data being processed was seen, to timestamp events
+	seen time.Time
+	// data held by ClientReader in the order it was seen, to timestamp
+	// requests
+	clientPieces []clientPiece
+	// ClientReader.Buffered when clientPieces was last brought up to date
+	clientBuffered int
+}
+
+// clientPiece is data from the client waiting to be read, and when it was
+// seen.
+type clientPiece struct {
+	len  int
+	seen time.Time
 }
 
 func New(logger log.Logger, handler EventHandler) *Consumer {
@@ -120,16 +321,87 @@ func New(logger log.Logger, handler EventHandler) *Consumer {
 	}
 }
 
+// AddEvent buffers evt for delivery to the Handler.  Events without a
+// Timestamp are given the time the data being processed was seen.
 func (c *Consumer) AddEvent(evt Event) {
+	if evt.Timestamp.IsZero() {
+		evt.Timestamp = c.seen
+	}
 	if c.eventBuf == nil {
 		c.eventBuf = make([]Event, 0, 8)
 	}
 	c.eventBuf = append(c.eventBuf, evt)
-	if len(c.eventBuf) == cap(c.eventBuf) {
+	if len(c.eventBuf) == cap(c.eventBuf) && !c.batching {
+		c.FlushEvents()
+	}
+}
+
+// Seen returns when the data being processed was seen.
+func (c *Consumer) Seen() time.Time {
+	return c.seen
+}
+
+// RequestSeen returns when the next data to be read from ClientReader was
+// seen, such as the start of a request pipelined behind others, or the
+// zero Time if not known.
+func (c *Consumer) RequestSeen() time.Time {
+	c.readClientPieces()
+	if len(c.clientPieces) == 0 {
+		return time.Time{}
+	}
+	return c.clientPieces[0].seen
+}
+
+// readClientPieces discards the pieces of client data read from
+// ClientReader since it was last called.
+func (c *Consumer) readClientPieces() {
+	buffered := c.ClientReader.Buffered()
+	read := c.clientBuffered - buffered
+	c.clientBuffered = buffered
+	for read > 0 && len(c.clientPieces) > 0 {
+		if c.clientPieces[0].len > read {
+			c.clientPieces[0].len -= read
+			return
+		}
+		read -= c.clientPieces[0].len
+		c.clientPieces = c.clientPieces[1:]
+	}
+}
+
+// addClientData passes r to the ClientReader, noting when the data it adds
+// was seen.
+func (c *Consumer) addClientData(r tcpassembly.Reassembly) {
+	c.readClientPieces()
+	c.ClientReader.Reassembled([]tcpassembly.Reassembly{r})
+	if added := c.ClientReader.Buffered() - c.clientBuffered; added > 0 {
+		c.clientPieces = append(c.clientPieces, clientPiece{len: added, seen: r.Seen})
+		c.clientBuffered += added
+	}
+}
+
+// BeginBatch holds events added until EndBatch is called, so that events
+// from a single response are delivered to the Handler together.
+func (c *Consumer) BeginBatch() {
+	c.batching = true
+}
+
+// EndBatch delivers all events held since BeginBatch.
+func (c *Consumer) EndBatch() {
+	if c.batching {
+		c.batching = false
 		c.FlushEvents()
 	}
 }
 
+// ReportError passes err to the ErrorHandler, if any.  Decoders call it
+// once they have recovered from err as best they can, so the handler may
+// Close the Consumer.
+func (c *Consumer) ReportError(err error) {
+	if c.ErrorHandler != nil {
+		c.ErrorHandler(err)
+	}
+}
+
 func (c *Consumer) FlushEvents() {
 	c.Handler(c.eventBuf)
 	c.eventBuf = c.eventBuf[:0]
@@ -141,6 +413,7 @@ func (c *Consumer) Close() {
 		bufferPool.Put(c.ClientReader)
 		c.ClientReader = eofSource
 	}
+	c.clientPieces, c.clientBuffered = nil, 0
 	if c.ServerReader != eofSource {
 		c.ServerReader.Reset()
 		bufferPool.Put(c.ServerReader)
@@ -163,14 +436,37 @@ func (c *Consumer) log(items ...interface{}) {
 	}
 }
 
+// maxReassembly is the most data passed to a reader before decoding it.
+// Segments are read as a stream of bytes whatever their boundaries, and
+// those coalesced from many packets by GRO or LRO, or carried in jumbo
+// frames, may hold more than a reader buffers at once.
+const maxReassembly = reader.BufferSize / 4
+
+// splitReassembly returns the first piece of r holding at most
+// maxReassembly bytes, the rest of r, and whether any of r remains.
+func splitReassembly(r tcpassembly.Reassembly) (piece, rest tcpassembly.Reassembly, more bool) {
+	if len(r.Bytes) <= maxReassembly {
+		return r, tcpassembly.Reassembly{}, false
+	}
+	piece, rest = r, r
+	piece.Bytes, piece.End = r.Bytes[:maxReassembly], false
+	rest.Bytes, rest.Skip, rest.Start = r.Bytes[maxReassembly:], 0, false
+	return piece, rest, true
+}
+
 // ClientStream is a view on a Consumer that consumes tcpassembly data from the client
 type ClientStream Consumer
 
 func (cs *ClientStream) Reassembled(rs []tcpassembly.Reassembly) {
 	for _, r := range rs {
 		// (*Consumer)(cs).log("reassembling from client", r.Skip, len(r.Bytes))
-		cs.ClientReader.Reassembled([]tcpassembly.Reassembly{r})
-		(*Consumer)(cs).Run()
+		for more := true; more; {
+			var piece tcpassembly.Reassembly
+			piece, r, more = splitReassembly(r)
+			(*Consumer)(cs).addClientData(piece)
+			cs.seen = piece.Seen
+			(*Consumer)(cs).Run()
+		}
 	}
 }
 
@@ -182,6 +478,7 @@ func (cs *ClientStream) ReassemblyComplete() {
 		bufferPool.Put(cs.ClientReader)
 		cs.ClientReader = eofSource
 	}
+	cs.clientPieces, cs.clientBuffered = nil, 0
 }
 
 // ServerStream is a view on a Consumer that consumes tcpassembly data from the server
@@ -190,8 +487,13 @@ type ServerStream Consumer
 func (ss *ServerStream) Reassembled(rs []tcpassembly.Reassembly) {
 	for _, r := range rs {
 		// (*Consumer)(ss).log("reassembling from server", r.Skip, len(r.Bytes))
-		ss.ServerReader.Reassembled([]tcpassembly.Reassembly{r})
-		(*Consumer)(ss).Run()
+		for more := true; more; {
+			var piece tcpassembly.Reassembly
+			piece, r, more = splitReassembly(r)
+			ss.ServerReader.Reassembled([]tcpassembly.Reassembly{piece})
+			ss.seen = piece.Seen
+			(*Consumer)(ss).Run()
+		}
 	}
 }
 
@@ -16,6 +16,18 @@ func (s *DummySource) Discard(n int) (discarded int, err error) {
 	return 0, nil
 }
 
+func (s *DummySource) Skipping() int {
+	return 0
+}
+
+func (s *DummySource) Lost() int {
+	return 0
+}
+
+func (s *DummySource) Buffered() int {
+	return 0
+}
+
 func (s *DummySource) ReadN(n int) ([]byte, error) {
 	return nil, io.EOF
 }
@@ -0,0 +1,458 @@
+// Package redis decodes conversations using the Redis serialization protocol
+// (RESP) into the same events as memcached conversations.
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/box/memsniff/assembly/reader"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+)
+
+const (
+	crlf       = "\r\n"
+	debuglevel = 0
+
+	// longest argument read in full; longer arguments, such as large
+	// values, are skipped and only their sizes recorded
+	maxArgLen = 1024
+)
+
+var errProtocolDesync = errors.New("protocol desync in RESP conversation")
+
+// Consumer generates events based on a Redis conversation.
+//
+// Each command is read from the client, then its reply from the server.
+// Commands are answered in order, so pipelined commands are handled one at
+// a time as their replies arrive.
+type Consumer struct {
+	*model.Consumer
+	// command name in upper case
+	cmd string
+	// arguments of the current command, including its name.  Arguments
+	// longer than maxArgLen are empty.
+	args []string
+	// length of each argument
+	sizes []int
+	// elements of the current command array not yet read
+	remaining int
+	// length of the argument being read
+	argLen int
+
+	// index in args of the next key awaiting a reply to MGET
+	nextKey int
+	// number of reply values still to be skipped
+	skip int
+	// a get hit whose value has not yet been completely received
+	hit        model.Event
+	hitPending bool
+	// bytes lost from the server stream before the pending hit's value
+	hitLost int
+}
+
+// NewConsumer returns a Consumer for a connection known to use RESP.
+func NewConsumer(logger log.Logger, handler model.EventHandler) *model.Consumer {
+	mc := model.New(logger, handler)
+	Attach(mc)
+	return mc
+}
+
+// Attach decodes the conversation buffered in mc as RESP, starting with the
+// next client command.
+func Attach(mc *model.Consumer) {
+	c := &Consumer{Consumer: mc}
+	mc.Run = c.run
+	mc.State = c.readCommand
+}
+
+func (c *Consumer) run() {
+	for {
+		err := c.State()
+		switch err {
+		case nil:
+			continue
+		case reader.ErrShortRead, io.EOF:
+			return
+		default:
+			// data lost or protocol error, try to resync at the next command
+			c.log(2, "trying to resync after error:", err)
+			c.hitPending = false
+			c.EndBatch()
+			c.ClientReader.Reset()
+			c.ServerReader.Reset()
+			c.State = c.readCommand
+			c.ReportError(err)
+			return
+		}
+	}
+}
+
+// readCommand reads the start of a command, which is either an array of
+// bulk strings or an inline command of words separated by spaces.
+func (c *Consumer) readCommand() error {
+	c.args = c.args[:0]
+	c.sizes = c.sizes[:0]
+	c.hitPending = false
+	firstByte, err := c.ClientReader.PeekN(1)
+	if err != nil {
+		return err
+	}
+	line, err := c.ClientReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	if firstByte[0] != '*' {
+		for _, word := range strings.Fields(string(line)) {
+			c.args = append(c.args, word)
+			c.sizes = append(c.sizes, len(word))
+		}
+		return c.dispatch()
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil {
+		return errProtocolDesync
+	}
+	c.remaining = n
+	return c.nextArg()
+}
+
+// readArgHeader reads the length of the next bulk string argument.
+func (c *Consumer) readArgHeader() error {
+	line, err := c.ClientReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	if len(line) < 2 || line[0] != '$' {
+		return errProtocolDesync
+	}
+	c.argLen, err = strconv.Atoi(string(line[1:]))
+	if err != nil || c.argLen < 0 {
+		return errProtocolDesync
+	}
+	if c.argLen <= maxArgLen {
+		c.State = c.readArg
+		return nil
+	}
+	c.addArg("")
+	// the argument will be skipped as it arrives, so move on first
+	err = c.nextArg()
+	if _, derr := c.ClientReader.Discard(c.argLen + len(crlf)); derr != nil {
+		return derr
+	}
+	return err
+}
+
+func (c *Consumer) readArg() error {
+	b, err := c.ClientReader.ReadN(c.argLen + len(crlf))
+	if err != nil {
+		return err
+	}
+	c.addArg(string(b[:c.argLen]))
+	return c.nextArg()
+}
+
+func (c *Consumer) addArg(arg string) {
+	c.args = append(c.args, arg)
+	c.sizes = append(c.sizes, c.argLen)
+	c.remaining--
+}
+
+// nextArg reads the next argument of the current command, or handles the
+// command once all arguments have been read.
+func (c *Consumer) nextArg() error {
+	if c.remaining > 0 {
+		c.State = c.readArgHeader
+		return nil
+	}
+	return c.dispatch()
+}
+
+// dispatch handles a complete command, and prepares to read its reply.
+func (c *Consumer) dispatch() error {
+	if len(c.args) == 0 {
+		c.State = c.readCommand
+		return nil
+	}
+	c.cmd = strings.ToUpper(c.args[0])
+	c.log(3, "read command:", c.args)
+	if c.RequestsOnly {
+		return c.dispatchRequest()
+	}
+	switch c.cmd {
+	case "GET":
+		if len(c.args) == 2 {
+			c.State = c.readGetReply
+			return nil
+		}
+	case "MGET":
+		if len(c.args) >= 2 {
+			c.nextKey = 1
+			c.State = c.readMGetReply
+			return nil
+		}
+	case "SET":
+		c.handleSet()
+	case "DEL":
+		for _, key := range c.args[1:] {
+			c.addEvent(model.Event{Type: model.EventDelete, Key: key})
+		}
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		c.handleArith()
+	case "FLUSHALL":
+		c.addEvent(model.Event{Type: model.EventFlush})
+	}
+	return c.discardReply()
+}
+
+// dispatchRequest handles a complete command without waiting for its reply,
+// when replies are not captured.
+func (c *Consumer) dispatchRequest() error {
+	switch c.cmd {
+	case "GET", "MGET":
+		for _, key := range c.args[1:] {
+			c.addEvent(model.Event{Type: model.EventGet, Key: key})
+		}
+	case "SET":
+		c.handleSet()
+	case "DEL":
+		for _, key := range c.args[1:] {
+			c.addEvent(model.Event{Type: model.EventDelete, Key: key})
+		}
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		c.handleArith()
+	case "FLUSHALL":
+		c.addEvent(model.Event{Type: model.EventFlush})
+	}
+	c.State = c.readCommand
+	return nil
+}
+
+// handleSet emits an event for SET key value [options], taking the
+// expiration from the EX, PX, EXAT or PXAT option if present, or keeping it
+// with KEEPTTL.  The expiration follows the memcached convention described
+// for model.Event.
+func (c *Consumer) handleSet() {
+	if len(c.args) < 3 {
+		return
+	}
+	evt := model.Event{Type: model.EventSet, Key: c.args[1], Size: c.sizes[2]}
+	opts := c.args[3:]
+	for _, opt := range opts {
+		if strings.ToUpper(opt) == "KEEPTTL" {
+			evt.KeepsTTL = true
+		}
+	}
+	for i := 0; i+1 < len(opts); i++ {
+		n, err := strconv.Atoi(opts[i+1])
+		if err != nil {
+			continue
+		}
+		switch strings.ToUpper(opts[i]) {
+		case "EX", "EXAT":
+			evt.TTL = n
+		case "PX":
+			// round up so that a short expiration is not mistaken for none
+			evt.TTL = (n + 999) / 1000
+		case "PXAT":
+			evt.TTL = n / 1000
+		}
+	}
+	c.addEvent(evt)
+}
+
+func (c *Consumer) handleArith() {
+	if len(c.args) < 2 {
+		return
+	}
+	delta := 1
+	if c.cmd == "INCRBY" || c.cmd == "DECRBY" {
+		if len(c.args) < 3 {
+			return
+		}
+		var err error
+		delta, err = strconv.Atoi(c.args[2])
+		if err != nil {
+			return
+		}
+	}
+	evtType := model.EventIncr
+	if c.cmd == "DECR" || c.cmd == "DECRBY" {
+		evtType = model.EventDecr
+	}
+	c.addEvent(model.Event{Type: evtType, Key: c.args[1], Size: delta})
+}
+
+// readGetReply emits a hit or miss for the key requested by GET.
+func (c *Consumer) readGetReply() error {
+	if c.hitPending {
+		return c.finishHit(c.readCommand)
+	}
+	line, err := c.ServerReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	return c.handleValue(c.args[1], line, c.readCommand)
+}
+
+// readMGetReply emits a hit or miss for each key requested by MGET, which
+// the server answers with an array of values in the order requested.
+func (c *Consumer) readMGetReply() error {
+	line, err := c.ServerReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		// an error rather than values
+		c.State = c.readCommand
+		return nil
+	}
+	// deliver events for all requested keys together
+	c.BeginBatch()
+	c.State = c.readMGetValue
+	return nil
+}
+
+func (c *Consumer) readMGetValue() error {
+	if c.hitPending {
+		return c.finishHit(c.readMGetValue)
+	}
+	if c.nextKey >= len(c.args) {
+		c.EndBatch()
+		c.State = c.readCommand
+		return nil
+	}
+	line, err := c.ServerReader.ReadLine()
+	if err != nil {
+		return err
+	}
+	key := c.args[c.nextKey]
+	c.nextKey++
+	return c.handleValue(key, line, c.readMGetValue)
+}
+
+// handleValue emits an event for key given the first line of the value
+// returned for it, then continues in state next.
+func (c *Consumer) handleValue(key string, line []byte, next model.State) error {
+	if len(line) == 0 {
+		return errProtocolDesync
+	}
+	switch line[0] {
+	case '$':
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return errProtocolDesync
+		}
+		if size < 0 {
+			c.addEvent(model.Event{Type: model.EventGetMiss, Key: key})
+			c.State = next
+			return nil
+		}
+		// the value may span several packets, so the hit is only
+		// reported once all of it has arrived
+		c.hit = model.Event{Type: model.EventGetHit, Key: key, Size: size}
+		c.hitPending = true
+		c.hitLost = c.ServerReader.Lost()
+		_, err = c.ServerReader.Discard(size + len(crlf))
+		return err
+	case '_':
+		// RESP3 null
+		c.addEvent(model.Event{Type: model.EventGetMiss, Key: key})
+		c.State = next
+		return nil
+	case '-':
+		// an error, such as for a key holding another type
+		c.State = next
+		return nil
+	default:
+		return errProtocolDesync
+	}
+}
+
+// finishHit emits the pending hit once its value has been received, then
+// continues in state next.
+func (c *Consumer) finishHit(next model.State) error {
+	if c.ServerReader.Skipping() > 0 {
+		return reader.ErrShortRead
+	}
+	c.hitPending = false
+	c.hit.Truncated = c.ServerReader.Lost() > c.hitLost
+	c.addEvent(c.hit)
+	c.State = next
+	return nil
+}
+
+// discardReply skips a single reply of any type.
+func (c *Consumer) discardReply() error {
+	c.skip = 1
+	c.State = c.discardValues
+	return nil
+}
+
+// discardValues skips the remaining c.skip values of a reply, including the
+// elements of aggregates.
+func (c *Consumer) discardValues() error {
+	for c.skip > 0 {
+		line, err := c.ServerReader.ReadLine()
+		if err != nil {
+			return err
+		}
+		if err := c.discardValue(line); err != nil {
+			return err
+		}
+	}
+	c.State = c.readCommand
+	return nil
+}
+
+// discardValue skips the value beginning with line, adding any elements it
+// contains to c.skip.
+func (c *Consumer) discardValue(line []byte) error {
+	if len(line) == 0 {
+		return errProtocolDesync
+	}
+	c.skip--
+	c.State = c.discardValues
+	switch line[0] {
+	case '+', '-', ':', '_', ',', '#', '(':
+		// simple values fit on a single line
+		return nil
+	}
+	n, err := strconv.Atoi(string(bytes.TrimSpace(line[1:])))
+	if err != nil {
+		return errProtocolDesync
+	}
+	if n < 0 {
+		// null bulk string or array
+		return nil
+	}
+	switch line[0] {
+	case '$', '=', '!':
+		_, err = c.ServerReader.Discard(n + len(crlf))
+		return err
+	case '*', '~', '>':
+		c.skip += n
+	case '%':
+		c.skip += 2 * n
+	case '|':
+		// attributes precede the value they describe
+		c.skip += 2*n + 1
+	default:
+		return errProtocolDesync
+	}
+	return nil
+}
+
+func (c *Consumer) addEvent(evt model.Event) {
+	c.Consumer.AddEvent(evt)
+}
+
+func (c *Consumer) log(level int, items ...interface{}) {
+	if c.Logger != nil && debuglevel >= level {
+		c.Logger.Log(items...)
+	}
+}
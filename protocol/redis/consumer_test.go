@@ -0,0 +1,215 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/protocol/model"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+func TestRedisGet(t *testing.T) {
+	client := []string{
+		"*2\r\n$3\r\nGET\r\n$4\r\nkey1\r\n",
+		"*2\r\n$3\r\nget\r\n$4\r\nkey2\r\n",
+		"*2\r\n$3\r\nGET\r\n$4\r\nkey3\r\n",
+	}
+	server := []string{
+		"$5\r\nhello\r\n",
+		"$-1\r\n",
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 5},
+		{Type: model.EventGetMiss, Key: "key2"},
+	})
+}
+
+func TestRedisMGet(t *testing.T) {
+	client := []string{
+		"*4\r\n$4\r\nMGET\r\n$4\r\nkey1\r\n$4\r\nkey2\r\n$4\r\nkey3\r\n",
+	}
+	server := []string{
+		"*3\r\n$2\r\nhi\r\n$-1\r\n$5\r\nworld\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 2},
+		{Type: model.EventGetMiss, Key: "key2"},
+		{Type: model.EventGetHit, Key: "key3", Size: 5},
+	})
+}
+
+func TestRedisSet(t *testing.T) {
+	client := []string{
+		"*3\r\n$3\r\nSET\r\n$4\r\nkey1\r\n$5\r\nhello\r\n",
+		"*5\r\n$3\r\nSET\r\n$4\r\nkey2\r\n$2\r\nhi\r\n$2\r\nEX\r\n$2\r\n60\r\n",
+		"*5\r\n$3\r\nSET\r\n$4\r\nkey3\r\n$1\r\nx\r\n$2\r\npx\r\n$4\r\n1500\r\n",
+		"*4\r\n$3\r\nSET\r\n$4\r\nkey4\r\n$1\r\ny\r\n$7\r\nkeepttl\r\n",
+	}
+	server := []string{
+		"+OK\r\n",
+		"+OK\r\n",
+		"+OK\r\n",
+		"+OK\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 5},
+		{Type: model.EventSet, Key: "key2", Size: 2, TTL: 60},
+		{Type: model.EventSet, Key: "key3", Size: 1, TTL: 2},
+		{Type: model.EventSet, Key: "key4", Size: 1, KeepsTTL: true},
+	})
+}
+
+func TestRedisDelete(t *testing.T) {
+	client := []string{
+		"*3\r\n$3\r\nDEL\r\n$4\r\nkey1\r\n$4\r\nkey2\r\n",
+	}
+	server := []string{
+		":1\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventDelete, Key: "key1"},
+		{Type: model.EventDelete, Key: "key2"},
+	})
+}
+
+func TestRedisIncrDecr(t *testing.T) {
+	client := []string{
+		"*2\r\n$4\r\nINCR\r\n$4\r\nkey1\r\n",
+		"*3\r\n$6\r\nDECRBY\r\n$4\r\nkey2\r\n$1\r\n5\r\n",
+		"*3\r\n$6\r\nINCRBY\r\n$4\r\nkey3\r\n$3\r\nabc\r\n",
+	}
+	server := []string{
+		":1\r\n",
+		":-5\r\n",
+		"-ERR value is not an integer or out of range\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventIncr, Key: "key1", Size: 1},
+		{Type: model.EventDecr, Key: "key2", Size: 5},
+	})
+}
+
+func TestRedisInline(t *testing.T) {
+	client := []string{
+		"PING\r\n",
+		"get key1\r\n",
+	}
+	server := []string{
+		"+PONG\r\n",
+		"$3\r\nabc\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventGetHit, Key: "key1", Size: 3},
+	})
+}
+
+func TestRedisSkipReplies(t *testing.T) {
+	client := []string{
+		"*2\r\n$7\r\nHGETALL\r\n$4\r\nhash\r\n",
+		"*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n",
+		"*2\r\n$3\r\nGET\r\n$4\r\nkey1\r\n",
+	}
+	server := []string{
+		"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n*2\r\n:1\r\n$-1\r\n",
+		"%2\r\n+server\r\n+redis\r\n+modules\r\n*0\r\n",
+		"_\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventGetMiss, Key: "key1"},
+	})
+}
+
+func TestRedisLargeValue(t *testing.T) {
+	value := strings.Repeat("x", 3000)
+	client := []string{
+		"*3\r\n$3\r\nSET\r\n$4\r\nkey1\r\n$3000\r\n" + value[:1000],
+		value[1000:] + "\r\n",
+		"*2\r\n$3\r\nGET\r\n$4\r\nkey1\r\n",
+	}
+	server := []string{
+		"+OK\r\n",
+		"$3000\r\n" + value[:100],
+		value[100:2000],
+		value[2000:] + "\r\n",
+	}
+	testReadConversation(t, client, server, []model.Event{
+		{Type: model.EventSet, Key: "key1", Size: 3000},
+		{Type: model.EventGetHit, Key: "key1", Size: 3000},
+	})
+}
+
+func TestRedisResync(t *testing.T) {
+	client := []string{
+		"*2\r\n$3\r\nGET\r\nkey1\r\n",
+	}
+	server := []string{
+		"$5\r\nhello\r\n",
+	}
+	testReadConversation(t, client, server, nil)
+}
+
+func TestRedisRequestsOnly(t *testing.T) {
+	var evts []model.Event
+	r := NewConsumer(&log.ConsoleLogger{}, func(batch []model.Event) {
+		evts = append(evts, batch...)
+	})
+	r.RequestsOnly = true
+	for _, s := range []string{
+		"*2\r\n$3\r\nGET\r\n$4\r\nkey1\r\n",
+		"*3\r\n$4\r\nMGET\r\n$4\r\nkey2\r\n$4\r\nkey3\r\n",
+		"DEL key4\r\n",
+	} {
+		r.ClientStream().Reassembled(reassemblyString(s))
+	}
+	r.ClientStream().ReassemblyComplete()
+
+	expected := []model.Event{
+		{Type: model.EventGet, Key: "key1"},
+		{Type: model.EventGet, Key: "key2"},
+		{Type: model.EventGet, Key: "key3"},
+		{Type: model.EventDelete, Key: "key4"},
+	}
+	if len(evts) != len(expected) {
+		t.Fatal("expected", expected, "got", evts)
+	}
+	for i := range evts {
+		if evts[i] != expected[i] {
+			t.Error("expected", expected[i], "got", evts[i])
+		}
+	}
+}
+
+func testReadConversation(t *testing.T, client, server []string, expected []model.Event) {
+	handler := func(evts []model.Event) {
+		for _, e := range evts {
+			if len(expected) == 0 {
+				t.Error("Unexpected event", e)
+				continue
+			}
+			if e != expected[0] {
+				t.Error("Expected", expected[0], "got", e)
+			}
+			expected = expected[1:]
+		}
+	}
+	r := NewConsumer(&log.ConsoleLogger{}, handler)
+
+	for _, s := range client {
+		r.ClientStream().Reassembled(reassemblyString(s))
+	}
+	for _, s := range server {
+		r.ServerStream().Reassembled(reassemblyString(s))
+	}
+	r.ClientStream().ReassemblyComplete()
+	r.ServerStream().ReassemblyComplete()
+
+	if len(expected) > 0 {
+		t.Error("Expected", expected, "events but never received")
+	}
+}
+
+func reassemblyString(s string) []tcpassembly.Reassembly {
+	return []tcpassembly.Reassembly{{Bytes: []byte(s)}}
+}
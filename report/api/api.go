@@ -0,0 +1,196 @@
+// Package api serves the busiest cache keys as JSON over HTTP on demand.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// defaultK is the number of keys returned if the k parameter is absent.
+const defaultK = 50
+
+// maxK is the largest k parameter accepted, so that a single request cannot
+// make the Pool collect an unbounded number of keys from every worker.
+const maxK = 10000
+
+// Source provides the busiest cache keys.  It is implemented by
+// *analysis.Pool.
+type Source interface {
+	Top(k int, by analysis.Metric) []analysis.KeyReport
+	TopAndReset(k int, by analysis.Metric) []analysis.KeyReport
+}
+
+// response is the JSON representation of a reply to a query.
+type response struct {
+	Timestamp time.Time `json:"ts"`
+	Keys      []key     `json:"keys"`
+}
+
+type key struct {
+	Key      string `json:"key"`
+	Client   string `json:"client,omitempty"`
+	Cluster  string `json:"cluster,omitempty"`
+	Size     int    `json:"size"`
+	Requests int    `json:"requests"`
+	Misses   int    `json:"misses"`
+	Bytes    int    `json:"bytes"`
+	// maximum overestimate of requests and bytes, if counted approximately
+	RequestsError int `json:"requests_error,omitempty"`
+	BytesError    int `json:"bytes_error,omitempty"`
+	Sets          int `json:"sets,omitempty"`
+	// sets the server did not store
+	StoreFailures int `json:"store_failures,omitempty"`
+	Deletes       int `json:"deletes,omitempty"`
+	Incrs         int `json:"incrs,omitempty"`
+	Decrs         int `json:"decrs,omitempty"`
+	CAS           int `json:"cas,omitempty"`
+	// cas commands rejected because the value had been modified
+	CASConflicts int `json:"cas_conflicts,omitempty"`
+	// sets by TTL in seconds, if tracked
+	SetTTLs map[int]int `json:"set_ttls,omitempty"`
+	// requests by value size bucket, if configured
+	Sizes []int `json:"sizes,omitempty"`
+	// commands modifying the key, and whether it is mostly read or
+	// written, if classified
+	Writes int    `json:"writes,omitempty"`
+	Access string `json:"access,omitempty"`
+	// time of the most recent activity on the key, if tracked
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// original keys sampled from the family Key, if retained
+	Examples []string `json:"examples,omitempty"`
+	// distinct client addresses, if counted
+	Clients int `json:"clients,omitempty"`
+	// whether Key may combine several keys made identical by redaction
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// TopHandler answers GET requests for the busiest keys from a Source:
+//
+//	/top?k=50&by=bytes&reset=true&min=1000
+//
+// k is the number of keys to return, by is the name of an analysis.Metric
+// to rank keys by, and reset clears recorded activity once it has been
+// collected.  If min is given, keys measuring less than min by the metric
+// are left out, even if fewer than k keys are returned; min must not be
+// negative.  Any number of requests may be served concurrently.
+type TopHandler struct {
+	src Source
+	by  analysis.Metric
+}
+
+// NewTopHandler returns a TopHandler that ranks keys from src by metric
+// unless the request specifies otherwise.
+func NewTopHandler(src Source, by analysis.Metric) *TopHandler {
+	return &TopHandler{src, by}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *TopHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	k, ok := parseK(w, q)
+	if !ok {
+		return
+	}
+	by := h.by
+	if s := q.Get("by"); s != "" {
+		var err error
+		by, err = analysis.ParseMetric(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	reset, _ := strconv.ParseBool(q.Get("reset"))
+
+	min := 0
+	if s := q.Get("min"); s != "" {
+		var err error
+		min, err = strconv.Atoi(s)
+		if err != nil || min < 0 {
+			http.Error(w, "min must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var keys []analysis.KeyReport
+	if reset {
+		keys = h.src.TopAndReset(k, by)
+	} else {
+		keys = h.src.Top(k, by)
+	}
+	keys = analysis.AtLeast(keys, by, min)
+
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(newResponse(time.Now(), keys))
+}
+
+// parseK returns the k parameter of q, or defaultK if it is absent.  If k is
+// invalid or larger than maxK, parseK reports the error to w and returns
+// false.
+func parseK(w http.ResponseWriter, q url.Values) (int, bool) {
+	s := q.Get("k")
+	if s == "" {
+		return defaultK, true
+	}
+	k, err := strconv.Atoi(s)
+	if err != nil || k < 1 {
+		http.Error(w, "k must be a positive integer", http.StatusBadRequest)
+		return 0, false
+	}
+	if k > maxK {
+		http.Error(w, "k must be at most "+strconv.Itoa(maxK), http.StatusBadRequest)
+		return 0, false
+	}
+	return k, true
+}
+
+func newResponse(ts time.Time, krs []analysis.KeyReport) response {
+	res := response{
+		Timestamp: ts,
+		Keys:      make([]key, len(krs)),
+	}
+	for i, kr := range krs {
+		res.Keys[i] = key{
+			Key:           kr.Name,
+			Client:        kr.Client,
+			Cluster:       kr.Cluster,
+			Size:          kr.Size,
+			Requests:      kr.RequestsEstimate,
+			Misses:        kr.MissesEstimate,
+			Bytes:         kr.TrafficEstimate,
+			RequestsError: kr.RequestsError,
+			BytesError:    kr.TrafficError,
+			Sets:          kr.SetsEstimate,
+			StoreFailures: kr.StoreFailuresEstimate,
+			Deletes:       kr.DeletesEstimate,
+			Incrs:         kr.IncrsEstimate,
+			Decrs:         kr.DecrsEstimate,
+			CAS:           kr.CASEstimate,
+			CASConflicts:  kr.CASConflictsEstimate,
+			SetTTLs:       kr.SetTTLs,
+			Sizes:         kr.SizeHistogram,
+			Writes:        kr.WritesEstimate,
+			Access:        kr.Access.String(),
+			Examples:      kr.Examples,
+			Clients:       kr.ClientsEstimate,
+			Redacted:      kr.Redacted,
+		}
+		if !kr.LastSeen.IsZero() {
+			lastSeen := kr.LastSeen
+			res.Keys[i].LastSeen = &lastSeen
+		}
+	}
+	return res
+}
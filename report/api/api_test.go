@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/box/memsniff/analysis"
+)
+
+type testSource struct {
+	k     int
+	by    analysis.Metric
+	reset bool
+}
+
+func (s *testSource) Top(k int, by analysis.Metric) []analysis.KeyReport {
+	s.k, s.by = k, by
+	return []analysis.KeyReport{{Name: "a", RequestsEstimate: 2, TrafficEstimate: 20, Size: 10}}
+}
+
+func (s *testSource) TopAndReset(k int, by analysis.Metric) []analysis.KeyReport {
+	s.reset = true
+	return s.Top(k, by)
+}
+
+func TestTopHandler(t *testing.T) {
+	src := &testSource{}
+	h := NewTopHandler(src, analysis.MetricBytes)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/top?k=5&by=requests&reset=true", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatal("unexpected status", rec.Code, rec.Body.String())
+	}
+	if src.k != 5 || src.by != analysis.MetricRequests || !src.reset {
+		t.Error("unexpected query", src)
+	}
+	if !strings.Contains(rec.Body.String(), `"keys":[{"key":"a","size":10,"requests":2,"misses":0,"bytes":20}]`) {
+		t.Error("unexpected body", rec.Body.String())
+	}
+}
+
+func TestTopHandlerMin(t *testing.T) {
+	h := NewTopHandler(&testSource{}, analysis.MetricBytes)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/top?min=21", nil))
+	if !strings.Contains(rec.Body.String(), `"keys":[]`) {
+		t.Error("expected keys below min to be left out, got", rec.Body.String())
+	}
+}
+
+func TestTopHandlerBadRequest(t *testing.T) {
+	h := NewTopHandler(&testSource{}, analysis.MetricBytes)
+	for _, url := range []string{"/top?k=0", "/top?k=x", "/top?k=1000000000", "/top?by=nonsense", "/top?min=x", "/top?min=-1"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Error("expected bad request for", url, "got", rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/top", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("expected method not allowed, got", rec.Code)
+	}
+}
+
+type testPrefixes struct {
+	prefix string
+	by     analysis.Metric
+}
+
+func (p *testPrefixes) TopPrefixes(prefix string, k int, by analysis.Metric) []analysis.PrefixReport {
+	p.prefix, p.by = prefix, by
+	return []analysis.PrefixReport{{Prefix: prefix + "1:", Requests: 2, Bytes: 20, Children: 1}}
+}
+
+func TestPrefixesHandler(t *testing.T) {
+	src := &testPrefixes{}
+	h := NewPrefixesHandler(src, analysis.MetricBytes)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/prefixes?prefix=user:&by=requests", nil))
+	if src.prefix != "user:" || src.by != analysis.MetricRequests {
+		t.Error("expected user: by requests, got", src.prefix, src.by)
+	}
+	if !strings.Contains(rec.Body.String(), `"prefixes":[{"prefix":"user:1:","requests":2,"bytes":20,"children":1}]`) {
+		t.Error("unexpected body", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/prefixes?by=sets", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Error("expected bad request for by=sets, got", rec.Code)
+	}
+}
+
+type testMemStats []analysis.WorkerMemStats
+
+func (s testMemStats) MemStats() []analysis.WorkerMemStats {
+	return s
+}
+
+func TestMemStatsHandler(t *testing.T) {
+	h := NewMemStatsHandler(testMemStats{{Keys: 2, Bytes: 200}, {Keys: 1, Bytes: 100}})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/memstats", nil))
+	if !strings.Contains(rec.Body.String(), `"keys":3,"bytes":300,"workers":[{"keys":2,"bytes":200},{"keys":1,"bytes":100}]`) {
+		t.Error("unexpected body", rec.Body.String())
+	}
+}
+
+type testPacketCounts []int64
+
+func (c testPacketCounts) PacketCounts() []int64 {
+	return c
+}
+
+func TestPacketsHandler(t *testing.T) {
+	h := NewPacketsHandler(testPacketCounts{5, 3})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/packets", nil))
+	if !strings.Contains(rec.Body.String(), `"packets":8,"workers":[5,3]`) {
+		t.Error("unexpected body", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/packets", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("expected 405, got", rec.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// AnalysisConfig provides the settings of analysis that may be changed
+// while running.  It is implemented by *analysis.Pool.
+type AnalysisConfig interface {
+	Settings() analysis.Settings
+	Reconfigure(s analysis.Settings) error
+}
+
+// AssemblyConfig provides the number of workers reassembling conversations,
+// which may be changed while running.  It is implemented by
+// *assembly.Pool.
+type AssemblyConfig interface {
+	Workers() int
+	Resize(n int)
+}
+
+// maxWorkersPerCPU bounds the workers that may be configured, as a multiple
+// of the CPUs Go schedules goroutines on, since more workers than CPUs only
+// add contention.
+const maxWorkersPerCPU = 4
+
+// maxQueueSize bounds the event batches each analysis worker may be
+// configured to queue.
+const maxQueueSize = 1 << 16
+
+type config struct {
+	AssemblyWorkers int    `json:"assembly_workers"`
+	AnalysisWorkers int    `json:"analysis_workers"`
+	AnalysisQueue   int    `json:"analysis_queue"`
+	Weight          string `json:"weight"`
+}
+
+// weightNames are the names of each analysis.WeightMode in a config.
+var weightNames = map[analysis.WeightMode]string{
+	analysis.WeightBytes: "bytes",
+	analysis.WeightCount: "count",
+}
+
+// ConfigHandler answers GET requests for the current settings of assembly
+// and analysis, and POST requests changing them:
+//
+//	/config?assembly_workers=8&analysis_workers=32&analysis_queue=1024&weight=count
+//
+// Parameters left out of a POST request are unchanged.  Either way the
+// settings in effect are returned.  Changing the number of assembly
+// workers completes the conversations in progress, and changing any
+// analysis setting replaces the analysis workers; see assembly.Pool.Resize
+// and analysis.Pool.Reconfigure.
+type ConfigHandler struct {
+	analysis AnalysisConfig
+	assembly AssemblyConfig
+}
+
+// NewConfigHandler returns a ConfigHandler for an analysis and an assembly
+// pool.
+func NewConfigHandler(analysis AnalysisConfig, assembly AssemblyConfig) *ConfigHandler {
+	return &ConfigHandler{analysis, assembly}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := h.reconfigure(r.URL.Query()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := h.analysis.Settings()
+	res := config{
+		AssemblyWorkers: h.assembly.Workers(),
+		AnalysisWorkers: s.Workers,
+		AnalysisQueue:   s.QueueSize,
+		Weight:          weightNames[s.WeightMode],
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// reconfigure applies the settings given in q.  All settings are validated
+// before any are applied.
+func (h *ConfigHandler) reconfigure(q url.Values) error {
+	maxWorkers := maxWorkersPerCPU * runtime.GOMAXPROCS(0)
+	assemblyWorkers, err := positiveParam(q, "assembly_workers", maxWorkers)
+	if err != nil {
+		return err
+	}
+	s := h.analysis.Settings()
+	changed := false
+	if n, err := positiveParam(q, "analysis_workers", maxWorkers); err != nil {
+		return err
+	} else if n > 0 {
+		s.Workers, changed = n, true
+	}
+	if n, err := positiveParam(q, "analysis_queue", maxQueueSize); err != nil {
+		return err
+	} else if n > 0 {
+		s.QueueSize, changed = n, true
+	}
+	if name := q.Get("weight"); name != "" {
+		found := false
+		for mode, modeName := range weightNames {
+			if name == modeName {
+				s.WeightMode, found = mode, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown weight %q", name)
+		}
+		changed = true
+	}
+
+	if assemblyWorkers > 0 {
+		h.assembly.Resize(assemblyWorkers)
+	}
+	if changed {
+		return h.analysis.Reconfigure(s)
+	}
+	return nil
+}
+
+// positiveParam returns the value of parameter name in q, or 0 if it is
+// absent.  The value must be between 1 and max.
+func positiveParam(q url.Values, name string, max int) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	if n > max {
+		return 0, fmt.Errorf("%s must be at most %d", name, max)
+	}
+	return n, nil
+}
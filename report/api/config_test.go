@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/box/memsniff/analysis"
+)
+
+type testConfig struct {
+	settings        analysis.Settings
+	assemblyWorkers int
+	reconfigured    bool
+}
+
+func (c *testConfig) Settings() analysis.Settings { return c.settings }
+
+func (c *testConfig) Reconfigure(s analysis.Settings) error {
+	c.settings, c.reconfigured = s, true
+	return nil
+}
+
+func (c *testConfig) Workers() int { return c.assemblyWorkers }
+
+func (c *testConfig) Resize(n int) { c.assemblyWorkers = n }
+
+func TestConfigHandler(t *testing.T) {
+	c := &testConfig{settings: analysis.Settings{Workers: 4, QueueSize: 100}, assemblyWorkers: 2}
+	h := NewConfigHandler(c, c)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/config", nil))
+	if !strings.Contains(rec.Body.String(), `{"assembly_workers":2,"analysis_workers":4,"analysis_queue":100,"weight":"bytes"}`) {
+		t.Error("unexpected body", rec.Body.String())
+	}
+	if c.reconfigured {
+		t.Error("expected GET not to reconfigure")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/config?assembly_workers=3&weight=count", nil))
+	if !strings.Contains(rec.Body.String(), `{"assembly_workers":3,"analysis_workers":4,"analysis_queue":100,"weight":"count"}`) {
+		t.Error("unexpected body", rec.Body.String())
+	}
+}
+
+func TestConfigHandlerBadRequest(t *testing.T) {
+	c := &testConfig{assemblyWorkers: 2}
+	h := NewConfigHandler(c, c)
+	for _, url := range []string{"/config?assembly_workers=0", "/config?analysis_queue=x", "/config?analysis_queue=1000000000", "/config?analysis_workers=1000000", "/config?assembly_workers=3&weight=nonsense"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Error("expected bad request for", url, "got", rec.Code)
+		}
+	}
+	if c.assemblyWorkers != 2 || c.reconfigured {
+		t.Error("expected invalid requests to change nothing, got", c)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// ConnectionSource provides the busiest client connections.  It is
+// implemented by *analysis.Pool.
+type ConnectionSource interface {
+	TopConnections(k int) []analysis.ConnectionReport
+}
+
+type connectionsResponse struct {
+	Timestamp   time.Time    `json:"ts"`
+	Connections []connection `json:"connections"`
+}
+
+type connection struct {
+	Conn     string `json:"conn"`
+	Requests int    `json:"requests"`
+	Bytes    int    `json:"bytes"`
+}
+
+// ConnectionsHandler answers GET requests for the busiest connections from
+// a ConnectionSource:
+//
+//	/connections?k=50
+//
+// k is the number of connections to return.
+type ConnectionsHandler struct {
+	src ConnectionSource
+}
+
+// NewConnectionsHandler returns a ConnectionsHandler for src.
+func NewConnectionsHandler(src ConnectionSource) *ConnectionsHandler {
+	return &ConnectionsHandler{src}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ConnectionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	k, ok := parseK(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	crs := h.src.TopConnections(k)
+	res := connectionsResponse{
+		Timestamp:   time.Now(),
+		Connections: make([]connection, len(crs)),
+	}
+	for i, cr := range crs {
+		res.Connections[i] = connection{cr.Conn, cr.RequestsEstimate, cr.TrafficEstimate}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// MemStatsSource provides estimates of the memory held by the hotlists of
+// each analysis worker.  It is implemented by *analysis.Pool.
+type MemStatsSource interface {
+	MemStats() []analysis.WorkerMemStats
+}
+
+type memStatsResponse struct {
+	Timestamp time.Time `json:"ts"`
+	// totals across all workers
+	Keys    int              `json:"keys"`
+	Bytes   int              `json:"bytes"`
+	Workers []workerMemStats `json:"workers"`
+}
+
+type workerMemStats struct {
+	Keys  int `json:"keys"`
+	Bytes int `json:"bytes"`
+}
+
+// MemStatsHandler answers GET requests for the memory held by the hotlists
+// of a MemStatsSource, to watch their growth over time:
+//
+//	/memstats
+type MemStatsHandler struct {
+	src MemStatsSource
+}
+
+// NewMemStatsHandler returns a MemStatsHandler for src.
+func NewMemStatsHandler(src MemStatsSource) *MemStatsHandler {
+	return &MemStatsHandler{src}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *MemStatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := h.src.MemStats()
+	res := memStatsResponse{
+		Timestamp: time.Now(),
+		Workers:   make([]workerMemStats, len(stats)),
+	}
+	for i, ms := range stats {
+		res.Workers[i] = workerMemStats{ms.Keys, ms.Bytes}
+		res.Keys += ms.Keys
+		res.Bytes += ms.Bytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// PacketCountSource provides the number of packets dispatched to each
+// assembly worker.  It is implemented by *assembly.Pool.
+type PacketCountSource interface {
+	PacketCounts() []int64
+}
+
+type packetsResponse struct {
+	Timestamp time.Time `json:"ts"`
+	// total across all workers
+	Packets int64   `json:"packets"`
+	Workers []int64 `json:"workers"`
+}
+
+// PacketsHandler answers GET requests for the packets dispatched to each
+// assembly worker of a PacketCountSource, to reveal whether traffic is
+// spread evenly:
+//
+//	/packets
+type PacketsHandler struct {
+	src PacketCountSource
+}
+
+// NewPacketsHandler returns a PacketsHandler for src.
+func NewPacketsHandler(src PacketCountSource) *PacketsHandler {
+	return &PacketsHandler{src}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *PacketsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts := h.src.PacketCounts()
+	if counts == nil {
+		counts = []int64{}
+	}
+	res := packetsResponse{
+		Timestamp: time.Now(),
+		Workers:   counts,
+	}
+	for _, n := range counts {
+		res.Packets += n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(res)
+}
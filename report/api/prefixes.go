@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// PrefixSource provides the busiest prefixes of keys.  It is implemented by
+// *analysis.Pool.
+type PrefixSource interface {
+	TopPrefixes(prefix string, k int, by analysis.Metric) []analysis.PrefixReport
+}
+
+type prefixesResponse struct {
+	Timestamp time.Time `json:"ts"`
+	Prefix    string    `json:"prefix"`
+	Prefixes  []prefix  `json:"prefixes"`
+}
+
+type prefix struct {
+	Prefix   string `json:"prefix"`
+	Requests int    `json:"requests"`
+	Bytes    int    `json:"bytes"`
+	// number of longer prefixes that may be requested under this one
+	Children int `json:"children"`
+}
+
+// PrefixesHandler answers GET requests for the busiest prefixes one segment
+// longer than a given prefix, from a PrefixSource:
+//
+//	/prefixes?prefix=user:&k=50&by=requests
+//
+// prefix is the prefix to expand, or the top level prefixes if absent.  k
+// is the number of prefixes to return, and by is bytes or requests.
+type PrefixesHandler struct {
+	src PrefixSource
+	by  analysis.Metric
+}
+
+// NewPrefixesHandler returns a PrefixesHandler that ranks prefixes from src
+// by metric unless the request specifies otherwise.
+func NewPrefixesHandler(src PrefixSource, by analysis.Metric) *PrefixesHandler {
+	return &PrefixesHandler{src, by}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *PrefixesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	k, ok := parseK(w, q)
+	if !ok {
+		return
+	}
+	by := h.by
+	if s := q.Get("by"); s != "" {
+		var err error
+		by, err = analysis.ParseMetric(s)
+		if err != nil || (by != analysis.MetricBytes && by != analysis.MetricRequests) {
+			http.Error(w, "by must be bytes or requests", http.StatusBadRequest)
+			return
+		}
+	}
+
+	parent := q.Get("prefix")
+	prs := h.src.TopPrefixes(parent, k, by)
+	res := prefixesResponse{
+		Timestamp: time.Now(),
+		Prefix:    parent,
+		Prefixes:  make([]prefix, len(prs)),
+	}
+	for i, pr := range prs {
+		res.Prefixes[i] = prefix{pr.Prefix, pr.Requests, pr.Bytes, pr.Children}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// ScanSource provides the client connections flagged as scanning keys.  It
+// is implemented by *analysis.Pool.
+type ScanSource interface {
+	Scanning() []analysis.ScanReport
+}
+
+type scansResponse struct {
+	Timestamp time.Time `json:"ts"`
+	Scans     []scan    `json:"scans"`
+}
+
+type scan struct {
+	Conn       string `json:"conn"`
+	Client     string `json:"client"`
+	Requests   int    `json:"requests"`
+	UniqueKeys int    `json:"unique_keys"`
+}
+
+// ScansHandler answers GET requests for the connections currently flagged
+// as scanning by a ScanSource:
+//
+//	/scans
+type ScansHandler struct {
+	src ScanSource
+}
+
+// NewScansHandler returns a ScansHandler for src.
+func NewScansHandler(src ScanSource) *ScansHandler {
+	return &ScansHandler{src}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ScansHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	srs := h.src.Scanning()
+	res := scansResponse{
+		Timestamp: time.Now(),
+		Scans:     make([]scan, len(srs)),
+	}
+	for i, sr := range srs {
+		res.Scans[i] = scan{sr.Conn, sr.Client, sr.Requests, sr.UniqueKeys}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// the client has gone away if this fails, so there is no one to tell
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,133 @@
+// Package csv periodically writes the busiest cache keys as comma-separated
+// values, for import into spreadsheets.
+package csv
+
+import (
+	enccsv "encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/report"
+)
+
+// DefaultColumns are the columns written if none are given to New, in
+// order.
+var DefaultColumns = []string{"timestamp", "rank", "key", "requests", "bytes", "avg_size"}
+
+// columnValues gives the value of each column for a key at a given rank.
+var columnValues = map[string]func(ts time.Time, rank int, kr analysis.KeyReport) string{
+	"timestamp": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return ts.Format(time.RFC3339)
+	},
+	"rank": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return strconv.Itoa(rank)
+	},
+	"key": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return kr.Name
+	},
+	"client": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return kr.Client
+	},
+	"cluster": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return kr.Cluster
+	},
+	"requests": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return strconv.Itoa(kr.RequestsEstimate)
+	},
+	"misses": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return strconv.Itoa(kr.MissesEstimate)
+	},
+	"bytes": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		return strconv.Itoa(kr.TrafficEstimate)
+	},
+	"avg_size": func(ts time.Time, rank int, kr analysis.KeyReport) string {
+		if kr.RequestsEstimate == 0 {
+			return "0"
+		}
+		return strconv.Itoa(kr.TrafficEstimate / kr.RequestsEstimate)
+	},
+}
+
+// Writer writes the busiest cache keys of each periodic report to an
+// io.Writer, one row per key per interval, preceded by a single header row.
+type Writer struct {
+	w       *enccsv.Writer
+	k       int
+	columns []string
+	reports report.Queue
+	// whether the header row has been written
+	wroteHeader bool
+	done        chan struct{}
+}
+
+// New returns a Writer that writes the top k keys of each report passed to
+// Report to w.  Each row contains the named columns in order: any of
+// timestamp, rank, key, client, cluster, requests, misses, bytes or
+// avg_size.  DefaultColumns are written if columns is empty.
+func New(w io.Writer, k int, columns []string) (*Writer, error) {
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+	for _, c := range columns {
+		if _, ok := columnValues[c]; !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", c)
+		}
+	}
+	return &Writer{
+		w:       enccsv.NewWriter(w),
+		k:       k,
+		columns: append([]string(nil), columns...),
+		reports: report.NewQueue(),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Report queues rep to be written by Run.  It does not block, so it may be
+// called by analysis.Pool.ReportEvery.
+func (cw *Writer) Report(rep analysis.Report) {
+	cw.reports.Add(rep)
+}
+
+// Run writes reports until Close is called or writing fails.
+func (cw *Writer) Run() error {
+	for {
+		select {
+		case rep := <-cw.reports:
+			if err := cw.write(rep.Timestamp, report.Top(rep, cw.k)); err != nil {
+				return err
+			}
+		case <-cw.done:
+			return nil
+		}
+	}
+}
+
+// Close stops a running Writer.
+func (cw *Writer) Close() {
+	close(cw.done)
+}
+
+// write writes a row for each of keys, ranked from 1, after the header row
+// if it has not yet been written.
+func (cw *Writer) write(ts time.Time, keys []analysis.KeyReport) error {
+	if !cw.wroteHeader {
+		if err := cw.w.Write(cw.columns); err != nil {
+			return err
+		}
+		cw.wroteHeader = true
+	}
+	row := make([]string, len(cw.columns))
+	for i, kr := range keys {
+		for j, c := range cw.columns {
+			row[j] = columnValues[c](ts, i+1, kr)
+		}
+		if err := cw.w.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.w.Flush()
+	return cw.w.Error()
+}
@@ -0,0 +1,54 @@
+package csv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	cw, err := New(&buf, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	keys := []analysis.KeyReport{
+		{Name: `a,"b"`, RequestsEstimate: 2, TrafficEstimate: 20},
+		{Name: "c", RequestsEstimate: 0, TrafficEstimate: 0},
+	}
+	if err := cw.write(ts, keys); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.write(ts, keys[1:]); err != nil {
+		t.Fatal(err)
+	}
+	expected := `timestamp,rank,key,requests,bytes,avg_size
+2017-01-02T03:04:05Z,1,"a,""b""",2,20,10
+2017-01-02T03:04:05Z,2,c,0,0,0
+2017-01-02T03:04:05Z,1,c,0,0,0
+`
+	if buf.String() != expected {
+		t.Error("expected", expected, "got", buf.String())
+	}
+}
+
+func TestColumns(t *testing.T) {
+	var buf bytes.Buffer
+	cw, err := New(&buf, 10, []string{"key", "misses"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.write(time.Now(), []analysis.KeyReport{{Name: "a", MissesEstimate: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "key,misses\na,3\n"; buf.String() != expected {
+		t.Error("expected", expected, "got", buf.String())
+	}
+
+	if _, err := New(&buf, 10, []string{"nope"}); err == nil {
+		t.Error("expected error for unknown column")
+	}
+}
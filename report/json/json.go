@@ -0,0 +1,113 @@
+// Package json periodically writes the busiest cache keys as newline-delimited
+// JSON.
+package json
+
+import (
+	encjson "encoding/json"
+	"io"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/report"
+)
+
+// record is the JSON representation of a single cache key in a report.
+// A record without a key is written when there are no keys to report.
+type record struct {
+	Timestamp time.Time `json:"ts"`
+	Key       string    `json:"key,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Bytes     int       `json:"bytes"`
+	Requests  int       `json:"requests"`
+	Misses    int       `json:"misses"`
+	// whether Key is mostly read or written, if classified
+	Access string `json:"access,omitempty"`
+	// whether Key may combine several keys made identical by redaction
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// flusher is implemented by buffered writers such as bufio.Writer.
+type flusher interface {
+	Flush() error
+}
+
+// Writer writes the busiest cache keys of each periodic report to an
+// io.Writer, one JSON object per key per interval.
+type Writer struct {
+	w       io.Writer
+	enc     *encjson.Encoder
+	k       int
+	reports report.Queue
+	done    chan struct{}
+}
+
+// New returns a Writer that writes the top k keys of each report passed to
+// Report to w.
+func New(w io.Writer, k int) *Writer {
+	return &Writer{
+		w:       w,
+		enc:     encjson.NewEncoder(w),
+		k:       k,
+		reports: report.NewQueue(),
+		done:    make(chan struct{}),
+	}
+}
+
+// Report queues rep to be written by Run.  It does not block, so it may be
+// called by analysis.Pool.ReportEvery.
+func (jw *Writer) Report(rep analysis.Report) {
+	jw.reports.Add(rep)
+}
+
+// Run writes reports until Close is called or writing fails.
+//
+// Reports are queued before writing, so a slow writer skips reports rather
+// than delaying other reporters or blocking analysis.
+func (jw *Writer) Run() error {
+	for {
+		select {
+		case rep := <-jw.reports:
+			if err := jw.write(rep.Timestamp, report.Top(rep, jw.k)); err != nil {
+				return err
+			}
+		case <-jw.done:
+			return nil
+		}
+	}
+}
+
+// Close stops a running Writer.
+func (jw *Writer) Close() {
+	close(jw.done)
+}
+
+// write writes a single report.  An empty report is written as a single
+// record with only a timestamp, so readers can tell the Writer is alive.
+func (jw *Writer) write(ts time.Time, keys []analysis.KeyReport) error {
+	if len(keys) == 0 {
+		if err := jw.enc.Encode(record{Timestamp: ts}); err != nil {
+			return err
+		}
+	}
+	for _, kr := range keys {
+		err := jw.enc.Encode(record{
+			Timestamp: ts,
+			Key:       kr.Name,
+			Client:    kr.Client,
+			Cluster:   kr.Cluster,
+			Bytes:     kr.TrafficEstimate,
+			Requests:  kr.RequestsEstimate,
+			Misses:    kr.MissesEstimate,
+			Access:    kr.Access.String(),
+			Redacted:  kr.Redacted,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if f, ok := jw.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
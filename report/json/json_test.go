@@ -0,0 +1,80 @@
+package json
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	jw := New(&buf, 10)
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	keys := []analysis.KeyReport{
+		{Name: "a", RequestsEstimate: 2, TrafficEstimate: 20},
+		{Name: "b", RequestsEstimate: 1, TrafficEstimate: 5},
+	}
+	if err := jw.write(ts, keys); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"ts":"2017-01-02T03:04:05Z","key":"a","bytes":20,"requests":2,"misses":0}
+{"ts":"2017-01-02T03:04:05Z","key":"b","bytes":5,"requests":1,"misses":0}
+`
+	if buf.String() != expected {
+		t.Error("expected", expected, "got", buf.String())
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	jw := New(&buf, 10)
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := jw.write(ts, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"ts":"2017-01-02T03:04:05Z","bytes":0,"requests":0,"misses":0}
+`
+	if buf.String() != expected {
+		t.Error("expected", expected, "got", buf.String())
+	}
+}
+
+// chanWriter passes each write to a channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestRunWritesReports(t *testing.T) {
+	w := make(chanWriter, 2)
+	jw := New(w, 1)
+	ran := make(chan error, 1)
+	go func() { ran <- jw.Run() }()
+
+	jw.Report(analysis.Report{
+		Timestamp: time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC),
+		Keys: []analysis.KeyReport{
+			{Name: "a", RequestsEstimate: 2, TrafficEstimate: 20},
+			{Name: "b", RequestsEstimate: 1, TrafficEstimate: 5},
+		},
+	})
+	expected := `{"ts":"2017-01-02T03:04:05Z","key":"a","bytes":20,"requests":2,"misses":0}
+`
+	if line := <-w; line != expected {
+		t.Error("expected", expected, "got", line)
+	}
+	jw.Close()
+	if err := <-ran; err != nil {
+		t.Error(err)
+	}
+	// only the top key is written
+	select {
+	case line := <-w:
+		t.Error("unexpected", line)
+	default:
+	}
+}
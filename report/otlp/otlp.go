@@ -0,0 +1,281 @@
+// Package otlp periodically pushes the busiest cache keys and aggregate
+// activity to an OpenTelemetry collector, using OTLP over HTTP with JSON
+// encoding.
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/report"
+)
+
+// metricsPath is the path at which collectors receive OTLP metrics over
+// HTTP, used if the endpoint does not specify one.
+const metricsPath = "/v1/metrics"
+
+// scopeName identifies memsniff as the source of the metrics.
+const scopeName = "github.com/box/memsniff"
+
+// temporalityCumulative marks a sum as counting from its start time.
+const temporalityCumulative = 2
+
+// Source provides the aggregate activity pushed to the collector alongside
+// the keys of each report.  It is implemented by *analysis.Pool.
+type Source interface {
+	Summary() analysis.Summary
+}
+
+// The following types are the JSON encoding of an OTLP
+// ExportMetricsServiceRequest, limited to the fields used here.  64-bit
+// integers are encoded as strings.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Unit        string `json:"unit"`
+	Gauge       *gauge `json:"gauge,omitempty"`
+	Sum         *sum   `json:"sum,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             string     `json:"asInt"`
+}
+
+// Exporter pushes gauges for the busiest cache keys of each periodic report,
+// and sums of aggregate activity from a Source, to an OTLP collector:
+//
+//	memsniff.key.bytes, memsniff.key.requests, memsniff.key.misses
+//	memsniff.gets, memsniff.get_misses, memsniff.sets, memsniff.bytes,
+//	memsniff.new_keys
+type Exporter struct {
+	logger    log.Logger
+	src       Source
+	endpoint  string
+	client    *http.Client
+	resource  []keyValue
+	k         int
+	maxLabels int
+	reports   report.Queue
+	done      chan struct{}
+}
+
+// New returns an Exporter that pushes the top k keys of each report passed
+// to Report, with the aggregate activity of src, to the collector at
+// endpoint.  Pushes taking longer than interval, the time between reports,
+// are abandoned.  endpoint is an http or https URL, to which /v1/metrics is
+// added if it has no path.
+// Only the first maxLabels keys are pushed individually, and the remainder
+// are summed into a single key "__other__", as for Prometheus.  attrs
+// describe the source of the metrics, such as host.name, and are attached
+// to the OTLP resource.  Errors pushing are logged to logger.
+func New(logger log.Logger, src Source, endpoint string, attrs map[string]string, interval time.Duration, k, maxLabels int) (*Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("OTLP endpoint %q must be an http or https URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = metricsPath
+	}
+	return &Exporter{
+		logger:    logger,
+		src:       src,
+		endpoint:  u.String(),
+		client:    &http.Client{Timeout: interval},
+		resource:  attributes(attrs),
+		k:         k,
+		maxLabels: maxLabels,
+		reports:   report.NewQueue(),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Report queues rep to be pushed by Run.  It does not block, so it may be
+// called by analysis.Pool.ReportEvery.
+func (e *Exporter) Report(rep analysis.Report) {
+	e.reports.Add(rep)
+}
+
+// Run pushes metrics until Close is called.  Errors pushing to the
+// collector are logged, and do not stop the Exporter.
+func (e *Exporter) Run() {
+	for {
+		select {
+		case rep := <-e.reports:
+			if err := e.push(rep.Timestamp, report.Top(rep, e.k), e.src.Summary()); err != nil {
+				e.log("error pushing to OTLP collector:", err)
+			}
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Close stops a running Exporter.
+func (e *Exporter) Close() {
+	close(e.done)
+}
+
+// push sends a single request to the collector.
+func (e *Exporter) push(now time.Time, keys []analysis.KeyReport, summary analysis.Summary) error {
+	body, err := json.Marshal(e.request(now, keys, summary))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// read the body so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *Exporter) request(now time.Time, keys []analysis.KeyReport, summary analysis.Summary) exportRequest {
+	keys = report.CapKeys(keys, e.maxLabels)
+	ts := nanos(now)
+
+	keyGauge := func(name, desc, unit string, value func(analysis.KeyReport) int) metric {
+		g := &gauge{DataPoints: make([]dataPoint, len(keys))}
+		for i, kr := range keys {
+			g.DataPoints[i] = dataPoint{
+				Attributes:   keyAttributes(kr),
+				TimeUnixNano: ts,
+				AsInt:        strconv.Itoa(value(kr)),
+			}
+		}
+		return metric{Name: name, Description: desc, Unit: unit, Gauge: g}
+	}
+	// the summary counts from its start, which changes when it is reset
+	start := nanos(summary.Start)
+	total := func(name, desc, unit string, value int64) metric {
+		return metric{Name: name, Description: desc, Unit: unit, Sum: &sum{
+			DataPoints: []dataPoint{{
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				AsInt:             strconv.FormatInt(value, 10),
+			}},
+			AggregationTemporality: temporalityCumulative,
+			IsMonotonic:            true,
+		}}
+	}
+
+	metrics := []metric{
+		keyGauge("memsniff.key.bytes", "Estimated bytes of values returned for the busiest cache keys.", "By",
+			func(kr analysis.KeyReport) int { return kr.TrafficEstimate }),
+		keyGauge("memsniff.key.requests", "Estimated requests returning a value for the busiest cache keys.", "{request}",
+			func(kr analysis.KeyReport) int { return kr.RequestsEstimate }),
+		keyGauge("memsniff.key.misses", "Estimated requests not returning a value for the busiest cache keys.", "{request}",
+			func(kr analysis.KeyReport) int { return kr.MissesEstimate }),
+		total("memsniff.gets", "Estimated keys requested by get commands.", "{request}", summary.GetsEstimate),
+		total("memsniff.get_misses", "Estimated keys requested by get commands that were not found.", "{request}", summary.MissesEstimate),
+		total("memsniff.sets", "Estimated storage commands.", "{request}", summary.SetsEstimate),
+		total("memsniff.bytes", "Estimated bytes of values returned by gets and sent by storage commands.", "By", summary.TrafficEstimate),
+	}
+	if summary.NewKeysTracked {
+		metrics = append(metrics, total("memsniff.new_keys", "Estimated cache keys seen for the first time.", "{key}", summary.NewKeysEstimate))
+	}
+	return exportRequest{[]resourceMetrics{{
+		Resource: resource{e.resource},
+		ScopeMetrics: []scopeMetrics{{
+			Scope:   scope{scopeName},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+func keyAttributes(kr analysis.KeyReport) []keyValue {
+	attrs := []keyValue{{"key", anyValue{kr.Name}}}
+	if kr.Client != "" {
+		attrs = append(attrs, keyValue{"client", anyValue{kr.Client}})
+	}
+	if kr.Cluster != "" {
+		attrs = append(attrs, keyValue{"cluster", anyValue{kr.Cluster}})
+	}
+	return attrs
+}
+
+// attributes returns attrs in order by name.
+func attributes(attrs map[string]string) []keyValue {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	kvs := make([]keyValue, len(names))
+	for i, name := range names {
+		kvs[i] = keyValue{name, anyValue{attrs[name]}}
+	}
+	return kvs
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (e *Exporter) log(items ...interface{}) {
+	if e.logger != nil {
+		e.logger.Log(items...)
+	}
+}
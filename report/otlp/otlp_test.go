@@ -0,0 +1,103 @@
+package otlp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestNewEndpoint(t *testing.T) {
+	e, err := New(nil, nil, "http://collector:4318", nil, time.Second, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.endpoint != "http://collector:4318/v1/metrics" {
+		t.Error("expected default metrics path, got", e.endpoint)
+	}
+	if _, err := New(nil, nil, "collector:4318", nil, time.Second, 10, 10); err == nil {
+		t.Error("expected error for endpoint without scheme")
+	}
+}
+
+func TestPush(t *testing.T) {
+	var got exportRequest
+	var contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if r.URL.Path != "/v1/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	attrs := map[string]string{"host.name": "cache1", "memsniff.ports": "11211"}
+	e, err := New(nil, nil, srv.URL, attrs, time.Second, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []analysis.KeyReport{
+		{Name: "a", TrafficEstimate: 30, RequestsEstimate: 3},
+		{Name: "b", TrafficEstimate: 20, RequestsEstimate: 2},
+		{Name: "c", TrafficEstimate: 10, RequestsEstimate: 1},
+	}
+	start := time.Unix(100, 0)
+	summary := analysis.Summary{Start: start, GetsEstimate: 6}
+	if err := e.push(time.Unix(160, 0), keys, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" {
+		t.Error("unexpected content type", contentType)
+	}
+	if len(got.ResourceMetrics) != 1 {
+		t.Fatal("expected one resource, got", got.ResourceMetrics)
+	}
+	rm := got.ResourceMetrics[0]
+	ra := rm.Resource.Attributes
+	if len(ra) != 2 || ra[0].Key != "host.name" || ra[0].Value.StringValue != "cache1" || ra[1].Key != "memsniff.ports" {
+		t.Error("unexpected resource attributes", ra)
+	}
+
+	metrics := map[string]metric{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	bytes := metrics["memsniff.key.bytes"].Gauge
+	if bytes == nil || len(bytes.DataPoints) != 3 {
+		t.Fatal("expected capped key gauge, got", bytes)
+	}
+	other := bytes.DataPoints[2]
+	if other.Attributes[0].Value.StringValue != "__other__" || other.AsInt != "10" || other.TimeUnixNano != "160000000000" {
+		t.Error("unexpected combined key", other)
+	}
+	gets := metrics["memsniff.gets"].Sum
+	if gets == nil || !gets.IsMonotonic || gets.AggregationTemporality != temporalityCumulative {
+		t.Fatal("expected cumulative sum of gets, got", gets)
+	}
+	if dp := gets.DataPoints[0]; dp.AsInt != "6" || dp.StartTimeUnixNano != "100000000000" {
+		t.Error("unexpected gets data point", dp)
+	}
+}
+
+func TestPushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	e, err := New(nil, nil, srv.URL+"/v1/metrics", nil, time.Second, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.push(time.Now(), nil, analysis.Summary{}); err == nil {
+		t.Error("expected error for unsuccessful response")
+	}
+}
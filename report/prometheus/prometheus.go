@@ -0,0 +1,177 @@
+// Package prometheus exposes the busiest cache keys and analysis health to
+// Prometheus in its text exposition format.
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/report"
+)
+
+// otherLabel is the key label for the combined activity of keys beyond the
+// label limit.
+const otherLabel = report.OtherKey
+
+// Source provides the analysis health reported to Prometheus alongside the
+// keys of the latest report.  It is implemented by *analysis.Pool.
+type Source interface {
+	Stats() analysis.Stats
+	QueueDepths() []int
+	Summary() analysis.Summary
+	Flushes() map[string]int
+}
+
+// Handler serves metrics on each scrape: the keys of the most recent periodic
+// report, and analysis health from a Source.
+type Handler struct {
+	src       Source
+	k         int
+	maxLabels int
+
+	mu   sync.Mutex
+	keys []analysis.KeyReport
+}
+
+// NewHandler returns a Handler that reports the top k keys of the most
+// recent report passed to Report, and no keys until then.  Only the first
+// maxLabels keys are reported individually, and the remainder are summed
+// into a single series with the key label "__other__", to bound the number
+// of series Prometheus must store.
+func NewHandler(src Source, k, maxLabels int) *Handler {
+	return &Handler{
+		src:       src,
+		k:         k,
+		maxLabels: maxLabels,
+	}
+}
+
+// Report replaces the keys served with those of rep.  It may be called by
+// analysis.Pool.ReportEvery, so that scrapes see whole intervals even when
+// the Pool is reset after each report.
+func (h *Handler) Report(rep analysis.Report) {
+	keys := report.Top(rep, h.k)
+	h.mu.Lock()
+	h.keys = keys
+	h.mu.Unlock()
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	keys := h.keys
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	bw := bufio.NewWriter(w)
+	h.write(bw, keys, h.src.Stats(), h.src.QueueDepths(), h.src.Summary(), h.src.Flushes())
+	// the client has gone away if this fails, so there is no one to tell
+	_ = bw.Flush()
+}
+
+func (h *Handler) write(w io.Writer, keys []analysis.KeyReport, stats analysis.Stats, depths []int, summary analysis.Summary, flushes map[string]int) {
+	keys = h.capLabels(keys)
+
+	writeHeader(w, "memsniff_key_bytes", "gauge", "Estimated bytes of values returned for the busiest cache keys.")
+	for _, kr := range keys {
+		writeSample(w, "memsniff_key_bytes", keyLabels(kr), kr.TrafficEstimate)
+	}
+	writeHeader(w, "memsniff_key_requests", "gauge", "Estimated requests returning a value for the busiest cache keys.")
+	for _, kr := range keys {
+		writeSample(w, "memsniff_key_requests", keyLabels(kr), kr.RequestsEstimate)
+	}
+	writeHeader(w, "memsniff_key_misses", "gauge", "Estimated requests not returning a value for the busiest cache keys.")
+	for _, kr := range keys {
+		writeSample(w, "memsniff_key_misses", keyLabels(kr), kr.MissesEstimate)
+	}
+
+	// totals only behave as counters if they survive resets
+	totalType := "gauge"
+	if summary.Monotonic {
+		totalType = "counter"
+	}
+	writeHeader(w, "memsniff_gets_total", totalType, "Estimated keys requested by get commands.")
+	writeSample(w, "memsniff_gets_total", "", int(summary.GetsEstimate))
+	writeHeader(w, "memsniff_get_misses_total", totalType, "Estimated keys requested by get commands that were not found.")
+	writeSample(w, "memsniff_get_misses_total", "", int(summary.MissesEstimate))
+	writeHeader(w, "memsniff_sets_total", totalType, "Estimated storage commands.")
+	writeSample(w, "memsniff_sets_total", "", int(summary.SetsEstimate))
+	writeHeader(w, "memsniff_bytes_total", totalType, "Estimated bytes of values returned by gets and sent by storage commands.")
+	writeSample(w, "memsniff_bytes_total", "", int(summary.TrafficEstimate))
+	if summary.NewKeysTracked {
+		writeHeader(w, "memsniff_new_keys_total", totalType, "Estimated cache keys seen for the first time.")
+		writeSample(w, "memsniff_new_keys_total", "", int(summary.NewKeysEstimate))
+	}
+
+	writeHeader(w, "memsniff_events_handled_total", "counter", "Events recorded by analysis.")
+	writeSample(w, "memsniff_events_handled_total", "", int(stats.EventsHandled))
+	writeHeader(w, "memsniff_events_dropped_total", "counter", "Events discarded because analysis could not keep up.")
+	writeSample(w, "memsniff_events_dropped_total", "", int(stats.EventsDropped))
+	writeHeader(w, "memsniff_batches_dropped_total", "counter", "Batches of events discarded because an analysis worker queue was full.")
+	writeSample(w, "memsniff_batches_dropped_total", "", int(stats.BatchesDropped))
+	writeHeader(w, "memsniff_keys_dropped_total", "counter", "Cache keys in batches discarded because an analysis worker queue was full.")
+	writeSample(w, "memsniff_keys_dropped_total", "", int(stats.KeysDropped))
+	writeHeader(w, "memsniff_keys_evicted_total", "counter", "Tracked cache keys discarded to bound memory.")
+	writeSample(w, "memsniff_keys_evicted_total", "", int(stats.KeysEvicted))
+	writeHeader(w, "memsniff_keys_truncated_total", "counter", "Events whose cache keys were truncated for exceeding the maximum key length.")
+	writeSample(w, "memsniff_keys_truncated_total", "", int(stats.KeysTruncated))
+	writeHeader(w, "memsniff_keys_rejected_total", "counter", "Events discarded for cache keys exceeding the maximum key length.")
+	writeSample(w, "memsniff_keys_rejected_total", "", int(stats.KeysRejected))
+
+	writeHeader(w, "memsniff_flushes_total", "counter", "Requests to invalidate every item on the servers of each cluster.")
+	clusters := make([]string, 0, len(flushes))
+	for cluster := range flushes {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+	for _, cluster := range clusters {
+		writeSample(w, "memsniff_flushes_total", `cluster="`+escapeLabel(cluster)+`"`, flushes[cluster])
+	}
+
+	writeHeader(w, "memsniff_worker_queue_depth", "gauge", "Batches of events waiting in each analysis worker queue.")
+	for i, depth := range depths {
+		writeSample(w, "memsniff_worker_queue_depth", `worker="`+strconv.Itoa(i)+`"`, depth)
+	}
+}
+
+// capLabels limits keys to maxLabels entries, combining the rest into a
+// single entry named otherLabel.
+func (h *Handler) capLabels(keys []analysis.KeyReport) []analysis.KeyReport {
+	return report.CapKeys(keys, h.maxLabels)
+}
+
+func keyLabels(kr analysis.KeyReport) string {
+	labels := `key="` + escapeLabel(kr.Name) + `"`
+	if kr.Client != "" {
+		labels += `,client="` + escapeLabel(kr.Client) + `"`
+	}
+	if kr.Cluster != "" {
+		labels += `,cluster="` + escapeLabel(kr.Cluster) + `"`
+	}
+	return labels
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w io.Writer, name, labels string, value int) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, value)
+	} else {
+		fmt.Fprintf(w, "%s %d\n", name, value)
+	}
+}
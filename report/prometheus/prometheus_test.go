@@ -0,0 +1,58 @@
+package prometheus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestCapLabels(t *testing.T) {
+	h := NewHandler(nil, 10, 2)
+	keys := []analysis.KeyReport{
+		{Name: "a", TrafficEstimate: 30},
+		{Name: "b", TrafficEstimate: 20},
+		{Name: "c", TrafficEstimate: 10},
+		{Name: "d", TrafficEstimate: 5},
+	}
+	capped := h.capLabels(keys)
+	if len(capped) != 3 || capped[2].Name != otherLabel || capped[2].TrafficEstimate != 15 {
+		t.Error("expected a, b and 15 bytes of other, got", capped)
+	}
+	if keys[2].Name != "c" {
+		t.Error("capLabels modified its input")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	h := NewHandler(nil, 10, 10)
+	var buf bytes.Buffer
+	keys := []analysis.KeyReport{{Name: `we"ird`, TrafficEstimate: 30, RequestsEstimate: 3}}
+	h.write(&buf, keys, analysis.Stats{EventsHandled: 7}, []int{4, 0}, analysis.Summary{Monotonic: true, GetsEstimate: 12}, map[string]int{"east": 2})
+
+	out := buf.String()
+	for _, expected := range []string{
+		`memsniff_key_bytes{key="we\"ird"} 30`,
+		`memsniff_key_requests{key="we\"ird"} 3`,
+		`memsniff_events_handled_total 7`,
+		`memsniff_worker_queue_depth{worker="0"} 4`,
+		`# TYPE memsniff_events_handled_total counter`,
+		`memsniff_gets_total 12`,
+		`# TYPE memsniff_gets_total counter`,
+		`memsniff_flushes_total{cluster="east"} 2`,
+	} {
+		if !strings.Contains(out, expected+"\n") {
+			t.Error("expected line", expected, "in", out)
+		}
+	}
+	if strings.Contains(out, "memsniff_new_keys_total") {
+		t.Error("expected no new keys metric when not tracked, got", out)
+	}
+
+	buf.Reset()
+	h.write(&buf, nil, analysis.Stats{}, nil, analysis.Summary{NewKeysTracked: true, NewKeysEstimate: 5}, nil)
+	if out := buf.String(); !strings.Contains(out, "memsniff_new_keys_total 5\n") {
+		t.Error("expected new keys metric when tracked, got", out)
+	}
+}
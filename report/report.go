@@ -0,0 +1,95 @@
+// Package report contains common definitions for exporting cache key activity
+// to other systems.  Each exporter is implemented in a subpackage.
+package report
+
+import (
+	"github.com/box/memsniff/analysis"
+)
+
+// QueueSize is the number of reports a Queue holds for a reporter that is
+// slow to handle them.
+const QueueSize = 4
+
+// Queue holds the reports made by analysis.Pool.ReportEvery for a reporter
+// that handles them in its own goroutine, so that a slow reporter delays
+// neither the Pool's report loop nor the other reporters.
+type Queue chan analysis.Report
+
+// NewQueue returns an empty Queue.
+func NewQueue() Queue {
+	return make(Queue, QueueSize)
+}
+
+// Add queues rep without blocking, and reports whether it was queued.  rep
+// is skipped if QueueSize reports are already waiting for the reporter.
+func (q Queue) Add(rep analysis.Report) bool {
+	select {
+	case q <- rep:
+		return true
+	default:
+		return false
+	}
+}
+
+// Top returns up to k of the busiest keys in rep, which are already in
+// descending order.
+func Top(rep analysis.Report, k int) []analysis.KeyReport {
+	if k < 0 {
+		k = 0
+	}
+	if len(rep.Keys) > k {
+		return rep.Keys[:k]
+	}
+	return rep.Keys
+}
+
+// OtherKey is the name given by CapKeys to the combined activity of keys
+// beyond the limit.
+const OtherKey = "__other__"
+
+// CapKeys limits keys to n entries, combining the rest into a single entry
+// named OtherKey, to bound the number of series a metrics system must store.
+// keys is not modified.  A negative n is treated as zero.
+func CapKeys(keys []analysis.KeyReport, n int) []analysis.KeyReport {
+	if n < 0 {
+		n = 0
+	}
+	if len(keys) <= n {
+		return keys
+	}
+	other := analysis.KeyReport{Name: OtherKey}
+	for _, kr := range keys[n:] {
+		other.RequestsEstimate += kr.RequestsEstimate
+		other.MissesEstimate += kr.MissesEstimate
+		other.TrafficEstimate += kr.TrafficEstimate
+	}
+	capped := make([]analysis.KeyReport, n, n+1)
+	copy(capped, keys)
+	return append(capped, other)
+}
+
+// RoundBytes returns keys with their byte counts rounded to the nearest
+// multiple of granularity, such as 1024 to report whole kilobytes, so that
+// reports do not change with every small fluctuation in traffic.  Counts of
+// requests are left exact.  keys is not modified, and is returned as is if
+// granularity is less than 2.
+func RoundBytes(keys []analysis.KeyReport, granularity int) []analysis.KeyReport {
+	if granularity < 2 {
+		return keys
+	}
+	rounded := make([]analysis.KeyReport, len(keys))
+	for i, kr := range keys {
+		kr.Size = roundTo(kr.Size, granularity)
+		kr.TrafficEstimate = roundTo(kr.TrafficEstimate, granularity)
+		kr.TrafficError = roundTo(kr.TrafficError, granularity)
+		kr.SetTrafficEstimate = roundTo(kr.SetTrafficEstimate, granularity)
+		rounded[i] = kr
+	}
+	return rounded
+}
+
+// roundTo rounds n to the nearest multiple of granularity, rounding halves
+// up.
+func roundTo(n, granularity int) int {
+	return (n + granularity/2) / granularity * granularity
+}
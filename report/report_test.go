@@ -0,0 +1,54 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestRoundBytes(t *testing.T) {
+	keys := []analysis.KeyReport{
+		{Name: "a", Size: 1500, TrafficEstimate: 3000, RequestsEstimate: 2},
+		{Name: "b", Size: 300, TrafficEstimate: 511, SetTrafficEstimate: 512},
+	}
+	rounded := RoundBytes(keys, 1024)
+	if rounded[0].Size != 1024 || rounded[0].TrafficEstimate != 3072 || rounded[0].RequestsEstimate != 2 {
+		t.Error("expected bytes rounded to whole kilobytes, got", rounded[0])
+	}
+	if rounded[1].Size != 0 || rounded[1].TrafficEstimate != 0 || rounded[1].SetTrafficEstimate != 1024 {
+		t.Error("expected halves rounded up, got", rounded[1])
+	}
+	if keys[0].Size != 1500 {
+		t.Error("expected keys not to be modified, got", keys[0])
+	}
+	if exact := RoundBytes(keys, 0); exact[0].TrafficEstimate != 3000 {
+		t.Error("expected no rounding without a granularity, got", exact[0])
+	}
+}
+
+func TestQueueSkipsWhenFull(t *testing.T) {
+	q := NewQueue()
+	for i := 0; i < QueueSize; i++ {
+		if !q.Add(analysis.Report{}) {
+			t.Fatal("expected report", i, "to be queued")
+		}
+	}
+	if q.Add(analysis.Report{}) {
+		t.Error("expected report to be skipped once the queue is full")
+	}
+	<-q
+	if !q.Add(analysis.Report{}) {
+		t.Error("expected report to be queued once the reporter catches up")
+	}
+}
+
+func TestCapKeysNegative(t *testing.T) {
+	keys := []analysis.KeyReport{
+		{Name: "a", RequestsEstimate: 2},
+		{Name: "b", RequestsEstimate: 1},
+	}
+	capped := CapKeys(keys, -1)
+	if len(capped) != 1 || capped[0].Name != OtherKey || capped[0].RequestsEstimate != 3 {
+		t.Error("expected every key combined, got", capped)
+	}
+}
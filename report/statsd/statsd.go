@@ -0,0 +1,166 @@
+// Package statsd periodically sends gauges for the busiest cache keys to a
+// statsd daemon.
+package statsd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/log"
+	"github.com/box/memsniff/report"
+)
+
+const (
+	// metricPrefix precedes the sanitized key in every metric name.
+	metricPrefix = "memsniff.key."
+	// maxDatagram is the largest payload sent in a single packet, chosen to
+	// fit within a typical Ethernet MTU.
+	maxDatagram = 1432
+)
+
+// Emitter sends gauges for the busiest cache keys of each periodic report to
+// statsd:
+//
+//	memsniff.key.<sanitized-key>.requests
+//	memsniff.key.<sanitized-key>.bytes
+type Emitter struct {
+	logger  log.Logger
+	w       io.Writer
+	k       int
+	reports report.Queue
+	done    chan struct{}
+	// sanitized names for which a collision has already been logged
+	collided map[string]bool
+}
+
+// New returns an Emitter that sends gauges for the top k keys of each
+// report passed to Report to the statsd daemon at addr (host:port).
+// Sanitized key names that collide are logged to logger.
+func New(logger log.Logger, addr string, k int) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newEmitter(logger, conn, k), nil
+}
+
+func newEmitter(logger log.Logger, w io.Writer, k int) *Emitter {
+	return &Emitter{
+		logger:   logger,
+		w:        w,
+		k:        k,
+		reports:  report.NewQueue(),
+		done:     make(chan struct{}),
+		collided: make(map[string]bool),
+	}
+}
+
+// Report queues rep to be sent by Run.  It does not block, so it may be
+// called by analysis.Pool.ReportEvery.
+func (e *Emitter) Report(rep analysis.Report) {
+	e.reports.Add(rep)
+}
+
+// Run sends gauges until Close is called.  Errors sending to statsd are
+// logged, and do not stop the Emitter.
+func (e *Emitter) Run() {
+	for {
+		select {
+		case rep := <-e.reports:
+			if err := e.emit(report.Top(rep, e.k)); err != nil {
+				e.log("error sending to statsd:", err)
+			}
+		case <-e.done:
+			if c, ok := e.w.(io.Closer); ok {
+				_ = c.Close()
+			}
+			return
+		}
+	}
+}
+
+// Close stops a running Emitter.
+func (e *Emitter) Close() {
+	close(e.done)
+}
+
+// emit sends gauges for keys, packing as many as fit into each datagram.
+func (e *Emitter) emit(keys []analysis.KeyReport) error {
+	var buf bytes.Buffer
+	for _, m := range e.metrics(keys) {
+		line := metricPrefix + m.name + ".requests:" + strconv.Itoa(m.requests) + "|g\n" +
+			metricPrefix + m.name + ".bytes:" + strconv.Itoa(m.bytes) + "|g\n"
+		if buf.Len() > 0 && buf.Len()+len(line) > maxDatagram {
+			if _, err := e.w.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		_, err := e.w.Write(buf.Bytes())
+		return err
+	}
+	return nil
+}
+
+type metric struct {
+	name     string
+	requests int
+	bytes    int
+}
+
+// metrics returns the gauge values for keys by sanitized name.  Keys with
+// the same sanitized name are combined, and the collision logged the first
+// time it is seen.
+func (e *Emitter) metrics(keys []analysis.KeyReport) []metric {
+	var metrics []metric
+	index := make(map[string]int, len(keys))
+	raw := make(map[string]string, len(keys))
+	for _, kr := range keys {
+		name := Sanitize(kr.Name)
+		i, ok := index[name]
+		if !ok {
+			index[name] = len(metrics)
+			raw[name] = kr.Name
+			metrics = append(metrics, metric{name, kr.RequestsEstimate, kr.TrafficEstimate})
+			continue
+		}
+		if !e.collided[name] {
+			e.collided[name] = true
+			e.log("statsd metric name collision for keys", raw[name], "and", kr.Name, "as", name)
+		}
+		metrics[i].requests += kr.RequestsEstimate
+		metrics[i].bytes += kr.TrafficEstimate
+	}
+	return metrics
+}
+
+// Sanitize converts a cache key into a single statsd metric name component.
+// Characters with special meaning to statsd or Graphite, such as dots,
+// colons and slashes, are replaced with underscores.
+func Sanitize(key string) string {
+	s := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+func (e *Emitter) log(items ...interface{}) {
+	if e.logger != nil {
+		e.logger.Log(items...)
+	}
+}
@@ -0,0 +1,73 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/box/memsniff/analysis"
+)
+
+// datagrams records each Write as a separate datagram.
+type datagrams []string
+
+func (d *datagrams) Write(p []byte) (int, error) {
+	*d = append(*d, string(p))
+	return len(p), nil
+}
+
+type testLogger []string
+
+func (l *testLogger) Log(items ...interface{}) {
+	*l = append(*l, "logged")
+}
+
+func TestSanitize(t *testing.T) {
+	if s := Sanitize("user:123/profile.v2"); s != "user_123_profile_v2" {
+		t.Error("expected user_123_profile_v2, got", s)
+	}
+}
+
+func TestEmit(t *testing.T) {
+	var d datagrams
+	var l testLogger
+	e := newEmitter(&l, &d, 10)
+	keys := []analysis.KeyReport{
+		{Name: "a:1", RequestsEstimate: 2, TrafficEstimate: 20},
+		{Name: "a/1", RequestsEstimate: 1, TrafficEstimate: 5},
+	}
+	if err := e.emit(keys); err != nil {
+		t.Fatal(err)
+	}
+	expected := "memsniff.key.a_1.requests:3|g\nmemsniff.key.a_1.bytes:25|g\n"
+	if len(d) != 1 || d[0] != expected {
+		t.Error("expected", expected, "got", d)
+	}
+	if len(l) != 1 {
+		t.Error("expected collision to be logged once, got", len(l))
+	}
+
+	_ = e.emit(keys)
+	if len(l) != 1 {
+		t.Error("expected collision to be logged only once, got", len(l))
+	}
+}
+
+func TestEmitSplitsDatagrams(t *testing.T) {
+	var d datagrams
+	e := newEmitter(nil, &d, 100)
+	var keys []analysis.KeyReport
+	for i := 0; i < 100; i++ {
+		keys = append(keys, analysis.KeyReport{Name: strings.Repeat("k", 20) + string(rune('a'+i%26)) + string(rune('a'+i/26))})
+	}
+	if err := e.emit(keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(d) < 2 {
+		t.Error("expected multiple datagrams, got", len(d))
+	}
+	for _, p := range d {
+		if len(p) > maxDatagram {
+			t.Error("datagram of length", len(p), "exceeds", maxDatagram)
+		}
+	}
+}
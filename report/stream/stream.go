@@ -0,0 +1,253 @@
+// Package stream serves periodic snapshots of the busiest cache keys over
+// TCP, so that a remote client can render them as they are collected.
+//
+// The protocol is newline-delimited JSON: after connecting, a client
+// receives one Snapshot object per line each interval until it disconnects.
+// Clients send nothing.
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/report"
+)
+
+// clientBuffer is the number of snapshots queued for a client that is slow
+// to read them.  Further snapshots are skipped for that client until it
+// catches up.
+const clientBuffer = 4
+
+// writeTimeout bounds how long a client may take to accept a snapshot
+// before it is disconnected.
+const writeTimeout = 30 * time.Second
+
+// Snapshot is the busiest keys at a single point in time.
+type Snapshot struct {
+	Timestamp time.Time `json:"ts"`
+	Keys      []Key     `json:"keys"`
+}
+
+// Key is the activity of a single cache key in a Snapshot.
+type Key struct {
+	Key      string `json:"key"`
+	Client   string `json:"client,omitempty"`
+	Cluster  string `json:"cluster,omitempty"`
+	Size     int    `json:"size"`
+	Requests int    `json:"requests"`
+	Misses   int    `json:"misses"`
+	Bytes    int    `json:"bytes"`
+	// maximum overestimate of Requests and Bytes, if counted
+	// approximately
+	RequestsError int `json:"requests_error,omitempty"`
+	BytesError    int `json:"bytes_error,omitempty"`
+}
+
+// Server sends the busiest cache keys of each periodic report to every
+// client connected to it.  Each report is encoded once however many clients
+// are connected, and not at all when none are, so clients attaching and
+// detaching do not affect analysis.
+type Server struct {
+	k       int
+	reports report.Queue
+
+	mu        sync.Mutex
+	clients   map[*client]bool
+	listeners []net.Listener
+	closed    bool
+	done      chan struct{}
+}
+
+// client is a single connection to a Server.
+type client struct {
+	conn  net.Conn
+	lines chan []byte
+}
+
+// NewServer returns a Server that sends the top k keys of each report
+// passed to Report.  Call Serve to accept clients.
+func NewServer(k int) *Server {
+	s := &Server{
+		k:       k,
+		reports: report.NewQueue(),
+		clients: make(map[*client]bool),
+		done:    make(chan struct{}),
+	}
+	go s.broadcast()
+	return s
+}
+
+// Report queues rep to be sent to every client.  It does not block, so it
+// may be called by analysis.Pool.ReportEvery.
+func (s *Server) Report(rep analysis.Report) {
+	s.reports.Add(rep)
+}
+
+// Serve accepts clients from l until Close is called, when it returns nil,
+// or accepting fails.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		s.attach(conn)
+	}
+}
+
+// Close stops accepting clients and disconnects those already connected.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.clients {
+		s.detachLocked(c)
+	}
+}
+
+func (s *Server) attach(conn net.Conn) {
+	c := &client{conn: conn, lines: make(chan []byte, clientBuffer)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return
+	}
+	s.clients[c] = true
+	go s.send(c)
+}
+
+// send writes snapshots queued for c until it is detached or a write fails.
+func (s *Server) send(c *client) {
+	for line := range c.lines {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := c.conn.Write(line); err != nil {
+			s.mu.Lock()
+			s.detachLocked(c)
+			s.mu.Unlock()
+			// drain any snapshots queued before detaching
+			for range c.lines {
+			}
+			return
+		}
+	}
+}
+
+// detachLocked disconnects c.  s.mu must be held.
+func (s *Server) detachLocked(c *client) {
+	if !s.clients[c] {
+		return
+	}
+	delete(s.clients, c)
+	close(c.lines)
+	c.conn.Close()
+}
+
+// broadcast queues a snapshot of each report for every client.
+func (s *Server) broadcast() {
+	for {
+		select {
+		case rep := <-s.reports:
+			if s.numClients() == 0 {
+				continue
+			}
+			line, err := encode(rep.Timestamp, report.Top(rep, s.k))
+			if err != nil {
+				continue
+			}
+			s.mu.Lock()
+			for c := range s.clients {
+				select {
+				case c.lines <- line:
+				default:
+					// the client is behind, so it skips this snapshot
+				}
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) numClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// encode returns the wire form of a snapshot of keys taken at ts.
+func encode(ts time.Time, keys []analysis.KeyReport) ([]byte, error) {
+	snap := Snapshot{Timestamp: ts, Keys: make([]Key, len(keys))}
+	for i, kr := range keys {
+		snap.Keys[i] = Key{
+			Key:           kr.Name,
+			Client:        kr.Client,
+			Cluster:       kr.Cluster,
+			Size:          kr.Size,
+			Requests:      kr.RequestsEstimate,
+			Misses:        kr.MissesEstimate,
+			Bytes:         kr.TrafficEstimate,
+			RequestsError: kr.RequestsError,
+			BytesError:    kr.TrafficError,
+		}
+	}
+	line, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// Decoder reads Snapshots sent by a Server.
+type Decoder struct {
+	dec *json.Decoder
+}
+
+// NewDecoder returns a Decoder reading from r, usually a connection to a
+// Server:
+//
+//	conn, err := net.Dial("tcp", "memcache1:9878")
+//	...
+//	dec := stream.NewDecoder(conn)
+//	for {
+//		snap, err := dec.Next()
+//		...
+//	}
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{json.NewDecoder(bufio.NewReader(r))}
+}
+
+// Next blocks until the next Snapshot arrives and returns it.  Next returns
+// io.EOF once the Server closes the connection.
+func (d *Decoder) Next() (Snapshot, error) {
+	var snap Snapshot
+	err := d.dec.Decode(&snap)
+	return snap, err
+}
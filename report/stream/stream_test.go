@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	ts := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	line, err := encode(ts, []analysis.KeyReport{{Name: "a", Size: 10, RequestsEstimate: 2, TrafficEstimate: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"ts":"2017-01-02T03:04:05Z","keys":[{"key":"a","size":10,"requests":2,"misses":0,"bytes":20}]}` + "\n"
+	if string(line) != expected {
+		t.Error("expected", expected, "got", string(line))
+	}
+
+	dec := NewDecoder(strings.NewReader(string(line)))
+	snap, err := dec.Next()
+	if err != nil || !snap.Timestamp.Equal(ts) || len(snap.Keys) != 1 || snap.Keys[0].Bytes != 20 {
+		t.Error("unexpected snapshot", snap, err)
+	}
+	if _, err := dec.Next(); err != io.EOF {
+		t.Error("expected EOF, got", err)
+	}
+}
+
+func TestServerClients(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen:", err)
+	}
+	s := NewServer(10)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+	// report every few milliseconds, as the Pool would every interval
+	stopReports := make(chan struct{})
+	defer close(stopReports)
+	go func() {
+		for {
+			select {
+			case now := <-time.After(10 * time.Millisecond):
+				s.Report(analysis.Report{Timestamp: now, Keys: []analysis.KeyReport{{Name: "a", RequestsEstimate: 1}}})
+			case <-stopReports:
+				return
+			}
+		}
+	}()
+
+	dial := func() (net.Conn, *Decoder) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, NewDecoder(conn)
+	}
+	first, firstDec := dial()
+	second, secondDec := dial()
+	defer second.Close()
+	if snap, err := firstDec.Next(); err != nil || len(snap.Keys) != 1 || snap.Keys[0].Key != "a" {
+		t.Fatal("unexpected snapshot", snap, err)
+	}
+
+	// one client leaving does not affect the other
+	first.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := secondDec.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Close()
+	if err := <-served; err != nil {
+		t.Error("expected Serve to return nil after Close, got", err)
+	}
+	for {
+		if _, err := secondDec.Next(); err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the argument to the TIOCGWINSZ ioctl.
+type winsize struct {
+	rows    uint16
+	cols    uint16
+	xpixels uint16
+	ypixels uint16
+}
+
+// terminalSize returns the width and height in characters of the terminal
+// open as fd.
+func terminalSize(fd uintptr) (width, height int, err error) {
+	var ws winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.cols), int(ws.rows), nil
+}
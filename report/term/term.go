@@ -0,0 +1,201 @@
+// Package term draws a continuously refreshed table of the busiest cache keys
+// on a terminal, in the manner of top.
+package term
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+	"github.com/box/memsniff/report"
+)
+
+const (
+	// clearScreen moves the cursor to the top left and erases the display.
+	clearScreen = "\x1b[H\x1b[2J"
+	// width of each rate column, including separating space
+	rateWidth = 12
+	// dimensions used when the terminal size cannot be determined
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// counts are the totals reported for a key in a single frame.
+type counts struct {
+	requests int
+	bytes    int
+}
+
+// rowKey identifies a row across frames.
+type rowKey struct {
+	name    string
+	client  string
+	cluster string
+}
+
+// row is a single key in a frame with its rates since the previous frame.
+type row struct {
+	name        string
+	requestRate float64
+	byteRate    float64
+}
+
+// byRequestRate sorts rows in descending order by request rate.
+type byRequestRate []row
+
+func (r byRequestRate) Len() int           { return len(r) }
+func (r byRequestRate) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byRequestRate) Less(i, j int) bool { return r[i].requestRate > r[j].requestRate }
+
+// Renderer repaints a table of the busiest cache keys of each periodic
+// report.  Rates are computed from each key's totals in a report covering a
+// single interval, or from the change in its totals between reports if they
+// accumulate activity.
+type Renderer struct {
+	w       io.Writer
+	k       int
+	reset   bool
+	reports report.Queue
+	// returns the terminal width and height in characters
+	size func() (width, height int)
+	done chan struct{}
+
+	prev     map[rowKey]counts
+	prevTime time.Time
+	// most recently drawn rows, redrawn when the terminal is resized
+	rows []row
+}
+
+// New returns a Renderer that draws the top k keys of each report passed to
+// Report to w.  reset is whether the Pool is reset after each report, so
+// that its totals cover a single interval.  If w is a terminal the table is
+// fitted to its size.
+func New(w io.Writer, k int, reset bool) *Renderer {
+	size := func() (int, int) { return defaultWidth, defaultHeight }
+	if f, ok := w.(*os.File); ok {
+		size = func() (int, int) {
+			width, height, err := terminalSize(f.Fd())
+			if err != nil || width <= 0 || height <= 0 {
+				return defaultWidth, defaultHeight
+			}
+			return width, height
+		}
+	}
+	return &Renderer{
+		w:       w,
+		k:       k,
+		reset:   reset,
+		reports: report.NewQueue(),
+		size:    size,
+		done:    make(chan struct{}),
+	}
+}
+
+// Report queues rep to be drawn by Run.  It does not block, so it may be
+// called by analysis.Pool.ReportEvery.
+func (r *Renderer) Report(rep analysis.Report) {
+	r.reports.Add(rep)
+}
+
+// Run draws frames until Close is called or writing fails.  The current
+// frame is redrawn immediately when the terminal is resized.
+func (r *Renderer) Run() error {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+
+	r.prevTime = time.Now()
+	for {
+		select {
+		case rep := <-r.reports:
+			r.rows = r.frame(rep.Timestamp, report.Top(rep, r.k))
+			if err := r.draw(); err != nil {
+				return err
+			}
+		case <-resized:
+			if err := r.draw(); err != nil {
+				return err
+			}
+		case <-r.done:
+			return nil
+		}
+	}
+}
+
+// Close stops a running Renderer.
+func (r *Renderer) Close() {
+	close(r.done)
+}
+
+// frame returns rows for keys with their rates since the previous frame.
+// If the Pool is reset after each report, a key's totals are its activity
+// since the previous frame.  Otherwise a key not in the previous frame,
+// including every key in the first frame, has no rate yet and is shown as
+// 0, since its totals may span far more than one interval, and a key whose
+// totals have decreased because the Pool was reset is counted from zero.
+func (r *Renderer) frame(now time.Time, keys []analysis.KeyReport) []row {
+	secs := now.Sub(r.prevTime).Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+	cur := make(map[rowKey]counts, len(keys))
+	rows := make([]row, 0, len(keys))
+	for _, kr := range keys {
+		rk := rowKey{kr.Name, kr.Client, kr.Cluster}
+		c := counts{kr.RequestsEstimate, kr.TrafficEstimate}
+		cur[rk] = c
+		var delta counts
+		if r.reset {
+			delta = c
+		} else if p, ok := r.prev[rk]; ok {
+			delta = c
+			if p.requests <= c.requests && p.bytes <= c.bytes {
+				delta = counts{c.requests - p.requests, c.bytes - p.bytes}
+			}
+		}
+		name := kr.Label()
+		rows = append(rows, row{name, float64(delta.requests) / secs, float64(delta.bytes) / secs})
+	}
+	sort.Stable(byRequestRate(rows))
+	r.prev = cur
+	r.prevTime = now
+	return rows
+}
+
+// draw clears the terminal and writes as many rows as fit, with keys
+// truncated to the width of the terminal.
+func (r *Renderer) draw() error {
+	width, height := r.size()
+	keyWidth := width - 2*rateWidth
+	if keyWidth < 1 {
+		keyWidth = 1
+	}
+	bw := bufio.NewWriter(r.w)
+	fmt.Fprint(bw, clearScreen)
+	fmt.Fprintf(bw, "%-*s%*s%*s\r\n", keyWidth, "Key", rateWidth, "Req/s", rateWidth, "Bytes/s")
+	for i, rw := range r.rows {
+		// leave a line for the cursor so the header does not scroll away
+		if i >= height-2 {
+			break
+		}
+		fmt.Fprintf(bw, "%-*s%*.1f%*.0f\r\n", keyWidth, truncate(rw.name, keyWidth-1), rateWidth, rw.requestRate, rateWidth, rw.byteRate)
+	}
+	return bw.Flush()
+}
+
+// truncate shortens s to at most n bytes.
+func truncate(s string, n int) string {
+	if n < 0 {
+		n = 0
+	}
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package term
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/box/memsniff/analysis"
+)
+
+func TestFrameRates(t *testing.T) {
+	r := New(nil, 10, false)
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.prevTime = start
+	rows := r.frame(start.Add(time.Second), []analysis.KeyReport{
+		{Name: "a", RequestsEstimate: 10, TrafficEstimate: 100},
+		{Name: "b", RequestsEstimate: 50, TrafficEstimate: 50},
+	})
+	// totals accumulated before the first frame are not a rate
+	for _, rw := range rows {
+		if rw.requestRate != 0 || rw.byteRate != 0 {
+			t.Error("expected no rate in the first frame, got", rw)
+		}
+	}
+	rows = r.frame(start.Add(3*time.Second), []analysis.KeyReport{
+		// b was reset, so is counted from zero
+		{Name: "b", RequestsEstimate: 4, TrafficEstimate: 4},
+		{Name: "a", RequestsEstimate: 30, TrafficEstimate: 300},
+		// c is new, so has no rate yet
+		{Name: "c", RequestsEstimate: 2, TrafficEstimate: 2},
+	})
+
+	expected := []row{
+		{"a", 10, 100},
+		{"b", 2, 2},
+		{"c", 0, 0},
+	}
+	if len(rows) != len(expected) {
+		t.Fatal("expected", expected, "got", rows)
+	}
+	for i := range expected {
+		if rows[i] != expected[i] {
+			t.Error("expected", expected[i], "got", rows[i])
+		}
+	}
+}
+
+func TestFrameRatesReset(t *testing.T) {
+	r := New(nil, 10, true)
+	start := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.prevTime = start
+	// each report covers only the interval since the previous one
+	rows := r.frame(start.Add(2*time.Second), []analysis.KeyReport{
+		{Name: "a", RequestsEstimate: 10, TrafficEstimate: 100},
+	})
+	if len(rows) != 1 || rows[0] != (row{"a", 5, 50}) {
+		t.Error("expected a at 5 requests per second, got", rows)
+	}
+	rows = r.frame(start.Add(3*time.Second), []analysis.KeyReport{
+		{Name: "a", RequestsEstimate: 20, TrafficEstimate: 200},
+	})
+	if len(rows) != 1 || rows[0] != (row{"a", 20, 200}) {
+		t.Error("expected a at 20 requests per second, got", rows)
+	}
+}
+
+func TestDrawFitsTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	r := New(&buf, 10, true)
+	r.size = func() (int, int) { return 40, 4 }
+	r.rows = []row{
+		{strings.Repeat("k", 50), 3, 30},
+		{"b", 2, 20},
+		{"c", 1, 10},
+	}
+	if err := r.draw(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := strings.TrimPrefix(buf.String(), clearScreen)
+	lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+	// header and as many rows as fit above the cursor
+	if len(lines) != 3 {
+		t.Fatal("expected 3 lines, got", lines)
+	}
+	for _, line := range lines {
+		if len(line) != 40 {
+			t.Error("expected line of 40 characters, got", len(line), line)
+		}
+	}
+}